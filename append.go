@@ -0,0 +1,23 @@
+package store
+
+// Appender is implemented by stores that can append to an object in place,
+// e.g. to accumulate log lines without uploading the whole object again.
+//
+// Object stores such as S3 and Qiniu can't append to an object: emulating
+// it would mean downloading and uploading the whole object on every append,
+// so they don't implement Appender.
+type Appender interface {
+	// AppendData appends data to the object, creating it if it doesn't
+	// exist.
+	AppendData(data []byte, key string) error
+}
+
+// AppendData appends data to key on st, failing with ErrNotSupported if st
+// isn't an Appender.
+func AppendData(st Interface, data []byte, key string) error {
+	a, ok := st.(Appender)
+	if !ok {
+		return notSupportedError("AppendData", st)
+	}
+	return a.AppendData(data, key)
+}
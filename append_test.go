@@ -0,0 +1,39 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAppendData(t *testing.T) {
+	dir := t.TempDir()
+	stores := map[string]struct {
+		st  Interface
+		key string
+	}{
+		"os":  {NewOSStore(WithFileMode(0600)), filepath.Join(dir, "logs", "app.log")},
+		"mem": {NewMemStore(), "logs/app.log"},
+	}
+	for name, tc := range stores {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, AppendData(tc.st, []byte("line 1\n"), tc.key))
+			assert.NoError(t, AppendData(tc.st, []byte("line 2\n"), tc.key))
+			data, err := tc.st.DownloadBytes(tc.key)
+			assert.NoError(t, err)
+			assert.Equal(t, "line 1\nline 2\n", string(data))
+		})
+	}
+
+	fi, err := os.Stat(stores["os"].key)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	assert.ErrorIs(t, AppendData(NewOSStore(), []byte("x"), dir+"/../escape.log"), ErrInvalidKey)
+}
+
+func TestAppendData_NotSupported(t *testing.T) {
+	st, _ := newFakeS3Store(t)
+	assert.ErrorIs(t, AppendData(st, []byte("line\n"), "logs/app.log"), ErrNotSupported)
+}
@@ -0,0 +1,184 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAsyncQueueSize = 64
+	defaultAsyncWorkers   = 4
+)
+
+// AsyncStoreOptions configures an AsyncStore.
+type AsyncStoreOptions struct {
+	// QueueSize is the number of uploads that can be buffered before
+	// EnqueueUpload applies backpressure. Defaults to 64.
+	QueueSize int
+	// Workers is the number of uploads running concurrently. Defaults to 4.
+	Workers int
+	// NonBlocking makes EnqueueUpload return ErrQueueFull instead of
+	// blocking when the queue is full.
+	NonBlocking bool
+	// Retries is the number of times a failed upload is retried.
+	Retries int
+	// RetryDelay is the delay before each retry.
+	RetryDelay time.Duration
+	// OnError, if set, is called with the last error of an upload that
+	// failed after all retries.
+	OnError func(key string, err error)
+}
+
+func (o AsyncStoreOptions) withDefaults() AsyncStoreOptions {
+	if o.QueueSize <= 0 {
+		o.QueueSize = defaultAsyncQueueSize
+	}
+	if o.Workers <= 0 {
+		o.Workers = defaultAsyncWorkers
+	}
+	if o.Retries < 0 {
+		o.Retries = 0
+	}
+	return o
+}
+
+type asyncUpload struct {
+	data []byte
+	key  string
+}
+
+// AsyncStore wraps a store and uploads data enqueued with EnqueueUpload in
+// the background. All other methods are passed through to the wrapped store.
+type AsyncStore struct {
+	Interface
+	opts AsyncStoreOptions
+
+	queue   chan asyncUpload
+	workers sync.WaitGroup
+
+	closeLk sync.RWMutex
+	closed  bool
+
+	lk      sync.Mutex
+	pending int
+	idle    []chan struct{}
+}
+
+// NewAsyncStore creates an AsyncStore uploading to st and starts its workers.
+func NewAsyncStore(st Interface, opts AsyncStoreOptions) *AsyncStore {
+	opts = opts.withDefaults()
+	s := &AsyncStore{
+		Interface: st,
+		opts:      opts,
+		queue:     make(chan asyncUpload, opts.QueueSize),
+	}
+	s.workers.Add(opts.Workers)
+	for i := 0; i < opts.Workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// EnqueueUpload queues data to be uploaded to key. The data must not be
+// modified after the call. When the queue is full it blocks, or returns
+// ErrQueueFull if the store is non-blocking.
+func (s *AsyncStore) EnqueueUpload(data []byte, key string) error {
+	s.closeLk.RLock()
+	defer s.closeLk.RUnlock()
+	if s.closed {
+		return ErrQueueClosed
+	}
+	s.addPending()
+	u := asyncUpload{data: data, key: key}
+	if !s.opts.NonBlocking {
+		s.queue <- u
+		return nil
+	}
+	select {
+	case s.queue <- u:
+		return nil
+	default:
+		s.donePending()
+		return ErrQueueFull
+	}
+}
+
+// Flush waits until all enqueued uploads are done or ctx is done.
+func (s *AsyncStore) Flush(ctx context.Context) error {
+	s.lk.Lock()
+	if s.pending == 0 {
+		s.lk.Unlock()
+		return nil
+	}
+	ch := make(chan struct{})
+	s.idle = append(s.idle, ch)
+	s.lk.Unlock()
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close stops accepting uploads, waits for the queued ones to finish and
+// closes the wrapped store.
+func (s *AsyncStore) Close() error {
+	s.closeLk.Lock()
+	if s.closed {
+		s.closeLk.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.closeLk.Unlock()
+
+	s.workers.Wait()
+	return closeStore(s.Interface)
+}
+
+func (s *AsyncStore) worker() {
+	defer s.workers.Done()
+	for u := range s.queue {
+		if err := s.upload(u); err != nil {
+			log.Errorw("async upload failed", "key", u.key, "error", err)
+			if s.opts.OnError != nil {
+				s.opts.OnError(u.key, err)
+			}
+		}
+		s.donePending()
+	}
+}
+
+func (s *AsyncStore) upload(u asyncUpload) (err error) {
+	for attempt := 0; attempt <= s.opts.Retries; attempt++ {
+		if attempt > 0 && s.opts.RetryDelay > 0 {
+			time.Sleep(s.opts.RetryDelay)
+		}
+		if err = s.Interface.UploadData(u.data, u.key); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("upload %s after %d attempts: %w", u.key, s.opts.Retries+1, err)
+}
+
+func (s *AsyncStore) addPending() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.pending++
+}
+
+func (s *AsyncStore) donePending() {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.pending--
+	if s.pending == 0 {
+		for _, ch := range s.idle {
+			close(ch)
+		}
+		s.idle = nil
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingStore records UploadData calls, optionally blocking them until
+// release is closed and failing the first failures calls.
+type recordingStore struct {
+	Interface
+	release chan struct{}
+
+	lk       sync.Mutex
+	failures int
+	keys     []string
+}
+
+func (s *recordingStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("upload failed")
+	}
+	s.keys = append(s.keys, key)
+	return nil
+}
+
+func (s *recordingStore) uploaded() []string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	keys := append([]string(nil), s.keys...)
+	sort.Strings(keys)
+	return keys
+}
+
+func TestAsyncStore_DrainOnClose(t *testing.T) {
+	backend := &recordingStore{Interface: NewOSStore()}
+	s := NewAsyncStore(backend, AsyncStoreOptions{Workers: 2})
+
+	for _, key := range []string{"a", "b", "c", "d"} {
+		assert.NoError(t, s.EnqueueUpload([]byte(key), key))
+	}
+	assert.NoError(t, s.Close())
+	assert.Equal(t, []string{"a", "b", "c", "d"}, backend.uploaded())
+	assert.ErrorIs(t, s.EnqueueUpload([]byte("e"), "e"), ErrQueueClosed)
+}
+
+func TestAsyncStore_Flush(t *testing.T) {
+	backend := &recordingStore{Interface: NewOSStore(), release: make(chan struct{})}
+	s := NewAsyncStore(backend, AsyncStoreOptions{})
+	t.Cleanup(func() {
+		_ = s.Close()
+	})
+
+	assert.NoError(t, s.EnqueueUpload([]byte("a"), "a"))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, s.Flush(ctx), context.DeadlineExceeded)
+
+	close(backend.release)
+	assert.NoError(t, s.Flush(context.Background()))
+	assert.Equal(t, []string{"a"}, backend.uploaded())
+}
+
+func TestAsyncStore_Backpressure(t *testing.T) {
+	backend := &recordingStore{Interface: NewOSStore(), release: make(chan struct{})}
+	s := NewAsyncStore(backend, AsyncStoreOptions{QueueSize: 1, Workers: 1, NonBlocking: true})
+
+	// One upload is held by the worker and one fills the queue.
+	assert.NoError(t, s.EnqueueUpload([]byte("a"), "a"))
+	assert.Eventually(t, func() bool { return len(s.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, s.EnqueueUpload([]byte("b"), "b"))
+	assert.ErrorIs(t, s.EnqueueUpload([]byte("c"), "c"), ErrQueueFull)
+
+	close(backend.release)
+	assert.NoError(t, s.Close())
+	assert.Equal(t, []string{"a", "b"}, backend.uploaded())
+}
+
+func TestAsyncStore_BlocksWhenFull(t *testing.T) {
+	backend := &recordingStore{Interface: NewOSStore(), release: make(chan struct{})}
+	s := NewAsyncStore(backend, AsyncStoreOptions{QueueSize: 1, Workers: 1})
+
+	assert.NoError(t, s.EnqueueUpload([]byte("a"), "a"))
+	assert.Eventually(t, func() bool { return len(s.queue) == 0 }, time.Second, time.Millisecond)
+	assert.NoError(t, s.EnqueueUpload([]byte("b"), "b"))
+
+	enqueued := make(chan struct{})
+	go func() {
+		_ = s.EnqueueUpload([]byte("c"), "c")
+		close(enqueued)
+	}()
+	select {
+	case <-enqueued:
+		t.Fatal("EnqueueUpload should block while the queue is full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(backend.release)
+	<-enqueued
+	assert.NoError(t, s.Close())
+	assert.Equal(t, []string{"a", "b", "c"}, backend.uploaded())
+}
+
+func TestAsyncStore_RetryAndOnError(t *testing.T) {
+	backend := &recordingStore{Interface: NewOSStore(), failures: 3}
+	var lk sync.Mutex
+	var failed []string
+	s := NewAsyncStore(backend, AsyncStoreOptions{
+		Workers: 1,
+		Retries: 1,
+		OnError: func(key string, err error) {
+			lk.Lock()
+			defer lk.Unlock()
+			failed = append(failed, key)
+		},
+	})
+
+	// "a" fails twice and gives up, "b" fails once and succeeds on retry.
+	assert.NoError(t, s.EnqueueUpload([]byte("a"), "a"))
+	assert.NoError(t, s.EnqueueUpload([]byte("b"), "b"))
+	assert.NoError(t, s.Close())
+	assert.Equal(t, []string{"b"}, backend.uploaded())
+	assert.Equal(t, []string{"a"}, failed)
+}
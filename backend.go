@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"sync"
+)
+
+// BackendFactory creates the backend serving the paths of a registered
+// protocol.
+type BackendFactory func() (Interface, error)
+
+var (
+	backendsLk sync.RWMutex
+	backends   = map[PathProtocol]BackendFactory{}
+)
+
+// builtinProtocols are served by the backends a Store is created with.
+// FileScheme is resolved to OSProtocol paths before the registered
+// backends are looked up, so it can't be registered either.
+var builtinProtocols = map[PathProtocol]bool{
+	OSProtocol:    true,
+	QiniuProtocol: true,
+	S3Protocol:    true,
+	FileScheme:    true,
+}
+
+// RegisterBackend makes the paths of protocol, protocol:/key, routable by
+// the union Store. Each Store calls factory the first time it's asked for
+// such a path and keeps the backend until it's closed; a failing factory is
+// called again on the next path.
+//
+// The protocols of the built-in backends, qiniu and s3, and the file scheme
+// are reserved: they are served by the backends a Store is created with, not
+// through the registry.
+// Like sql.Register, RegisterBackend panics if protocol is reserved or
+// already registered, if it isn't a valid lower-case URL scheme or if
+// factory is nil. It's meant to be called from an init function.
+func RegisterBackend(protocol PathProtocol, factory BackendFactory) {
+	if factory == nil {
+		panic("store: RegisterBackend factory is nil")
+	}
+	if !validScheme(protocol.String()) {
+		panic(fmt.Sprintf("store: invalid protocol %q", protocol))
+	}
+	backendsLk.Lock()
+	defer backendsLk.Unlock()
+	if builtinProtocols[protocol] {
+		panic(fmt.Sprintf("store: protocol %s is reserved for a built-in backend", protocol))
+	}
+	if _, dup := backends[protocol]; dup {
+		panic(fmt.Sprintf("store: RegisterBackend called twice for protocol %s", protocol))
+	}
+	backends[protocol] = factory
+}
+
+// registeredBackend returns the factory of a protocol registered with
+// RegisterBackend.
+func registeredBackend(protocol PathProtocol) (BackendFactory, bool) {
+	backendsLk.RLock()
+	defer backendsLk.RUnlock()
+	factory, ok := backends[protocol]
+	return factory, ok
+}
+
+// validScheme reports whether s is a URL scheme as url.Parse returns it:
+// a lower-case letter followed by lower-case letters, digits, '+', '-' or
+// '.'.
+func validScheme(s string) bool {
+	if s == "" || s[0] < 'a' || s[0] > 'z' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '+' || c == '-' || c == '.') {
+			return false
+		}
+	}
+	return true
+}
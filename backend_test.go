@@ -0,0 +1,79 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testProtocol PathProtocol = "memtest"
+
+var (
+	registerTestBackend sync.Once
+	testBackendCalls    atomic.Int32
+	testBackendFail     atomic.Bool
+)
+
+// useTestBackend registers testProtocol, once per test binary, with a
+// factory creating a MemStore unless testBackendFail is set.
+func useTestBackend(t *testing.T) {
+	registerTestBackend.Do(func() {
+		RegisterBackend(testProtocol, func() (Interface, error) {
+			testBackendCalls.Add(1)
+			if testBackendFail.Load() {
+				return nil, errors.New("backend unavailable")
+			}
+			return NewMemStore(), nil
+		})
+	})
+	testBackendCalls.Store(0)
+	testBackendFail.Store(false)
+}
+
+func TestRegisterBackend(t *testing.T) {
+	useTestBackend(t)
+
+	protocol, p, err := GetPathProtocol("memtest:/dir/key")
+	assert.NoError(t, err)
+	assert.Equal(t, testProtocol, protocol)
+	assert.Equal(t, "dir/key", p)
+	assert.True(t, IsUnionPath("memtest:/dir/key"))
+
+	s := &Store{osStore: NewOSStore()}
+	testBackendFail.Store(true)
+	assert.ErrorContains(t, s.UploadData([]byte("data"), "memtest:/dir/key"), "backend unavailable")
+	testBackendFail.Store(false)
+
+	assert.NoError(t, s.UploadData([]byte("data"), "memtest:/dir/key"))
+	data, err := s.DownloadBytes(BuildPath(testProtocol, "dir/key"))
+	assert.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+	keys, err := s.ListPrefix("memtest:/dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/key"}, keys)
+	assert.Equal(t, int32(2), testBackendCalls.Load(), "the backend should be created once per store")
+
+	assert.NoError(t, s.Close())
+	_, err = s.DownloadBytes("memtest:/dir/key")
+	assert.ErrorIs(t, err, ErrNotFound, "a closed store should start over with a new backend")
+}
+
+func TestRegisterBackend_Invalid(t *testing.T) {
+	useTestBackend(t)
+	factory := func() (Interface, error) { return NewMemStore(), nil }
+
+	assert.Panics(t, func() { RegisterBackend(testProtocol, factory) })
+	assert.Panics(t, func() { RegisterBackend(S3Protocol, factory) })
+	assert.Panics(t, func() { RegisterBackend(OSProtocol, factory) })
+	assert.Panics(t, func() { RegisterBackend(FileScheme, factory) })
+	assert.Panics(t, func() { RegisterBackend("Mixed", factory) })
+	assert.Panics(t, func() { RegisterBackend("bad:scheme", factory) })
+	assert.Panics(t, func() { RegisterBackend("nilfactory", nil) })
+
+	protocol, _, err := GetPathProtocol("unregistered:/dir/key")
+	assert.NoError(t, err)
+	assert.Equal(t, UnknownPathProtocol, protocol)
+}
@@ -0,0 +1,130 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// defaultBatchConcurrency is the number of keys processed at the same time
+// by the batch helpers when the concurrency isn't set.
+const defaultBatchConcurrency = 8
+
+// maxBatchErrorKeys is the number of failed keys listed by BatchError.Error.
+const maxBatchErrorKeys = 3
+
+// BatchError is returned by the batch operations when some keys failed.
+// errors.Is and errors.As look through the errors of all the failed keys,
+// e.g. errors.Is(err, ErrNotFound) reports whether any key was missing.
+type BatchError struct {
+	total  int
+	failed map[string]error
+}
+
+// newBatchError returns a BatchError for the failed keys out of total, or
+// nil if no key failed.
+func newBatchError(total int, failed map[string]error) error {
+	if len(failed) == 0 {
+		return nil
+	}
+	return BatchError{total: total, failed: failed}
+}
+
+// Failed returns the error of every failed key.
+func (e BatchError) Failed() map[string]error {
+	return e.failed
+}
+
+func (e BatchError) Error() string {
+	keys := make([]string, 0, len(e.failed))
+	for key := range e.failed {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d keys failed", len(keys), e.total)
+	for i, key := range keys {
+		if i == maxBatchErrorKeys {
+			fmt.Fprintf(&b, "; and %d more", len(keys)-i)
+			break
+		}
+		fmt.Fprintf(&b, "; %s: %v", key, e.failed[key])
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the failed keys for errors.Is and errors.As.
+func (e BatchError) Unwrap() []error {
+	errs := make([]error, 0, len(e.failed))
+	for _, err := range e.failed {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// DownloadManyBytes downloads the keys from st with up to concurrency
+// downloads at the same time, which defaults to 8 if not positive. It
+// returns the data of the keys that were downloaded and, if some keys
+// failed, a BatchError holding their errors.
+func DownloadManyBytes(st Interface, keys []string, concurrency int) (map[string][]byte, error) {
+	return runBatch(keys, concurrency, st.DownloadBytes)
+}
+
+// StatMany stats the keys on st with up to concurrency stats at the same
+// time, which defaults to 8 if not positive. It returns the stats of the
+// keys that were stat'ed and, if some keys failed, a BatchError holding
+// their errors; missing keys fail with ErrNotFound.
+func StatMany(st Interface, keys []string, concurrency int) (map[string]FileStat, error) {
+	return runBatch(keys, concurrency, st.Stat)
+}
+
+// ExistsMany checks whether the keys exist on st with up to concurrency
+// checks at the same time, which defaults to 8 if not positive. Missing keys
+// are reported as false; only the keys that couldn't be checked are in the
+// returned BatchError.
+func ExistsMany(st Interface, keys []string, concurrency int) (map[string]bool, error) {
+	return runBatch(keys, concurrency, st.Exists)
+}
+
+// runBatch calls fn for every distinct key with a bounded pool of workers
+// and collects the results by key. The errors are returned as a BatchError.
+func runBatch[T any](keys []string, concurrency int, fn func(key string) (T, error)) (map[string]T, error) {
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+	var (
+		lk      sync.Mutex
+		results = make(map[string]T, len(keys))
+		errs    = make(map[string]error)
+		wg      sync.WaitGroup
+		keysCh  = make(chan string)
+	)
+	for i := 0; i < min(concurrency, len(keys)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keysCh {
+				v, err := fn(key)
+				lk.Lock()
+				if err != nil {
+					errs[key] = err
+				} else {
+					results[key] = v
+				}
+				lk.Unlock()
+			}
+		}()
+	}
+	seen := make(map[string]struct{}, len(keys))
+	for _, key := range keys {
+		if _, ok := seen[key]; ok {
+			continue
+		}
+		seen[key] = struct{}{}
+		keysCh <- key
+	}
+	close(keysCh)
+	wg.Wait()
+	return results, newBatchError(len(seen), errs)
+}
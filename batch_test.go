@@ -0,0 +1,112 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadManyBytes(t *testing.T) {
+	st := NewMemStore()
+	var keys []string
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("cfg/%02d.json", i)
+		keys = append(keys, key)
+		if i%4 != 0 {
+			assert.NoError(t, st.UploadData([]byte(key), key))
+		}
+	}
+	keys = append(keys, keys[1])
+
+	data, err := DownloadManyBytes(st, keys, 4)
+	assert.Len(t, data, 15)
+	for key, content := range data {
+		assert.Equal(t, key, string(content))
+	}
+	var batchErr BatchError
+	assert.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Failed(), 5)
+	for key, err := range batchErr.Failed() {
+		assert.ErrorIs(t, err, ErrNotFound, key)
+	}
+}
+
+func TestStatMany(t *testing.T) {
+	st := NewOSStore()
+	dir := t.TempDir()
+	var keys []string
+	for i := 0; i < 10; i++ {
+		key := filepath.Join(dir, fmt.Sprintf("%02d.bin", i))
+		keys = append(keys, key)
+		if i%2 == 0 {
+			assert.NoError(t, st.UploadData(make([]byte, i), key))
+		}
+	}
+	invalid := dir + "/../escape"
+	keys = append(keys, invalid)
+
+	stats, err := StatMany(st, keys, 4)
+	assert.Len(t, stats, 5)
+	for key, stat := range stats {
+		assert.Equal(t, key, filepath.Join(dir, fmt.Sprintf("%02d.bin", stat.Size)))
+	}
+	var batchErr BatchError
+	assert.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Failed(), 6)
+	assert.ErrorIs(t, batchErr.Failed()[invalid], ErrInvalidKey)
+
+	exists, err := ExistsMany(st, keys, 4)
+	assert.Len(t, exists, 10)
+	for i, key := range keys[:10] {
+		assert.Equal(t, i%2 == 0, exists[key], key)
+	}
+	assert.ErrorAs(t, err, &batchErr)
+	assert.Len(t, batchErr.Failed(), 1)
+	assert.ErrorIs(t, err, ErrInvalidKey)
+}
+
+func TestRunBatch_Concurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	keys := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	results, err := runBatch(keys, 3, func(key string) (int, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for p := peak.Load(); n > p && !peak.CompareAndSwap(p, n); p = peak.Load() {
+		}
+		time.Sleep(10 * time.Millisecond)
+		return len(key), nil
+	})
+	assert.Len(t, results, len(keys))
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, peak.Load(), int32(3), "no more than 3 keys should run at the same time")
+}
+
+func TestBatchError(t *testing.T) {
+	assert.NoError(t, newBatchError(3, nil))
+
+	err := newBatchError(10, map[string]error{
+		"a": fmt.Errorf("stat a: %w", ErrNotFound),
+		"b": ErrReadOnly,
+		"c": errors.New("boom"),
+		"d": errors.New("boom"),
+	})
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.NotErrorIs(t, err, ErrAuthFailed)
+	assert.Equal(t, "4 of 10 keys failed; a: stat a: object not found; b: store is read-only; c: boom; and 1 more", err.Error())
+
+	var batchErr BatchError
+	assert.ErrorAs(t, fmt.Errorf("load index: %w", err), &batchErr)
+	assert.Len(t, batchErr.Failed(), 4)
+
+	var pathErr *fs.PathError
+	err = newBatchError(1, map[string]error{"x": fmt.Errorf("%w: %w", ErrNotFound, &fs.PathError{Op: "open", Path: "x", Err: fs.ErrNotExist})})
+	assert.ErrorAs(t, err, &pathErr)
+	assert.Equal(t, "x", pathErr.Path)
+}
@@ -0,0 +1,373 @@
+package store
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const cacheTempPattern = ".cache-tmp-*"
+
+var (
+	_ Interface = &CacheStore{}
+	_ io.Closer = &CacheStore{}
+)
+
+// CacheStore is a read-through cache of a remote store on the local disk.
+// Whole-object downloads are served from the cache, which is filled on a
+// miss and keeps the most recently used objects up to maxBytes in total.
+//
+// Range reads are served from the cache when the object is cached, and go
+// to the remote without filling the cache otherwise, so sparse reads of
+// large objects don't evict everything else. Writes and deletes go through
+// to the remote and invalidate the cached copies. Stat, Exists and
+// ListPrefix always ask the remote.
+//
+// The cache only sees the writes made through the CacheStore, so objects
+// changed on the remote by other writers are served stale until they are
+// evicted.
+type CacheStore struct {
+	remote   Interface
+	dir      string
+	maxBytes int64
+	log      *logger
+
+	lk      sync.Mutex
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+	size    int64
+	// epoch is bumped by every invalidation. A fill started before an
+	// invalidation is dropped, it may hold the old content.
+	epoch uint64
+}
+
+type cacheEntry struct {
+	key  string
+	path string
+	size int64
+}
+
+// NewCacheStore creates a CacheStore caching the objects of remote in
+// cacheDir, keeping up to maxBytes of them. Cache files left in cacheDir by
+// a previous CacheStore are removed, they may be stale.
+func NewCacheStore(remote Interface, cacheDir string, maxBytes int64, opts ...Option) Interface {
+	o := newOptions(opts)
+	s := &CacheStore{
+		remote:   remote,
+		dir:      cacheDir,
+		maxBytes: maxBytes,
+		log:      newLogger(o.logger),
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+	}
+	s.removeLeftovers()
+	return s
+}
+
+// removeLeftovers removes the cache and temporary files in the cache
+// directory, leaving anything else alone.
+func (s *CacheStore) removeLeftovers() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		name := e.Name()
+		tmp, _ := filepath.Match(cacheTempPattern, name)
+		if _, err := hex.DecodeString(name); (err == nil && len(name) == sha256.Size*2) || tmp {
+			if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+				s.log.Debugw("remove stale cache file", "file", name, "error", err)
+			}
+		}
+	}
+}
+
+func (s *CacheStore) cachePath(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+// open opens the cached copy of key, marking it as recently used. It
+// returns nil on a miss.
+func (s *CacheStore) open(key string) *os.File {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	el, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	entry := el.Value.(*cacheEntry)
+	f, err := os.Open(entry.path)
+	if err != nil {
+		s.log.Debugw("open cache file", "key", key, "error", err)
+		s.removeLocked(el)
+		return nil
+	}
+	s.lru.MoveToFront(el)
+	return f
+}
+
+// fillEpoch returns the epoch a fill of the cache has to be started in.
+func (s *CacheStore) fillEpoch() uint64 {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	return s.epoch
+}
+
+// add moves the complete temporary file tmp into the cache as the copy of
+// key and evicts the least recently used objects beyond maxBytes. The file
+// is dropped if the cache was invalidated since epoch or it doesn't fit.
+func (s *CacheStore) add(key string, tmp string, size int64, epoch uint64) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if epoch != s.epoch || size > s.maxBytes {
+		_ = os.Remove(tmp)
+		return
+	}
+	if el, ok := s.entries[key]; ok {
+		s.removeLocked(el)
+	}
+	path := s.cachePath(key)
+	if err := os.Rename(tmp, path); err != nil {
+		s.log.Debugw("add cache file", "key", key, "error", err)
+		_ = os.Remove(tmp)
+		return
+	}
+	s.entries[key] = s.lru.PushFront(&cacheEntry{key: key, path: path, size: size})
+	s.size += size
+	for s.size > s.maxBytes {
+		s.removeLocked(s.lru.Back())
+	}
+}
+
+// addData caches data as the copy of key.
+func (s *CacheStore) addData(key string, data []byte, epoch uint64) {
+	if int64(len(data)) > s.maxBytes {
+		return
+	}
+	f, err := s.createTemp()
+	if err != nil {
+		s.log.Debugw("create cache file", "key", key, "error", err)
+		return
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		s.log.Debugw("write cache file", "key", key, "error", err)
+		_ = os.Remove(f.Name())
+		return
+	}
+	s.add(key, f.Name(), int64(len(data)), epoch)
+}
+
+func (s *CacheStore) createTemp() (*os.File, error) {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(s.dir, cacheTempPattern)
+}
+
+func (s *CacheStore) removeLocked(el *list.Element) {
+	entry := s.lru.Remove(el).(*cacheEntry)
+	delete(s.entries, entry.key)
+	s.size -= entry.size
+	if err := os.Remove(entry.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Debugw("remove cache file", "key", entry.key, "error", err)
+	}
+}
+
+// invalidate drops the cached copies of the keys matched by match and
+// cancels the fills in flight.
+func (s *CacheStore) invalidate(match func(key string) bool) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	s.epoch++
+	for key, el := range s.entries {
+		if match(key) {
+			s.removeLocked(el)
+		}
+	}
+}
+
+func (s *CacheStore) invalidateKey(key string) {
+	s.invalidate(func(k string) bool {
+		return k == key
+	})
+}
+
+// write runs a write to the remote, invalidating key before and after it so
+// neither the old cached copy nor a fill racing with the write survives.
+func (s *CacheStore) write(key string, fn func() error) error {
+	s.invalidateKey(key)
+	defer s.invalidateKey(key)
+	return fn()
+}
+
+func (s *CacheStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	return s.write(key, func() error {
+		return s.remote.UploadData(data, key, opts...)
+	})
+}
+
+func (s *CacheStore) Upload(file string, key string, opts ...UploadOption) error {
+	return s.write(key, func() error {
+		return s.remote.Upload(file, key, opts...)
+	})
+}
+
+func (s *CacheStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	return s.write(key, func() error {
+		return s.remote.UploadReader(reader, size, key, opts...)
+	})
+}
+
+func (s *CacheStore) Delete(key string) error {
+	return s.write(key, func() error {
+		return s.remote.Delete(key)
+	})
+}
+
+func (s *CacheStore) DeleteDirectory(dir string) error {
+	match := func(key string) bool {
+		return strings.HasPrefix(key, dir)
+	}
+	s.invalidate(match)
+	defer s.invalidate(match)
+	return s.remote.DeleteDirectory(dir)
+}
+
+func (s *CacheStore) Exists(key string) (bool, error) {
+	return s.remote.Exists(key)
+}
+
+func (s *CacheStore) Stat(key string) (FileStat, error) {
+	return s.remote.Stat(key)
+}
+
+func (s *CacheStore) ListPrefix(key string) ([]string, error) {
+	return s.remote.ListPrefix(key)
+}
+
+// DownloadBytes reads the cached copy of key, downloading and caching it on
+// a miss.
+func (s *CacheStore) DownloadBytes(key string) ([]byte, error) {
+	if f := s.open(key); f != nil {
+		defer f.Close() // nolint: errcheck
+		return io.ReadAll(f)
+	}
+	epoch := s.fillEpoch()
+	data, err := s.remote.DownloadBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	s.addData(key, data, epoch)
+	return data, nil
+}
+
+// DownloadReader reads the cached copy of key. On a miss, the object is
+// streamed from the remote and cached as it is read; it's only cached if
+// it's read to the end before the reader is closed.
+func (s *CacheStore) DownloadReader(key string) (io.ReadCloser, error) {
+	if f := s.open(key); f != nil {
+		return f, nil
+	}
+	epoch := s.fillEpoch()
+	r, err := s.remote.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := s.createTemp()
+	if err != nil {
+		s.log.Debugw("create cache file", "key", key, "error", err)
+		return r, nil
+	}
+	return &cacheFillReader{ReadCloser: r, s: s, key: key, epoch: epoch, tmp: tmp}, nil
+}
+
+func (s *CacheStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	f := s.open(key)
+	if f == nil {
+		return s.remote.DownloadRangeBytes(key, offset, size)
+	}
+	defer f.Close() // nolint: errcheck
+	return io.ReadAll(fileRange(f, offset, size))
+}
+
+func (s *CacheStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	f := s.open(key)
+	if f == nil {
+		return s.remote.DownloadRangeReader(key, offset, size)
+	}
+	return &rangeReaderCloser{Reader: fileRange(f, offset, size), closer: f.Close}, nil
+}
+
+// fileRange returns the size bytes of f from offset, to the end if size is
+// negative.
+func fileRange(f *os.File, offset int64, size int64) io.Reader {
+	if size < 0 {
+		return io.NewSectionReader(f, offset, 1<<63-1-offset)
+	}
+	return io.NewSectionReader(f, offset, size)
+}
+
+// Close closes the remote. The cache files are left in place.
+func (s *CacheStore) Close() error {
+	return closeStore(s.remote)
+}
+
+// cacheFillReader copies the object read from the remote into a temporary
+// file, which is added to the cache when the object has been read to the
+// end.
+type cacheFillReader struct {
+	io.ReadCloser
+	s      *CacheStore
+	key    string
+	epoch  uint64
+	tmp    *os.File
+	n      int64
+	eof    bool
+	failed bool
+}
+
+func (r *cacheFillReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 && !r.failed {
+		r.n += int64(n)
+		if r.n > r.s.maxBytes {
+			r.failed = true
+		} else if _, werr := r.tmp.Write(p[:n]); werr != nil {
+			r.s.log.Debugw("write cache file", "key", r.key, "error", werr)
+			r.failed = true
+		}
+	}
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+func (r *cacheFillReader) Close() error {
+	err := r.ReadCloser.Close()
+	if r.tmp == nil {
+		return err
+	}
+	tmp := r.tmp
+	r.tmp = nil
+	if cerr := tmp.Close(); cerr != nil {
+		r.failed = true
+	}
+	if r.eof && !r.failed {
+		r.s.add(r.key, tmp.Name(), r.n, r.epoch)
+	} else {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,151 @@
+package store
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// downloadCountingStore counts the downloads of the wrapped store.
+type downloadCountingStore struct {
+	Interface
+	downloads atomic.Int64
+}
+
+func (s *downloadCountingStore) DownloadBytes(key string) ([]byte, error) {
+	s.downloads.Add(1)
+	return s.Interface.DownloadBytes(key)
+}
+
+func (s *downloadCountingStore) DownloadReader(key string) (io.ReadCloser, error) {
+	s.downloads.Add(1)
+	return s.Interface.DownloadReader(key)
+}
+
+func (s *downloadCountingStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	s.downloads.Add(1)
+	return s.Interface.DownloadRangeBytes(key, offset, size)
+}
+
+func TestCacheStore_Suite(t *testing.T) {
+	testAll(t, NewCacheStore(NewMemStore(), t.TempDir(), 1<<20), "cache")
+}
+
+func TestCacheStore(t *testing.T) {
+	remote := &downloadCountingStore{Interface: NewMemStore()}
+	st := NewCacheStore(remote, t.TempDir(), 1<<20)
+	assert.NoError(t, st.UploadData([]byte("v1"), "a.txt"))
+
+	for i := 0; i < 3; i++ {
+		data, err := st.DownloadBytes("a.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "v1", string(data))
+	}
+	assert.Equal(t, int64(1), remote.downloads.Load(), "repeated reads should hit the cache")
+
+	data, err := st.DownloadRangeBytes("a.txt", 1, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", string(data))
+	assert.Equal(t, int64(1), remote.downloads.Load(), "range reads of cached objects should hit the cache")
+
+	assert.NoError(t, st.UploadData([]byte("v2"), "a.txt"))
+	data, err = st.DownloadBytes("a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", string(data), "writes should invalidate the cached copy")
+	assert.Equal(t, int64(2), remote.downloads.Load())
+
+	assert.NoError(t, st.Delete("a.txt"))
+	_, err = st.DownloadBytes("a.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCacheStore_DownloadReader(t *testing.T) {
+	remote := &downloadCountingStore{Interface: NewMemStore()}
+	st := NewCacheStore(remote, t.TempDir(), 1<<20)
+	assert.NoError(t, st.UploadData([]byte("streamed"), "r.txt"))
+
+	// a partial read isn't cached
+	r, err := st.DownloadReader("r.txt")
+	assert.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+
+	for i := 0; i < 2; i++ {
+		r, err = st.DownloadReader("r.txt")
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, "streamed", string(data))
+	}
+	assert.Equal(t, int64(2), remote.downloads.Load())
+}
+
+func TestCacheStore_Eviction(t *testing.T) {
+	remote := &downloadCountingStore{Interface: NewMemStore()}
+	dir := t.TempDir()
+	st := NewCacheStore(remote, dir, 10).(*CacheStore)
+	for _, key := range []string{"a", "b", "c", "big"} {
+		size := 4
+		if key == "big" {
+			size = 11
+		}
+		assert.NoError(t, st.UploadData(make([]byte, size), key))
+	}
+
+	read := func(key string) {
+		_, err := st.DownloadBytes(key)
+		assert.NoError(t, err)
+	}
+	read("a")
+	read("b")
+	read("a")
+	read("c") // evicts b, the least recently used
+	assert.Equal(t, int64(8), st.size)
+	assert.Equal(t, int64(3), remote.downloads.Load())
+
+	read("a")
+	read("c")
+	assert.Equal(t, int64(3), remote.downloads.Load())
+	read("b")
+	assert.Equal(t, int64(4), remote.downloads.Load())
+
+	read("big") // larger than the cache, never cached
+	read("big")
+	assert.Equal(t, int64(6), remote.downloads.Load())
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 2)
+
+	// a new cache doesn't trust the files of the previous one
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "keep.txt"), []byte("mine"), 0644))
+	NewCacheStore(remote, dir, 10)
+	files, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+	assert.Equal(t, "keep.txt", files[0].Name())
+}
+
+func TestCacheStore_RangeMiss(t *testing.T) {
+	remote := &downloadCountingStore{Interface: NewMemStore()}
+	st := NewCacheStore(remote, t.TempDir(), 1<<20).(*CacheStore)
+	assert.NoError(t, st.UploadData([]byte("0123456789"), "range.bin"))
+
+	data, err := st.DownloadRangeBytes("range.bin", 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "234", string(data))
+	assert.Zero(t, st.size, "range reads shouldn't fill the cache")
+
+	r, err := st.DownloadRangeReader("range.bin", 7, -1)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "789", string(data))
+}
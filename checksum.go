@@ -0,0 +1,106 @@
+package store
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// ChecksumAlgorithm is the hash used to verify downloads.
+type ChecksumAlgorithm string
+
+const (
+	// ChecksumMD5 verifies against the ETag, which is the MD5 of single
+	// part S3 objects. This is the default.
+	ChecksumMD5 ChecksumAlgorithm = "md5"
+	// ChecksumSHA256 verifies against the SHA-256 stored in the object
+	// metadata.
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	// ChecksumCRC32C verifies against the CRC32C stored in the object
+	// metadata.
+	ChecksumCRC32C ChecksumAlgorithm = "crc32c"
+)
+
+// ErrChecksumMismatch is returned, possibly wrapped, when the downloaded data
+// doesn't match the checksum stored with the object.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// VerifiedDownloader is implemented by stores that can check the integrity
+// of downloaded objects.
+type VerifiedDownloader interface {
+	// DownloadBytesVerified downloads the object and checks it against its
+	// stored checksum. It fails with ErrChecksumMismatch instead of
+	// returning corrupt data, and if the object has no checksum to check.
+	DownloadBytesVerified(key string) ([]byte, error)
+}
+
+func (a ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case ChecksumMD5, "":
+		return md5.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	default:
+		return nil, fmt.Errorf("unknown checksum algorithm %q", a)
+	}
+}
+
+// metadataKey is the user metadata holding the hex checksum of an object,
+// without the X-Amz-Meta- prefix.
+func (a ChecksumAlgorithm) metadataKey() string {
+	switch a {
+	case ChecksumSHA256:
+		return "Sha256"
+	case ChecksumCRC32C:
+		return "Crc32c"
+	default:
+		return "Md5"
+	}
+}
+
+// amzHeader is the S3 header the server verifies the base64 checksum of an
+// upload against, if there's one for a.
+func (a ChecksumAlgorithm) amzHeader() string {
+	switch a {
+	case ChecksumSHA256:
+		return "X-Amz-Checksum-Sha256"
+	case ChecksumCRC32C:
+		return "X-Amz-Checksum-Crc32c"
+	default:
+		return ""
+	}
+}
+
+// s3Checksum returns the hex checksum of an object with algorithm a. The
+// ETag is used for MD5 unless it's the ETag of a multipart upload, which
+// ends with the number of parts; otherwise the checksum is read from the
+// user metadata.
+func (a ChecksumAlgorithm) s3Checksum(info minio.ObjectInfo) (string, bool) {
+	if (a == ChecksumMD5 || a == "") && len(info.ETag) == md5.Size*2 && !strings.Contains(info.ETag, "-") {
+		return info.ETag, true
+	}
+	sum, ok := info.UserMetadata[a.metadataKey()]
+	return sum, ok && sum != ""
+}
+
+// verifyChecksum checks data against the hex checksum sum.
+func (a ChecksumAlgorithm) verifyChecksum(data []byte, sum string) error {
+	h, err := a.newHash()
+	if err != nil {
+		return err
+	}
+	h.Write(data)
+	if got := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(got, sum) {
+		return fmt.Errorf("%s is %s, expected %s: %w", a, got, sum, ErrChecksumMismatch)
+	}
+	return nil
+}
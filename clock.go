@@ -0,0 +1,35 @@
+package store
+
+import (
+	"sync"
+	"time"
+)
+
+var (
+	clockLk sync.RWMutex
+	clockFn = time.Now
+)
+
+// now returns the current time. Time-dependent features such as recycle
+// purging and object expiry use it instead of time.Now so tests can control
+// the passage of time.
+func now() time.Time {
+	clockLk.RLock()
+	defer clockLk.RUnlock()
+	return clockFn()
+}
+
+// SetClockForTest replaces the clock used by time-dependent features and
+// returns a function that restores the previous clock.
+// It's intended for tests only.
+func SetClockForTest(fn func() time.Time) (restore func()) {
+	clockLk.Lock()
+	defer clockLk.Unlock()
+	prev := clockFn
+	clockFn = fn
+	return func() {
+		clockLk.Lock()
+		defer clockLk.Unlock()
+		clockFn = prev
+	}
+}
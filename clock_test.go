@@ -0,0 +1,49 @@
+package store
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeClock is a manually advanced clock for tests.
+type fakeClock struct {
+	lk sync.Mutex
+	t  time.Time
+}
+
+func newFakeClock(t *testing.T, start time.Time) *fakeClock {
+	c := &fakeClock{t: start}
+	t.Cleanup(SetClockForTest(c.Now))
+	return c
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	return c.t
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.lk.Lock()
+	defer c.lk.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestSetClockForTest(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	restore := SetClockForTest(func() time.Time { return start })
+	assert.Equal(t, start, now())
+
+	restore()
+	assert.WithinDuration(t, time.Now(), now(), time.Minute, "restore should bring back the real clock")
+}
+
+func TestFakeClock_Advance(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	c := newFakeClock(t, start)
+	c.Advance(time.Hour)
+	assert.Equal(t, start.Add(time.Hour), now())
+}
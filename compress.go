@@ -0,0 +1,319 @@
+package store
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression algorithms of a CompressStore.
+const (
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// Compressed objects are laid out as
+//
+//	magic | algorithm | uncompressed size | compressed stream
+//
+// where the uncompressed size is a big-endian int64, -1 if it wasn't known
+// when the object was uploaded. Objects without the magic are read as is,
+// so a CompressStore can be put in front of a store holding raw objects.
+const (
+	compressMagic     = "SCZ1"
+	compressHeaderLen = len(compressMagic) + 1 + 8
+)
+
+var (
+	_ Interface = &CompressStore{}
+	_ io.Closer = &CompressStore{}
+)
+
+var compressAlgorithmIDs = map[string]byte{
+	CompressGzip: 'g',
+	CompressZstd: 'z',
+}
+
+// CompressStore compresses objects before writing them to the wrapped store
+// and decompresses them on download.
+//
+// Compressed streams can't be decompressed from an arbitrary offset, so
+// range reads decompress the object from its start and discard the bytes
+// before the range. Stat reads the header of the object to report its
+// uncompressed size, and has to decompress the objects uploaded with
+// UploadReader and an unknown size.
+type CompressStore struct {
+	inner Interface
+	algo  byte
+}
+
+// NewCompressStore creates a CompressStore compressing with algo,
+// CompressGzip or CompressZstd. Objects compressed with either algorithm are
+// read back regardless of algo.
+func NewCompressStore(inner Interface, algo string) (Interface, error) {
+	id, ok := compressAlgorithmIDs[algo]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression algorithm %q", algo)
+	}
+	return &CompressStore{inner: inner, algo: id}, nil
+}
+
+func compressHeader(algo byte, size int64) []byte {
+	header := make([]byte, 0, compressHeaderLen)
+	header = append(header, compressMagic...)
+	header = append(header, algo)
+	return binary.BigEndian.AppendUint64(header, uint64(size))
+}
+
+// parseCompressHeader returns the algorithm and uncompressed size recorded
+// in header, ok is false if header isn't the header of a compressed object.
+func parseCompressHeader(header []byte) (algo byte, size int64, ok bool) {
+	if len(header) < compressHeaderLen || string(header[:len(compressMagic)]) != compressMagic {
+		return 0, 0, false
+	}
+	algo = header[len(compressMagic)]
+	size = int64(binary.BigEndian.Uint64(header[len(compressMagic)+1:]))
+	return algo, size, true
+}
+
+// compress writes the compressed content of r to w, returning the number of
+// bytes read from r.
+func (s *CompressStore) compress(w io.Writer, r io.Reader) (int64, error) {
+	var zw io.WriteCloser
+	switch s.algo {
+	case 'g':
+		zw = gzip.NewWriter(w)
+	default:
+		enc, err := zstd.NewWriter(w)
+		if err != nil {
+			return 0, err
+		}
+		zw = enc
+	}
+	n, err := io.Copy(zw, r)
+	if cerr := zw.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// decompressReader reads the decompressed content of r, which has to be
+// compressed with algo.
+func decompressReader(algo byte, r io.Reader) (io.ReadCloser, error) {
+	switch algo {
+	case 'g':
+		return gzip.NewReader(r)
+	case 'z':
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown compression algorithm id %q", algo)
+	}
+}
+
+// compressUploadOptions returns the options of the upload of the compressed
+// data. ContentHash and Progress are handled on the uncompressed data.
+func compressUploadOptions(opts []UploadOption) []UploadOption {
+	return append(slices.Clip(opts), ContentHash("", nil), Progress(nil))
+}
+
+// UploadData compresses data before uploading it. ContentHash hashes and
+// Progress counts the uncompressed data.
+func (s *CompressStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	buf := bytes.NewBuffer(compressHeader(s.algo, int64(len(data))))
+	if _, err := s.compress(buf, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("compress %s: %w", key, err)
+	}
+	if err := s.inner.UploadData(buf.Bytes(), key, compressUploadOptions(opts)...); err != nil {
+		return err
+	}
+	hasher.data(data)
+	hasher.done()
+	if o.Progress != nil {
+		o.Progress(int64(len(data)), int64(len(data)))
+	}
+	return nil
+}
+
+func (s *CompressStore) Upload(file string, key string, opts ...UploadOption) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.UploadReader(f, fi.Size(), key, opts...)
+}
+
+// UploadReader compresses the content of reader as it is uploaded. The
+// compressed size isn't known up front, so the wrapped store is passed a
+// size of -1.
+func (s *CompressStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		_, err := pw.Write(compressHeader(s.algo, size))
+		if err == nil {
+			_, err = s.compress(pw, newProgressReader(hasher.reader(reader), size, o.Progress))
+		}
+		_ = pw.CloseWithError(err)
+	}()
+	err = s.inner.UploadReader(pr, -1, key, compressUploadOptions(opts)...)
+	// stop the compression if the upload gave up early
+	_ = pr.CloseWithError(err)
+	if err != nil {
+		return err
+	}
+	hasher.done()
+	return nil
+}
+
+func (s *CompressStore) DeleteDirectory(dir string) error {
+	return s.inner.DeleteDirectory(dir)
+}
+
+func (s *CompressStore) Delete(key string) error {
+	return s.inner.Delete(key)
+}
+
+func (s *CompressStore) Exists(key string) (bool, error) {
+	return s.inner.Exists(key)
+}
+
+// Stat returns the uncompressed size of the object.
+func (s *CompressStore) Stat(key string) (FileStat, error) {
+	stat, err := s.inner.Stat(key)
+	if err != nil {
+		return stat, err
+	}
+	header, err := s.inner.DownloadRangeBytes(key, 0, int64(compressHeaderLen))
+	if err != nil {
+		return FileStat{}, err
+	}
+	_, size, ok := parseCompressHeader(header)
+	switch {
+	case !ok:
+	case size >= 0:
+		stat.Size = size
+	default:
+		r, err := s.DownloadReader(key)
+		if err != nil {
+			return FileStat{}, err
+		}
+		defer r.Close() // nolint: errcheck
+		if stat.Size, err = io.Copy(io.Discard, r); err != nil {
+			return FileStat{}, fmt.Errorf("decompress %s: %w", key, err)
+		}
+	}
+	return stat, nil
+}
+
+func (s *CompressStore) DownloadBytes(key string) ([]byte, error) {
+	r, err := s.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint: errcheck
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("decompress %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// DownloadReader decompresses the object as it is read. Objects that
+// weren't compressed are returned as is.
+func (s *CompressStore) DownloadReader(key string) (io.ReadCloser, error) {
+	r, err := s.inner.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, compressHeaderLen)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		_ = r.Close()
+		return nil, err
+	}
+	algo, _, ok := parseCompressHeader(header[:n])
+	if !ok {
+		return &rangeReaderCloser{Reader: io.MultiReader(bytes.NewReader(header[:n]), r), closer: r.Close}, nil
+	}
+	zr, err := decompressReader(algo, r)
+	if err != nil {
+		_ = r.Close()
+		return nil, fmt.Errorf("decompress %s: %w", key, err)
+	}
+	return &rangeReaderCloser{Reader: zr, closer: func() error {
+		_ = zr.Close()
+		return r.Close()
+	}}, nil
+}
+
+// DownloadRangeBytes decompresses the object up to the end of the range.
+func (s *CompressStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	r, err := s.DownloadRangeReader(key, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close() // nolint: errcheck
+	return io.ReadAll(r)
+}
+
+// DownloadRangeReader decompresses the object from its start, discarding
+// the bytes before offset. Range reads of objects that weren't compressed
+// go to the wrapped store.
+func (s *CompressStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	header, err := s.inner.DownloadRangeBytes(key, 0, int64(compressHeaderLen))
+	if err != nil {
+		return nil, err
+	}
+	if _, _, ok := parseCompressHeader(header); !ok {
+		return s.inner.DownloadRangeReader(key, offset, size)
+	}
+	r, err := s.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, r, offset); err != nil && !errors.Is(err, io.EOF) {
+		_ = r.Close()
+		return nil, fmt.Errorf("decompress %s: %w", key, err)
+	}
+	if size < 0 {
+		return r, nil
+	}
+	return &rangeReaderCloser{Reader: io.LimitReader(r, size), closer: r.Close}, nil
+}
+
+func (s *CompressStore) ListPrefix(key string) ([]string, error) {
+	return s.inner.ListPrefix(key)
+}
+
+// Close closes the wrapped store.
+func (s *CompressStore) Close() error {
+	return closeStore(s.inner)
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompressStore_Suite(t *testing.T) {
+	for _, algo := range []string{CompressGzip, CompressZstd} {
+		t.Run(algo, func(t *testing.T) {
+			st, err := NewCompressStore(NewMemStore(), algo)
+			assert.NoError(t, err)
+			testAll(t, st, "compress-"+algo)
+		})
+	}
+}
+
+func TestCompressStore(t *testing.T) {
+	inner := NewMemStore()
+	st, err := NewCompressStore(inner, CompressZstd)
+	assert.NoError(t, err)
+	logs := bytes.Repeat([]byte("2024-01-01T00:00:00Z INFO request served\n"), 1000)
+
+	var res UploadResult
+	assert.NoError(t, st.UploadData(logs, "data.log", ContentHash(ChecksumSHA256, &res)))
+	stored, err := inner.Stat("data.log")
+	assert.NoError(t, err)
+	assert.Less(t, stored.Size, int64(len(logs))/10, "the object should be stored compressed")
+	assert.Equal(t, int64(len(logs)), res.Size, "the uncompressed data should be hashed")
+
+	stat, err := st.Stat("data.log")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(logs)), stat.Size)
+	data, err := st.DownloadBytes("data.log")
+	assert.NoError(t, err)
+	assert.Equal(t, logs, data)
+	data, err = st.DownloadRangeBytes("data.log", 42, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, logs[42:62], data)
+
+	// streamed uploads of unknown size
+	assert.NoError(t, st.UploadReader(bytes.NewReader(logs), -1, "stream.log"))
+	stat, err = st.Stat("stream.log")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(logs)), stat.Size)
+	r, err := st.DownloadRangeReader("stream.log", int64(len(logs))-5, -1)
+	assert.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, logs[len(logs)-5:], data)
+
+	file := filepath.Join(t.TempDir(), "file.log")
+	assert.NoError(t, os.WriteFile(file, logs, 0644))
+	assert.NoError(t, st.Upload(file, "file.log"))
+	data, err = st.DownloadBytes("file.log")
+	assert.NoError(t, err)
+	assert.Equal(t, logs, data)
+}
+
+func TestCompressStore_RawObjects(t *testing.T) {
+	inner := NewMemStore()
+	assert.NoError(t, inner.UploadData([]byte("raw object"), "raw.txt"))
+	assert.NoError(t, inner.UploadData([]byte("tiny"), "tiny.txt"))
+	gz, err := NewCompressStore(inner, CompressGzip)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.UploadData([]byte("gzipped"), "gz.txt"))
+
+	st, err := NewCompressStore(inner, CompressZstd)
+	assert.NoError(t, err)
+	for key, want := range map[string]string{"raw.txt": "raw object", "tiny.txt": "tiny", "gz.txt": "gzipped"} {
+		data, err := st.DownloadBytes(key)
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data), key)
+		stat, err := st.Stat(key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(want)), stat.Size, key)
+	}
+	data, err := st.DownloadRangeBytes("raw.txt", 4, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "obj", string(data))
+
+	_, err = NewCompressStore(inner, "lz4")
+	assert.Error(t, err)
+}
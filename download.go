@@ -0,0 +1,74 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// readAll reads r into memory like io.ReadAll, failing with ErrTooLarge if
+// it holds more than max bytes. A max of zero or less doesn't limit.
+func readAll(r io.Reader, max int64) ([]byte, error) {
+	if max <= 0 {
+		return io.ReadAll(r)
+	}
+	data, err := io.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, max)
+	}
+	return data, nil
+}
+
+// DownloadConditions make a download conditional on the object having
+// changed since the caller last read it, for client-side caching.
+type DownloadConditions struct {
+	// IfNoneMatch is the ETag of the cached object, as returned by Stat.
+	IfNoneMatch string
+	// IfModifiedSince is the time the object was cached. Like HTTP, it has
+	// a resolution of one second and is ignored if IfNoneMatch is set.
+	IfModifiedSince time.Time
+}
+
+// ConditionalDownloader is implemented by stores that can skip downloading
+// an object that hasn't changed.
+type ConditionalDownloader interface {
+	// DownloadReaderIf opens the object like DownloadReader, or returns
+	// ErrNotModified if the object matches cond.
+	DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error)
+}
+
+// notModified reports whether an object with etag and modTime is unchanged
+// according to c. It's used by the backends without conditional downloads of
+// their own.
+func (c DownloadConditions) notModified(etag string, modTime time.Time) bool {
+	if c.IfNoneMatch != "" {
+		return c.IfNoneMatch == etag
+	}
+	if !c.IfModifiedSince.IsZero() {
+		return !modTime.Truncate(time.Second).After(c.IfModifiedSince.Truncate(time.Second))
+	}
+	return false
+}
+
+// ParallelDownloader is implemented by stores that can download an object
+// with concurrent range requests.
+type ParallelDownloader interface {
+	// DownloadParallel reads the object in parts of partSize bytes, with up
+	// to concurrency parts downloaded or buffered at the same time, and
+	// returns them as one ordered stream. Closing the reader cancels the
+	// parts still downloading.
+	DownloadParallel(key string, partSize int64, concurrency int) (io.ReadCloser, error)
+}
+
+// DownloadParallel downloads key from st with concurrent range requests if
+// st is a ParallelDownloader. Other stores, such as OSStore, which gains
+// nothing from it, are read with DownloadReader.
+func DownloadParallel(st Interface, key string, partSize int64, concurrency int) (io.ReadCloser, error) {
+	if pd, ok := st.(ParallelDownloader); ok {
+		return pd.DownloadParallel(key, partSize, concurrency)
+	}
+	return st.DownloadReader(key)
+}
@@ -0,0 +1,613 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"slices"
+	"sync"
+)
+
+// Encrypted objects are laid out as
+//
+//	magic | key id | nonce prefix | segment...
+//
+// where the key id identifies the key the object was encrypted with, so
+// objects stay readable while keys are rotated. The plaintext is sealed with
+// AES-256-GCM in segments of encryptSegmentSize bytes, the last one shorter
+// or empty, so objects can be encrypted and decrypted as they are streamed.
+// As in the STREAM construction, the nonce of a segment is the nonce prefix,
+// the index of the segment and a flag set on the last one, so segments can't
+// be reordered, dropped or cut off unnoticed.
+const (
+	encryptMagic       = "SEN1"
+	encryptKeyIDSz     = 8
+	encryptNonceSz     = 12
+	encryptPrefixSz    = 7
+	encryptTagSz       = 16
+	encryptHeaderSz    = len(encryptMagic) + encryptKeyIDSz + encryptPrefixSz
+	encryptSegmentSize = 64 << 10
+	// encryptedSegmentSize is the size of a sealed full segment.
+	encryptedSegmentSize = encryptSegmentSize + encryptTagSz
+
+	defaultRotateConcurrency = 4
+	// rotateAttempts is the number of times RotateKey re-encrypts an object
+	// written to while it's being rotated.
+	rotateAttempts = 3
+)
+
+var (
+	_ Interface = &EncryptedStore{}
+	_ io.Closer = &EncryptedStore{}
+)
+
+type encryptKey struct {
+	id   string
+	aead cipher.AEAD
+}
+
+func newEncryptKey(key []byte) (*encryptKey, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid encryption key size %d, must be 32 bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(key)
+	return &encryptKey{id: string(sum[:encryptKeyIDSz]), aead: aead}, nil
+}
+
+// segmentNonce returns the nonce of segment i of an object.
+func (k *encryptKey) segmentNonce(prefix []byte, i uint32, last bool) []byte {
+	nonce := make([]byte, encryptNonceSz)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[encryptPrefixSz:], i)
+	if last {
+		nonce[encryptNonceSz-1] = 1
+	}
+	return nonce
+}
+
+// encryptedSize returns the size of a plaintext of size bytes once
+// encrypted, -1 if the size isn't known.
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return -1
+	}
+	segments := max(1, (size+encryptSegmentSize-1)/encryptSegmentSize)
+	return int64(encryptHeaderSz) + size + segments*encryptTagSz
+}
+
+// decryptedSize returns the size of the plaintext of an encrypted object of
+// size bytes.
+func decryptedSize(size int64) int64 {
+	size -= int64(encryptHeaderSz)
+	segments := (size + encryptedSegmentSize - 1) / encryptedSegmentSize
+	return max(0, size-segments*encryptTagSz)
+}
+
+// EncryptedStore encrypts objects with AES-256-GCM before writing them to the
+// wrapped store and decrypts them on download. Readers are encrypted and
+// decrypted as they are streamed, range reads decrypt the whole object.
+type EncryptedStore struct {
+	inner Interface
+	log   *logger
+
+	lk      sync.RWMutex
+	current *encryptKey
+	keys    map[string]*encryptKey
+
+	rotateConcurrency int
+}
+
+// NewEncryptedStore creates an EncryptedStore encrypting with key, which
+// must be 32 bytes long.
+func NewEncryptedStore(inner Interface, key []byte, opts ...Option) (*EncryptedStore, error) {
+	k, err := newEncryptKey(key)
+	if err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	return &EncryptedStore{
+		inner:             inner,
+		log:               newLogger(o.logger),
+		current:           k,
+		keys:              map[string]*encryptKey{k.id: k},
+		rotateConcurrency: defaultRotateConcurrency,
+	}, nil
+}
+
+// NewEncryptStore returns inner wrapped in an EncryptedStore encrypting
+// with key, which must be 32 bytes long. Use NewEncryptedStore to rotate
+// keys.
+func NewEncryptStore(inner Interface, key []byte, opts ...Option) (Interface, error) {
+	return NewEncryptedStore(inner, key, opts...)
+}
+
+// WithDecryptKeys adds keys that are only used to decrypt objects, such as
+// the previous key while a rotation is in progress.
+func (s *EncryptedStore) WithDecryptKeys(keys ...[]byte) (*EncryptedStore, error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for _, key := range keys {
+		k, err := newEncryptKey(key)
+		if err != nil {
+			return nil, err
+		}
+		s.keys[k.id] = k
+	}
+	return s, nil
+}
+
+// WithRotateConcurrency sets the number of objects RotateKey re-encrypts
+// concurrently. Defaults to 4.
+func (s *EncryptedStore) WithRotateConcurrency(n int) *EncryptedStore {
+	if n <= 0 {
+		n = defaultRotateConcurrency
+	}
+	s.rotateConcurrency = n
+	return s
+}
+
+func (s *EncryptedStore) currentKey() *encryptKey {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	return s.current
+}
+
+func (s *EncryptedStore) encrypt(k *encryptKey, plain []byte) ([]byte, error) {
+	r, err := newEncryptReader(k, bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// keyOf returns the key the encrypted object starting with head was sealed
+// with.
+func (s *EncryptedStore) keyOf(head []byte) (*encryptKey, error) {
+	if len(head) < len(encryptMagic)+encryptKeyIDSz || string(head[:len(encryptMagic)]) != encryptMagic {
+		return nil, errors.New("not an encrypted object")
+	}
+	id := string(head[len(encryptMagic) : len(encryptMagic)+encryptKeyIDSz])
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return k, nil
+}
+
+func (s *EncryptedStore) decrypt(data []byte) ([]byte, error) {
+	r, err := s.newDecryptReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+// encryptReader encrypts the plaintext read from src segment by segment.
+type encryptReader struct {
+	k      *encryptKey
+	src    *bufio.Reader
+	prefix []byte
+	index  uint32
+	buf    []byte
+	out    []byte
+	done   bool
+}
+
+func newEncryptReader(k *encryptKey, src io.Reader) (*encryptReader, error) {
+	prefix := make([]byte, encryptPrefixSz)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, fmt.Errorf("generate nonce: %w", err)
+	}
+	header := make([]byte, 0, encryptHeaderSz)
+	header = append(header, encryptMagic...)
+	header = append(header, k.id...)
+	header = append(header, prefix...)
+	return &encryptReader{
+		k:      k,
+		src:    bufio.NewReaderSize(src, encryptSegmentSize+1),
+		prefix: prefix,
+		out:    header,
+	}, nil
+}
+
+func (r *encryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.seal(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// seal encrypts the next segment. A segment is the last one if no byte
+// follows it.
+func (r *encryptReader) seal() error {
+	chunk, err := r.src.Peek(encryptSegmentSize + 1)
+	last := len(chunk) <= encryptSegmentSize
+	if err != nil && !(last && errors.Is(err, io.EOF)) {
+		return err
+	}
+	if !last {
+		chunk = chunk[:encryptSegmentSize]
+		if r.index == math.MaxUint32 {
+			return errors.New("object is too large to encrypt")
+		}
+	}
+	r.buf = r.k.aead.Seal(r.buf[:0], r.k.segmentNonce(r.prefix, r.index, last), chunk, nil)
+	r.out = r.buf
+	if _, err := r.src.Discard(len(chunk)); err != nil {
+		return err
+	}
+	r.index++
+	r.done = last
+	return nil
+}
+
+// decryptReader decrypts the segments of an object read from src.
+type decryptReader struct {
+	k      *encryptKey
+	src    *bufio.Reader
+	prefix []byte
+	index  uint32
+	buf    []byte
+	out    []byte
+	done   bool
+}
+
+// newDecryptReader reads the header of the encrypted object read from src
+// and returns a reader of its plaintext.
+func (s *EncryptedStore) newDecryptReader(src io.Reader) (io.Reader, error) {
+	br := bufio.NewReaderSize(src, encryptedSegmentSize+1)
+	head, err := br.Peek(len(encryptMagic) + encryptKeyIDSz)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	k, err := s.keyOf(head)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, encryptHeaderSz)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return nil, errors.New("encrypted object is truncated")
+	}
+	return &decryptReader{k: k, src: br, prefix: header[len(header)-encryptPrefixSz:]}, nil
+}
+
+func (r *decryptReader) Read(p []byte) (int, error) {
+	for len(r.out) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.out)
+	r.out = r.out[n:]
+	return n, nil
+}
+
+// open decrypts the next segment. A segment is the last one if no byte
+// follows it; a segment sealed as another one doesn't authenticate.
+func (r *decryptReader) open() error {
+	chunk, err := r.src.Peek(encryptedSegmentSize + 1)
+	last := len(chunk) <= encryptedSegmentSize
+	if err != nil && !(last && errors.Is(err, io.EOF)) {
+		return err
+	}
+	if !last {
+		chunk = chunk[:encryptedSegmentSize]
+	}
+	r.buf, err = r.k.aead.Open(r.buf[:0], r.k.segmentNonce(r.prefix, r.index, last), chunk, nil)
+	if err != nil {
+		return fmt.Errorf("segment %d: %w", r.index, err)
+	}
+	r.out = r.buf
+	if _, err := r.src.Discard(len(chunk)); err != nil {
+		return err
+	}
+	r.index++
+	r.done = last
+	return nil
+}
+
+func (s *EncryptedStore) download(key string) ([]byte, error) {
+	data, err := s.inner.DownloadBytes(key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := s.decrypt(data)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return plain, nil
+}
+
+// Stat returns the size of the decrypted object.
+func (s *EncryptedStore) Stat(key string) (FileStat, error) {
+	stat, err := s.inner.Stat(key)
+	if err != nil {
+		return stat, err
+	}
+	stat.Size = decryptedSize(stat.Size)
+	return stat, nil
+}
+
+// UploadData encrypts data before uploading it. ContentHash hashes the
+// plaintext, not the encrypted bytes, so the checksum identifies the content.
+func (s *EncryptedStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	hasher, err := NewUploadOptions(opts...).newContentHasher()
+	if err != nil {
+		return err
+	}
+	sealed, err := s.encrypt(s.currentKey(), data)
+	if err != nil {
+		return err
+	}
+	if err := s.inner.UploadData(sealed, key, append(slices.Clip(opts), ContentHash("", nil))...); err != nil {
+		return err
+	}
+	hasher.data(data)
+	hasher.done()
+	return nil
+}
+
+func (s *EncryptedStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return s.UploadReader(f, fi.Size(), key, opts...)
+}
+
+// UploadReader encrypts the content of reader as it is uploaded.
+func (s *EncryptedStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	hasher, err := NewUploadOptions(opts...).newContentHasher()
+	if err != nil {
+		return err
+	}
+	r, err := newEncryptReader(s.currentKey(), hasher.reader(reader))
+	if err != nil {
+		return err
+	}
+	if err := s.inner.UploadReader(r, encryptedSize(size), key, append(slices.Clip(opts), ContentHash("", nil))...); err != nil {
+		return err
+	}
+	hasher.done()
+	return nil
+}
+
+func (s *EncryptedStore) DeleteDirectory(dir string) (err error) {
+	return s.inner.DeleteDirectory(dir)
+}
+
+func (s *EncryptedStore) Delete(key string) (err error) {
+	return s.inner.Delete(key)
+}
+
+func (s *EncryptedStore) Exists(key string) (bool, error) {
+	return s.inner.Exists(key)
+}
+
+func (s *EncryptedStore) DownloadBytes(key string) ([]byte, error) {
+	return s.download(key)
+}
+
+// DownloadReader decrypts the object as it's read. Each segment is
+// authenticated before any of its bytes are returned.
+func (s *EncryptedStore) DownloadReader(key string) (io.ReadCloser, error) {
+	rc, err := s.inner.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.newDecryptReader(rc)
+	if err != nil {
+		_ = rc.Close()
+		return nil, fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	return &rangeReaderCloser{Reader: &decryptErrorReader{r: r, key: key}, closer: rc.Close}, nil
+}
+
+// decryptErrorReader adds the key to the errors of a decrypting reader.
+type decryptErrorReader struct {
+	r   io.Reader
+	key string
+}
+
+func (r *decryptErrorReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		err = fmt.Errorf("decrypt %s: %w", r.key, err)
+	}
+	return n, err
+}
+
+// DownloadRangeBytes decrypts the whole object and returns the range.
+func (s *EncryptedStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	data, err := s.download(key)
+	if err != nil {
+		return nil, err
+	}
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if size >= 0 && size < int64(len(data)) {
+		data = data[:size]
+	}
+	return data, nil
+}
+
+// DownloadRangeReader decrypts the whole object and returns the range.
+func (s *EncryptedStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	data, err := s.DownloadRangeBytes(key, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (s *EncryptedStore) ListPrefix(key string) ([]string, error) {
+	return s.inner.ListPrefix(key)
+}
+
+// RotateKey makes newKey the encryption key and re-encrypts every object
+// under prefix with it. Each object is streamed through decryption and
+// encryption into a temporary file, which replaces it in a single upload once
+// the object has been read whole. Objects carry the id of their key, so an
+// interrupted rotation leaves every object readable by either the old or the
+// new key; calling RotateKey again skips the objects already rotated. The old
+// key must stay available, e.g. via WithDecryptKeys, until RotateKey returns
+// without an error.
+//
+// The upload is conditional on the ETag the object had when its rotation
+// started, so an object written meanwhile isn't replaced with its previous
+// content; it's rotated again, up to 3 times. Objects of stores without ETags
+// are replaced unconditionally.
+func (s *EncryptedStore) RotateKey(prefix string, newKey []byte) error {
+	k, err := newEncryptKey(newKey)
+	if err != nil {
+		return err
+	}
+	s.lk.Lock()
+	s.current = k
+	s.keys[k.id] = k
+	s.lk.Unlock()
+
+	keys, err := s.inner.ListPrefix(prefix)
+	if err != nil {
+		return fmt.Errorf("list %s: %w", prefix, err)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		errLk    sync.Mutex
+		firstErr error
+		work     = make(chan string)
+	)
+	for i := 0; i < s.rotateConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range work {
+				if err := s.rotate(key, k); err != nil {
+					errLk.Lock()
+					if firstErr == nil {
+						firstErr = err
+					}
+					errLk.Unlock()
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		work <- key
+	}
+	close(work)
+	wg.Wait()
+	return firstErr
+}
+
+// rotate re-encrypts the object with k unless it already uses k, starting
+// over if the object is written to meanwhile.
+func (s *EncryptedStore) rotate(key string, k *encryptKey) error {
+	for attempt := 1; ; attempt++ {
+		err := s.rotateOnce(key, k)
+		if !errors.Is(err, ErrPreconditionFailed) || attempt == rotateAttempts {
+			return err
+		}
+		s.log.Debugw("object changed while rotating its key, retrying", "key", key, "attempt", attempt)
+	}
+}
+
+func (s *EncryptedStore) rotateOnce(key string, k *encryptKey) error {
+	stat, err := s.inner.Stat(key)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", key, err)
+	}
+	head, err := s.inner.DownloadRangeBytes(key, 0, int64(len(encryptMagic)+encryptKeyIDSz))
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	old, err := s.keyOf(head)
+	if err != nil {
+		return fmt.Errorf("rotate %s: %w", key, err)
+	}
+	if old.id == k.id {
+		return nil
+	}
+	rc, err := s.inner.DownloadReader(key)
+	if err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	defer rc.Close() // nolint: errcheck
+	plain, err := s.newDecryptReader(rc)
+	if err != nil {
+		return fmt.Errorf("decrypt %s: %w", key, err)
+	}
+	sealed, err := newEncryptReader(k, &decryptErrorReader{r: plain, key: key})
+	if err != nil {
+		return err
+	}
+	// Stores such as OSStore without atomic writes truncate the object
+	// before writing it, so it must be read whole before it's replaced.
+	tmp, err := os.CreateTemp("", "store-rotate-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // nolint: errcheck
+	defer tmp.Close()           // nolint: errcheck
+	size, err := io.Copy(tmp, sealed)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	opts := []UploadOption{Overwrite(true)}
+	if stat.ETag != "" {
+		opts = append(opts, IfMatch(stat.ETag))
+	}
+	if err := s.inner.UploadReader(tmp, size, key, opts...); err != nil {
+		return fmt.Errorf("upload %s: %w", key, err)
+	}
+	s.log.Debugw("rotated encryption key", "key", key)
+	return nil
+}
+
+// Close closes the wrapped store.
+func (s *EncryptedStore) Close() error {
+	return closeStore(s.inner)
+}
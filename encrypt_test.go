@@ -0,0 +1,219 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testEncryptKey(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 32)
+}
+
+func TestEncryptedStore(t *testing.T) {
+	s, err := NewEncryptedStore(NewMemStore(), testEncryptKey(1))
+	assert.NoError(t, err)
+	testAll(t, s, "encrypted")
+}
+
+func TestEncryptedStore_InvalidKey(t *testing.T) {
+	_, err := NewEncryptedStore(NewMemStore(), []byte("short"))
+	assert.Error(t, err)
+	_, err = NewEncryptStore(NewMemStore(), []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestNewEncryptStore(t *testing.T) {
+	inner := NewMemStore()
+	s, err := NewEncryptStore(inner, testEncryptKey(1))
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("secret"), "key"))
+	raw, err := inner.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("secret")))
+	data, err := s.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", string(data))
+}
+
+func TestEncryptedStore_Ciphertext(t *testing.T) {
+	inner := NewMemStore()
+	s, err := NewEncryptedStore(inner, testEncryptKey(1))
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("secret"), "key"))
+
+	raw, err := inner.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.False(t, bytes.Contains(raw, []byte("secret")))
+
+	other, err := NewEncryptedStore(inner, testEncryptKey(2))
+	assert.NoError(t, err)
+	_, err = other.DownloadBytes("key")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+// failingUploadStore fails the uploads of the keys in fail.
+type failingUploadStore struct {
+	Interface
+	fail map[string]bool
+}
+
+func (s *failingUploadStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	if s.fail[key] {
+		return errors.New("upload failed")
+	}
+	return s.Interface.UploadData(data, key, opts...)
+}
+
+func (s *failingUploadStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	if s.fail[key] {
+		return errors.New("upload failed")
+	}
+	return s.Interface.UploadReader(reader, size, key, opts...)
+}
+
+func TestEncryptedStore_RotateKey(t *testing.T) {
+	oldKey, newKey := testEncryptKey(1), testEncryptKey(2)
+	inner := &failingUploadStore{Interface: NewMemStore(), fail: map[string]bool{}}
+	s, err := NewEncryptedStore(inner, oldKey)
+	assert.NoError(t, err)
+	keys := []string{"data/a", "data/b", "data/c"}
+	for _, key := range keys {
+		assert.NoError(t, s.UploadData([]byte("content of "+key), key))
+	}
+
+	// The rotation is interrupted by a failure on one object.
+	inner.fail["data/b"] = true
+	assert.Error(t, s.WithRotateConcurrency(2).RotateKey("data/", newKey))
+
+	// Every object is still readable with one of the keys.
+	resumed, err := NewEncryptedStore(inner, newKey)
+	assert.NoError(t, err)
+	_, err = resumed.DownloadBytes("data/b")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+	resumed, err = resumed.WithDecryptKeys(oldKey)
+	assert.NoError(t, err)
+	for _, key := range keys {
+		data, err := resumed.DownloadBytes(key)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("content of "+key), data)
+	}
+
+	// Resuming the rotation completes it.
+	inner.fail["data/b"] = false
+	assert.NoError(t, resumed.RotateKey("data/", newKey))
+	rotated, err := NewEncryptedStore(inner, newKey)
+	assert.NoError(t, err)
+	for _, key := range keys {
+		data, err := rotated.DownloadBytes(key)
+		assert.NoError(t, err, fmt.Sprintf("%s should be encrypted with the new key", key))
+		assert.Equal(t, []byte("content of "+key), data)
+	}
+}
+
+func TestEncryptedStore_Segments(t *testing.T) {
+	inner := NewMemStore()
+	s, err := NewEncryptedStore(inner, testEncryptKey(1))
+	assert.NoError(t, err)
+	for _, size := range []int{2 * encryptSegmentSize, 2*encryptSegmentSize + 5} {
+		data := bytes.Repeat([]byte("0123456789abcdef"), size/16+1)[:size]
+		key := fmt.Sprintf("segments-%d", size)
+		assert.NoError(t, s.UploadReader(bytes.NewReader(data), int64(size), key))
+
+		raw, err := inner.DownloadBytes(key)
+		assert.NoError(t, err)
+		assert.Equal(t, encryptedSize(int64(size)), int64(len(raw)))
+		stat, err := s.Stat(key)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(size), stat.Size)
+		r, err := s.DownloadReader(key)
+		assert.NoError(t, err)
+		got, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, data, got)
+
+		// cut after the first segment, which then doesn't authenticate as
+		// the last one
+		assert.NoError(t, inner.UploadData(raw[:encryptHeaderSz+encryptedSegmentSize], key))
+		_, err = s.DownloadBytes(key)
+		assert.Error(t, err, "a truncated object must not decrypt")
+	}
+}
+
+func TestEncryptedStore_Tampered(t *testing.T) {
+	inner := NewMemStore()
+	s, err := NewEncryptedStore(inner, testEncryptKey(1))
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("ledger"), "key"))
+	stat, err := s.Stat("key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), stat.Size, "Stat should report the plaintext size")
+
+	raw, err := inner.DownloadBytes("key")
+	assert.NoError(t, err)
+	raw[len(raw)-1] ^= 1
+	assert.NoError(t, inner.UploadData(raw, "key"))
+	_, err = s.DownloadBytes("key")
+	assert.Error(t, err, "modified ciphertext must not decrypt")
+	_, err = s.DownloadRangeBytes("key", 0, 1)
+	assert.Error(t, err)
+}
+
+// racingUploadStore writes to a key right before the first upload to it,
+// as another writer would while the object is being rotated.
+type racingUploadStore struct {
+	Interface
+	write func(key string)
+	raced map[string]bool
+}
+
+func (s *racingUploadStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	if !s.raced[key] {
+		s.raced[key] = true
+		s.write(key)
+	}
+	return s.Interface.UploadReader(reader, size, key, opts...)
+}
+
+func TestEncryptedStore_RotateKey_ConcurrentWrite(t *testing.T) {
+	oldKey, newKey := testEncryptKey(1), testEncryptKey(2)
+	inner := &racingUploadStore{Interface: NewMemStore(), raced: map[string]bool{}}
+	writer, err := NewEncryptedStore(inner.Interface, oldKey)
+	assert.NoError(t, err)
+	inner.write = func(key string) {
+		assert.NoError(t, writer.UploadData([]byte("written meanwhile"), key))
+	}
+	s, err := NewEncryptedStore(inner, oldKey)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("before"), "data/a"))
+
+	assert.NoError(t, s.RotateKey("data/", newKey))
+	rotated, err := NewEncryptedStore(inner, newKey)
+	assert.NoError(t, err)
+	data, err := rotated.DownloadBytes("data/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "written meanwhile", string(data), "the rotation must not undo a concurrent write")
+}
+
+func TestEncryptedStore_RotateKey_InPlace(t *testing.T) {
+	// without atomic writes, OSStore truncates a file before writing it
+	inner := NewOSStore(WithAtomicWrites(false))
+	key := filepath.Join(t.TempDir(), "data", "a")
+	s, err := NewEncryptedStore(inner, testEncryptKey(1))
+	assert.NoError(t, err)
+	data := bytes.Repeat([]byte("0123456789abcdef"), 300<<10/16)
+	assert.NoError(t, s.UploadData(data, key))
+
+	assert.NoError(t, s.RotateKey(filepath.Dir(key), testEncryptKey(2)))
+	rotated, err := NewEncryptedStore(inner, testEncryptKey(2))
+	assert.NoError(t, err)
+	got, err := rotated.DownloadBytes(key)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
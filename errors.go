@@ -0,0 +1,46 @@
+package store
+
+import "errors"
+
+var (
+	// ErrNotFound is returned, possibly wrapped, when the object doesn't
+	// exist. Use errors.Is to check for it.
+	ErrNotFound = errors.New("object not found")
+	// ErrAlreadyExists is returned, possibly wrapped, by backends that refuse
+	// to overwrite an existing object.
+	ErrAlreadyExists = errors.New("object already exists")
+	// ErrPreconditionFailed is returned, possibly wrapped, when the IfMatch
+	// or IfNoneMatch condition of an upload doesn't hold.
+	ErrPreconditionFailed = errors.New("precondition failed")
+	// ErrNotModified is returned by conditional downloads when the object
+	// hasn't changed.
+	ErrNotModified = errors.New("object not modified")
+	// ErrInvalidKey is returned, possibly wrapped, for keys that would
+	// reach outside of their store, such as local paths with ".." elements.
+	ErrInvalidKey = errors.New("invalid key")
+	// ErrNotSupported is returned, possibly wrapped, when the backend doesn't
+	// support the operation.
+	ErrNotSupported = errors.New("operation not supported")
+	// ErrClosed is returned when using a store after closing it.
+	ErrClosed = errors.New("store is closed")
+	// ErrReadOnly is returned when the credentials can read from the store
+	// but are not allowed to write to or delete from it, and by the writes
+	// of a ReadOnlyStore.
+	ErrReadOnly = errors.New("store is read-only")
+	// ErrPermissionDenied is returned, possibly wrapped, when the
+	// credentials are not allowed to read from the store.
+	ErrPermissionDenied = errors.New("permission denied")
+	// ErrAuthFailed is returned when the store rejects the credentials.
+	ErrAuthFailed = errors.New("store authentication failed")
+	// ErrQueueFull is returned by a non-blocking AsyncStore when its upload
+	// queue is full.
+	ErrQueueFull = errors.New("upload queue is full")
+	// ErrQueueClosed is returned when enqueueing to a closed AsyncStore.
+	ErrQueueClosed = errors.New("upload queue is closed")
+	// ErrUnknownKey is returned when an object was encrypted with a key that
+	// isn't known to the EncryptedStore.
+	ErrUnknownKey = errors.New("object encrypted with an unknown key")
+	// ErrTooLarge is returned, possibly wrapped, when an object read into
+	// memory is larger than the limit set with WithMaxDownloadBytes.
+	ErrTooLarge = errors.New("object too large")
+)
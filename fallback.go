@@ -0,0 +1,215 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	_ Interface = &FallbackStore{}
+	_ io.Closer = &FallbackStore{}
+)
+
+// errNotExist makes Exists fall through to the next backend.
+var errNotExist = errors.New("key does not exist")
+
+// Router returns the indexes of the backends to read key from, in order.
+type Router func(key string) (order []int)
+
+// FallbackPolicy reports whether a read that failed with err falls through
+// to the next backend. Errors it rejects are returned right away.
+type FallbackPolicy func(err error) bool
+
+// FallbackOnError falls through on any error except denied access: the
+// credentials of a backend being rejected is a misconfiguration to surface,
+// not a reason to serve the key from another backend. It's the default
+// policy of a FallbackStore. Note that S3 denies reading a missing key to
+// credentials that can't list the bucket.
+func FallbackOnError(err error) bool {
+	if errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrReadOnly) || errors.Is(err, ErrPermissionDenied) ||
+		errors.Is(err, os.ErrPermission) {
+		return false
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return false
+	}
+	return true
+}
+
+// FallbackOnNotFound only falls through when the backend doesn't have the
+// key, e.g. while the data of a migration is split between two buckets.
+func FallbackOnNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound) || errors.Is(err, os.ErrNotExist) ||
+		minio.ToErrorResponse(err).Code == "NoSuchKey"
+}
+
+// FallbackStore reads from a list of backends, falling back to the next one
+// when a backend fails with an error its FallbackPolicy accepts. Writes and
+// deletes go to the primary (first) backend.
+type FallbackStore struct {
+	stores []Interface
+	router Router
+	policy FallbackPolicy
+}
+
+// NewFallbackStore creates a FallbackStore over the primary backend followed
+// by the fallbacks. Reads go primary-first unless a Router is set.
+func NewFallbackStore(primary Interface, fallbacks ...Interface) *FallbackStore {
+	return &FallbackStore{
+		stores: append([]Interface{primary}, fallbacks...),
+		policy: FallbackOnError,
+	}
+}
+
+// WithRouter sets the function choosing the read order per key, e.g. to send
+// reads of a cold prefix straight to the secondary backend. Indexes outside
+// the backend list are ignored. Passing nil restores primary-first order.
+func (s *FallbackStore) WithRouter(router Router) *FallbackStore {
+	s.router = router
+	return s
+}
+
+// WithPolicy sets the errors that fall through to the next backend.
+// Passing nil restores FallbackOnError.
+func (s *FallbackStore) WithPolicy(policy FallbackPolicy) *FallbackStore {
+	if policy == nil {
+		policy = FallbackOnError
+	}
+	s.policy = policy
+	return s
+}
+
+func (s *FallbackStore) order(key string) []Interface {
+	if s.router == nil {
+		return s.stores
+	}
+	var stores []Interface
+	for _, i := range s.router(key) {
+		if i >= 0 && i < len(s.stores) {
+			stores = append(stores, s.stores[i])
+		}
+	}
+	return stores
+}
+
+// read calls fn on the backends in read order until one succeeds or fails
+// with an error the policy doesn't fall through on.
+func (s *FallbackStore) read(key string, fn func(st Interface) error) error {
+	stores := s.order(key)
+	if len(stores) == 0 {
+		return errors.New("no backend to read " + key + " from")
+	}
+	var err error
+	for _, st := range stores {
+		if err = fn(st); err == nil {
+			return nil
+		}
+		if !errors.Is(err, errNotExist) && s.policy != nil && !s.policy(err) {
+			return err
+		}
+		log.Debugw("fallback read failed", "key", key, "store", st, "error", err)
+	}
+	return err
+}
+
+func (s *FallbackStore) primary() Interface {
+	return s.stores[0]
+}
+
+func (s *FallbackStore) Stat(key string) (stat FileStat, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		stat, err = st.Stat(key)
+		return err
+	})
+	return stat, err
+}
+
+func (s *FallbackStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	return s.primary().UploadData(data, key, opts...)
+}
+
+func (s *FallbackStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	return s.primary().Upload(file, key, opts...)
+}
+
+func (s *FallbackStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	return s.primary().UploadReader(reader, size, key, opts...)
+}
+
+func (s *FallbackStore) DeleteDirectory(dir string) (err error) {
+	return s.primary().DeleteDirectory(dir)
+}
+
+func (s *FallbackStore) Delete(key string) (err error) {
+	return s.primary().Delete(key)
+}
+
+// Exists reports whether any backend in read order has the key.
+func (s *FallbackStore) Exists(key string) (exists bool, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		exists, err = st.Exists(key)
+		if err == nil && !exists {
+			return errNotExist
+		}
+		return err
+	})
+	if errors.Is(err, errNotExist) {
+		return false, nil
+	}
+	return exists, err
+}
+
+func (s *FallbackStore) DownloadBytes(key string) (data []byte, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		data, err = st.DownloadBytes(key)
+		return err
+	})
+	return data, err
+}
+
+func (s *FallbackStore) DownloadReader(key string) (r io.ReadCloser, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		r, err = st.DownloadReader(key)
+		return err
+	})
+	return r, err
+}
+
+func (s *FallbackStore) DownloadRangeBytes(key string, offset int64, size int64) (data []byte, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		data, err = st.DownloadRangeBytes(key, offset, size)
+		return err
+	})
+	return data, err
+}
+
+func (s *FallbackStore) DownloadRangeReader(key string, offset int64, size int64) (r io.ReadCloser, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		r, err = st.DownloadRangeReader(key, offset, size)
+		return err
+	})
+	return r, err
+}
+
+func (s *FallbackStore) ListPrefix(key string) (keys []string, err error) {
+	err = s.read(key, func(st Interface) (err error) {
+		keys, err = st.ListPrefix(key)
+		return err
+	})
+	return keys, err
+}
+
+// Close closes all the backends and returns their errors joined.
+func (s *FallbackStore) Close() error {
+	var errs []error
+	for _, st := range s.stores {
+		if err := closeStore(st); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,135 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// spyStore counts the downloads served by the wrapped store and can be made
+// to fail them, with failErr if set.
+type spyStore struct {
+	Interface
+	fail      bool
+	failErr   error
+	downloads int
+}
+
+func (s *spyStore) DownloadBytes(key string) ([]byte, error) {
+	s.downloads++
+	if s.fail {
+		if s.failErr != nil {
+			return nil, s.failErr
+		}
+		return nil, errors.New("download failed")
+	}
+	return s.Interface.DownloadBytes(key)
+}
+
+func (s *spyStore) Exists(key string) (bool, error) {
+	if s.fail {
+		return false, errors.New("exists failed")
+	}
+	return s.Interface.Exists(key)
+}
+
+func TestFallbackStore_DownloadBytes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, NewOSStore().UploadData([]byte("content"), file))
+	primary := &spyStore{Interface: NewOSStore(), fail: true}
+	secondary := &spyStore{Interface: NewOSStore()}
+	s := NewFallbackStore(primary, secondary)
+
+	data, err := s.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	assert.Equal(t, 1, primary.downloads)
+	assert.Equal(t, 1, secondary.downloads)
+
+	secondary.fail = true
+	_, err = s.DownloadBytes(file)
+	assert.Error(t, err)
+}
+
+func TestFallbackStore_Router(t *testing.T) {
+	dir := t.TempDir()
+	cold := filepath.Join(dir, "cold", "file.txt")
+	assert.NoError(t, NewOSStore().UploadData([]byte("cold"), cold))
+	primary := &spyStore{Interface: NewOSStore()}
+	secondary := &spyStore{Interface: NewOSStore()}
+	s := NewFallbackStore(primary, secondary).WithRouter(func(key string) []int {
+		if strings.HasPrefix(key, filepath.Join(dir, "cold")) {
+			return []int{1, 0}
+		}
+		return []int{0, 1}
+	})
+
+	data, err := s.DownloadBytes(cold)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("cold"), data)
+	assert.Equal(t, 0, primary.downloads, "cold key should not touch the primary")
+	assert.Equal(t, 1, secondary.downloads)
+}
+
+func TestFallbackStore_Exists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	s := NewFallbackStore(&spyStore{Interface: NewOSStore(), fail: true}, NewOSStore())
+
+	exists, err := s.Exists(file)
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	assert.NoError(t, s.UploadData([]byte("content"), file))
+	exists, err = s.Exists(file)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestFallbackStore_Exists_Denied(t *testing.T) {
+	primary, fake := newFakeS3Store(t)
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead {
+			return http.StatusForbidden, ""
+		}
+		return 0, ""
+	})
+	secondary := NewMemStore()
+	assert.NoError(t, secondary.UploadData([]byte("content"), "file.txt"))
+
+	s := NewFallbackStore(primary, secondary)
+	exists, err := s.Exists("file.txt")
+	assert.Error(t, err, "denied access must not fall through")
+	assert.False(t, exists)
+}
+
+func TestFallbackStore_Policy(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, NewOSStore().UploadData([]byte("content"), file))
+	denied := &spyStore{Interface: NewOSStore(), fail: true, failErr: fmt.Errorf("%w: access denied", ErrReadOnly)}
+	secondary := &spyStore{Interface: NewOSStore()}
+
+	s := NewFallbackStore(denied, secondary)
+	_, err := s.DownloadBytes(file)
+	assert.ErrorIs(t, err, ErrReadOnly, "denied access must not fall through")
+	assert.Equal(t, 0, secondary.downloads)
+
+	s.WithPolicy(func(error) bool { return true })
+	data, err := s.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	flaky := &spyStore{Interface: NewOSStore(), fail: true}
+	s = NewFallbackStore(flaky, secondary).WithPolicy(FallbackOnNotFound)
+	_, err = s.DownloadBytes(file)
+	assert.Error(t, err, "only missing keys fall through")
+	missing := &spyStore{Interface: NewOSStore(), fail: true, failErr: fmt.Errorf("%w: %s", ErrNotFound, file)}
+	s = NewFallbackStore(missing, secondary).WithPolicy(FallbackOnNotFound)
+	data, err = s.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+}
@@ -0,0 +1,11 @@
+package store
+
+import "context"
+
+// HealthChecker is implemented by stores that can check they are reachable,
+// e.g. for a readiness probe.
+type HealthChecker interface {
+	// HealthCheck returns an error if the store can't be reached or its
+	// bucket isn't accessible.
+	HealthCheck(ctx context.Context) error
+}
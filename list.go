@@ -0,0 +1,196 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DepthLister is implemented by stores that can list a prefix down to a
+// maximum depth.
+type DepthLister interface {
+	// ListPrefixDepth lists the keys under prefix down to maxDepth levels,
+	// where 1 lists the direct children only. Directories at maxDepth are
+	// returned with a trailing slash instead of their content. The keys are
+	// sorted.
+	ListPrefixDepth(prefix string, maxDepth int) ([]string, error)
+}
+
+func checkListDepth(maxDepth int) error {
+	if maxDepth < 1 {
+		return fmt.Errorf("invalid list depth: %d", maxDepth)
+	}
+	return nil
+}
+
+// ObjectStat is the FileStat of a listed key.
+type ObjectStat struct {
+	Key string
+	FileStat
+}
+
+// StatLister is implemented by stores that can list the stats of the keys
+// under a prefix along with the keys, without a Stat per key.
+type StatLister interface {
+	// ListPrefixStat lists the keys under prefix like ListPrefix, with
+	// their stats.
+	ListPrefixStat(prefix string) ([]ObjectStat, error)
+}
+
+// ListPrefixStat lists the keys under prefix on st with their stats. Stores
+// that aren't StatListers are listed with ListPrefix and a Stat per key.
+func ListPrefixStat(st Interface, prefix string) ([]ObjectStat, error) {
+	if sl, ok := st.(StatLister); ok {
+		return sl.ListPrefixStat(prefix)
+	}
+	keys, err := st.ListPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := runBatch(keys, defaultBatchConcurrency, st.Stat)
+	if err != nil {
+		return nil, err
+	}
+	objects := make([]ObjectStat, 0, len(stats))
+	for _, key := range keys {
+		if stat, ok := stats[key]; ok {
+			objects = append(objects, ObjectStat{Key: key, FileStat: stat})
+		}
+	}
+	return objects, nil
+}
+
+// UsageReporter is implemented by stores that can sum up the objects under
+// a prefix in a single listing pass.
+type UsageReporter interface {
+	// PrefixUsage returns the total size and the number of the objects
+	// under prefix.
+	PrefixUsage(prefix string) (totalBytes int64, count int64, err error)
+}
+
+// PrefixUsage returns the total size and the number of the objects under
+// prefix on st. Stores that aren't UsageReporters are summed up from
+// ListPrefixStat.
+func PrefixUsage(st Interface, prefix string) (totalBytes int64, count int64, err error) {
+	if ur, ok := st.(UsageReporter); ok {
+		return ur.PrefixUsage(prefix)
+	}
+	objects, err := ListPrefixStat(st, prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, obj := range objects {
+		totalBytes += obj.Size
+	}
+	return totalBytes, int64(len(objects)), nil
+}
+
+// DeletePreviewer is implemented by stores that can list the keys a
+// DeleteDirectory would delete.
+type DeletePreviewer interface {
+	// DeleteDirectoryPreview lists the keys DeleteDirectory(dir) would
+	// delete, without deleting anything.
+	DeleteDirectoryPreview(dir string) ([]string, error)
+}
+
+// DeleteDirectoryPreview lists the keys DeleteDirectory(dir) would delete on
+// st, without deleting anything. Stores that aren't DeletePreviewers are
+// listed with ListPrefix under dir. Use PrefixUsage under dir for the number
+// and the total size of the objects.
+func DeleteDirectoryPreview(st Interface, dir string) ([]string, error) {
+	if dp, ok := st.(DeletePreviewer); ok {
+		return dp.DeleteDirectoryPreview(dir)
+	}
+	return st.ListPrefix(makeSureKeyAsDir(dir))
+}
+
+// EmptyChecker is implemented by stores that can tell whether a prefix holds
+// any object without listing all of them.
+type EmptyChecker interface {
+	// IsEmpty reports whether there are no objects under prefix.
+	IsEmpty(prefix string) (bool, error)
+}
+
+// IsEmpty reports whether there are no objects under prefix on st. Stores
+// that aren't EmptyCheckers are listed with ListPrefix.
+func IsEmpty(st Interface, prefix string) (bool, error) {
+	if ec, ok := st.(EmptyChecker); ok {
+		return ec.IsEmpty(prefix)
+	}
+	keys, err := st.ListPrefix(prefix)
+	if err != nil {
+		return false, err
+	}
+	return len(keys) == 0, nil
+}
+
+// ContextLister is implemented by stores whose listings can be stopped
+// early with a context.
+type ContextLister interface {
+	// ListPrefixContext lists the keys under prefix like ListPrefix. It
+	// stops listing when ctx is done, returning ctx.Err().
+	ListPrefixContext(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ListPrefixContext lists the keys under prefix on st, stopping when ctx is
+// done. Stores that aren't ContextListers are listed with ListPrefix, whose
+// result is dropped if ctx is done by then.
+func ListPrefixContext(ctx context.Context, st Interface, prefix string) ([]string, error) {
+	if cl, ok := st.(ContextLister); ok {
+		return cl.ListPrefixContext(ctx, prefix)
+	}
+	keys, err := st.ListPrefix(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// SkipRemaining is returned by a WalkFunc to stop the walk early. The walk
+// then returns nil.
+var SkipRemaining = errors.New("skip remaining objects")
+
+// WalkFunc is called by WalkPrefix for each object. Returning an error stops
+// the walk with that error, except for SkipRemaining.
+type WalkFunc func(obj ObjectStat) error
+
+// Walker is implemented by stores that can visit the objects under a prefix
+// as they are listed, without keeping the listing.
+type Walker interface {
+	// WalkPrefix calls fn for each key under prefix, like ListPrefixStat
+	// lists them. It stops when ctx is done, returning ctx.Err().
+	WalkPrefix(ctx context.Context, prefix string, fn WalkFunc) error
+}
+
+// WalkPrefix calls fn for each object under prefix on st, stopping at the
+// first error returned by fn or when ctx is done. Stores that aren't Walkers
+// are listed with ListPrefixStat first.
+func WalkPrefix(ctx context.Context, st Interface, prefix string, fn WalkFunc) error {
+	if w, ok := st.(Walker); ok {
+		return w.WalkPrefix(ctx, prefix, fn)
+	}
+	objects, err := ListPrefixStat(st, prefix)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(obj); err != nil {
+			return walkResult(err)
+		}
+	}
+	return nil
+}
+
+// walkResult is the result of a walk stopped by err.
+func walkResult(err error) error {
+	if errors.Is(err, SkipRemaining) {
+		return nil
+	}
+	return err
+}
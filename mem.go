@@ -0,0 +1,272 @@
+package store
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	_ Interface             = &MemStore{}
+	_ ConditionalDownloader = &MemStore{}
+	_ StatLister            = &MemStore{}
+	_ UsageReporter         = &MemStore{}
+	_ Toucher               = &MemStore{}
+	_ Appender              = &MemStore{}
+)
+
+// MemStore is an in-memory store, mainly useful in tests. Keys are flat like
+// object store keys, and directories are key prefixes ending in "/".
+type MemStore struct {
+	lk      sync.RWMutex
+	objects map[string]memObject
+}
+
+type memObject struct {
+	data    []byte
+	modTime time.Time
+}
+
+func NewMemStore() Interface {
+	return &MemStore{
+		objects: map[string]memObject{},
+	}
+}
+
+func (s *MemStore) get(key string) (memObject, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return memObject{}, fmt.Errorf("object %s: %w", key, ErrNotFound)
+	}
+	return obj, nil
+}
+
+func (s *MemStore) Stat(key string) (FileStat, error) {
+	obj, err := s.get(key)
+	if err != nil {
+		return FileStat{}, err
+	}
+	return FileStat{
+		Size:    int64(len(obj.data)),
+		ETag:    memETag(obj.data),
+		ModTime: obj.modTime,
+	}, nil
+}
+
+// memETag is the hex MD5 of data, like the ETag of a single part S3 upload.
+func memETag(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// UploadData stores a copy of data.
+func (s *MemStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	old, ok := s.objects[key]
+	if ok && !o.Overwrite {
+		return fmt.Errorf("object %s: %w", key, ErrAlreadyExists)
+	}
+	if o.conditional() {
+		if err := o.checkETag(key, memETag(old.data), ok); err != nil {
+			return err
+		}
+	}
+	s.objects[key] = memObject{data: bytes.Clone(data), modTime: now()}
+	hasher.data(data)
+	hasher.done()
+	if o.Progress != nil {
+		o.Progress(int64(len(data)), int64(len(data)))
+	}
+	return nil
+}
+
+// AppendData appends a copy of data to the object, creating it if it
+// doesn't exist.
+func (s *MemStore) AppendData(data []byte, key string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	old := s.objects[key]
+	s.objects[key] = memObject{data: append(bytes.Clone(old.data), data...), modTime: now()}
+	return nil
+}
+
+func (s *MemStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("read file %s: %w", file, err)
+	}
+	return s.UploadData(data, key, opts...)
+}
+
+// UploadReader stores the content of reader. The size is not checked.
+func (s *MemStore) UploadReader(reader io.Reader, _ int64, key string, opts ...UploadOption) (err error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return fmt.Errorf("read %s: %w", key, err)
+	}
+	return s.UploadData(data, key, opts...)
+}
+
+// DeleteDirectory removes all the objects under dir.
+func (s *MemStore) DeleteDirectory(dir string) (err error) {
+	dir = makeSureKeyAsDir(dir)
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	for key := range s.objects {
+		if strings.HasPrefix(key, dir) {
+			delete(s.objects, key)
+		}
+	}
+	return nil
+}
+
+func (s *MemStore) Delete(key string) (err error) {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return fmt.Errorf("object %s: %w", key, ErrNotFound)
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+func (s *MemStore) Exists(key string) (bool, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	_, ok := s.objects[key]
+	return ok, nil
+}
+
+func (s *MemStore) DownloadBytes(key string) ([]byte, error) {
+	obj, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.Clone(obj.data), nil
+}
+
+func (s *MemStore) DownloadReader(key string) (io.ReadCloser, error) {
+	obj, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+// DownloadReaderIf opens the object unless its ETag or modification time
+// matches cond.
+func (s *MemStore) DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error) {
+	obj, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	if cond.notModified(memETag(obj.data), obj.modTime) {
+		return nil, fmt.Errorf("object %s: %w", key, ErrNotModified)
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), nil
+}
+
+func (s *MemStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	r, err := s.DownloadRangeReader(key, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func (s *MemStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	obj, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	data := obj.data
+	if offset < 0 {
+		return nil, fmt.Errorf("invalid offset %d", offset)
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	data = data[offset:]
+	if size >= 0 && size < int64(len(data)) {
+		data = data[:size]
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+// ListPrefixStat returns all the keys starting with key with their stats,
+// sorted by key.
+func (s *MemStore) ListPrefixStat(key string) (objects []ObjectStat, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	for k, obj := range s.objects {
+		if strings.HasPrefix(k, key) {
+			objects = append(objects, ObjectStat{
+				Key: k,
+				FileStat: FileStat{
+					Size:    int64(len(obj.data)),
+					ETag:    memETag(obj.data),
+					ModTime: obj.modTime,
+				},
+			})
+		}
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].Key < objects[j].Key
+	})
+	return objects, nil
+}
+
+// PrefixUsage returns the total size and the number of the keys starting
+// with key.
+func (s *MemStore) PrefixUsage(key string) (totalBytes int64, count int64, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	for k, obj := range s.objects {
+		if strings.HasPrefix(k, key) {
+			totalBytes += int64(len(obj.data))
+			count++
+		}
+	}
+	return totalBytes, count, nil
+}
+
+// Touch sets the modification time of the object to now.
+func (s *MemStore) Touch(key string) error {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return fmt.Errorf("object %s: %w", key, ErrNotFound)
+	}
+	obj.modTime = now()
+	s.objects[key] = obj
+	return nil
+}
+
+// ListPrefix returns all the keys starting with key, sorted.
+func (s *MemStore) ListPrefix(key string) (keys []string, err error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	for k := range s.objects {
+		if strings.HasPrefix(k, key) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
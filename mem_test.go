@@ -0,0 +1,13 @@
+package store
+
+import (
+	"testing"
+)
+
+func TestMemStore(t *testing.T) {
+	testAll(t, NewMemStore(), "mem")
+}
+
+func BenchmarkMemStore(b *testing.B) {
+	benchAll(b, NewMemStore(), "mem")
+}
@@ -0,0 +1,30 @@
+package store
+
+// MetadataGetter is implemented by stores that record metadata along with
+// the objects.
+type MetadataGetter interface {
+	// GetMetadata returns the user metadata of the object along with its
+	// content headers, such as Content-Type and Cache-Control, without
+	// reading the content. It fails with ErrNotFound if the object doesn't
+	// exist.
+	GetMetadata(key string) (map[string]string, error)
+}
+
+// GetMetadata returns the metadata of key on st. Stores that aren't
+// MetadataGetters, such as OSStore, don't record any: key is only checked to
+// exist with Stat, and the metadata is empty but for the Content-Type the
+// stat may have.
+func GetMetadata(st Interface, key string) (map[string]string, error) {
+	if mg, ok := st.(MetadataGetter); ok {
+		return mg.GetMetadata(key)
+	}
+	stat, err := st.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	meta := map[string]string{}
+	if stat.ContentType != "" {
+		meta["Content-Type"] = stat.ContentType
+	}
+	return meta, nil
+}
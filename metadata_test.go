@@ -0,0 +1,23 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetMetadata_NotRecorded(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.txt")
+	for name, st := range map[string]Interface{"os": NewOSStore(), "mem": NewMemStore()} {
+		t.Run(name, func(t *testing.T) {
+			assert.NoError(t, st.UploadData([]byte("a"), path))
+			meta, err := GetMetadata(st, path)
+			assert.NoError(t, err)
+			assert.Empty(t, meta)
+
+			_, err = GetMetadata(st, path+".missing")
+			assert.ErrorIs(t, err, ErrNotFound)
+		})
+	}
+}
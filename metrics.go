@@ -0,0 +1,226 @@
+package store
+
+import (
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	_ Interface = &MetricsStore{}
+	_ io.Closer = &MetricsStore{}
+)
+
+// MetricsStore wraps a store and records Prometheus metrics for its
+// operations:
+//
+//   - store_operations_total{operation, result}: calls by result, where the
+//     result is "ok" or a coarse error class (see errorClass)
+//   - store_operation_duration_seconds{operation}: call latency; for the
+//     reader downloads this is the time to open the reader
+//   - store_transferred_bytes_total{operation}: bytes uploaded or downloaded
+type MetricsStore struct {
+	inner Interface
+
+	ops      *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	bytes    *prometheus.CounterVec
+}
+
+// NewMetricsStore creates a MetricsStore registering its metrics with reg,
+// or with prometheus.DefaultRegisterer if reg is nil. Several stores can
+// share a registerer; they then share the metrics too.
+func NewMetricsStore(inner Interface, reg prometheus.Registerer) Interface {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	s := &MetricsStore{
+		inner: inner,
+		ops: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "store",
+			Name:      "operations_total",
+			Help:      "Number of store operations by result.",
+		}, []string{"operation", "result"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "store",
+			Name:      "operation_duration_seconds",
+			Help:      "Latency of store operations.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}, []string{"operation"}),
+		bytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "store",
+			Name:      "transferred_bytes_total",
+			Help:      "Bytes uploaded or downloaded by store operations.",
+		}, []string{"operation"}),
+	}
+	s.ops = register(reg, s.ops)
+	s.duration = register(reg, s.duration)
+	s.bytes = register(reg, s.bytes)
+	return s
+}
+
+// register registers c with reg, returning the collector already registered
+// under the same name if any.
+func register[C prometheus.Collector](reg prometheus.Registerer, c C) C {
+	if err := reg.Register(c); err != nil {
+		var are prometheus.AlreadyRegisteredError
+		if errors.As(err, &are) {
+			if existing, ok := are.ExistingCollector.(C); ok {
+				return existing
+			}
+		}
+		panic(err)
+	}
+	return c
+}
+
+// observe records a call to op started at start that transferred n bytes.
+func (s *MetricsStore) observe(op string, start time.Time, n int64, err error) {
+	s.ops.WithLabelValues(op, errorClass(err)).Inc()
+	s.duration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if n > 0 {
+		s.bytes.WithLabelValues(op).Add(float64(n))
+	}
+}
+
+// errorClass returns a coarse, low cardinality class of err.
+func errorClass(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrNotFound), errors.Is(err, os.ErrNotExist):
+		return "not_found"
+	case errors.Is(err, ErrAuthFailed), errors.Is(err, ErrReadOnly), errors.Is(err, ErrPermissionDenied),
+		errors.Is(err, os.ErrPermission):
+		return "denied"
+	}
+	switch minio.ToErrorResponse(err).Code {
+	case "NoSuchKey", "NoSuchBucket":
+		return "not_found"
+	case "AccessDenied", "InvalidAccessKeyId", "SignatureDoesNotMatch":
+		return "denied"
+	}
+	if IsRetryable(err) {
+		return "transient"
+	}
+	return "error"
+}
+
+func (s *MetricsStore) Stat(key string) (stat FileStat, err error) {
+	defer func(start time.Time) { s.observe("stat", start, 0, err) }(time.Now())
+	return s.inner.Stat(key)
+}
+
+func (s *MetricsStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	defer func(start time.Time) { s.observe("upload_data", start, int64(len(data)), err) }(time.Now())
+	return s.inner.UploadData(data, key, opts...)
+}
+
+func (s *MetricsStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	var n int64
+	defer func(start time.Time) { s.observe("upload", start, n, err) }(time.Now())
+	if err = s.inner.Upload(file, key, opts...); err == nil {
+		if fi, statErr := os.Stat(file); statErr == nil {
+			n = fi.Size()
+		}
+	}
+	return err
+}
+
+func (s *MetricsStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	r := &countingReader{Reader: reader}
+	defer func(start time.Time) { s.observe("upload_reader", start, r.n, err) }(time.Now())
+	return s.inner.UploadReader(r, size, key, opts...)
+}
+
+func (s *MetricsStore) DeleteDirectory(dir string) (err error) {
+	defer func(start time.Time) { s.observe("delete_directory", start, 0, err) }(time.Now())
+	return s.inner.DeleteDirectory(dir)
+}
+
+func (s *MetricsStore) Delete(key string) (err error) {
+	defer func(start time.Time) { s.observe("delete", start, 0, err) }(time.Now())
+	return s.inner.Delete(key)
+}
+
+func (s *MetricsStore) Exists(key string) (exists bool, err error) {
+	defer func(start time.Time) { s.observe("exists", start, 0, err) }(time.Now())
+	return s.inner.Exists(key)
+}
+
+func (s *MetricsStore) DownloadBytes(key string) (data []byte, err error) {
+	defer func(start time.Time) { s.observe("download_bytes", start, int64(len(data)), err) }(time.Now())
+	return s.inner.DownloadBytes(key)
+}
+
+func (s *MetricsStore) DownloadReader(key string) (r io.ReadCloser, err error) {
+	defer func(start time.Time) { s.observe("download_reader", start, 0, err) }(time.Now())
+	r, err = s.inner.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.countDownload("download_reader", r), nil
+}
+
+func (s *MetricsStore) DownloadRangeBytes(key string, offset int64, size int64) (data []byte, err error) {
+	defer func(start time.Time) { s.observe("download_range_bytes", start, int64(len(data)), err) }(time.Now())
+	return s.inner.DownloadRangeBytes(key, offset, size)
+}
+
+func (s *MetricsStore) DownloadRangeReader(key string, offset int64, size int64) (r io.ReadCloser, err error) {
+	defer func(start time.Time) { s.observe("download_range_reader", start, 0, err) }(time.Now())
+	r, err = s.inner.DownloadRangeReader(key, offset, size)
+	if err != nil {
+		return nil, err
+	}
+	return s.countDownload("download_range_reader", r), nil
+}
+
+func (s *MetricsStore) ListPrefix(key string) (keys []string, err error) {
+	defer func(start time.Time) { s.observe("list_prefix", start, 0, err) }(time.Now())
+	return s.inner.ListPrefix(key)
+}
+
+// countDownload counts the bytes read from r as transferred by op.
+func (s *MetricsStore) countDownload(op string, r io.ReadCloser) io.ReadCloser {
+	counter := s.bytes.WithLabelValues(op)
+	return &countingReadCloser{
+		ReadCloser: r,
+		add: func(n int) {
+			counter.Add(float64(n))
+		},
+	}
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	add func(n int)
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.add(n)
+	}
+	return n, err
+}
+
+// Close closes the wrapped store.
+func (s *MetricsStore) Close() error {
+	return closeStore(s.inner)
+}
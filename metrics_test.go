@@ -0,0 +1,43 @@
+package store
+
+import (
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetricsStore(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	s := NewMetricsStore(NewMemStore(), reg).(*MetricsStore)
+
+	assert.NoError(t, s.UploadData([]byte("content"), "key"))
+	data, err := s.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	r, err := s.DownloadRangeReader("key", 3, -1)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	_, err = s.DownloadBytes("missing")
+	assert.Error(t, err)
+
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.ops.WithLabelValues("upload_data", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.ops.WithLabelValues("download_bytes", "ok")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(s.ops.WithLabelValues("download_bytes", "not_found")))
+	assert.Equal(t, 7.0, testutil.ToFloat64(s.bytes.WithLabelValues("upload_data")))
+	assert.Equal(t, 7.0, testutil.ToFloat64(s.bytes.WithLabelValues("download_bytes")))
+	assert.Equal(t, 4.0, testutil.ToFloat64(s.bytes.WithLabelValues("download_range_reader")))
+	assert.Equal(t, 3, testutil.CollectAndCount(s.duration))
+
+	// A second store on the same registerer shares the metrics.
+	other := NewMetricsStore(NewMemStore(), reg).(*MetricsStore)
+	assert.NoError(t, other.UploadData([]byte("content"), "key"))
+	assert.Equal(t, 2.0, testutil.ToFloat64(s.ops.WithLabelValues("upload_data", "ok")))
+}
+
+func TestMetricsStore_Suite(t *testing.T) {
+	testAll(t, NewMetricsStore(NewMemStore(), prometheus.NewRegistry()), "metrics")
+}
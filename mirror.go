@@ -0,0 +1,231 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+)
+
+var (
+	_ Interface = &MirrorStore{}
+	_ io.Closer = &MirrorStore{}
+)
+
+// MirrorPolicy decides how a MirrorStore treats failed writes to replicas.
+type MirrorPolicy int
+
+const (
+	// MirrorBestEffort logs the failed writes to replicas and succeeds as
+	// long as the primary succeeds.
+	MirrorBestEffort MirrorPolicy = iota
+	// MirrorStrict fails a write if any replica fails. The primary and the
+	// replicas that succeeded keep the write.
+	MirrorStrict
+)
+
+// MirrorStore writes to a primary backend and replicates every upload and
+// delete to its replicas, e.g. to dual-write during a migration. Reads only
+// go to the primary.
+//
+// A write goes to the primary first and fails without touching the
+// replicas if the primary fails, except for UploadReader, which streams the
+// data to all the backends at once; the replicas then fail too.
+type MirrorStore struct {
+	primary  Interface
+	replicas []Interface
+	policy   MirrorPolicy
+}
+
+// NewMirrorStore creates a best-effort MirrorStore over primary and
+// replicas. See WithPolicy.
+func NewMirrorStore(primary Interface, replicas ...Interface) Interface {
+	return &MirrorStore{
+		primary:  primary,
+		replicas: replicas,
+	}
+}
+
+// WithPolicy sets how failed writes to replicas are treated.
+func (s *MirrorStore) WithPolicy(policy MirrorPolicy) *MirrorStore {
+	s.policy = policy
+	return s
+}
+
+// replicate runs fn on every replica concurrently and applies the policy to
+// the failures.
+func (s *MirrorStore) replicate(op string, key string, fn func(st Interface) error) error {
+	errs := make([]error, len(s.replicas))
+	var wg sync.WaitGroup
+	for i, st := range s.replicas {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = fn(st)
+		}()
+	}
+	wg.Wait()
+	return s.replicaErrors(op, key, errs)
+}
+
+func (s *MirrorStore) replicaErrors(op string, key string, errs []error) error {
+	var failed []error
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+		log.Warnw("mirror write to replica failed", "op", op, "key", key, "replica", i, "error", err)
+		failed = append(failed, fmt.Errorf("replica %d: %w", i, err))
+	}
+	if s.policy != MirrorStrict || len(failed) == 0 {
+		return nil
+	}
+	return fmt.Errorf("mirror %s %s: %w", op, key, errors.Join(failed...))
+}
+
+// replicaOptions adapts the upload options of the primary to the replicas.
+// Conditions on ETags don't carry over to backends with other ETags, and
+// the result and progress of the upload are reported by the primary.
+func replicaOptions(opts []UploadOption) []UploadOption {
+	return append(slices.Clip(opts), IfMatch(""), IfNoneMatch(""), ContentHash("", nil), Progress(nil))
+}
+
+func (s *MirrorStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	if err := s.primary.UploadData(data, key, opts...); err != nil {
+		return err
+	}
+	ropts := replicaOptions(opts)
+	return s.replicate("UploadData", key, func(st Interface) error {
+		return st.UploadData(data, key, ropts...)
+	})
+}
+
+func (s *MirrorStore) Upload(file string, key string, opts ...UploadOption) error {
+	if err := s.primary.Upload(file, key, opts...); err != nil {
+		return err
+	}
+	ropts := replicaOptions(opts)
+	return s.replicate("Upload", key, func(st Interface) error {
+		return st.Upload(file, key, ropts...)
+	})
+}
+
+// UploadReader streams reader to the primary and the replicas at once, so
+// the data is read only once. The slowest backend sets the pace. A replica
+// that fails is dropped from the stream without failing the others.
+func (s *MirrorStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	ropts := replicaOptions(opts)
+	fan := &mirrorWriter{pipes: make([]*io.PipeWriter, len(s.replicas))}
+	errs := make([]error, len(s.replicas))
+	var wg sync.WaitGroup
+	for i, st := range s.replicas {
+		pr, pw := io.Pipe()
+		fan.pipes[i] = pw
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = st.UploadReader(pr, size, key, ropts...)
+			// unblock the writer if the replica stopped reading early
+			_ = pr.CloseWithError(errMirrorReplicaDone)
+		}()
+	}
+	err := s.primary.UploadReader(io.TeeReader(reader, fan), size, key, opts...)
+	fan.close(err)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+	return s.replicaErrors("UploadReader", key, errs)
+}
+
+var errMirrorReplicaDone = errors.New("replica upload finished")
+
+// mirrorWriter copies the data read by the primary into the pipes of the
+// replicas, dropping the replicas that stop reading.
+type mirrorWriter struct {
+	pipes []*io.PipeWriter
+}
+
+func (w *mirrorWriter) Write(p []byte) (int, error) {
+	for i, pw := range w.pipes {
+		if pw == nil {
+			continue
+		}
+		if _, err := pw.Write(p); err != nil {
+			w.pipes[i] = nil
+		}
+	}
+	return len(p), nil
+}
+
+// close ends the streams of the replicas, with err if the primary failed.
+func (w *mirrorWriter) close(err error) {
+	for _, pw := range w.pipes {
+		if pw != nil {
+			_ = pw.CloseWithError(err)
+		}
+	}
+}
+
+// Delete deletes key from the primary and the replicas. Replicas that don't
+// have the key don't fail the delete.
+func (s *MirrorStore) Delete(key string) error {
+	if err := s.primary.Delete(key); err != nil {
+		return err
+	}
+	return s.replicate("Delete", key, func(st Interface) error {
+		if err := st.Delete(key); err != nil && !errors.Is(err, ErrNotFound) {
+			return err
+		}
+		return nil
+	})
+}
+
+func (s *MirrorStore) DeleteDirectory(dir string) error {
+	if err := s.primary.DeleteDirectory(dir); err != nil {
+		return err
+	}
+	return s.replicate("DeleteDirectory", dir, func(st Interface) error {
+		return st.DeleteDirectory(dir)
+	})
+}
+
+func (s *MirrorStore) Exists(key string) (bool, error) {
+	return s.primary.Exists(key)
+}
+
+func (s *MirrorStore) Stat(key string) (FileStat, error) {
+	return s.primary.Stat(key)
+}
+
+func (s *MirrorStore) DownloadBytes(key string) ([]byte, error) {
+	return s.primary.DownloadBytes(key)
+}
+
+func (s *MirrorStore) DownloadReader(key string) (io.ReadCloser, error) {
+	return s.primary.DownloadReader(key)
+}
+
+func (s *MirrorStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	return s.primary.DownloadRangeBytes(key, offset, size)
+}
+
+func (s *MirrorStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	return s.primary.DownloadRangeReader(key, offset, size)
+}
+
+func (s *MirrorStore) ListPrefix(key string) ([]string, error) {
+	return s.primary.ListPrefix(key)
+}
+
+// Close closes all the backends and returns their errors joined.
+func (s *MirrorStore) Close() error {
+	var errs []error
+	for _, st := range append([]Interface{s.primary}, s.replicas...) {
+		if err := closeStore(st); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
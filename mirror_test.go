@@ -0,0 +1,98 @@
+package store
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMirrorStore_Suite(t *testing.T) {
+	testAll(t, NewMirrorStore(NewMemStore(), NewMemStore()), "mirror")
+}
+
+// earlyFailingStore fails UploadReader after reading a few bytes.
+type earlyFailingStore struct {
+	Interface
+}
+
+func (s *earlyFailingStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	if _, err := io.ReadFull(reader, make([]byte, 4)); err != nil {
+		return err
+	}
+	return errors.New("connection reset")
+}
+
+func TestMirrorStore(t *testing.T) {
+	primary, replica := NewMemStore(), NewMemStore()
+	st := NewMirrorStore(primary, replica)
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("from file"), 0644))
+
+	var res UploadResult
+	assert.NoError(t, st.UploadData([]byte("data"), "data.txt", ContentHash(ChecksumSHA256, &res)))
+	assert.NoError(t, st.Upload(file, "file.txt"))
+	big := bytes.Repeat([]byte("0123456789"), 100<<10)
+	assert.NoError(t, st.UploadReader(bytes.NewReader(big), int64(len(big)), "reader.bin"))
+	assert.Equal(t, int64(4), res.Size)
+
+	for _, backend := range []Interface{primary, replica} {
+		data, err := backend.DownloadBytes("data.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+		data, err = backend.DownloadBytes("file.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "from file", string(data))
+		data, err = backend.DownloadBytes("reader.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, big, data)
+	}
+
+	assert.NoError(t, replica.Delete("file.txt"))
+	assert.NoError(t, st.Delete("file.txt"), "a replica missing the key shouldn't fail the delete")
+	assert.NoError(t, st.Delete("data.txt"))
+	exists, err := replica.Exists("data.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMirrorStore_Policy(t *testing.T) {
+	primary := NewMemStore()
+	replica := &failingUploadStore{Interface: NewMemStore(), fail: map[string]bool{"a.txt": true}}
+	st := NewMirrorStore(primary, replica)
+	assert.NoError(t, st.UploadData([]byte("a"), "a.txt"), "best effort ignores failed replicas")
+
+	st.(*MirrorStore).WithPolicy(MirrorStrict)
+	assert.Error(t, st.UploadData([]byte("a"), "a.txt"))
+	data, err := primary.DownloadBytes("a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "a", string(data), "the primary keeps the write")
+
+	// a failing primary isn't replicated
+	failing := &failingUploadStore{Interface: NewMemStore(), fail: map[string]bool{"b.txt": true}}
+	replica2 := NewMemStore()
+	assert.Error(t, NewMirrorStore(failing, replica2).UploadData([]byte("b"), "b.txt"))
+	exists, err := replica2.Exists("b.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestMirrorStore_UploadReaderReplicaFails(t *testing.T) {
+	primary, healthy := NewMemStore(), NewMemStore()
+	st := NewMirrorStore(primary, &earlyFailingStore{Interface: NewMemStore()}, healthy).(*MirrorStore)
+	data := bytes.Repeat([]byte("x"), 1<<20)
+
+	assert.NoError(t, st.UploadReader(bytes.NewReader(data), int64(len(data)), "r.bin"))
+	for _, backend := range []Interface{primary, healthy} {
+		got, err := backend.DownloadBytes("r.bin")
+		assert.NoError(t, err)
+		assert.Equal(t, len(data), len(got))
+	}
+
+	st.WithPolicy(MirrorStrict)
+	assert.Error(t, st.UploadReader(bytes.NewReader(data), int64(len(data)), "r2.bin"))
+}
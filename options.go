@@ -0,0 +1,178 @@
+package store
+
+import (
+	"net/http"
+	"os"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Option configures a store created by NewOSStore, NewS3Store,
+// NewS3MultiStore or NewQiniuStore. The decorators created by
+// NewCacheStore, NewEncryptedStore and NewRetryStore only use WithLogger.
+type Option func(*options)
+
+type options struct {
+	logger       *zap.SugaredLogger
+	httpClient   *http.Client
+	atomicWrites bool
+	checksum     ChecksumAlgorithm
+	rateLimit    int64
+	fileMode     os.FileMode
+	dirMode      os.FileMode
+	fsync        bool
+	maxDownload  int64
+	createOnly   bool
+}
+
+func newOptions(opts []Option) options {
+	o := options{
+		logger:       &log.SugaredLogger,
+		atomicWrites: true,
+		checksum:     ChecksumMD5,
+		fileMode:     0644,
+		dirMode:      0755,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithLogger makes the store log to l instead of the package logger.
+// A nil l disables logging.
+func WithLogger(l *zap.SugaredLogger) Option {
+	return func(o *options) {
+		if l == nil {
+			l = zap.NewNop().Sugar()
+		}
+		o.logger = l
+	}
+}
+
+// WithHTTPClient makes the S3 stores send their requests with the transport
+// of client, e.g. to set up proxies or connection pooling. Only the client's
+// Transport is used; the TLS and timeout settings of S3Config are ignored.
+// Other stores ignore this option.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithAtomicWrites controls whether OSStore writes a file to a temporary
+// file in the same directory and renames it into place once complete, so
+// readers never see a partially written file. It's enabled by default;
+// disable it to stream into the destination path directly.
+// Other stores ignore this option.
+func WithAtomicWrites(atomic bool) Option {
+	return func(o *options) {
+		o.atomicWrites = atomic
+	}
+}
+
+// WithCreateOnly makes the uploads of OSStore create-only by default: they
+// fail with ErrAlreadyExists if the file exists unless Overwrite(true),
+// IfMatch or IfNoneMatch is passed. Other stores ignore this option.
+func WithCreateOnly(createOnly bool) Option {
+	return func(o *options) {
+		o.createOnly = createOnly
+	}
+}
+
+// WithChecksumAlgorithm sets the checksum DownloadBytesVerified checks
+// downloads against, ChecksumMD5 by default.
+// Stores that don't verify downloads ignore this option.
+func WithChecksumAlgorithm(alg ChecksumAlgorithm) Option {
+	return func(o *options) {
+		o.checksum = alg
+	}
+}
+
+// WithRateLimit limits every upload and download of the store to
+// bytesPerSec, e.g. to keep a backfill from saturating the uplink. Each
+// transfer is limited on its own, so concurrent transfers add up. It
+// composes with the Progress upload option. Zero, the default, doesn't
+// limit.
+func WithRateLimit(bytesPerSec int64) Option {
+	return func(o *options) {
+		o.rateLimit = bytesPerSec
+	}
+}
+
+// WithFileMode sets the permissions of the files OSStore writes, 0644 by
+// default, e.g. 0664 for group-writable files or 0600 for secrets. The mode
+// is set as is, regardless of the umask.
+// Other stores ignore this option.
+func WithFileMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.fileMode = mode.Perm()
+	}
+}
+
+// WithDirMode sets the permissions of the directories OSStore creates,
+// 0755 by default. Like with os.MkdirAll, the umask applies.
+// Other stores ignore this option.
+func WithDirMode(mode os.FileMode) Option {
+	return func(o *options) {
+		o.dirMode = mode.Perm()
+	}
+}
+
+// WithFsync makes OSStore sync the files it writes, and the directories
+// holding them, to disk before an upload returns, so that an upload that
+// succeeded survives a power loss. It's disabled by default since syncing
+// slows writes down considerably.
+// Other stores ignore this option.
+func WithFsync(fsync bool) Option {
+	return func(o *options) {
+		o.fsync = fsync
+	}
+}
+
+// WithMaxDownloadBytes makes DownloadBytes, DownloadRangeBytes and
+// DownloadBytesVerified fail with ErrTooLarge rather than read more than n
+// bytes into memory, e.g. for services downloading user-controlled keys.
+// At most n+1 bytes are read to find out. Zero, the default, doesn't limit.
+// The readers and the stores other than OSStore, S3Store and QiniuStore
+// ignore this option.
+func WithMaxDownloadBytes(n int64) Option {
+	return func(o *options) {
+		o.maxDownload = n
+	}
+}
+
+// logger is the logger of a store. Its Debugw returns early when debug
+// logging is off so the hot paths don't pay for building the log entry.
+type logger struct {
+	*zap.SugaredLogger
+	core zapcore.Core
+}
+
+func newLogger(l *zap.SugaredLogger) *logger {
+	return &logger{
+		SugaredLogger: l,
+		core:          l.Desugar().Core(),
+	}
+}
+
+// debugEnabled reports whether debug logging is on. A nil logger, as in a
+// store that wasn't created by its constructor, uses the package logger.
+func (l *logger) debugEnabled() bool {
+	if l == nil {
+		return log.Desugar().Core().Enabled(zapcore.DebugLevel)
+	}
+	return l.core.Enabled(zapcore.DebugLevel)
+}
+
+func (l *logger) Debugw(msg string, keysAndValues ...interface{}) {
+	if !l.debugEnabled() {
+		return
+	}
+	if l == nil {
+		log.Debugw(msg, keysAndValues...)
+		return
+	}
+	l.SugaredLogger.Debugw(msg, keysAndValues...)
+}
@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithLogger(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	fake := newFakeS3(t, "test-bucket")
+	st, err := NewS3Store(fake.config("test-bucket"), WithLogger(zap.New(core).Sugar().With("tenant", "t1")))
+	assert.NoError(t, err)
+
+	assert.NoError(t, st.UploadData([]byte("content"), "key"))
+	entries := logs.FilterMessage("uploaded data").All()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "t1", entries[0].ContextMap()["tenant"])
+	for _, e := range logs.All() {
+		assert.NotContains(t, e.ContextMap(), "secret_key")
+	}
+}
+
+func TestWithLogger_Decorators(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	opt := WithLogger(zap.New(core).Sugar())
+
+	flaky := &flakyStore{Interface: NewMemStore(), failures: 1}
+	noSleep := func(context.Context, time.Duration) error { return nil }
+	retry := NewRetryStore(flaky, RetryPolicy{MaxRetries: 1, Sleep: noSleep}, opt)
+	assert.NoError(t, flaky.Interface.UploadData([]byte("content"), "key"))
+	_, err := retry.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, logs.FilterMessage("retrying").Len())
+
+	// A cache directory that is a file fails every cache write.
+	notDir := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(notDir, nil, 0644))
+	cache := NewCacheStore(NewMemStore(), notDir, 1<<20, opt)
+	assert.NoError(t, cache.UploadData([]byte("content"), "key"))
+	_, err = cache.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.NotZero(t, logs.FilterMessage("create cache file").Len())
+
+	enc, err := NewEncryptedStore(NewMemStore(), testEncryptKey(1), opt)
+	assert.NoError(t, err)
+	assert.NoError(t, enc.UploadData([]byte("content"), "data/key"))
+	assert.NoError(t, enc.RotateKey("data/", testEncryptKey(2)))
+	assert.Equal(t, 1, logs.FilterMessage("rotated encryption key").Len())
+}
+
+func TestWithLogger_DebugOff(t *testing.T) {
+	core, logs := observer.New(zapcore.InfoLevel)
+	st := NewOSStore(WithLogger(zap.New(core).Sugar())).(*OSStore)
+	assert.False(t, st.log.debugEnabled())
+
+	assert.NoError(t, st.DeleteDirectory(filepath.Join(t.TempDir(), "missing")))
+	st.log.Debugw("not logged")
+	assert.Zero(t, logs.Len())
+}
+
+func TestWithLogger_Nil(t *testing.T) {
+	st := NewOSStore(WithLogger(nil)).(*OSStore)
+	assert.False(t, st.log.debugEnabled())
+
+	var zero OSStore
+	assert.NotPanics(t, func() {
+		zero.log.Debugw("falls back to the package logger")
+	})
+}
+
+func TestWithMaxDownloadBytes(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	s3, err := NewS3Store(fake.config("test-bucket"), WithMaxDownloadBytes(5), WithChecksumAlgorithm(ChecksumSHA256))
+	assert.NoError(t, err)
+	dir := t.TempDir()
+	stores := map[string]struct {
+		st   Interface
+		root string
+	}{
+		"os": {NewOSStore(WithMaxDownloadBytes(5)), dir},
+		"s3": {s3, "limit"},
+	}
+	for name, tc := range stores {
+		t.Run(name, func(t *testing.T) {
+			small, large := filepath.Join(tc.root, "small"), filepath.Join(tc.root, "large")
+			assert.NoError(t, tc.st.UploadData([]byte("12345"), small, ContentHash(ChecksumSHA256, &UploadResult{})))
+			assert.NoError(t, tc.st.UploadData([]byte("123456"), large, ContentHash(ChecksumSHA256, &UploadResult{})))
+
+			data, err := tc.st.DownloadBytes(small)
+			assert.NoError(t, err)
+			assert.Equal(t, "12345", string(data))
+			_, err = tc.st.DownloadBytes(large)
+			assert.ErrorIs(t, err, ErrTooLarge)
+
+			data, err = tc.st.DownloadRangeBytes(large, 1, -1)
+			assert.NoError(t, err)
+			assert.Equal(t, "23456", string(data))
+			_, err = tc.st.DownloadRangeBytes(large, 0, -1)
+			assert.ErrorIs(t, err, ErrTooLarge)
+
+			r, err := tc.st.DownloadReader(large)
+			assert.NoError(t, err)
+			data, err = io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, "123456", string(data), "readers aren't limited")
+			assert.NoError(t, r.Close())
+		})
+	}
+	_, err = s3.(*S3Store).DownloadBytesVerified("limit/large")
+	assert.ErrorIs(t, err, ErrTooLarge)
+}
@@ -1,127 +1,611 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
 )
 
-func NewOSStore() Interface {
-	return &OSStore{}
+func NewOSStore(opts ...Option) Interface {
+	o := newOptions(opts)
+	return &OSStore{
+		log:          newLogger(o.logger),
+		atomicWrites: o.atomicWrites,
+		rateLimit:    o.rateLimit,
+		fileMode:     o.fileMode,
+		dirMode:      o.dirMode,
+		fsync:        o.fsync,
+		maxDownload:  o.maxDownload,
+		createOnly:   o.createOnly,
+	}
 }
 
 type OSStore struct {
+	log          *logger
+	atomicWrites bool
+	rateLimit    int64
+	fileMode     os.FileMode
+	dirMode      os.FileMode
+	fsync        bool
+	maxDownload  int64
+	createOnly   bool
 }
 
-func (s *OSStore) ListPrefix(key string) (keys []string, err error) {
-	fi, err := os.Stat(key)
+// ListPrefix returns all the files under key, recursively, like the object
+// store backends do. A key naming a file returns that file, and a missing
+// or empty directory returns no keys.
+func (s *OSStore) ListPrefix(key string) ([]string, error) {
+	return s.ListPrefixContext(context.TODO(), key)
+}
+
+// ListPrefixContext walks the directory tree under key like ListPrefix,
+// stopping the walk as soon as ctx is done.
+func (s *OSStore) ListPrefixContext(ctx context.Context, key string) (keys []string, err error) {
+	key, err = NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
+	err = filepath.WalkDir(key, func(p string, d fs.DirEntry, err error) error {
+		if p == key && errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			keys = append(keys, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// IsEmpty reports whether there is nothing under key, reading a single entry
+// of the directory. Unlike ListPrefix, it counts directories as entries: a
+// directory holding only empty directories isn't empty. A file is listed by
+// ListPrefix as its own key, so it isn't empty either.
+func (s *OSStore) IsEmpty(key string) (bool, error) {
+	key, err := NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return false, err
+	}
+	f, err := os.Open(key)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close() // nolint: errcheck
+	fi, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
 	if !fi.IsDir() {
-		keys = append(keys, path.Join(key, fi.Name()))
-		return
+		return false, nil
 	}
-	files, err := os.ReadDir(key)
+	_, err = f.ReadDir(1)
+	if errors.Is(err, io.EOF) {
+		return true, nil
+	}
+	return false, err
+}
+
+// ListPrefixStat walks the directory tree under key like ListPrefix, with
+// the stats of the files.
+func (s *OSStore) ListPrefixStat(key string) (objects []ObjectStat, err error) {
+	err = s.WalkPrefix(context.TODO(), key, func(obj ObjectStat) error {
+		objects = append(objects, obj)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	for _, file := range files {
-		keys = append(keys, path.Join(key, file.Name()))
+	return objects, nil
+}
+
+// WalkPrefix walks the directory tree under key like ListPrefix, calling fn
+// with the stat of each file.
+func (s *OSStore) WalkPrefix(ctx context.Context, key string, fn WalkFunc) error {
+	key, err := NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return err
+	}
+	err = filepath.WalkDir(key, func(p string, d fs.DirEntry, err error) error {
+		if p == key && errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return fn(ObjectStat{
+			Key: p,
+			FileStat: FileStat{
+				Size:    fi.Size(),
+				ETag:    osETag(fi),
+				ModTime: fi.ModTime(),
+			},
+		})
+	})
+	return walkResult(err)
+}
+
+// PrefixUsage walks the directory tree under key like ListPrefix, summing
+// up the sizes of the files.
+func (s *OSStore) PrefixUsage(key string) (totalBytes int64, count int64, err error) {
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return 0, 0, err
+	}
+	err = filepath.WalkDir(key, func(p string, d fs.DirEntry, err error) error {
+		if p == key && errors.Is(err, fs.ErrNotExist) {
+			return filepath.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		totalBytes += fi.Size()
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return totalBytes, count, nil
+}
+
+// Touch sets the access and modification times of the file to now.
+func (s *OSStore) Touch(key string) error {
+	key, err := NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return err
 	}
-	return
+	fi, err := os.Stat(key)
+	if err != nil {
+		return osError(err)
+	}
+	if fi.IsDir() {
+		return fmt.Errorf("%s is a directory: %w", key, ErrNotFound)
+	}
+	t := now()
+	return osError(os.Chtimes(key, t, t))
+}
+
+// ListPrefixDepth walks the directory tree under key down to maxDepth
+// levels without descending into the directories at maxDepth.
+func (s *OSStore) ListPrefixDepth(key string, maxDepth int) (keys []string, err error) {
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkListDepth(maxDepth); err != nil {
+		return nil, err
+	}
+	root := filepath.Clean(key)
+	err = filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		depth := len(strings.Split(filepath.ToSlash(rel), "/"))
+		if !d.IsDir() {
+			keys = append(keys, p)
+			return nil
+		}
+		if depth >= maxDepth {
+			keys = append(keys, makeSureKeyAsDir(p))
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListRollup walks the directory tree under key and rolls up the files deeper
+// than depth under their ancestor directory at that depth.
+func (s *OSStore) ListRollup(key string, depth int) ([]RollupEntry, error) {
+	key, err := NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRollup(key, depth)
+	if err != nil {
+		return nil, err
+	}
+	err = filepath.WalkDir(key, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		r.add(filepath.ToSlash(p), fi.Size())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.result(), nil
 }
 
 // Stat returns a FileStat for the given key.
+// Directories are not objects, so they are reported as not existing.
 func (s *OSStore) Stat(key string) (FileStat, error) {
-	fileInfo, err := os.Stat(key)
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return FileStat{}, err
 	}
+	fileInfo, err := os.Stat(key)
+	if err != nil {
+		return FileStat{}, osError(err)
+	}
+	if fileInfo.IsDir() {
+		return FileStat{}, fmt.Errorf("%s is a directory: %w", key, ErrNotFound)
+	}
 	return FileStat{
-		Size: fileInfo.Size(),
+		Size:    fileInfo.Size(),
+		ETag:    osETag(fileInfo),
+		ModTime: fileInfo.ModTime(),
 	}, nil
 }
 
-// UploadData writes data to the given file.
-// If the file already exists, it will return an error.
-func (s *OSStore) UploadData(data []byte, key string) (err error) {
+// osETag derives the ETag of a file from its modification time and size,
+// which doesn't require reading the file. Conditional uploads make sure the
+// ETag of the file they write changes even within the timestamp resolution
+// of the file system.
+func osETag(fi fs.FileInfo) string {
+	return fmt.Sprintf("%x-%x", fi.ModTime().UnixNano(), fi.Size())
+}
+
+// uploadOptions returns the options of an upload, create-only by default
+// for a store created WithCreateOnly.
+func (s *OSStore) uploadOptions(opts []UploadOption) UploadOptions {
+	if s.createOnly {
+		return createOnlyUploadOptions(opts...)
+	}
+	return NewUploadOptions(opts...)
+}
+
+// UploadData writes data to the given file, replacing it unless
+// Overwrite(false) is passed, see UploadOptions.
+func (s *OSStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return err
+	}
+	o := s.uploadOptions(opts)
 	dir := path.Dir(key)
-	err = os.MkdirAll(dir, 0755)
+	err = os.MkdirAll(dir, s.dirMode)
 	if err != nil {
 		return err
 	}
-	_, err = os.Stat(key)
-	if !os.IsNotExist(err) {
-		if err == nil {
-			// file exists
-			return fmt.Errorf("file %s already exists", key)
-		}
+	if err := s.checkOverwrite(key, o); err != nil {
 		return err
 	}
-	if err := os.WriteFile(key, data, 0644); err != nil {
-		return fmt.Errorf("write file %s error: %s", key, err.Error())
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
 	}
+	err = s.writeFile(key, s.fileMode, o, func(f *os.File) error {
+		hasher.data(data)
+		_, err := io.Copy(f, newProgressReader(s.limiter().reader(bytes.NewReader(data)), int64(len(data)), o.Progress))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write file %s error: %w", key, err)
+	}
+	hasher.done()
 	return nil
 }
 
 // Upload "upload local file to local", it means just copy the file.
-func (s *OSStore) Upload(file string, key string) (err error) {
-	e, err := s.Exists(key)
+func (s *OSStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	key, err = NormalizeKey(OSProtocol, key)
 	if err != nil {
-		return fmt.Errorf("check exists: %s", err)
+		return err
+	}
+	o := s.uploadOptions(opts)
+	if err := s.checkOverwrite(key, o); err != nil {
+		return err
 	}
-	if e {
-		return fmt.Errorf("file %s already exists", key)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
 	}
 	src, err := os.Open(file)
 	if err != nil {
 		return fmt.Errorf("open file %s error: %s", file, err)
 	}
 	defer src.Close() // nolint: errcheck
-	dest, err := os.Create(key)
+	err = os.MkdirAll(path.Dir(key), s.dirMode)
 	if err != nil {
-		return fmt.Errorf("create file %s error: %s", key, err)
+		return err
 	}
-	defer dest.Close() // nolint: errcheck
-	_, err = io.Copy(dest, src)
+	total, mode := int64(-1), s.fileMode
+	if fi, err := src.Stat(); err == nil {
+		total = fi.Size()
+		if o.PreserveMode {
+			mode = fi.Mode().Perm()
+		}
+	} else if o.PreserveMode {
+		return fmt.Errorf("stat file %s: %w", file, err)
+	}
+	err = s.writeFile(key, mode, o, func(dest *os.File) error {
+		_, err := io.Copy(dest, newProgressReader(s.limiter().reader(hasher.reader(src)), total, o.Progress))
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("copy file %s error: %s", key, err)
+		return fmt.Errorf("copy file %s error: %w", key, err)
 	}
+	hasher.done()
 	return nil
 }
 
-// UploadReader writes the reader to a file.
-func (s *OSStore) UploadReader(reader io.Reader, _ int64, key string) (err error) {
+// UploadReader writes the reader to a file. The size is only used to report
+// progress and may be -1 if it isn't known.
+func (s *OSStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return err
+	}
+	o := s.uploadOptions(opts)
 	dir := path.Dir(key)
-	err = os.MkdirAll(dir, 0755)
+	err = os.MkdirAll(dir, s.dirMode)
 	if err != nil {
 		return err
 	}
-	_, err = os.Stat(key)
-	if !os.IsNotExist(err) {
-		if err == nil {
-			// file exists
-			return fmt.Errorf("file %s already exists", key)
-		}
+	if err := s.checkOverwrite(key, o); err != nil {
+		return err
+	}
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	err = s.writeFile(key, s.fileMode, o, func(file *os.File) error {
+		_, err := io.Copy(file, newProgressReader(s.limiter().reader(hasher.reader(reader)), size, o.Progress))
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("write file %s error: %w", key, err)
+	}
+	hasher.done()
+	return nil
+}
+
+// AppendData appends data to the file, creating it and its directory if
+// they don't exist. Appends bypass atomic writes: data is written to the end
+// of the file in place, with a single write so that concurrent appends of
+// up to PIPE_BUF bytes don't interleave on POSIX systems.
+func (s *OSStore) AppendData(data []byte, key string) (err error) {
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
 		return err
 	}
-	file, err := os.Create(key)
+	if err := os.MkdirAll(path.Dir(key), s.dirMode); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(key, os.O_WRONLY|os.O_APPEND|os.O_CREATE, s.fileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("append to file %s: %w", key, err)
+	}
+	if err := s.sync(f); err != nil {
+		return err
+	}
+	s.log.Debugw("appended data", "key", key, "size", len(data))
+	return f.Close()
+}
+
+// checkOverwrite fails early, before anything is written, if a create-only
+// upload targets an existing file. writeFile checks again when it creates
+// the file, so a concurrent writer can't be overwritten either.
+func (s *OSStore) checkOverwrite(key string, o UploadOptions) error {
+	if o.Overwrite {
+		return nil
+	}
+	exists, err := s.Exists(key)
 	if err != nil {
-		return fmt.Errorf("create file %s error: %s", key, err)
+		return fmt.Errorf("check exists: %w", err)
+	}
+	if exists {
+		return fmt.Errorf("file %s: %w", key, ErrAlreadyExists)
+	}
+	return nil
+}
+
+// writeFile creates key with mode and fills it with write. An upload with
+// IfMatch or IfNoneMatch compares the ETag of key and writes it while holding
+// a lock on its directory, so racing conditional uploads don't lose updates.
+func (s *OSStore) writeFile(key string, mode os.FileMode, o UploadOptions, write func(f *os.File) error) error {
+	if !o.conditional() {
+		return s.createFile(key, mode, o, write)
+	}
+	unlock, err := lockDir(filepath.Dir(key))
+	if err != nil {
+		return fmt.Errorf("lock directory: %w", err)
+	}
+	defer unlock()
+	var etag string
+	fi, err := os.Stat(key)
+	exists := err == nil
+	switch {
+	case exists:
+		etag = osETag(fi)
+	case !errors.Is(err, fs.ErrNotExist):
+		return err
+	}
+	if err := o.checkETag(key, etag, exists); err != nil {
+		return err
+	}
+	if err := s.createFile(key, mode, o, write); err != nil {
+		return err
+	}
+	if !exists {
+		return nil
 	}
-	defer file.Close() // nolint: errcheck
-	_, err = io.Copy(file, reader)
+	// the new content must not be mistaken for the old one
+	nfi, err := os.Stat(key)
 	if err != nil {
-		return fmt.Errorf("write file %s error: %s", key, err)
+		return err
+	}
+	if osETag(nfi) == etag {
+		mtime := nfi.ModTime().Add(time.Nanosecond)
+		return os.Chtimes(key, mtime, mtime)
 	}
 	return nil
 }
 
+// createFile creates key with mode and fills it with write. With atomic
+// writes, the content goes to a temporary file in the same directory that is
+// moved to key once complete, so key never holds a partial file; the
+// temporary file is removed if anything fails. A create-only upload fails
+// with ErrAlreadyExists if key exists when it's created. With fsync, the file
+// and its directory are synced before it returns.
+func (s *OSStore) createFile(key string, mode os.FileMode, o UploadOptions, write func(f *os.File) error) (err error) {
+	if !s.atomicWrites {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if !o.Overwrite {
+			flags |= os.O_EXCL
+		}
+		f, err := os.OpenFile(key, flags, mode)
+		if errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("file %s: %w", key, ErrAlreadyExists)
+		}
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint: errcheck
+		// set the mode regardless of the umask and of the mode of the
+		// file being replaced, like the rename of an atomic write does
+		if err := f.Chmod(mode); err != nil {
+			return err
+		}
+		if err := write(f); err != nil {
+			return err
+		}
+		return s.sync(f)
+	}
+	f, err := os.CreateTemp(filepath.Dir(key), "."+filepath.Base(key)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if err = write(f); err != nil {
+		return err
+	}
+	// CreateTemp creates the file with mode 0600
+	if err = f.Chmod(mode); err != nil {
+		return err
+	}
+	if s.fsync {
+		if err = f.Sync(); err != nil {
+			return err
+		}
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if o.Overwrite {
+		if err = os.Rename(f.Name(), key); err != nil {
+			return err
+		}
+		return s.syncDir(filepath.Dir(key))
+	}
+	// unlike rename, link fails if key exists
+	err = os.Link(f.Name(), key)
+	if errors.Is(err, fs.ErrExist) {
+		err = fmt.Errorf("file %s: %w", key, ErrAlreadyExists)
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(f.Name()); err != nil {
+		return err
+	}
+	return s.syncDir(filepath.Dir(key))
+}
+
+// sync syncs a file written in place and its directory if the store syncs
+// its writes.
+func (s *OSStore) sync(f *os.File) error {
+	if !s.fsync {
+		return nil
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	return s.syncDir(filepath.Dir(f.Name()))
+}
+
+// syncDir syncs the entries of dir if the store syncs its writes.
+func (s *OSStore) syncDir(dir string) error {
+	if !s.fsync {
+		return nil
+	}
+	return syncDir(dir)
+}
+
 // DeleteDirectory deletes a directory and all of its contents.
 // If the directory is empty, return nil.
 func (s *OSStore) DeleteDirectory(dir string) (err error) {
+	dir, err = NormalizeKey(OSProtocol, dir)
+	if err != nil {
+		return err
+	}
 	st, err := os.Stat(dir)
 	if os.IsNotExist(err) {
 		return nil
@@ -129,22 +613,51 @@ func (s *OSStore) DeleteDirectory(dir string) (err error) {
 	if !st.IsDir() {
 		return fmt.Errorf("%s is not a directory", dir)
 	}
+	s.log.Debugw("delete directory", "dir", dir)
 	return os.RemoveAll(dir)
 }
 
+// DeleteDirectoryPreview lists the files DeleteDirectory(dir) would remove.
+func (s *OSStore) DeleteDirectoryPreview(dir string) ([]string, error) {
+	dir, err := NormalizeKey(OSProtocol, dir)
+	if err != nil {
+		return nil, err
+	}
+	st, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !st.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	return s.ListPrefix(dir)
+}
+
 // Delete removes a file.
 // with same behavior as os.Remove.
 func (s *OSStore) Delete(key string) (err error) {
-	return os.Remove(key)
+	key, err = NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return err
+	}
+	return osError(os.Remove(key))
 }
 
 // Exists checks if a file exists.
+// Directories are not objects, so Exists reports false for them.
 func (s *OSStore) Exists(key string) (bool, error) {
-	_, err := os.Stat(key)
+	key, err := NormalizeKey(OSProtocol, key)
+	if err != nil {
+		return false, err
+	}
+	fi, err := os.Stat(key)
 	if !os.IsNotExist(err) {
 		if err == nil {
 			// file exists
-			return true, nil
+			return !fi.IsDir(), nil
 		}
 		// other error
 		return false, err
@@ -153,44 +666,67 @@ func (s *OSStore) Exists(key string) (bool, error) {
 }
 
 func (s *OSStore) DownloadBytes(key string) ([]byte, error) {
-	f, err := os.Open(key)
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, osError(err)
+	}
 	defer f.Close() // nolint: errcheck
-	return io.ReadAll(f)
+	return readAll(s.limiter().reader(f), s.maxDownload)
 }
 
 func (s *OSStore) DownloadReader(key string) (io.ReadCloser, error) {
-	f, err := os.Open(key)
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
-	return f, nil
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, osError(err)
+	}
+	return s.limiter().readCloser(f), nil
 }
 
-func (s *OSStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
-	f, err := os.Open(key)
+// DownloadReaderIf opens the file unless its ETag or modification time
+// matches cond.
+func (s *OSStore) DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error) {
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close() // nolint: errcheck
-	no, err := f.Seek(offset, 0)
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, osError(err)
+	}
+	fi, err := f.Stat()
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
-	if offset != no {
-		return nil, fmt.Errorf("seek offset not matched, expected %d, got %d", offset, no)
+	if cond.notModified(osETag(fi), fi.ModTime()) {
+		_ = f.Close()
+		return nil, fmt.Errorf("file %s: %w", key, ErrNotModified)
 	}
-	buf := make([]byte, size)
-	n, err := f.Read(buf)
+	return s.limiter().readCloser(f), nil
+}
+
+// DownloadRangeBytes reads size bytes starting at offset.
+// The range is clamped to the end of the file, and a negative size reads
+// until the end of the file.
+func (s *OSStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
-	if int64(n) != size {
-		return nil, fmt.Errorf("read size not matched, expected %d, got %d", size, n)
+	r, err := s.DownloadRangeReader(key, offset, size)
+	if err != nil {
+		return nil, err
 	}
-	return buf, nil
+	defer r.Close() // nolint: errcheck
+	return readAll(r, s.maxDownload)
 }
 
 type rangeReaderCloser struct {
@@ -202,20 +738,74 @@ func (r *rangeReaderCloser) Close() error {
 	return r.closer()
 }
 
+// DownloadRangeReader opens the file positioned at offset, limited to size bytes.
+// The range is clamped to the end of the file, and a negative size reads
+// until the end of the file.
 func (s *OSStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
-	f, err := os.Open(key)
+	key, err := NormalizeKey(OSProtocol, key)
 	if err != nil {
 		return nil, err
 	}
-	no, err := f.Seek(offset, 0)
+	f, err := os.Open(key)
+	if err != nil {
+		return nil, osError(err)
+	}
+	no, err := f.Seek(offset, io.SeekStart)
 	if err != nil {
+		_ = f.Close()
 		return nil, err
 	}
 	if offset != no {
+		_ = f.Close()
 		return nil, fmt.Errorf("seek offset not matched, expected %d, got %d", offset, no)
 	}
+	if size < 0 {
+		return s.limiter().readCloser(f), nil
+	}
+	return &rangeReaderCloser{s.limiter().reader(io.LimitReader(f, size)), f.Close}, nil
+}
+
+// limiter returns the rate limiter of a transfer, nil without a rate limit.
+func (s *OSStore) limiter() *rateLimiter {
+	return newRateLimiter(s.rateLimit)
+}
+
+// osError wraps a missing file error with ErrNotFound, keeping the original
+// error so os.IsNotExist-style checks with errors.Is still work.
+func osError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+// HealthCheck stats the root directory.
+func (s *OSStore) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := os.Stat(string(filepath.Separator))
+	return err
+}
 
-	return &rangeReaderCloser{io.LimitReader(f, size), f.Close}, nil
+// Close is a no-op, OSStore holds no resources between calls.
+func (s *OSStore) Close() error {
+	return nil
 }
 
-var _ Interface = &OSStore{}
+var (
+	_ Interface             = &OSStore{}
+	_ io.Closer             = &OSStore{}
+	_ HealthChecker         = &OSStore{}
+	_ RollupLister          = &OSStore{}
+	_ DepthLister           = &OSStore{}
+	_ ConditionalDownloader = &OSStore{}
+	_ StatLister            = &OSStore{}
+	_ Walker                = &OSStore{}
+	_ ContextLister         = &OSStore{}
+	_ UsageReporter         = &OSStore{}
+	_ Toucher               = &OSStore{}
+	_ DeletePreviewer       = &OSStore{}
+	_ EmptyChecker          = &OSStore{}
+	_ Appender              = &OSStore{}
+)
@@ -0,0 +1,14 @@
+//go:build !unix
+
+package store
+
+import "sync"
+
+var dirLock sync.Mutex
+
+// lockDir serializes the conditional uploads of this process. Without flock,
+// other processes aren't excluded.
+func lockDir(string) (unlock func(), err error) {
+	dirLock.Lock()
+	return dirLock.Unlock, nil
+}
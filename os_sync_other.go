@@ -0,0 +1,8 @@
+//go:build !unix
+
+package store
+
+// syncDir is a no-op where directories can't be opened for syncing.
+func syncDir(string) error {
+	return nil
+}
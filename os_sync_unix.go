@@ -0,0 +1,16 @@
+//go:build unix
+
+package store
+
+import "os"
+
+// syncDir syncs the entries of dir, making the files created in or renamed
+// into it durable.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	return f.Sync()
+}
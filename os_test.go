@@ -2,10 +2,17 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -213,3 +220,370 @@ func TestOSStore_DownloadRangeReader(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, data[:4], content)
 }
+
+func TestOSStore_ListRollup(t *testing.T) {
+	store := NewOSStore().(*OSStore)
+	dir := t.TempDir()
+	files := map[string]string{
+		"top.txt":     "1",
+		"a/one.txt":   "22",
+		"a/b/two.txt": "333",
+		"a/b/c/3.txt": "4444",
+		"d/four.txt":  "55555",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	entries, err := store.ListRollup(dir, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []RollupEntry{
+		{Prefix: dir + "/a/", IsDir: true, Count: 3, Size: 9},
+		{Prefix: dir + "/d/", IsDir: true, Count: 1, Size: 5},
+		{Prefix: dir + "/top.txt", Count: 1, Size: 1},
+	}, entries)
+
+	entries, err = store.ListRollup(dir, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []RollupEntry{
+		{Prefix: dir + "/a/b/", IsDir: true, Count: 2, Size: 7},
+		{Prefix: dir + "/a/one.txt", Count: 1, Size: 2},
+		{Prefix: dir + "/d/four.txt", Count: 1, Size: 5},
+		{Prefix: dir + "/top.txt", Count: 1, Size: 1},
+	}, entries)
+
+	_, err = store.ListRollup(dir, 0)
+	assert.Error(t, err, "expected error for invalid depth")
+}
+
+func TestOSStore_DownloadRangeBytes_PastEOF(t *testing.T) {
+	store := NewOSStore()
+	file := filepath.Join(t.TempDir(), "file.txt")
+	data := []byte("content")
+	_ = os.WriteFile(file, data, 0644)
+
+	content, err := store.DownloadRangeBytes(file, 4, 100)
+	assert.NoError(t, err, "size past EOF should be clamped")
+	assert.Equal(t, data[4:], content)
+
+	content, err = store.DownloadRangeBytes(file, int64(len(data)), 4)
+	assert.NoError(t, err, "offset at EOF should not error")
+	assert.Empty(t, content)
+
+	content, err = store.DownloadRangeBytes(file, 2, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, data[2:], content, "size -1 should read to the end")
+}
+
+func TestOSStore(t *testing.T) {
+	testAll(t, NewOSStore(), t.TempDir())
+}
+
+func BenchmarkOSStore(b *testing.B) {
+	benchAll(b, NewOSStore(), b.TempDir())
+}
+
+func TestOSStore_Fsync(t *testing.T) {
+	t.Run("atomic", func(t *testing.T) {
+		testAll(t, NewOSStore(WithFsync(true)), t.TempDir())
+	})
+	t.Run("in place", func(t *testing.T) {
+		testAll(t, NewOSStore(WithFsync(true), WithAtomicWrites(false)), t.TempDir())
+	})
+}
+
+func TestOSStore_ListPrefixDepth(t *testing.T) {
+	store := NewOSStore().(*OSStore)
+	dir := t.TempDir()
+	for _, name := range []string{"top.txt", "a/one.txt", "a/b/two.txt", "a/b/c/three.txt", "a/b/c/d/four.txt"} {
+		assert.NoError(t, store.UploadData([]byte(name), filepath.Join(dir, name)))
+	}
+
+	keys, err := store.ListPrefixDepth(dir, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/a/b/", dir + "/a/one.txt", dir + "/top.txt"}, keys)
+
+	keys, err = store.ListPrefixDepth(dir, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/a/b/c/d/", dir + "/a/b/c/three.txt", dir + "/a/b/two.txt", dir + "/a/one.txt", dir + "/top.txt"}, keys)
+
+	_, err = store.ListPrefixDepth(dir, 0)
+	assert.Error(t, err, "expected error for invalid depth")
+}
+
+func TestOSStore_AlreadyExists(t *testing.T) {
+	store := NewOSStore(WithCreateOnly(true))
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, store.UploadData([]byte("content"), file))
+
+	assert.ErrorIs(t, store.UploadData([]byte("other"), file), ErrAlreadyExists)
+	assert.ErrorIs(t, store.UploadReader(bytes.NewReader([]byte("other")), 5, file), ErrAlreadyExists)
+	assert.ErrorIs(t, store.Upload(file, file), ErrAlreadyExists)
+	assert.ErrorIs(t, store.UploadData([]byte("other"), file, Overwrite(false)), ErrAlreadyExists)
+	streamed := NewOSStore(WithCreateOnly(true), WithAtomicWrites(false))
+	assert.ErrorIs(t, streamed.UploadData([]byte("other"), file), ErrAlreadyExists)
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(content))
+
+	assert.NoError(t, store.UploadData([]byte("other"), file, Overwrite(true)))
+	assert.NoError(t, streamed.UploadData([]byte("again"), file, Overwrite(true)))
+	content, err = os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "again", string(content))
+
+	_, err = store.Stat(filepath.Join(filepath.Dir(file), "missing.txt"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.True(t, errors.Is(err, fs.ErrNotExist), "the os error should still be wrapped")
+}
+
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("connection reset")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestOSStore_AtomicWrites(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file.txt")
+
+	store := NewOSStore()
+	err := store.UploadReader(&failingReader{data: []byte("partial")}, 100, file)
+	assert.Error(t, err)
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, entries, "neither the file nor the temporary file should be left")
+
+	assert.NoError(t, store.UploadData([]byte("content"), file))
+	fi, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0644), fi.Mode().Perm())
+
+	store = NewOSStore(WithAtomicWrites(false))
+	file = filepath.Join(dir, "streamed.txt")
+	err = store.UploadReader(&failingReader{data: []byte("partial")}, 100, file)
+	assert.Error(t, err)
+	content, err := os.ReadFile(file)
+	assert.NoError(t, err, "a streamed write leaves what was written so far")
+	assert.Equal(t, "partial", string(content))
+}
+
+func TestOSStore_IfMatchRace(t *testing.T) {
+	store := NewOSStore()
+	file := filepath.Join(t.TempDir(), "counter")
+	assert.NoError(t, store.UploadData([]byte("0000"), file))
+
+	const writers = 8
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				stat, err := store.Stat(file)
+				if !assert.NoError(t, err) {
+					return
+				}
+				data, err := store.DownloadBytes(file)
+				if !assert.NoError(t, err) {
+					return
+				}
+				n, _ := strconv.Atoi(string(data))
+				// same size content, so the ETag only changes with the mtime
+				err = store.UploadData([]byte(fmt.Sprintf("%04d", n+1)), file, IfMatch(stat.ETag))
+				if errors.Is(err, ErrPreconditionFailed) {
+					continue
+				}
+				assert.NoError(t, err)
+				return
+			}
+		}()
+	}
+	wg.Wait()
+	data, err := store.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%04d", writers), string(data), "no update should be lost")
+}
+
+func TestOSStore_RateLimit(t *testing.T) {
+	store := NewOSStore(WithRateLimit(256 << 10))
+	file := filepath.Join(t.TempDir(), "limited.bin")
+	data := bytes.Repeat([]byte("x"), 64<<10)
+
+	var transferred int64
+	start := time.Now()
+	err := store.UploadReader(bytes.NewReader(data), int64(len(data)), file, Progress(func(n, _ int64) {
+		transferred = n
+	}))
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+	assert.Equal(t, int64(len(data)), transferred)
+
+	start = time.Now()
+	got, err := store.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestOSStore_Touch(t *testing.T) {
+	clock := newFakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	st := NewOSStore()
+	file := filepath.Join(t.TempDir(), "touch.txt")
+	assert.NoError(t, st.UploadData([]byte("data"), file))
+
+	clock.Advance(time.Hour)
+	assert.NoError(t, st.(Toucher).Touch(file))
+	stat, err := st.Stat(file)
+	assert.NoError(t, err)
+	assert.True(t, stat.ModTime.Equal(clock.Now()), "got %v", stat.ModTime)
+	assert.ErrorIs(t, st.(Toucher).Touch(filepath.Dir(file)), ErrNotFound)
+}
+
+func TestOSStore_Traversal(t *testing.T) {
+	st := NewOSStore()
+	dir := t.TempDir()
+	key := filepath.Join(dir, "sub") + "/../../escaped.txt"
+	assert.ErrorIs(t, st.UploadData([]byte("data"), key), ErrInvalidKey)
+	_, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.txt"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = st.DownloadBytes("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrInvalidKey)
+	assert.ErrorIs(t, st.Delete(key), ErrInvalidKey)
+	assert.ErrorIs(t, st.DeleteDirectory(dir+"/.."), ErrInvalidKey)
+}
+
+func TestOSStore_ListPrefixContext(t *testing.T) {
+	store := NewOSStore()
+	dir := t.TempDir()
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, store.UploadData([]byte("x"), filepath.Join(dir, strconv.Itoa(i))))
+	}
+	keys, err := ListPrefixContext(context.Background(), store, dir)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 10)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	keys, err = ListPrefixContext(ctx, store, dir)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, keys)
+}
+
+func TestOSStore_IsEmpty(t *testing.T) {
+	st := NewOSStore()
+	dir := t.TempDir()
+
+	empty, err := IsEmpty(st, dir)
+	assert.NoError(t, err)
+	assert.True(t, empty)
+	empty, err = IsEmpty(st, filepath.Join(dir, "missing"))
+	assert.NoError(t, err)
+	assert.True(t, empty)
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "sub"), 0755))
+	empty, err = IsEmpty(st, dir)
+	assert.NoError(t, err)
+	assert.False(t, empty, "a directory with a subdirectory isn't empty")
+
+	file := filepath.Join(dir, "sub", "file.txt")
+	assert.NoError(t, os.WriteFile(file, []byte("content"), 0644))
+	empty, err = IsEmpty(st, file)
+	assert.NoError(t, err)
+	assert.False(t, empty)
+}
+
+func TestOSStore_UploadReader_UnknownSize(t *testing.T) {
+	st := NewOSStore()
+	key := filepath.Join(t.TempDir(), "stream.bin")
+	assert.NoError(t, st.UploadReader(bytes.NewReader([]byte("streamed")), -1, key))
+	data, err := os.ReadFile(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "streamed", string(data))
+}
+
+func TestOSStore_Upload_NestedDestination(t *testing.T) {
+	st := NewOSStore()
+	src := filepath.Join(t.TempDir(), "src.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("content"), 0644))
+
+	key := filepath.Join(t.TempDir(), "a", "b", "c", "dest.txt")
+	assert.NoError(t, st.Upload(src, key))
+	data, err := os.ReadFile(key)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	missing := filepath.Join(t.TempDir(), "x", "dest.txt")
+	assert.Error(t, st.Upload(filepath.Join(t.TempDir(), "missing.txt"), missing))
+	_, err = os.Stat(filepath.Dir(missing))
+	assert.True(t, os.IsNotExist(err), "no directory is created for a missing source")
+}
+
+func TestOSStore_FileMode(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "src.txt")
+	assert.NoError(t, os.WriteFile(src, []byte("content"), 0644))
+
+	for _, atomic := range []bool{true, false} {
+		t.Run(fmt.Sprintf("atomic=%v", atomic), func(t *testing.T) {
+			dir := t.TempDir()
+			for _, mode := range []os.FileMode{0600, 0664} {
+				st := NewOSStore(WithAtomicWrites(atomic), WithFileMode(mode), WithDirMode(0700))
+				sub := filepath.Join(dir, mode.String())
+				uploads := map[string]func(key string) error{
+					"data": func(key string) error {
+						return st.UploadData([]byte("content"), key)
+					},
+					"reader": func(key string) error {
+						return st.UploadReader(bytes.NewReader([]byte("content")), 7, key)
+					},
+					"file": func(key string) error {
+						return st.Upload(src, key)
+					},
+				}
+				for name, upload := range uploads {
+					key := filepath.Join(sub, name, "file.txt")
+					assert.NoError(t, upload(key))
+					fi, err := os.Stat(key)
+					assert.NoError(t, err)
+					assert.Equal(t, mode, fi.Mode().Perm(), name)
+					fi, err = os.Stat(filepath.Dir(key))
+					assert.NoError(t, err)
+					assert.Equal(t, os.FileMode(0700), fi.Mode().Perm(), name)
+				}
+			}
+		})
+	}
+}
+
+func TestOSStore_Upload_PreserveMode(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "run.sh")
+	assert.NoError(t, os.WriteFile(src, []byte("#!/bin/sh\n"), 0644))
+	assert.NoError(t, os.Chmod(src, 0750))
+
+	for _, atomic := range []bool{true, false} {
+		st := NewOSStore(WithAtomicWrites(atomic))
+		dir := t.TempDir()
+
+		key := filepath.Join(dir, "kept.sh")
+		assert.NoError(t, st.Upload(src, key, PreserveMode(true)))
+		fi, err := os.Stat(key)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0750), fi.Mode().Perm())
+
+		key = filepath.Join(dir, "default.sh")
+		assert.NoError(t, st.Upload(src, key))
+		fi, err = os.Stat(key)
+		assert.NoError(t, err)
+		assert.Equal(t, os.FileMode(0644), fi.Mode().Perm(), "the mode is only kept on request")
+	}
+}
@@ -0,0 +1,166 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+var (
+	_ Interface = &PrefixStore{}
+	_ io.Closer = &PrefixStore{}
+)
+
+// PrefixStore scopes a store to the keys under a prefix, e.g. to give each
+// tenant of a shared bucket its own namespace. Keys are relative to the
+// namespace: the prefix is prepended to the keys passed in and stripped from
+// the keys listed. Keys with ".." elements are rejected, so nothing outside
+// the namespace can be reached, even on an OSStore.
+type PrefixStore struct {
+	inner  Interface
+	prefix string
+}
+
+// NewPrefixStore creates a PrefixStore keeping its keys under prefix on
+// inner. The prefix is treated as a directory, "tenant-a" and "tenant-a/"
+// both scope the keys to "tenant-a/".
+func NewPrefixStore(inner Interface, prefix string) Interface {
+	if prefix != "" {
+		prefix = makeSureKeyAsDir(prefix)
+	}
+	return &PrefixStore{inner: inner, prefix: prefix}
+}
+
+// key maps a key of the namespace to the key on the wrapped store. A leading
+// slash is dropped so that "/a" and "a" are the same key.
+func (s *PrefixStore) key(key string) (string, error) {
+	if hasDotDot(key) {
+		return "", fmt.Errorf("key %s is outside the namespace: %w", key, ErrInvalidKey)
+	}
+	if s.prefix == "" {
+		return key, nil
+	}
+	return s.prefix + objectKey(key), nil
+}
+
+// relative maps a key listed on the wrapped store back into the namespace.
+// Stores that strip the leading slash of keys list them without it.
+func (s *PrefixStore) relative(key string) (string, bool) {
+	if rel, ok := strings.CutPrefix(key, s.prefix); ok {
+		return rel, true
+	}
+	return strings.CutPrefix(key, strings.TrimPrefix(s.prefix, "/"))
+}
+
+func (s *PrefixStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.inner.UploadData(data, k, opts...)
+}
+
+func (s *PrefixStore) Upload(file string, key string, opts ...UploadOption) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.inner.Upload(file, k, opts...)
+}
+
+func (s *PrefixStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.inner.UploadReader(reader, size, k, opts...)
+}
+
+func (s *PrefixStore) DeleteDirectory(dir string) error {
+	k, err := s.key(dir)
+	if err != nil {
+		return err
+	}
+	return s.inner.DeleteDirectory(k)
+}
+
+func (s *PrefixStore) Delete(key string) error {
+	k, err := s.key(key)
+	if err != nil {
+		return err
+	}
+	return s.inner.Delete(k)
+}
+
+func (s *PrefixStore) Exists(key string) (bool, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return false, err
+	}
+	return s.inner.Exists(k)
+}
+
+func (s *PrefixStore) Stat(key string) (FileStat, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return FileStat{}, err
+	}
+	return s.inner.Stat(k)
+}
+
+func (s *PrefixStore) DownloadBytes(key string) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.inner.DownloadBytes(k)
+}
+
+func (s *PrefixStore) DownloadReader(key string) (io.ReadCloser, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.inner.DownloadReader(k)
+}
+
+func (s *PrefixStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.inner.DownloadRangeBytes(k, offset, size)
+}
+
+func (s *PrefixStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.inner.DownloadRangeReader(k, offset, size)
+}
+
+// ListPrefix lists the keys of the namespace starting with key, relative to
+// the namespace. An empty key lists the whole namespace.
+func (s *PrefixStore) ListPrefix(key string) ([]string, error) {
+	k, err := s.key(key)
+	if err != nil {
+		return nil, err
+	}
+	listed, err := s.inner.ListPrefix(k)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(listed))
+	for _, k := range listed {
+		if rel, ok := s.relative(k); ok {
+			keys = append(keys, rel)
+		}
+	}
+	return keys, nil
+}
+
+// Close closes the wrapped store.
+func (s *PrefixStore) Close() error {
+	return closeStore(s.inner)
+}
@@ -0,0 +1,55 @@
+package store
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrefixStore_Suite(t *testing.T) {
+	testAll(t, NewPrefixStore(NewMemStore(), "tenant"), "prefix")
+}
+
+func TestPrefixStore(t *testing.T) {
+	inner := NewMemStore()
+	assert.NoError(t, inner.UploadData([]byte("other"), "tenant-b/a.txt"))
+	assert.NoError(t, inner.UploadData([]byte("other"), "tenant-ab/a.txt"))
+	st := NewPrefixStore(inner, "tenant-a")
+
+	assert.NoError(t, st.UploadData([]byte("mine"), "a.txt"))
+	assert.NoError(t, st.UploadData([]byte("nested"), "/dir/b.txt"))
+	data, err := inner.DownloadBytes("tenant-a/dir/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "nested", string(data))
+
+	keys, err := st.ListPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/b.txt"}, keys, "only the namespace should be listed")
+	keys, err = st.ListPrefix("dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/b.txt"}, keys)
+
+	data, err = st.DownloadBytes("a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "mine", string(data))
+	_, err = st.DownloadBytes("../tenant-b/a.txt")
+	assert.Error(t, err, "keys must not escape the namespace")
+
+	assert.NoError(t, st.DeleteDirectory(""))
+	keys, err = inner.ListPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant-ab/a.txt", "tenant-b/a.txt"}, keys)
+}
+
+func TestPrefixStore_OS(t *testing.T) {
+	root := t.TempDir()
+	st := NewPrefixStore(NewOSStore(), filepath.Join(root, "tenant"))
+	assert.NoError(t, st.UploadData([]byte("data"), "sub/file.txt"))
+	keys, err := st.ListPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"sub/file.txt"}, keys)
+	exists, err := NewOSStore().Exists(filepath.Join(root, "tenant", "sub", "file.txt"))
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
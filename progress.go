@@ -0,0 +1,75 @@
+package store
+
+import (
+	"io"
+	"sync"
+)
+
+// ProgressFunc is called as a transfer progresses with the number of bytes
+// transferred so far and the total, which is -1 if unknown.
+type ProgressFunc func(transferred, total int64)
+
+// progressReader calls fn after every read from r, so the callback fires
+// from the copy loop of any backend.
+type progressReader struct {
+	r     io.Reader
+	n     int64
+	total int64
+	fn    ProgressFunc
+}
+
+// newProgressReader returns r itself if fn is nil.
+func newProgressReader(r io.Reader, total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return r
+	}
+	return &progressReader{r: r, total: total, fn: fn}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.n += int64(n)
+		r.fn(r.n, r.total)
+	}
+	return n, err
+}
+
+// progressHook is a minio progress hook. minio reads what it has uploaded
+// from it, so it counts the bytes without copying them anywhere. The parts of
+// a multipart upload report concurrently, so the calls are serialized.
+type progressHook struct {
+	lk    sync.Mutex
+	n     int64
+	total int64
+	fn    ProgressFunc
+}
+
+func newProgressHook(total int64, fn ProgressFunc) io.Reader {
+	if fn == nil {
+		return nil
+	}
+	return &progressHook{total: total, fn: fn}
+}
+
+func (h *progressHook) Read(p []byte) (int, error) {
+	h.lk.Lock()
+	defer h.lk.Unlock()
+	h.n += int64(len(p))
+	h.fn(h.n, h.total)
+	return len(p), nil
+}
+
+// DownloadReaderProgress opens key on st like DownloadReader, calling fn as
+// the returned reader is read. The total comes from a Stat of the key.
+func DownloadReaderProgress(st Interface, key string, fn ProgressFunc) (io.ReadCloser, error) {
+	stat, err := st.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	r, err := st.DownloadReader(key)
+	if err != nil {
+		return nil, err
+	}
+	return &rangeReaderCloser{newProgressReader(r, stat.Size, fn), r.Close}, nil
+}
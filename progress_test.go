@@ -0,0 +1,33 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDownloadReaderProgress(t *testing.T) {
+	st := NewMemStore()
+	data := bytes.Repeat([]byte("x"), 100<<10)
+	assert.NoError(t, st.UploadData(data, "big.bin"))
+
+	var transferred, total int64
+	calls := 0
+	r, err := DownloadReaderProgress(st, "big.bin", func(n, t int64) {
+		transferred, total = n, t
+		calls++
+	})
+	assert.NoError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+	assert.Equal(t, int64(len(data)), transferred)
+	assert.Equal(t, int64(len(data)), total)
+	assert.Greater(t, calls, 1, "the callback should fire as the data is read")
+
+	_, err = DownloadReaderProgress(st, "missing.bin", func(int64, int64) {})
+	assert.ErrorIs(t, err, ErrNotFound)
+}
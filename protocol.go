@@ -22,34 +22,173 @@ const (
 	UnknownPathProtocol PathProtocol = "unknown"
 )
 
-func GetPathProtocol(p string) (PathProtocol, string, error) {
+// FileScheme is the scheme of file URIs, which resolve to OSProtocol paths:
+// file:///a/b, file://localhost/a/b and file:/a/b are all the local path
+// /a/b.
+const FileScheme = "file"
+
+// fileURIPath returns the local path of a file URI. A URI with a Windows
+// drive letter such as file:///C:/a has the path C:/a.
+func fileURIPath(u *url.URL, p string) (string, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return "", fmt.Errorf("unsupported network path: %s", p)
+	}
+	if !strings.HasPrefix(u.Path, "/") {
+		return "", fmt.Errorf("unsupported path: %s", p)
+	}
+	if len(u.Path) >= 3 && u.Path[2] == ':' && isDriveLetter(u.Path[1]) {
+		return u.Path[1:], nil
+	}
+	return u.Path, nil
+}
+
+func isDriveLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// isWindowsAbsPath reports whether p is an absolute Windows path, with a
+// drive letter (C:\a or C:/a) or UNC (\\server\share\a). url.Parse would
+// take the drive letter for a scheme.
+func isWindowsAbsPath(p string) bool {
+	if strings.HasPrefix(p, `\\`) {
+		return true
+	}
+	return len(p) >= 3 && isDriveLetter(p[0]) && p[1] == ':' && (p[2] == '\\' || p[2] == '/')
+}
+
+// PathInfo is a parsed union path.
+type PathInfo struct {
+	Protocol PathProtocol
+	// Bucket is the bucket of a network-style S3 path such as
+	// s3://bucket/key, empty for the other paths.
+	Bucket string
+	// Path is the key of the object, or the local path for OSProtocol.
+	Path string
+}
+
+// ParsePath parses a union path. Besides the forms accepted by
+// GetPathProtocol, it accepts network-style S3 paths, s3://bucket/key, as
+// written by the AWS CLI.
+func ParsePath(p string) (PathInfo, error) {
+	if isWindowsAbsPath(p) {
+		return PathInfo{Protocol: OSProtocol, Path: p}, nil
+	}
 	u, err := url.Parse(p)
 	if err != nil {
-		// todo: should panic or handle error?
-		return UnknownPathProtocol, "", err
+		return PathInfo{Protocol: UnknownPathProtocol}, err
+	}
+	if u.Scheme == S3Protocol.String() && u.Host != "" {
+		return PathInfo{Protocol: S3Protocol, Bucket: u.Host, Path: strings.TrimPrefix(u.Path, "/")}, nil
 	}
 	if u.Path == "" {
-		return UnknownPathProtocol, strings.TrimPrefix(u.Path, "/"), fmt.Errorf("unsupported path: %s", p)
+		return PathInfo{Protocol: UnknownPathProtocol}, fmt.Errorf("unsupported path: %s", p)
+	}
+	if u.Scheme == FileScheme {
+		path, err := fileURIPath(u, p)
+		if err != nil {
+			return PathInfo{Protocol: UnknownPathProtocol, Path: path}, err
+		}
+		return PathInfo{Protocol: OSProtocol, Path: path}, nil
 	}
 	if u.Host != "" {
 		// The provided path appears to be a network path.
-		// Currently, network protocols are not supported.
-		// TODO: Add support for network paths in the future.
-		return UnknownPathProtocol, strings.TrimPrefix(u.Path, "/"), fmt.Errorf("unsupported network path: %s", p)
+		// Only S3 supports network paths.
+		return PathInfo{Protocol: UnknownPathProtocol, Path: strings.TrimPrefix(u.Path, "/")}, fmt.Errorf("unsupported network path: %s", p)
 	}
 	switch u.Scheme {
 	case QiniuProtocol.String():
-		return QiniuProtocol, strings.TrimPrefix(u.Path, "/"), nil
+		return PathInfo{Protocol: QiniuProtocol, Path: strings.TrimPrefix(u.Path, "/")}, nil
 	case S3Protocol.String():
-		return S3Protocol, strings.TrimPrefix(u.Path, "/"), nil
+		return PathInfo{Protocol: S3Protocol, Path: strings.TrimPrefix(u.Path, "/")}, nil
 	case OSProtocol.String():
 		if strings.HasPrefix(u.Path, "/") {
-			return OSProtocol, u.Path, nil
+			return PathInfo{Protocol: OSProtocol, Path: u.Path}, nil
+		}
+		return PathInfo{Protocol: UnknownPathProtocol, Path: u.Path}, fmt.Errorf("unsupported path: %s", p)
+	default:
+		if _, ok := registeredBackend(PathProtocol(u.Scheme)); ok {
+			return PathInfo{Protocol: PathProtocol(u.Scheme), Path: strings.TrimPrefix(u.Path, "/")}, nil
+		}
+		return PathInfo{Protocol: UnknownPathProtocol, Path: u.Path}, nil
+	}
+}
+
+// GetPathProtocol returns the protocol of a union path and the path without
+// it. The bucket of a network-style S3 path is dropped, use ParsePath to
+// get it.
+func GetPathProtocol(p string) (PathProtocol, string, error) {
+	info, err := ParsePath(p)
+	return info.Protocol, info.Path, err
+}
+
+// NormalizeKey cleans key the way the backends of protocol expect it. The
+// object stores, S3Protocol, QiniuProtocol and the protocols registered with
+// RegisterBackend, take keys without a leading slash. OSProtocol keys are native paths and are kept as they are, but
+// keys with ".." elements are rejected with ErrInvalidKey so that a key
+// can't climb out of the directory it names.
+func NormalizeKey(protocol PathProtocol, key string) (string, error) {
+	switch protocol {
+	case S3Protocol, QiniuProtocol:
+		return objectKey(key), nil
+	case OSProtocol:
+		if hasDotDot(key) {
+			return "", fmt.Errorf("key %s: %w", key, ErrInvalidKey)
 		}
-		return UnknownPathProtocol, u.Path, fmt.Errorf("unsupported path: %s", p)
+		return key, nil
 	default:
-		return UnknownPathProtocol, u.Path, nil
+		if _, ok := registeredBackend(protocol); ok {
+			return objectKey(key), nil
+		}
+		return "", fmt.Errorf("unsupported path protocol: %s", protocol)
+	}
+}
+
+// objectKey is the object store key of key, see NormalizeKey.
+func objectKey(key string) string {
+	return strings.TrimPrefix(key, "/")
+}
+
+// hasDotDot reports whether key has a ".." element, with either slash as
+// the separator.
+func hasDotDot(key string) bool {
+	for _, elem := range strings.FieldsFunc(key, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildPath returns the union path of key in the canonical form parsed by
+// GetPathProtocol, e.g. s3:/dir/key for the key dir/key of S3Protocol.
+// Characters with a meaning in URLs are escaped. OSProtocol paths are
+// returned as they are.
+func BuildPath(protocol PathProtocol, key string) string {
+	if protocol == OSProtocol {
+		return key
+	}
+	key = objectKey(key)
+	path := (&url.URL{Path: "/" + key}).EscapedPath()
+	if strings.HasPrefix(key, "/") {
+		// s3://dir/key would name the bucket dir, the empty authority
+		// keeps the slashes in the key.
+		return protocol.String() + "://" + path
+	}
+	return protocol.String() + ":" + path
+}
+
+// SplitPath is the inverse of BuildPath: it returns the protocol and key of
+// a union path. Unlike GetPathProtocol, paths of an unknown protocol are an
+// error.
+func SplitPath(p string) (PathProtocol, string, error) {
+	protocol, key, err := GetPathProtocol(p)
+	if err != nil {
+		return protocol, key, err
+	}
+	if protocol == UnknownPathProtocol {
+		return protocol, key, fmt.Errorf("unsupported path protocol: %s", p)
 	}
+	return protocol, key, nil
 }
 
 func IsUnionPath(p string) bool {
@@ -1,6 +1,7 @@
 package store
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -17,9 +18,20 @@ func TestGetPathProtocol(t *testing.T) {
 		{"s3:/file/path", S3Protocol, "file/path", false},
 		{"/file/path", OSProtocol, "/file/path", false},
 		{"qiniu://host/file/path", UnknownPathProtocol, "file/path", true},
-		{"s3://host/file/path", UnknownPathProtocol, "file/path", true},
+		{"s3://bucket/file/path", S3Protocol, "file/path", false},
 		{"unknown://host/path", UnknownPathProtocol, "path", true},
 		{"", UnknownPathProtocol, "", true},
+		{"file:///a/b", OSProtocol, "/a/b", false},
+		{"file://localhost/a/b", OSProtocol, "/a/b", false},
+		{"file:/a/b", OSProtocol, "/a/b", false},
+		{"file:///a%20b/c", OSProtocol, "/a b/c", false},
+		{"file:///C:/a", OSProtocol, "C:/a", false},
+		{"file://host/a/b", UnknownPathProtocol, "", true},
+		{"file:a/b", UnknownPathProtocol, "", true},
+		{`C:\foo\bar`, OSProtocol, `C:\foo\bar`, false},
+		{`\\server\share\x`, OSProtocol, `\\server\share\x`, false},
+		{"C:/foo", OSProtocol, "C:/foo", false},
+		{"c:/foo", OSProtocol, "c:/foo", false},
 	}
 
 	for _, test := range tests {
@@ -43,8 +55,10 @@ func TestIsUnionPath(t *testing.T) {
 		{"s3:/file/path", true},
 		{"/file/path", false},
 		{"qiniu://host/file/path", false},
-		{"s3://host/file/path", false},
+		{"s3://bucket/file/path", true},
 		{"unknown://file/path", false},
+		{"file:///file/path", false},
+		{`C:\file\path`, false},
 	}
 
 	for _, test := range tests {
@@ -52,3 +66,109 @@ func TestIsUnionPath(t *testing.T) {
 		assert.Equal(t, test.expected, result, "unexpected result for input: %s", test.input)
 	}
 }
+
+func TestParsePath(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected PathInfo
+		hasError bool
+	}{
+		{"s3://bucket/dir/key", PathInfo{Protocol: S3Protocol, Bucket: "bucket", Path: "dir/key"}, false},
+		{"s3://bucket/", PathInfo{Protocol: S3Protocol, Bucket: "bucket", Path: ""}, false},
+		{"s3://bucket", PathInfo{Protocol: S3Protocol, Bucket: "bucket", Path: ""}, false},
+		{"s3:/dir/key", PathInfo{Protocol: S3Protocol, Path: "dir/key"}, false},
+		{"qiniu:/dir/key", PathInfo{Protocol: QiniuProtocol, Path: "dir/key"}, false},
+		{"/dir/key", PathInfo{Protocol: OSProtocol, Path: "/dir/key"}, false},
+		{"qiniu://bucket/dir/key", PathInfo{Protocol: UnknownPathProtocol, Path: "dir/key"}, true},
+	}
+
+	for _, test := range tests {
+		info, err := ParsePath(test.input)
+		if test.hasError {
+			assert.Error(t, err, "expected error for input: %s", test.input)
+		} else {
+			assert.NoError(t, err, "unexpected error for input: %s", test.input)
+		}
+		assert.Equal(t, test.expected, info, "unexpected result for input: %s", test.input)
+	}
+}
+
+func TestNormalizeKey(t *testing.T) {
+	tests := []struct {
+		protocol PathProtocol
+		input    string
+		expected string
+		hasError bool
+	}{
+		{S3Protocol, "/dir/key", "dir/key", false},
+		{S3Protocol, "dir/key", "dir/key", false},
+		{QiniuProtocol, "/dir/key", "dir/key", false},
+		{OSProtocol, "/dir/key", "/dir/key", false},
+		{OSProtocol, "/dir/..key", "/dir/..key", false},
+		{OSProtocol, "../../etc/passwd", "", true},
+		{OSProtocol, "/dir/../../etc/passwd", "", true},
+		{OSProtocol, `C:\dir\..\key`, "", true},
+		{UnknownPathProtocol, "/dir/key", "", true},
+	}
+
+	for _, test := range tests {
+		key, err := NormalizeKey(test.protocol, test.input)
+		if test.hasError {
+			assert.Error(t, err, "expected error for input: %s", test.input)
+		} else {
+			assert.NoError(t, err, "unexpected error for input: %s", test.input)
+		}
+		assert.Equal(t, test.expected, key, "unexpected result for input: %s", test.input)
+	}
+}
+
+func TestBuildPath(t *testing.T) {
+	tests := []struct {
+		protocol PathProtocol
+		key      string
+		expected string
+	}{
+		{S3Protocol, "dir/key", "s3:/dir/key"},
+		{S3Protocol, "/dir/key", "s3:/dir/key"},
+		{QiniuProtocol, "dir/key", "qiniu:/dir/key"},
+		{S3Protocol, "dir/a b%?#", "s3:/dir/a%20b%25%3F%23"},
+		{S3Protocol, "//dir/key", "s3:////dir/key"},
+		{OSProtocol, "/dir/key", "/dir/key"},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, BuildPath(test.protocol, test.key), "unexpected result for key: %s", test.key)
+	}
+}
+
+func TestSplitPath_RoundTrip(t *testing.T) {
+	tests := []struct {
+		protocol PathProtocol
+		key      string
+	}{
+		{S3Protocol, "dir/key"},
+		{S3Protocol, ""},
+		{S3Protocol, "dir/"},
+		{S3Protocol, "/dir//key"},
+		{S3Protocol, "dir/a b%?#;=&+"},
+		{QiniuProtocol, "dir/key"},
+		{QiniuProtocol, "dir/中文.txt"},
+		{OSProtocol, "/dir/key"},
+		{OSProtocol, `C:\dir\key`},
+	}
+
+	for _, test := range tests {
+		p := BuildPath(test.protocol, test.key)
+		protocol, key, err := SplitPath(p)
+		assert.NoError(t, err, "unexpected error for path: %s", p)
+		assert.Equal(t, test.protocol, protocol, "unexpected protocol for path: %s", p)
+		expected := test.key
+		if test.protocol != OSProtocol {
+			expected = strings.TrimPrefix(expected, "/")
+		}
+		assert.Equal(t, expected, key, "unexpected key for path: %s", p)
+	}
+
+	_, _, err := SplitPath("http:/dir/key")
+	assert.Error(t, err)
+}
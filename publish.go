@@ -0,0 +1,19 @@
+package store
+
+// PublishOptions are the attributes set on an object by Publish.
+type PublishOptions struct {
+	// ContentType of the object. Defaults to the type implied by the key's
+	// extension, like the other uploads.
+	ContentType string
+	// ACL is a canned ACL such as "public-read".
+	ACL string
+	// Tags are the object tags.
+	Tags map[string]string
+}
+
+// Publisher is implemented by stores that can upload an object together
+// with its content type, ACL and tags in a single request, so that a
+// failure can't leave a half-published object.
+type Publisher interface {
+	Publish(key string, data []byte, opts PublishOptions) error
+}
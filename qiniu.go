@@ -1,10 +1,14 @@
 package store
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"slices"
 	"strings"
 	"time"
 
@@ -16,142 +20,403 @@ var (
 )
 
 type QiniuStore struct {
-	downloader *operation.Downloader
-	uploader   *operation.Uploader
-	lister     *operation.Lister
+	downloader  *operation.Downloader
+	uploader    *operation.Uploader
+	lister      *operation.Lister
+	rateLimit   int64
+	maxDownload int64
+	log         *logger
 }
 
-func NewQiniuStore() (Interface, error) {
+// QiniuConfig is the configuration of a single Qiniu cluster. The keys of
+// its json and toml encodings are those of the SDK's configuration files, so
+// a file written for QiNiuEnv can be loaded with LoadQiniuConfig.
+type QiniuConfig struct {
+	Bucket string `json:"bucket" yaml:"bucket" toml:"bucket"`
+	Ak     string `json:"ak" yaml:"ak" toml:"ak"`
+	Sk     string `json:"sk" yaml:"sk" toml:"sk"`
+
+	UpHosts        []string `json:"up_hosts" yaml:"up_hosts" toml:"up_hosts"`
+	RsHosts        []string `json:"rs_hosts" yaml:"rs_hosts" toml:"rs_hosts"`
+	RsfHosts       []string `json:"rsf_hosts" yaml:"rsf_hosts" toml:"rsf_hosts"`
+	ApiServerHosts []string `json:"api_server_hosts" yaml:"api_server_hosts" toml:"api_server_hosts"`
+	IoHosts        []string `json:"io_hosts" yaml:"io_hosts" toml:"io_hosts"`
+	// UcHosts are queried for the other hosts, which may then be left
+	// empty.
+	UcHosts []string `json:"uc_hosts" yaml:"uc_hosts" toml:"uc_hosts"`
+
+	// The timeouts are in milliseconds, zero keeps the SDK's default.
+	DialTimeoutMs int `json:"dial_timeout_ms" yaml:"dial_timeout_ms" toml:"dial_timeout_ms"`
+	UpTimeoutMs   int `json:"up_timeout_ms" yaml:"up_timeout_ms" toml:"up_timeout_ms"`
+	RsTimeoutMs   int `json:"rs_timeout_ms" yaml:"rs_timeout_ms" toml:"rs_timeout_ms"`
+	RsfTimeoutMs  int `json:"rsf_timeout_ms" yaml:"rsf_timeout_ms" toml:"rsf_timeout_ms"`
+	IoTimeoutMs   int `json:"io_timeout_ms" yaml:"io_timeout_ms" toml:"io_timeout_ms"`
+	UcTimeoutMs   int `json:"uc_timeout_ms" yaml:"uc_timeout_ms" toml:"uc_timeout_ms"`
+	ApiTimeoutMs  int `json:"api_timeout_ms" yaml:"api_timeout_ms" toml:"api_timeout_ms"`
+
+	PartSize         int64 `json:"part" yaml:"part" toml:"part"`
+	UpConcurrency    int   `json:"up_concurrency" yaml:"up_concurrency" toml:"up_concurrency"`
+	BatchConcurrency int   `json:"batch_concurrency" yaml:"batch_concurrency" toml:"batch_concurrency"`
+	BatchSize        int   `json:"batch_size" yaml:"batch_size" toml:"batch_size"`
+	// RecycleBin, if set, makes Delete move objects to this prefix instead
+	// of deleting them.
+	RecycleBin string `json:"recycle_bin" yaml:"recycle_bin" toml:"recycle_bin"`
+}
+
+// LoadQiniuConfig loads the qiniu configuration from a json, toml or yaml
+// file, chosen by its extension.
+func LoadQiniuConfig(cfgPath string) (*QiniuConfig, error) {
+	raw, err := os.ReadFile(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	return loadQiniuConfig(raw, configFormat(cfgPath))
+}
+
+// LoadQiniuConfigFromReader loads the qiniu configuration from r.
+// The format is one of "json", "toml" or "yaml".
+func LoadQiniuConfigFromReader(r io.Reader, format string) (*QiniuConfig, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return loadQiniuConfig(raw, format)
+}
+
+func loadQiniuConfig(raw []byte, format string) (*QiniuConfig, error) {
+	var cfg QiniuConfig
+	err := unmarshalConfig(raw, format, &cfg)
+	return &cfg, err
+}
+
+// validate checks that the SDK can reach the bucket: without uc hosts to
+// query, the SDK panics on a request to a missing host.
+func (cfg *QiniuConfig) validate() error {
+	if cfg.Bucket == "" {
+		return errors.New("qiniu bucket is not configured")
+	}
+	if len(cfg.UcHosts) > 0 {
+		return nil
+	}
+	switch {
+	case len(cfg.UpHosts) == 0:
+		return errors.New("qiniu up_hosts are not configured")
+	case len(cfg.RsHosts) == 0:
+		return errors.New("qiniu rs_hosts are not configured")
+	case len(cfg.IoHosts) == 0:
+		return errors.New("qiniu io_hosts are not configured")
+	}
+	return nil
+}
+
+func (cfg *QiniuConfig) sdkConfig() *operation.Config {
+	return &operation.Config{
+		UpHosts:          slices.Clone(cfg.UpHosts),
+		RsHosts:          slices.Clone(cfg.RsHosts),
+		RsfHosts:         slices.Clone(cfg.RsfHosts),
+		ApiServerHosts:   slices.Clone(cfg.ApiServerHosts),
+		IoHosts:          slices.Clone(cfg.IoHosts),
+		UcHosts:          slices.Clone(cfg.UcHosts),
+		DialTimeoutMs:    cfg.DialTimeoutMs,
+		UpTimeoutMs:      cfg.UpTimeoutMs,
+		RsTimeoutMs:      cfg.RsTimeoutMs,
+		RsfTimeoutMs:     cfg.RsfTimeoutMs,
+		IoTimeoutMs:      cfg.IoTimeoutMs,
+		UcTimeoutMs:      cfg.UcTimeoutMs,
+		ApiTimeoutMs:     cfg.ApiTimeoutMs,
+		Bucket:           cfg.Bucket,
+		Ak:               cfg.Ak,
+		Sk:               cfg.Sk,
+		PartSize:         cfg.PartSize,
+		UpConcurrency:    cfg.UpConcurrency,
+		BatchConcurrency: cfg.BatchConcurrency,
+		BatchSize:        cfg.BatchSize,
+		RecycleBin:       cfg.RecycleBin,
+	}
+}
+
+// NewQiniuStore creates a QiniuStore configured by the file QiNiuEnv points
+// to. See NewQiniuStoreWithConfig to configure it without the environment.
+func NewQiniuStore(opts ...Option) (Interface, error) {
 	if _, e := os.LookupEnv(QiNiuEnv); !e {
 		return nil, QiniuNotConfigError
 	}
+	o := newOptions(opts)
+	return &QiniuStore{
+		downloader:  operation.NewDownloaderV2(),
+		uploader:    operation.NewUploaderV2(),
+		lister:      operation.NewListerV2(),
+		rateLimit:   o.rateLimit,
+		maxDownload: o.maxDownload,
+		log:         newLogger(o.logger),
+	}, nil
+}
+
+// NewQiniuStoreWithConfig creates a QiniuStore for a single cluster
+// configured by cfg, regardless of QiNiuEnv.
+func NewQiniuStoreWithConfig(cfg *QiniuConfig, opts ...Option) (Interface, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	o := newOptions(opts)
+	c := cfg.sdkConfig()
 	return &QiniuStore{
-		downloader: operation.NewDownloaderV2(),
-		uploader:   operation.NewUploaderV2(),
-		lister:     operation.NewListerV2(),
+		downloader:  operation.NewDownloader(c),
+		uploader:    operation.NewUploader(c),
+		lister:      operation.NewLister(c),
+		rateLimit:   o.rateLimit,
+		maxDownload: o.maxDownload,
+		log:         newLogger(o.logger),
 	}, nil
 }
 
-func (s *QiniuStore) UploadData(data []byte, key string) (err error) {
-	key = strings.TrimPrefix(key, "/")
+func (s *QiniuStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("UploadData", "key", key, "took", time.Since(start))
+		s.log.Debugw("UploadData", "key", key, "took", time.Since(start))
 	}()
-	return s.uploader.UploadData(data, key)
+	o := NewUploadOptions(opts...)
+	if err := s.checkOverwrite(key, o); err != nil {
+		return err
+	}
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	hasher.data(data)
+	if s.rateLimit > 0 {
+		err = s.uploader.UploadReader(s.limiter().reader(bytes.NewReader(data)), key)
+	} else {
+		err = s.uploader.UploadData(data, key)
+	}
+	if err != nil {
+		return err
+	}
+	hasher.done()
+	if o.Progress != nil {
+		o.Progress(int64(len(data)), int64(len(data)))
+	}
+	return nil
 }
 
-func (s *QiniuStore) Upload(file string, key string) (err error) {
-	key = strings.TrimPrefix(key, "/")
+func (s *QiniuStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("Upload", "file", file, "key", key, "took", time.Since(start))
+		s.log.Debugw("Upload", "file", file, "key", key, "took", time.Since(start))
 	}()
-	return s.uploader.Upload(file, key)
+	o := NewUploadOptions(opts...)
+	if err := s.checkOverwrite(key, o); err != nil {
+		return err
+	}
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	if hasher == nil && o.Progress == nil && s.rateLimit <= 0 {
+		return s.uploader.Upload(file, key)
+	}
+	// stream the file through the hasher, the rate limiter and the progress
+	// callback instead of letting the SDK read it
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close() // nolint: errcheck
+	total := int64(-1)
+	if fi, err := f.Stat(); err == nil {
+		total = fi.Size()
+	}
+	if err := s.uploader.UploadReader(newProgressReader(s.limiter().reader(hasher.reader(f)), total, o.Progress), key); err != nil {
+		return err
+	}
+	hasher.done()
+	return nil
 }
 
-func (s *QiniuStore) UploadReader(reader io.Reader, _ int64, key string) (err error) {
-	key = strings.TrimPrefix(key, "/")
+func (s *QiniuStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("UploadReader", "key", key, "took", time.Since(start))
+		s.log.Debugw("UploadReader", "key", key, "took", time.Since(start))
 	}()
-	return s.uploader.UploadReader(reader, key)
+	o := NewUploadOptions(opts...)
+	if err := s.checkOverwrite(key, o); err != nil {
+		return err
+	}
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	if err := s.uploader.UploadReader(newProgressReader(s.limiter().reader(hasher.reader(reader)), size, o.Progress), key); err != nil {
+		return err
+	}
+	hasher.done()
+	return nil
+}
+
+// checkOverwrite fails a create-only upload if the key exists. Qiniu has no
+// conditional upload, so a concurrent upload of the same key may still be
+// overwritten, and uploads with IfMatch or IfNoneMatch aren't supported.
+func (s *QiniuStore) checkOverwrite(key string, o UploadOptions) error {
+	if o.conditional() {
+		return fmt.Errorf("conditional upload: %w", ErrNotSupported)
+	}
+	if o.Overwrite {
+		return nil
+	}
+	_, err := s.stat(key)
+	if err == nil {
+		return fmt.Errorf("object %s: %w", key, ErrAlreadyExists)
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("check exists: %w", err)
+	}
+	return nil
 }
 
 func (s *QiniuStore) DeleteDirectory(dir string) (err error) {
-	dir = strings.TrimPrefix(dir, "/")
+	dir = objectKey(dir)
 	start := time.Now()
 	defer func() {
-		log.Debugw("DeleteDirectory", "dir", dir, "took", time.Since(start))
+		s.log.Debugw("DeleteDirectory", "dir", dir, "took", time.Since(start))
 	}()
 	_, err = s.lister.DeleteDirectory(dir)
 	return
 }
 
 func (s *QiniuStore) Delete(key string) (err error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("Delete", "key", key, "took", time.Since(start))
+		s.log.Debugw("Delete", "key", key, "took", time.Since(start))
 	}()
 	return s.lister.Delete(key)
 }
 
 func (s *QiniuStore) Exists(key string) (bool, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("Exists", "key", key, "took", time.Since(start))
+		s.log.Debugw("Exists", "key", key, "took", time.Since(start))
 	}()
-	_, err := s.downloader.DownloadCheck(key)
-	if err != nil {
+	_, err := s.stat(key)
+	if errors.Is(err, ErrNotFound) {
 		return false, nil
 	}
+	if err != nil {
+		return false, err
+	}
 	return true, nil
 }
 
 func (s *QiniuStore) DownloadBytes(key string) ([]byte, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("DownloadBytes", "key", key, "took", time.Since(start))
+		s.log.Debugw("DownloadBytes", "key", key, "took", time.Since(start))
 	}()
-	return s.downloader.DownloadBytes(key)
+	if s.rateLimit > 0 || s.maxDownload > 0 {
+		r, err := s.DownloadReader(key)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() // nolint: errcheck
+		return readAll(r, s.maxDownload)
+	}
+	data, err := s.downloader.DownloadBytes(key)
+	return data, qiniuError(err)
 }
 
 func (s *QiniuStore) DownloadReader(key string) (io.ReadCloser, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("DownloadReader", "key", key, "took", time.Since(start))
+		s.log.Debugw("DownloadReader", "key", key, "took", time.Since(start))
 	}()
 	resp, err := s.downloader.DownloadRaw(key, http.Header{})
 	if err != nil {
-		return nil, err
+		return nil, qiniuError(err)
 	}
-	if resp.StatusCode != 200 {
-		return nil, fmt.Errorf("%d: %s", resp.StatusCode, err)
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, qiniuStatusError(key, resp)
 	}
-	return resp.Body, nil
+	return s.limiter().readCloser(resp.Body), nil
 }
 
 func (s *QiniuStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("DownloadRangeBytes", "key", key, "offset", offset, "size", size, "took", time.Since(start))
+		s.log.Debugw("DownloadRangeBytes", "key", key, "offset", offset, "size", size, "took", time.Since(start))
 	}()
+	if size < 0 || s.rateLimit > 0 || s.maxDownload > 0 {
+		r, err := s.DownloadRangeReader(key, offset, size)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close() // nolint: errcheck
+		return readAll(r, s.maxDownload)
+	}
 	_, data, err := s.downloader.DownloadRangeBytes(key, offset, size)
-	return data, err
+	return data, qiniuError(err)
 }
 
 func (s *QiniuStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("DownloadRangeReader", "key", key, "offset", offset, "size", size, "took", time.Since(start))
+		s.log.Debugw("DownloadRangeReader", "key", key, "offset", offset, "size", size, "took", time.Since(start))
 	}()
+	if size < 0 {
+		return s.downloadFrom(key, offset)
+	}
 	_, reader, err := s.downloader.DownloadRangeReader(key, offset, size)
-	return reader, err
+	if err != nil {
+		return nil, qiniuError(err)
+	}
+	return s.limiter().readCloser(reader), nil
+}
+
+// downloadFrom reads the object from offset to its end with an open-ended
+// range request, which the SDK's range helpers can't express.
+func (s *QiniuStore) downloadFrom(key string, offset int64) (io.ReadCloser, error) {
+	headers := http.Header{}
+	headers.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	resp, err := s.downloader.DownloadRaw(key, headers)
+	if err != nil {
+		return nil, qiniuError(err)
+	}
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+		return s.limiter().readCloser(resp.Body), nil
+	case http.StatusRequestedRangeNotSatisfiable:
+		_ = resp.Body.Close()
+		return io.NopCloser(strings.NewReader("")), nil
+	default:
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("download from offset %d: %w", offset, qiniuStatusError(key, resp))
+	}
 }
 
 func (s *QiniuStore) ListPrefix(key string) ([]string, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("ListPrefix", "key", key, "took", time.Since(start))
+		s.log.Debugw("ListPrefix", "key", key, "took", time.Since(start))
 	}()
 	return s.lister.ListPrefix(key), nil
 }
 
 func (s *QiniuStore) Stat(key string) (FileStat, error) {
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	start := time.Now()
 	defer func() {
-		log.Debugw("Stat", "key", key, "took", time.Since(start))
+		s.log.Debugw("Stat", "key", key, "took", time.Since(start))
 	}()
-	n, err := s.downloader.DownloadCheck(key)
+	n, err := s.stat(key)
 	if err != nil {
 		return FileStat{}, err
 	}
@@ -160,4 +425,75 @@ func (s *QiniuStore) Stat(key string) (FileStat, error) {
 	}, nil
 }
 
-var _ Interface = &QiniuStore{}
+// stat returns the size of key from the object's metadata, which is much
+// cheaper than the ranged download of DownloadCheck. The SDK's ListStat
+// drops the status of a failed stat, a missing key looks the same as an
+// auth or server error, so a failed stat is checked again with
+// DownloadCheck, which only reports a 404 as not found. Stores without a
+// lister use DownloadCheck right away.
+func (s *QiniuStore) stat(key string) (int64, error) {
+	if s.lister != nil {
+		stats := s.lister.ListStat([]string{key})
+		if len(stats) != 1 || stats[0] == nil {
+			return 0, fmt.Errorf("stat %s: request failed", key)
+		}
+		if stats[0].Size >= 0 {
+			return stats[0].Size, nil
+		}
+	}
+	n, err := s.downloader.DownloadCheck(key)
+	if err != nil {
+		return 0, fmt.Errorf("stat %s: %w", key, qiniuError(err))
+	}
+	return n, nil
+}
+
+// limiter returns the rate limiter of a transfer, nil without a rate limit.
+func (s *QiniuStore) limiter() *rateLimiter {
+	return newRateLimiter(s.rateLimit)
+}
+
+// qiniuError wraps the SDK's not found errors with ErrNotFound. Depending on
+// the call, the SDK reports a missing key as os.ErrNotExist or as an error
+// holding the response status.
+func qiniuError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, os.ErrNotExist) || strings.HasPrefix(err.Error(), "404") {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
+
+func qiniuStatusError(key string, resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("download %s: %w", key, ErrNotFound)
+	}
+	return fmt.Errorf("download %s: %s", key, resp.Status)
+}
+
+// HealthCheck checks that the bucket can be reached by looking up
+// healthCheckKey. The SDK's list calls drop their errors, so a missing key is
+// the lightest request that reports a failure. A missing key is fine.
+func (s *QiniuStore) HealthCheck(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := s.downloader.DownloadCheck(healthCheckKey)
+	if err = qiniuError(err); err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("check qiniu: %w", err)
+	}
+	return nil
+}
+
+// Close is a no-op, the Qiniu SDK doesn't expose its HTTP clients.
+func (s *QiniuStore) Close() error {
+	return nil
+}
+
+var (
+	_ Interface     = &QiniuStore{}
+	_ io.Closer     = &QiniuStore{}
+	_ HealthChecker = &QiniuStore{}
+)
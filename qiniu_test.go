@@ -0,0 +1,188 @@
+package store
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newFakeQiniuStore creates a QiniuStore talking to a server running
+// handler. released reports whether every connection the server accepted was
+// closed: the client's transport closes the connection of a response whose
+// body is closed before it was read, and keeps it open while the body isn't
+// closed. The error bodies are large so that the transport can't read them
+// ahead and reuse the connection.
+func newFakeQiniuStore(t *testing.T, handler http.HandlerFunc) (st *QiniuStore, released func() bool) {
+	var (
+		lk     sync.Mutex
+		active = map[net.Conn]bool{}
+	)
+	srv := httptest.NewUnstartedServer(handler)
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		lk.Lock()
+		defer lk.Unlock()
+		active[conn] = state != http.StateClosed
+	}
+	srv.Start()
+	t.Cleanup(srv.Close)
+
+	qs, err := NewQiniuStoreWithConfig(&QiniuConfig{
+		Bucket:  "bucket",
+		Ak:      "ak",
+		Sk:      "sk",
+		UpHosts: []string{srv.URL},
+		RsHosts: []string{srv.URL},
+		IoHosts: []string{srv.URL},
+	})
+	assert.NoError(t, err)
+	st = qs.(*QiniuStore)
+	released = func() bool {
+		lk.Lock()
+		defer lk.Unlock()
+		for _, a := range active {
+			if a {
+				return false
+			}
+		}
+		return true
+	}
+	return st, released
+}
+
+func TestQiniuStore_DownloadReader_NotFound(t *testing.T) {
+	st, released := newFakeQiniuStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	})
+
+	r, err := st.DownloadReader("missing")
+	assert.Nil(t, r)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, "download missing: object not found", err.Error())
+	assert.Eventually(t, released, time.Second, 10*time.Millisecond, "response body was not closed")
+}
+
+func TestQiniuStore_DownloadReader_ServerError(t *testing.T) {
+	st, released := newFakeQiniuStore(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write(bytes.Repeat([]byte("x"), 1<<20))
+	})
+
+	_, err := st.DownloadReader("key")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, "download key: 500 Internal Server Error", err.Error())
+	assert.Eventually(t, released, time.Second, 10*time.Millisecond, "response body was not closed")
+}
+
+// qiniuStatHandler serves the batch stats of the objects with the given
+// sizes, and counts the downloads. Other keys fail with status, 612 in the
+// batch for a 404, as for a missing key.
+func qiniuStatHandler(t *testing.T, sizes map[string]int64, status int, downloads *atomic.Int32) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/batch" {
+			downloads.Add(1)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		assert.NoError(t, r.ParseForm())
+		var ret []map[string]any
+		for _, op := range r.Form["op"] {
+			entry, err := base64.URLEncoding.DecodeString(strings.TrimPrefix(op, "/stat/"))
+			assert.NoError(t, err)
+			size, ok := sizes[strings.TrimPrefix(string(entry), "bucket:")]
+			switch {
+			case ok:
+				ret = append(ret, map[string]any{"code": 200, "data": map[string]any{"fsize": size}})
+			case status == http.StatusNotFound:
+				ret = append(ret, map[string]any{"code": 612, "error": "no such file or directory"})
+			default:
+				ret = append(ret, map[string]any{"code": status, "error": http.StatusText(status)})
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		assert.NoError(t, json.NewEncoder(w).Encode(ret))
+	}
+}
+
+func TestQiniuStore_Stat(t *testing.T) {
+	var downloads atomic.Int32
+	st, _ := newFakeQiniuStore(t, qiniuStatHandler(t, map[string]int64{"dir/key": 42, "empty": 0}, http.StatusNotFound, &downloads))
+
+	stat, err := st.Stat("/dir/key")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(42), stat.Size)
+	stat, err = st.Stat("empty")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stat.Size)
+	exists, err := st.Exists("dir/key")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	assert.Zero(t, downloads.Load(), "stat should not download")
+
+	_, err = st.Stat("missing")
+	assert.ErrorIs(t, err, ErrNotFound)
+	exists, err = st.Exists("missing")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestQiniuStore_Stat_Unauthorized(t *testing.T) {
+	var downloads atomic.Int32
+	st, _ := newFakeQiniuStore(t, qiniuStatHandler(t, map[string]int64{}, http.StatusUnauthorized, &downloads))
+
+	_, err := st.Stat("key")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	_, err = st.Exists("key")
+	assert.Error(t, err, "a failed stat is not a missing key")
+	err = st.UploadData([]byte("data"), "key", Overwrite(false))
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrAlreadyExists)
+	assert.Positive(t, downloads.Load(), "the failed stat should be checked with a download")
+}
+
+func TestLoadQiniuConfig(t *testing.T) {
+	// the SDK's own configuration file format
+	cfg, err := LoadQiniuConfigFromReader(strings.NewReader(`{
+		"bucket": "bucket", "ak": "ak", "sk": "sk",
+		"up_hosts": ["http://up"], "rs_hosts": ["http://rs"], "io_hosts": ["http://io"],
+		"part": 4194304, "io_timeout_ms": 1000
+	}`), "json")
+	assert.NoError(t, err)
+	assert.Equal(t, &QiniuConfig{
+		Bucket:      "bucket",
+		Ak:          "ak",
+		Sk:          "sk",
+		UpHosts:     []string{"http://up"},
+		RsHosts:     []string{"http://rs"},
+		IoHosts:     []string{"http://io"},
+		PartSize:    4194304,
+		IoTimeoutMs: 1000,
+	}, cfg)
+
+	file := filepath.Join(t.TempDir(), "qiniu.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte("bucket: bucket\nuc_hosts: [http://uc]\n"), 0644))
+	cfg, err = LoadQiniuConfig(file)
+	assert.NoError(t, err)
+	assert.Equal(t, &QiniuConfig{Bucket: "bucket", UcHosts: []string{"http://uc"}}, cfg)
+	_, err = NewQiniuStoreWithConfig(cfg)
+	assert.NoError(t, err)
+
+	_, err = NewQiniuStoreWithConfig(&QiniuConfig{Bucket: "bucket", UpHosts: []string{"http://up"}})
+	assert.EqualError(t, err, "qiniu rs_hosts are not configured")
+	_, err = NewQiniuStoreWithConfig(&QiniuConfig{UcHosts: []string{"http://uc"}})
+	assert.Error(t, err)
+}
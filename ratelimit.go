@@ -0,0 +1,78 @@
+package store
+
+import (
+	"io"
+	"time"
+)
+
+// rateLimiter is a token bucket limiting a single transfer to a number of
+// bytes per second. It holds at most one second worth of tokens and starts
+// empty, so a transfer never goes faster than the limit.
+type rateLimiter struct {
+	rate   float64
+	burst  int
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns nil, which doesn't limit, if bytesPerSec isn't
+// positive.
+func newRateLimiter(bytesPerSec int64) *rateLimiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		rate:  float64(bytesPerSec),
+		burst: int(min(bytesPerSec, 1<<30)),
+		last:  time.Now(),
+	}
+}
+
+// limit shortens p to what can be read at once.
+func (l *rateLimiter) limit(p []byte) []byte {
+	if l == nil || len(p) <= l.burst {
+		return p
+	}
+	return p[:l.burst]
+}
+
+// wait takes n tokens, sleeping until they're available.
+func (l *rateLimiter) wait(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	t := time.Now()
+	l.tokens = min(l.tokens+t.Sub(l.last).Seconds()*l.rate, float64(l.burst))
+	l.last = t
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+// reader limits the reads from r, or returns r if l is nil.
+func (l *rateLimiter) reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &rateLimitedReader{r: r, l: l}
+}
+
+// readCloser limits the reads from rc, or returns rc if l is nil.
+func (l *rateLimiter) readCloser(rc io.ReadCloser) io.ReadCloser {
+	if l == nil {
+		return rc
+	}
+	return &rangeReaderCloser{l.reader(rc), rc.Close}
+}
+
+type rateLimitedReader struct {
+	r io.Reader
+	l *rateLimiter
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(r.l.limit(p))
+	r.l.wait(n)
+	return n, err
+}
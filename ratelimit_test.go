@@ -0,0 +1,23 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(0))
+	r := newRateLimiter(0).reader(bytes.NewReader(nil))
+	assert.IsType(t, &bytes.Reader{}, r, "no limit should not wrap the reader")
+
+	data := bytes.Repeat([]byte("x"), 64<<10)
+	start := time.Now()
+	got, err := io.ReadAll(newRateLimiter(256 << 10).reader(bytes.NewReader(data)))
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond, "64KiB at 256KiB/s should take about 250ms")
+}
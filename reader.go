@@ -0,0 +1,247 @@
+package store
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+var (
+	_ io.ReadSeekCloser = &Reader{}
+	_ io.ReaderAt       = &Reader{}
+	_ io.ReadCloser     = &BufferedReader{}
+)
+
+// defaultReaderRetries is the number of times a Reader re-opens the object
+// after a read failing with no progress in between.
+const defaultReaderRetries = 3
+
+// defaultReadBufferSize is the buffer size of a BufferedReader created with
+// a non-positive size.
+const defaultReadBufferSize = 1 << 20
+
+// Reader reads an object from a store as an io.ReadSeekCloser. The object is
+// opened lazily on the first Read and re-opened at the new position after a
+// Seek. It's also an io.ReaderAt whose ReadAt calls are independent of the
+// Read position and of each other.
+//
+// When reading the object fails with a transient error, the Reader re-opens
+// it from the first byte it hasn't read yet, so a long download survives a
+// dropped connection.
+type Reader struct {
+	Store Interface
+	Key   string
+	// Offset is the position of the next Read in the object.
+	Offset int64
+	// Retry decides which read errors are resumed from, how many times in
+	// a row and how long to wait before. Reading any data resets the count.
+	Retry RetryPolicy
+
+	ctx      context.Context
+	size     int64
+	body     io.ReadCloser
+	failures int
+}
+
+// NewReader returns a Reader for key positioned at the start of the object.
+// Reads fail with the error of ctx once it's done. Cancellation is checked
+// between reads, a Read waiting on the store isn't interrupted.
+func NewReader(ctx context.Context, st Interface, key string) *Reader {
+	return &Reader{
+		Store: st,
+		Key:   key,
+		Retry: RetryPolicy{MaxRetries: defaultReaderRetries},
+		ctx:   ctx,
+		size:  -1,
+	}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	if err := r.context().Err(); err != nil {
+		return 0, err
+	}
+	for {
+		if r.body == nil {
+			if err := r.open(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.body.Read(p)
+		r.Offset += int64(n)
+		if n > 0 {
+			r.failures = 0
+		}
+		if err == nil || errors.Is(err, io.EOF) || !r.resume(err) {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+func (r *Reader) open() error {
+	return r.Retry.Do(r.context(), func() error {
+		body, err := r.Store.DownloadRangeReader(r.Key, r.Offset, -1)
+		if err != nil {
+			return err
+		}
+		r.body = body
+		return nil
+	})
+}
+
+// resume reports whether the read failing with err is to be resumed. If so,
+// it closes the body so that the next read re-opens the object at Offset,
+// after waiting for the backoff of the policy.
+func (r *Reader) resume(err error) bool {
+	if r.failures >= r.Retry.MaxRetries || !r.Retry.retryable(err) {
+		return false
+	}
+	d := r.Retry.backoff(r.failures)
+	r.failures++
+	log.Debugw("resuming read", "key", r.Key, "offset", r.Offset, "attempt", r.failures, "backoff", d, "error", err)
+	_ = r.closeBody()
+	return r.Retry.sleep(r.context(), d) == nil
+}
+
+// ReadAt reads len(p) bytes at off with a range download of its own, so it
+// doesn't move the Read position and concurrent calls are safe. Like Read,
+// it resumes the range after the bytes already read on transient errors. It
+// returns io.EOF when the object ends before p is filled.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	if off < 0 {
+		return 0, fmt.Errorf("read %s: negative offset %d", r.Key, off)
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	ctx := r.context()
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	err = r.Retry.Do(ctx, func() error {
+		body, err := r.Store.DownloadRangeReader(r.Key, off+int64(n), int64(len(p)-n))
+		if err != nil {
+			return err
+		}
+		defer body.Close() // nolint: errcheck
+		// not io.ReadFull, which would turn a dropped connection into an
+		// early end of the object
+		for n < len(p) {
+			m, err := body.Read(p[n:])
+			n += m
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (r *Reader) context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// Seek sets the position of the next Read. Seeking relative to the end
+// stats the object to learn its size.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	var pos int64
+	switch whence {
+	case io.SeekStart:
+		pos = offset
+	case io.SeekCurrent:
+		pos = r.Offset + offset
+	case io.SeekEnd:
+		size, err := r.objectSize()
+		if err != nil {
+			return r.Offset, err
+		}
+		pos = size + offset
+	default:
+		return r.Offset, fmt.Errorf("seek %s: invalid whence %d", r.Key, whence)
+	}
+	if pos < 0 {
+		return r.Offset, fmt.Errorf("seek %s: negative position %d", r.Key, pos)
+	}
+	if pos == r.Offset {
+		return pos, nil
+	}
+	if err := r.closeBody(); err != nil {
+		return r.Offset, err
+	}
+	r.Offset = pos
+	return pos, nil
+}
+
+// SeekStart rewinds the reader to the start of the object.
+func (r *Reader) SeekStart() error {
+	_, err := r.Seek(0, io.SeekStart)
+	return err
+}
+
+func (r *Reader) Close() error {
+	return r.closeBody()
+}
+
+func (r *Reader) objectSize() (int64, error) {
+	if r.size >= 0 {
+		return r.size, nil
+	}
+	stat, err := r.Store.Stat(r.Key)
+	if err != nil {
+		return 0, err
+	}
+	r.size = stat.Size
+	return r.size, nil
+}
+
+func (r *Reader) closeBody() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// BufferedReader reads ahead from a store reader in chunks of its buffer
+// size, so that many small reads, e.g. of a parser, make few reads of the
+// backend, each of which can be a round trip for a network store.
+type BufferedReader struct {
+	r   io.ReadCloser
+	buf *bufio.Reader
+}
+
+// NewBufferedReader returns a BufferedReader reading r with a buffer of
+// bufSize bytes, 1MiB if bufSize isn't positive. Closing it closes r.
+func NewBufferedReader(r io.ReadCloser, bufSize int) *BufferedReader {
+	if bufSize <= 0 {
+		bufSize = defaultReadBufferSize
+	}
+	return &BufferedReader{r: r, buf: bufio.NewReaderSize(r, bufSize)}
+}
+
+func (b *BufferedReader) Read(p []byte) (int, error) {
+	if b.buf == nil {
+		return 0, os.ErrClosed
+	}
+	return b.buf.Read(p)
+}
+
+// Close discards the data read ahead, releases the buffer and closes the
+// underlying reader.
+func (b *BufferedReader) Close() error {
+	if b.buf == nil {
+		return nil
+	}
+	b.buf = nil
+	return b.r.Close()
+}
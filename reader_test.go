@@ -0,0 +1,271 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestReader(t *testing.T, data []byte) *Reader {
+	st := NewOSStore()
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, st.UploadData(data, file))
+	r := NewReader(context.Background(), st, file)
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	return r
+}
+
+func TestReader_Seek(t *testing.T) {
+	data := []byte("0123456789")
+	r := newTestReader(t, data)
+
+	// Seek before the body is opened.
+	pos, err := r.Seek(2, io.SeekStart)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), pos)
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "234", string(buf))
+
+	// Seek relative to the current position re-opens the body.
+	pos, err = r.Seek(1, io.SeekCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), pos)
+	_, err = io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	assert.Equal(t, "678", string(buf))
+
+	pos, err = r.Seek(-2, io.SeekEnd)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), pos)
+	rest, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, "89", string(rest))
+
+	assert.NoError(t, r.SeekStart())
+	all, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, all)
+}
+
+func TestReader_Seek_Invalid(t *testing.T) {
+	r := newTestReader(t, []byte("content"))
+
+	_, err := r.Seek(-1, io.SeekStart)
+	assert.Error(t, err)
+	_, err = r.Seek(0, 42)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), r.Offset)
+}
+
+// flakyReadStore fails the next failures range reads with err after cut
+// bytes, and records the offset of every range read.
+type flakyReadStore struct {
+	Interface
+	cut      int64
+	failures int
+	err      error
+	offsets  []int64
+}
+
+func (s *flakyReadStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	s.offsets = append(s.offsets, offset)
+	r, err := s.Interface.DownloadRangeReader(key, offset, size)
+	if err != nil || s.failures == 0 {
+		return r, err
+	}
+	s.failures--
+	return &rangeReaderCloser{
+		Reader: io.MultiReader(io.LimitReader(r, s.cut), iotest.ErrReader(s.err)),
+		closer: r.Close,
+	}, nil
+}
+
+func newFlakyReader(t *testing.T, ctx context.Context, data []byte, st *flakyReadStore) *Reader {
+	st.Interface = NewMemStore()
+	assert.NoError(t, st.UploadData(data, "key"))
+	r := NewReader(ctx, st, "key")
+	r.Retry.Sleep = func(context.Context, time.Duration) error { return nil }
+	t.Cleanup(func() {
+		_ = r.Close()
+	})
+	return r
+}
+
+func TestReader_Resume(t *testing.T) {
+	data := []byte("0123456789")
+	st := &flakyReadStore{cut: 3, failures: 3, err: io.ErrUnexpectedEOF}
+	r := newFlakyReader(t, context.Background(), data, st)
+
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.Equal(t, []int64{0, 3, 6, 9}, st.offsets)
+}
+
+func TestReader_Resume_GivesUp(t *testing.T) {
+	// failures without progress in between exhaust the retries
+	st := &flakyReadStore{cut: 0, failures: 10, err: io.ErrUnexpectedEOF}
+	r := newFlakyReader(t, context.Background(), []byte("0123456789"), st)
+
+	_, err := io.ReadAll(r)
+	assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	assert.Len(t, st.offsets, defaultReaderRetries+1)
+
+	// errors that aren't transient aren't resumed from
+	st = &flakyReadStore{cut: 2, failures: 1, err: errors.New("corrupt")}
+	r = newFlakyReader(t, context.Background(), []byte("0123456789"), st)
+	got, err := io.ReadAll(r)
+	assert.EqualError(t, err, "corrupt")
+	assert.Equal(t, "01", string(got))
+	assert.Len(t, st.offsets, 1)
+}
+
+func TestReader_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := newFlakyReader(t, ctx, []byte("0123456789"), &flakyReadStore{})
+
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(r, buf)
+	assert.NoError(t, err)
+	cancel()
+	_, err = r.Read(buf)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int64(4), r.Offset)
+
+	_, err = NewReader(ctx, r.Store, "key").Read(buf)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestReader_ReadAt(t *testing.T) {
+	r := newTestReader(t, []byte("0123456789"))
+
+	buf := make([]byte, 3)
+	n, err := r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "456", string(buf[:n]))
+
+	n, err = r.ReadAt(buf, 8)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, "89", string(buf[:n]))
+
+	n, err = r.ReadAt(buf, 20)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Zero(t, n)
+
+	_, err = r.ReadAt(buf, -1)
+	assert.Error(t, err)
+	assert.Equal(t, int64(0), r.Offset, "ReadAt doesn't move the Read position")
+
+	// concurrent calls, checked with -race
+	var wg sync.WaitGroup
+	for off := int64(0); off < 10; off++ {
+		wg.Add(1)
+		go func(off int64) {
+			defer wg.Done()
+			buf := make([]byte, 1)
+			n, err := r.ReadAt(buf, off)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, n)
+			assert.Equal(t, byte('0'+off), buf[0])
+		}(off)
+	}
+	wg.Wait()
+}
+
+func TestReader_ReadAt_Resume(t *testing.T) {
+	st := &flakyReadStore{cut: 3, failures: 2, err: io.ErrUnexpectedEOF}
+	r := newFlakyReader(t, context.Background(), []byte("0123456789"), st)
+
+	buf := make([]byte, 8)
+	n, err := r.ReadAt(buf, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345678", string(buf[:n]))
+	assert.Equal(t, []int64{1, 4, 7}, st.offsets)
+}
+
+// readCountingBody counts the reads of the underlying reader.
+type readCountingBody struct {
+	io.Reader
+	reads  int
+	closed bool
+}
+
+func (c *readCountingBody) Read(p []byte) (int, error) {
+	c.reads++
+	return c.Reader.Read(p)
+}
+
+func (c *readCountingBody) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestBufferedReader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789"), 1000)
+	body := &readCountingBody{Reader: bytes.NewReader(data)}
+	r := NewBufferedReader(body, 4096)
+
+	var got []byte
+	buf := make([]byte, 3)
+	for {
+		n, err := r.Read(buf)
+		got = append(got, buf[:n]...)
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, data, got)
+	assert.LessOrEqual(t, body.reads, 4, "3-byte reads are served from the 4KiB buffer")
+
+	assert.NoError(t, r.Close())
+	assert.True(t, body.closed)
+	_, err := r.Read(buf)
+	assert.ErrorIs(t, err, os.ErrClosed)
+	assert.NoError(t, r.Close(), "closing twice is a no-op")
+}
+
+func BenchmarkBufferedReader(b *testing.B) {
+	data := bytes.Repeat([]byte{'x'}, 1<<20)
+	for _, bc := range []struct {
+		name    string
+		bufSize int
+	}{
+		{"Unbuffered", 0},
+		{"64KiB", 64 << 10},
+		{"1MiB", 1 << 20},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			reads := 0
+			buf := make([]byte, 16)
+			for i := 0; i < b.N; i++ {
+				body := &readCountingBody{Reader: bytes.NewReader(data)}
+				var r io.ReadCloser = body
+				if bc.bufSize > 0 {
+					r = NewBufferedReader(body, bc.bufSize)
+				}
+				for {
+					if _, err := r.Read(buf); err != nil {
+						break
+					}
+				}
+				_ = r.Close()
+				reads += body.reads
+			}
+			b.ReportMetric(float64(reads)/float64(b.N), "backend-reads/op")
+		})
+	}
+}
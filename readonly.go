@@ -0,0 +1,79 @@
+package store
+
+import (
+	"fmt"
+	"io"
+)
+
+var (
+	_ Interface = &ReadOnlyStore{}
+	_ io.Closer = &ReadOnlyStore{}
+)
+
+// ReadOnlyStore passes the reads through to the wrapped store and fails all
+// uploads and deletes with ErrReadOnly without calling it, e.g. to hand a
+// store to a component that must never modify it.
+type ReadOnlyStore struct {
+	inner Interface
+}
+
+func NewReadOnlyStore(inner Interface) Interface {
+	return &ReadOnlyStore{inner: inner}
+}
+
+func readOnlyError(op string, key string) error {
+	return fmt.Errorf("%s %s: %w", op, key, ErrReadOnly)
+}
+
+func (s *ReadOnlyStore) UploadData(data []byte, key string, opts ...UploadOption) error {
+	return readOnlyError("UploadData", key)
+}
+
+func (s *ReadOnlyStore) Upload(file string, key string, opts ...UploadOption) error {
+	return readOnlyError("Upload", key)
+}
+
+func (s *ReadOnlyStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	return readOnlyError("UploadReader", key)
+}
+
+func (s *ReadOnlyStore) Delete(key string) error {
+	return readOnlyError("Delete", key)
+}
+
+func (s *ReadOnlyStore) DeleteDirectory(dir string) error {
+	return readOnlyError("DeleteDirectory", dir)
+}
+
+func (s *ReadOnlyStore) Exists(key string) (bool, error) {
+	return s.inner.Exists(key)
+}
+
+func (s *ReadOnlyStore) Stat(key string) (FileStat, error) {
+	return s.inner.Stat(key)
+}
+
+func (s *ReadOnlyStore) DownloadBytes(key string) ([]byte, error) {
+	return s.inner.DownloadBytes(key)
+}
+
+func (s *ReadOnlyStore) DownloadReader(key string) (io.ReadCloser, error) {
+	return s.inner.DownloadReader(key)
+}
+
+func (s *ReadOnlyStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	return s.inner.DownloadRangeBytes(key, offset, size)
+}
+
+func (s *ReadOnlyStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
+	return s.inner.DownloadRangeReader(key, offset, size)
+}
+
+func (s *ReadOnlyStore) ListPrefix(key string) ([]string, error) {
+	return s.inner.ListPrefix(key)
+}
+
+// Close closes the wrapped store.
+func (s *ReadOnlyStore) Close() error {
+	return closeStore(s.inner)
+}
@@ -0,0 +1,47 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadOnlyStore(t *testing.T) {
+	inner := NewMemStore()
+	assert.NoError(t, inner.UploadData([]byte("content"), "dir/a.txt"))
+	st := NewReadOnlyStore(inner)
+
+	data, err := st.DownloadBytes("dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	data, err = st.DownloadRangeBytes("dir/a.txt", 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, "on", string(data))
+	r, err := st.DownloadReader("dir/a.txt")
+	assert.NoError(t, err)
+	data, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "content", string(data))
+	exists, err := st.Exists("dir/a.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	stat, err := st.Stat("dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(7), stat.Size)
+	keys, err := st.ListPrefix("dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/a.txt"}, keys)
+
+	assert.ErrorIs(t, st.UploadData([]byte("x"), "dir/b.txt"), ErrReadOnly)
+	assert.ErrorIs(t, st.Upload("file.txt", "dir/b.txt"), ErrReadOnly)
+	assert.ErrorIs(t, st.UploadReader(bytes.NewReader(nil), 0, "dir/b.txt"), ErrReadOnly)
+	assert.ErrorIs(t, st.Delete("dir/a.txt"), ErrReadOnly)
+	assert.ErrorIs(t, st.DeleteDirectory("dir/"), ErrReadOnly)
+
+	keys, err = inner.ListPrefix("dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/a.txt"}, keys, "the wrapped store must be left untouched")
+}
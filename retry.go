@@ -0,0 +1,300 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	defaultRetryBaseBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff  = 10 * time.Second
+)
+
+// RetryPolicy retries operations failing with transient errors, backing off
+// exponentially between attempts. The zero value doesn't retry.
+type RetryPolicy struct {
+	// MaxRetries is the number of retries after the first attempt.
+	MaxRetries int
+	// BaseBackoff is the delay before the first retry, doubled on every
+	// following retry. Defaults to 100ms.
+	BaseBackoff time.Duration
+	// MaxBackoff caps the delay between retries. Defaults to 10s.
+	MaxBackoff time.Duration
+	// Jitter, between 0 and 1, randomly shortens each delay by up to that
+	// fraction so that clients failing together don't retry in lockstep.
+	Jitter float64
+	// Retryable reports whether an error is worth retrying.
+	// Defaults to IsRetryable.
+	Retryable func(err error) bool
+	// Sleep waits for d or until ctx is done. Defaults to a timer; tests
+	// replace it to avoid real waits.
+	Sleep func(ctx context.Context, d time.Duration) error
+
+	// log is the logger of the store using the policy, the package logger
+	// when nil.
+	log *logger
+}
+
+// Do calls fn until it succeeds, fails with an error that isn't retryable,
+// or the retries are exhausted. It returns the last error of fn.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxRetries || !p.retryable(err) {
+			return err
+		}
+		d := p.backoff(attempt)
+		p.log.Debugw("retrying", "attempt", attempt+1, "backoff", d, "error", err)
+		if sleepErr := p.sleep(ctx, d); sleepErr != nil {
+			return err
+		}
+	}
+}
+
+// forUpload returns the policy of an upload with options o. Conditional and
+// create-only uploads aren't retried: if the response to an attempt that
+// went through is lost, the retry fails its precondition against the object
+// it just wrote and would report the upload as a conflict.
+func (p RetryPolicy) forUpload(o UploadOptions) RetryPolicy {
+	if o.conditional() || !o.Overwrite {
+		p.MaxRetries = 0
+	}
+	return p
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	if p.Retryable == nil {
+		return IsRetryable(err)
+	}
+	return p.Retryable(err)
+}
+
+func (p RetryPolicy) sleep(ctx context.Context, d time.Duration) error {
+	if p.Sleep == nil {
+		return sleepContext(ctx, d)
+	}
+	return p.Sleep(ctx, d)
+}
+
+// backoff returns the delay before retry attempt+1.
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	base := p.BaseBackoff
+	if base <= 0 {
+		base = defaultRetryBaseBackoff
+	}
+	maxBackoff := p.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = defaultRetryMaxBackoff
+	}
+	d := base
+	for i := 0; i < attempt && d < maxBackoff; i++ {
+		d *= 2
+	}
+	if d > maxBackoff {
+		d = maxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(rand.Float64() * min(p.Jitter, 1) * float64(d))
+	}
+	return d
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// IsRetryable reports whether err is a transient failure: a timeout, a
+// refused or reset connection, or a 500, 502, 503 or 504 response.
+// Client errors such as 403 and 404 are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var resp minio.ErrorResponse
+	if errors.As(err, &resp) && resp.StatusCode != 0 {
+		switch resp.StatusCode {
+		case http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return resp.Code == "SlowDown" || resp.Code == "RequestTimeout"
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ReaderFactory returns a fresh reader over the same content each time it's
+// called, so that an upload from a reader can be retried.
+type ReaderFactory func() (io.Reader, error)
+
+var (
+	_ Interface = &RetryStore{}
+	_ io.Closer = &RetryStore{}
+)
+
+// RetryStore wraps a store and retries its reads and uploads according to a
+// RetryPolicy. Deletes are not retried, since a retry after a delete that
+// failed late would report the key as missing, and neither are conditional
+// and create-only uploads.
+type RetryStore struct {
+	inner  Interface
+	policy RetryPolicy
+}
+
+func NewRetryStore(inner Interface, policy RetryPolicy, opts ...Option) Interface {
+	o := newOptions(opts)
+	policy.log = newLogger(o.logger)
+	return &RetryStore{
+		inner:  inner,
+		policy: policy,
+	}
+}
+
+func (s *RetryStore) do(fn func() error) error {
+	return s.policy.Do(context.TODO(), fn)
+}
+
+// doUpload retries an upload with opts as far as the policy allows for them.
+func (s *RetryStore) doUpload(opts []UploadOption, fn func() error) error {
+	return s.policy.forUpload(NewUploadOptions(opts...)).Do(context.TODO(), fn)
+}
+
+func (s *RetryStore) Stat(key string) (stat FileStat, err error) {
+	err = s.do(func() (err error) {
+		stat, err = s.inner.Stat(key)
+		return err
+	})
+	return stat, err
+}
+
+func (s *RetryStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	return s.doUpload(opts, func() error {
+		return s.inner.UploadData(data, key, opts...)
+	})
+}
+
+func (s *RetryStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	return s.doUpload(opts, func() error {
+		return s.inner.Upload(file, key, opts...)
+	})
+}
+
+// UploadReader is retried only if reader is an io.Seeker, seeking it back
+// to its current position before each retry. Use UploadReaderFactory to
+// retry uploads from other readers.
+func (s *RetryStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	seeker, ok := reader.(io.Seeker)
+	if !ok {
+		return s.inner.UploadReader(reader, size, key, opts...)
+	}
+	pos, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return s.inner.UploadReader(reader, size, key, opts...)
+	}
+	first := true
+	return s.doUpload(opts, func() error {
+		if !first {
+			if _, err := seeker.Seek(pos, io.SeekStart); err != nil {
+				return fmt.Errorf("rewind reader: %w", err)
+			}
+		}
+		first = false
+		return s.inner.UploadReader(reader, size, key, opts...)
+	})
+}
+
+// UploadReaderFactory uploads the content of a reader obtained from factory,
+// getting a fresh reader for each retry.
+func (s *RetryStore) UploadReaderFactory(factory ReaderFactory, size int64, key string, opts ...UploadOption) (err error) {
+	return s.doUpload(opts, func() error {
+		reader, err := factory()
+		if err != nil {
+			return err
+		}
+		if c, ok := reader.(io.Closer); ok {
+			defer c.Close() // nolint: errcheck
+		}
+		return s.inner.UploadReader(reader, size, key, opts...)
+	})
+}
+
+func (s *RetryStore) DeleteDirectory(dir string) (err error) {
+	return s.inner.DeleteDirectory(dir)
+}
+
+func (s *RetryStore) Delete(key string) (err error) {
+	return s.inner.Delete(key)
+}
+
+func (s *RetryStore) Exists(key string) (exists bool, err error) {
+	err = s.do(func() (err error) {
+		exists, err = s.inner.Exists(key)
+		return err
+	})
+	return exists, err
+}
+
+func (s *RetryStore) DownloadBytes(key string) (data []byte, err error) {
+	err = s.do(func() (err error) {
+		data, err = s.inner.DownloadBytes(key)
+		return err
+	})
+	return data, err
+}
+
+func (s *RetryStore) DownloadReader(key string) (r io.ReadCloser, err error) {
+	err = s.do(func() (err error) {
+		r, err = s.inner.DownloadReader(key)
+		return err
+	})
+	return r, err
+}
+
+func (s *RetryStore) DownloadRangeBytes(key string, offset int64, size int64) (data []byte, err error) {
+	err = s.do(func() (err error) {
+		data, err = s.inner.DownloadRangeBytes(key, offset, size)
+		return err
+	})
+	return data, err
+}
+
+func (s *RetryStore) DownloadRangeReader(key string, offset int64, size int64) (r io.ReadCloser, err error) {
+	err = s.do(func() (err error) {
+		r, err = s.inner.DownloadRangeReader(key, offset, size)
+		return err
+	})
+	return r, err
+}
+
+func (s *RetryStore) ListPrefix(key string) (keys []string, err error) {
+	err = s.do(func() (err error) {
+		keys, err = s.inner.ListPrefix(key)
+		return err
+	})
+	return keys, err
+}
+
+// Close closes the wrapped store.
+func (s *RetryStore) Close() error {
+	return closeStore(s.inner)
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeSleep returns a RetryPolicy.Sleep advancing clock instead of waiting
+// and recording the delays into slept.
+func fakeSleep(clock *fakeClock, slept *[]time.Duration) func(context.Context, time.Duration) error {
+	return func(_ context.Context, d time.Duration) error {
+		clock.Advance(d)
+		*slept = append(*slept, d)
+		return nil
+	}
+}
+
+func TestRetryPolicy_Do(t *testing.T) {
+	clock := newFakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	var slept []time.Duration
+	p := RetryPolicy{
+		MaxRetries:  5,
+		BaseBackoff: time.Second,
+		MaxBackoff:  3 * time.Second,
+		Sleep:       fakeSleep(clock, &slept),
+	}
+
+	calls := 0
+	err := p.Do(context.Background(), func() error {
+		calls++
+		if calls < 4 {
+			return syscall.ECONNRESET
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, calls)
+	assert.Equal(t, []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}, slept)
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 6, 0, time.UTC), now())
+}
+
+func TestRetryPolicy_Do_GivesUp(t *testing.T) {
+	var slept []time.Duration
+	p := RetryPolicy{MaxRetries: 2, Sleep: fakeSleep(newFakeClock(t, time.Now()), &slept)}
+
+	calls := 0
+	err := p.Do(context.Background(), func() error {
+		calls++
+		return syscall.ECONNREFUSED
+	})
+	assert.ErrorIs(t, err, syscall.ECONNREFUSED)
+	assert.Equal(t, 3, calls)
+
+	calls = 0
+	err = p.Do(context.Background(), func() error {
+		calls++
+		return minio.ErrorResponse{StatusCode: http.StatusNotFound, Code: "NoSuchKey"}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls, "not found should not be retried")
+}
+
+func TestIsRetryable(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{errors.New("boom"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, true},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{minio.ErrorResponse{StatusCode: http.StatusInternalServerError}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusServiceUnavailable}, true},
+		{minio.ErrorResponse{StatusCode: http.StatusForbidden, Code: "AccessDenied"}, false},
+		{minio.ErrorResponse{StatusCode: http.StatusNotFound, Code: "NoSuchKey"}, false},
+	} {
+		assert.Equal(t, tc.want, IsRetryable(tc.err), "%v", tc.err)
+	}
+}
+
+func TestRetryPolicy_Jitter(t *testing.T) {
+	p := RetryPolicy{BaseBackoff: time.Second, Jitter: 0.5}
+	for i := 0; i < 100; i++ {
+		d := p.backoff(1)
+		assert.GreaterOrEqual(t, d, time.Second)
+		assert.LessOrEqual(t, d, 2*time.Second)
+	}
+}
+
+// flakyStore fails the first failures calls of the methods it overrides
+// with a retryable error.
+type flakyStore struct {
+	Interface
+	failures int
+	calls    int
+}
+
+func (s *flakyStore) fail() error {
+	s.calls++
+	if s.failures > 0 {
+		s.failures--
+		return syscall.ECONNRESET
+	}
+	return nil
+}
+
+func (s *flakyStore) DownloadBytes(key string) ([]byte, error) {
+	if err := s.fail(); err != nil {
+		return nil, err
+	}
+	return s.Interface.DownloadBytes(key)
+}
+
+func (s *flakyStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	if err := s.fail(); err != nil {
+		// Consume the reader like a failed upload would.
+		_, _ = io.Copy(io.Discard, reader)
+		return err
+	}
+	return s.Interface.UploadReader(reader, size, key, opts...)
+}
+
+func (s *flakyStore) Delete(key string) error {
+	if err := s.fail(); err != nil {
+		return err
+	}
+	return s.Interface.Delete(key)
+}
+
+func newTestRetryStore(t *testing.T, failures int) (Interface, *flakyStore) {
+	inner := &flakyStore{Interface: NewMemStore(), failures: failures}
+	var slept []time.Duration
+	policy := RetryPolicy{MaxRetries: 3, Jitter: 0.5, Sleep: fakeSleep(newFakeClock(t, time.Now()), &slept)}
+	return NewRetryStore(inner, policy), inner
+}
+
+func TestRetryStore_DownloadBytes(t *testing.T) {
+	s, inner := newTestRetryStore(t, 2)
+	assert.NoError(t, inner.Interface.UploadData([]byte("content"), "key"))
+
+	data, err := s.DownloadBytes("key")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("content"), data)
+	assert.Equal(t, 3, inner.calls)
+}
+
+func TestRetryStore_UploadReader(t *testing.T) {
+	s, inner := newTestRetryStore(t, 1)
+
+	// A seekable reader is rewound before retrying.
+	assert.NoError(t, s.UploadReader(bytes.NewReader([]byte("seekable")), 8, "seekable"))
+	data, err := inner.Interface.DownloadBytes("seekable")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("seekable"), data)
+
+	// Other readers can't be retried.
+	inner.failures = 1
+	err = s.UploadReader(io.MultiReader(strings.NewReader("stream")), 6, "stream")
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+
+	inner.failures = 1
+	err = s.(*RetryStore).UploadReaderFactory(func() (io.Reader, error) {
+		return io.MultiReader(strings.NewReader("stream")), nil
+	}, 6, "stream")
+	assert.NoError(t, err)
+	data, err = inner.Interface.DownloadBytes("stream")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("stream"), data)
+}
+
+func TestRetryStore_DeleteNotRetried(t *testing.T) {
+	s, inner := newTestRetryStore(t, 1)
+	assert.NoError(t, inner.Interface.UploadData([]byte("content"), "key"))
+
+	assert.ErrorIs(t, s.Delete("key"), syscall.ECONNRESET)
+	assert.Equal(t, 1, inner.calls)
+}
+
+func TestRetryStore_ConditionalUploadNotRetried(t *testing.T) {
+	for _, opt := range []UploadOption{Overwrite(false), IfNoneMatch("*"), IfMatch("etag")} {
+		s, inner := newTestRetryStore(t, 1)
+		err := s.UploadReader(bytes.NewReader([]byte("content")), 7, "key", opt)
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+		assert.Equal(t, 1, inner.calls)
+
+		inner.calls, inner.failures = 0, 1
+		err = s.(*RetryStore).UploadReaderFactory(func() (io.Reader, error) {
+			return strings.NewReader("content"), nil
+		}, 7, "key", opt)
+		assert.ErrorIs(t, err, syscall.ECONNRESET)
+		assert.Equal(t, 1, inner.calls)
+	}
+}
+
+func TestRetryStore(t *testing.T) {
+	testAll(t, NewRetryStore(NewMemStore(), RetryPolicy{MaxRetries: 2}), "retry")
+}
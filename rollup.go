@@ -0,0 +1,65 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// RollupEntry is an entry of a listing rolled up at a fixed depth.
+// Objects deeper than the depth are aggregated under their ancestor at that
+// depth, which is reported with IsDir set and a trailing slash in Prefix.
+type RollupEntry struct {
+	Prefix string
+	IsDir  bool
+	Count  int64
+	Size   int64
+}
+
+// RollupLister is implemented by stores that can roll up a listing.
+type RollupLister interface {
+	ListRollup(prefix string, depth int) ([]RollupEntry, error)
+}
+
+// rollup buckets keys under a prefix by their ancestor at a fixed depth.
+type rollup struct {
+	prefix  string
+	depth   int
+	entries map[string]*RollupEntry
+}
+
+func newRollup(prefix string, depth int) (*rollup, error) {
+	if depth < 1 {
+		return nil, fmt.Errorf("invalid rollup depth: %d", depth)
+	}
+	return &rollup{prefix: prefix, depth: depth, entries: make(map[string]*RollupEntry)}, nil
+}
+
+func (r *rollup) add(key string, size int64) {
+	rel := strings.TrimPrefix(strings.TrimPrefix(key, r.prefix), "/")
+	parts := strings.Split(rel, "/")
+	if len(parts) <= r.depth {
+		r.entries[key] = &RollupEntry{Prefix: key, Count: 1, Size: size}
+		return
+	}
+	dir := key[:len(key)-len(rel)] + strings.Join(parts[:r.depth], "/") + "/"
+	e, ok := r.entries[dir]
+	if !ok {
+		e = &RollupEntry{Prefix: dir, IsDir: true}
+		r.entries[dir] = e
+	}
+	e.Count++
+	e.Size += size
+}
+
+// result returns the entries sorted by prefix.
+func (r *rollup) result() []RollupEntry {
+	entries := make([]RollupEntry, 0, len(r.entries))
+	for _, e := range r.entries {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Prefix < entries[j].Prefix
+	})
+	return entries
+}
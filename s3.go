@@ -2,28 +2,58 @@ package store
 
 import (
 	"bytes"
+	"cmp"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"net"
+	"net/http"
 	"os"
 	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/minio/minio-go/v7"
 	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/pelletier/go-toml"
+	"gopkg.in/yaml.v3"
 )
 
 const (
-	recyclePath = "_recycle/"
+	recyclePath    = "_recycle/"
+	healthCheckKey = ".store-healthcheck"
+
+	consistencyPollInterval    = 10 * time.Millisecond
+	maxConsistencyPollInterval = time.Second
 )
 
 var (
 	S3NotConfigError = fmt.Errorf("s3 store is not configured")
 )
 
+// The values of S3Config.CredentialsSource.
+const (
+	// CredentialsStatic uses AccessKey, SecretKey and Token. It's the default.
+	CredentialsStatic = "static"
+	// CredentialsIAM uses the EC2 instance or ECS task role.
+	CredentialsIAM = "iam"
+	// CredentialsEnv reads the AWS_ACCESS_KEY_ID family of variables.
+	CredentialsEnv = "env"
+	// CredentialsChain tries the static keys if set, the AWS and MinIO
+	// environment variables, the AWS credentials file and then IAM.
+	CredentialsChain = "chain"
+)
+
 type S3Config struct {
 	Endpoint  string `json:"endpoint" yaml:"endpoint" toml:"endpoint"`
 	Region    string `json:"region" yaml:"region" toml:"region"`
@@ -32,303 +62,1534 @@ type S3Config struct {
 	SecretKey string `json:"secret_key" yaml:"secret_key" toml:"secret_key"`
 	Token     string `json:"token" yaml:"token" toml:"token"`
 	UseSSL    bool   `json:"use_ssl" yaml:"use_ssl" toml:"use_ssl"`
+	// CredentialsSource selects where the credentials come from, one of
+	// CredentialsStatic, CredentialsIAM, CredentialsEnv or CredentialsChain.
+	// Defaults to CredentialsStatic.
+	CredentialsSource string `json:"credentials_source" yaml:"credentials_source" toml:"credentials_source"`
+	// Anonymous sends unsigned requests, for reading public buckets without
+	// credentials. The store is read-only: writes and deletes fail with
+	// ErrReadOnly. It can't be combined with credentials.
+	Anonymous bool `json:"anonymous" yaml:"anonymous" toml:"anonymous"`
+	// BucketLookup selects the addressing style: "path" for path-style
+	// requests as MinIO expects, "dns" for virtual-host style, or "auto" to
+	// let minio guess from the endpoint. Defaults to "auto".
+	BucketLookup string `json:"bucket_lookup" yaml:"bucket_lookup" toml:"bucket_lookup"`
+	// CACertPath is a PEM file of CA certificates trusted in addition to the
+	// system ones, e.g. for a gateway with a private CA.
+	CACertPath string `json:"ca_cert_path" yaml:"ca_cert_path" toml:"ca_cert_path"`
+	// InsecureSkipVerify disables the verification of the server certificate.
+	InsecureSkipVerify bool `json:"insecure_skip_verify" yaml:"insecure_skip_verify" toml:"insecure_skip_verify"`
+	// DialTimeout bounds establishing a connection. Defaults to 30s.
+	DialTimeout time.Duration `json:"dial_timeout" yaml:"dial_timeout" toml:"dial_timeout"`
+	// ResponseHeaderTimeout bounds the wait for the response headers once
+	// a request is sent. Defaults to 1m.
+	ResponseHeaderTimeout time.Duration `json:"response_header_timeout" yaml:"response_header_timeout" toml:"response_header_timeout"`
+	// ContentTypeByExt maps file extensions (e.g. ".car") to the content type
+	// set on uploaded objects. It takes precedence over mime.TypeByExtension.
+	ContentTypeByExt map[string]string `json:"content_type_by_ext" yaml:"content_type_by_ext" toml:"content_type_by_ext"`
+	// VerifyWritable makes NewS3Store put and delete a small health check
+	// object to make sure the bucket exists and is writable.
+	VerifyWritable bool `json:"verify_writable" yaml:"verify_writable" toml:"verify_writable"`
+	// CreateBucketIfNotExists makes NewS3Store create the bucket in Region
+	// if it doesn't exist yet. Meant for development and CI; leave it off in
+	// production so that a misconfigured bucket fails loudly.
+	CreateBucketIfNotExists bool `json:"create_bucket_if_not_exists" yaml:"create_bucket_if_not_exists" toml:"create_bucket_if_not_exists"`
+	// RecycleRepairPolicy decides how RepairRecycle resolves an interrupted
+	// soft-delete. Defaults to RepairCompleteDelete.
+	RecycleRepairPolicy RecycleRepairPolicy `json:"recycle_repair_policy" yaml:"recycle_repair_policy" toml:"recycle_repair_policy"`
+	// MaxRetries is the number of times a request failing with a transient
+	// error is retried. See IsRetryable.
+	MaxRetries int `json:"max_retries" yaml:"max_retries" toml:"max_retries"`
+	// RetryBackoff is the delay before the first retry, doubled on every
+	// following retry. Defaults to 100ms.
+	RetryBackoff time.Duration `json:"retry_backoff" yaml:"retry_backoff" toml:"retry_backoff"`
+	// ConsistencyTimeout, if set, makes uploads wait until the object is
+	// visible to Stat, for up to this long, for eventually consistent
+	// backends. Defaults to 0, which doesn't wait.
+	ConsistencyTimeout time.Duration `json:"consistency_timeout" yaml:"consistency_timeout" toml:"consistency_timeout"`
+	// PartSize is the size of the parts of the multipart uploads minio
+	// switches to for large objects, between 5MiB and 5GiB. Defaults to 0,
+	// which lets minio pick it from the size of the object.
+	//
+	// Uploads of an unknown size, UploadReader with a size of -1, are streamed
+	// in parts buffered in memory. Their part size defaults to 64MiB, which
+	// caps the object at 640GiB since S3 takes up to 10000 parts; a larger
+	// part size allows larger streams at the cost of memory.
+	PartSize int64 `json:"part_size" yaml:"part_size" toml:"part_size"`
+	// UploadConcurrency is the number of parts of a multipart upload sent
+	// at the same time. Defaults to 0, minio's default of 4. Streamed
+	// uploads only send parts concurrently with PartSize set, and buffer
+	// UploadConcurrency parts of PartSize bytes.
+	UploadConcurrency int `json:"upload_concurrency" yaml:"upload_concurrency" toml:"upload_concurrency"`
 }
 
 func LoadS3Config(cfgPath string) (*S3Config, error) {
-	var cfg S3Config
 	raw, err := os.ReadFile(cfgPath)
 	if err != nil {
 		return nil, err
 	}
-	ext := strings.ToLower(path.Ext(cfgPath))
-	if ext == ".json" {
-		err = json.Unmarshal(raw, &cfg)
-	} else if ext == ".toml" {
-		err = toml.Unmarshal(raw, &cfg)
-	} else {
-		return nil, fmt.Errorf("invalid s3 configuration format")
+	return loadS3Config(raw, configFormat(cfgPath))
+}
+
+// LoadS3ConfigFromReader loads the s3 configuration from r.
+// The format is one of "json", "toml" or "yaml".
+func LoadS3ConfigFromReader(r io.Reader, format string) (*S3Config, error) {
+	raw, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
 	}
+	return loadS3Config(raw, format)
+}
+
+func loadS3Config(raw []byte, format string) (*S3Config, error) {
+	var cfg S3Config
+	err := unmarshalConfig(raw, format, &cfg)
 	return &cfg, err
 }
 
-type S3Store struct {
-	cfg    *S3Config
-	client *minio.Client
+// configFormat returns the configuration format implied by the file extension.
+func configFormat(cfgPath string) string {
+	return strings.TrimPrefix(strings.ToLower(path.Ext(cfgPath)), ".")
+}
+
+// unmarshalConfig decodes raw into v, then expands the ${VAR} and $VAR
+// references to environment variables in its string values, so that secrets
+// can be kept out of the configuration files. $$ is a literal $. Referencing
+// a variable that isn't set is an error.
+func unmarshalConfig(raw []byte, format string, v interface{}) error {
+	var err error
+	switch strings.ToLower(format) {
+	case "json":
+		err = json.Unmarshal(raw, v)
+	case "toml":
+		err = toml.Unmarshal(raw, v)
+	case "yaml", "yml":
+		err = yaml.Unmarshal(raw, v)
+	default:
+		return fmt.Errorf("invalid configuration format %q", format)
+	}
+	if err != nil {
+		return err
+	}
+	return expandConfigEnv(reflect.ValueOf(v))
+}
+
+// expandConfigEnv expands the environment variables in the strings held by
+// v, walking through pointers, structs, maps and slices.
+func expandConfigEnv(v reflect.Value) error {
+	switch v.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return nil
+		}
+		return expandConfigEnv(v.Elem())
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Type().Field(i).IsExported() {
+				continue
+			}
+			if err := expandConfigEnv(v.Field(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := expandConfigEnv(v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// map values can't be set in place
+			val := reflect.New(iter.Value().Type()).Elem()
+			val.Set(iter.Value())
+			if err := expandConfigEnv(val); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), val)
+		}
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		s, err := expandEnv(v.String())
+		if err != nil {
+			return err
+		}
+		v.SetString(s)
+	}
+	return nil
+}
+
+// expandEnv expands the ${VAR} and $VAR references in s, failing on the
+// first variable that isn't set.
+func expandEnv(s string) (string, error) {
+	var missing []string
+	s = os.Expand(s, func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s referenced in configuration is not set", missing[0])
+	}
+	return s, nil
 }
 
-func NewS3Store(cfg *S3Config) (Interface, error) {
+type S3Store struct {
+	cfg         *S3Config
+	client      *minio.Client
+	transport   *http.Transport
+	retry       RetryPolicy
+	checksum    ChecksumAlgorithm
+	rateLimit   int64
+	maxDownload int64
+	log         *logger
+
+	versioningLk    sync.Mutex
+	versioningKnown bool
+	versioned       bool
 
-	fmt.Println("NewS3Store", cfg)
+	lifecycleLk sync.Mutex
+	expiryRules map[int]bool
+}
 
+func NewS3Store(cfg *S3Config, opts ...Option) (Interface, error) {
+	o := newOptions(opts)
+	var (
+		rt        http.RoundTripper
+		transport *http.Transport
+		err       error
+	)
+	if o.httpClient != nil {
+		rt = o.httpClient.Transport
+		if rt == nil {
+			rt = http.DefaultTransport
+		}
+	} else {
+		transport, err = s3Transport(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("initialize s3 transport: %w", err)
+		}
+		rt = transport
+	}
+	creds, err := s3Credentials(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := validatePartSize(cfg.PartSize); err != nil {
+		return nil, err
+	}
+	if cfg.Anonymous && (cfg.CreateBucketIfNotExists || cfg.VerifyWritable) {
+		return nil, errors.New("anonymous s3 access is read-only, it can't create or verify writing to the bucket")
+	}
+	lookup, err := s3BucketLookup(cfg.BucketLookup)
+	if err != nil {
+		return nil, err
+	}
 	client, err := minio.New(cfg.Endpoint, &minio.Options{
-		Creds:  credentials.NewStaticV4(cfg.AccessKey, cfg.SecretKey, cfg.Token),
-		Secure: cfg.UseSSL,
+		Creds:        creds,
+		Secure:       cfg.UseSSL,
+		Transport:    rt,
+		BucketLookup: lookup,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("initialize s3 client: %v", err)
+		return nil, fmt.Errorf("initialize s3 client: %w", err)
 	}
-	return &S3Store{
-		cfg:    cfg,
-		client: client,
-	}, nil
+	s := &S3Store{
+		cfg:         cfg,
+		client:      client,
+		transport:   transport,
+		checksum:    o.checksum,
+		rateLimit:   o.rateLimit,
+		maxDownload: o.maxDownload,
+		log:         newLogger(o.logger),
+	}
+	s.retry = RetryPolicy{
+		MaxRetries:  cfg.MaxRetries,
+		BaseBackoff: cfg.RetryBackoff,
+		log:         s.log,
+	}
+	s.log.Debugw("new s3 store", "endpoint", cfg.Endpoint, "bucket", cfg.Bucket)
+	if cfg.CreateBucketIfNotExists {
+		if err := s.createBucket(context.TODO()); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.VerifyWritable {
+		if err := s.verifyWritable(context.TODO()); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// s3Credentials returns the credentials provider selected by
+// cfg.CredentialsSource, or no credentials for cfg.Anonymous.
+func s3Credentials(cfg *S3Config) (*credentials.Credentials, error) {
+	if cfg.Anonymous {
+		if cfg.AccessKey != "" || cfg.SecretKey != "" || cfg.Token != "" || cfg.CredentialsSource != "" {
+			return nil, errors.New("anonymous s3 access can't be combined with credentials")
+		}
+		// static credentials without keys don't sign the requests
+		return credentials.New(&credentials.Static{}), nil
+	}
+	static := &credentials.Static{Value: credentials.Value{
+		AccessKeyID:     cfg.AccessKey,
+		SecretAccessKey: cfg.SecretKey,
+		SessionToken:    cfg.Token,
+		SignerType:      credentials.SignatureV4,
+	}}
+	switch strings.ToLower(cfg.CredentialsSource) {
+	case "", CredentialsStatic:
+		return credentials.New(static), nil
+	case CredentialsIAM:
+		return credentials.NewIAM(""), nil
+	case CredentialsEnv:
+		return credentials.NewEnvAWS(), nil
+	case CredentialsChain:
+		var providers []credentials.Provider
+		if cfg.AccessKey != "" {
+			providers = append(providers, static)
+		}
+		providers = append(providers,
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		)
+		return credentials.NewChainCredentials(providers), nil
+	default:
+		return nil, fmt.Errorf("invalid s3 credentials source: %s", cfg.CredentialsSource)
+	}
+}
+
+// s3Transport returns minio's default transport with the TLS and timeout
+// settings of cfg applied.
+func s3Transport(cfg *S3Config) (*http.Transport, error) {
+	transport, err := minio.DefaultTransport(cfg.UseSSL)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.CACertPath != "" || cfg.InsecureSkipVerify {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		}
+		transport.TLSClientConfig.InsecureSkipVerify = cfg.InsecureSkipVerify
+	}
+	if cfg.CACertPath != "" {
+		pem, err := os.ReadFile(cfg.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("read ca certificates: %w", err)
+		}
+		pool := transport.TLSClientConfig.RootCAs
+		if pool == nil {
+			if pool, err = x509.SystemCertPool(); err != nil {
+				pool = x509.NewCertPool()
+			}
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no ca certificates found in %s", cfg.CACertPath)
+		}
+		transport.TLSClientConfig.RootCAs = pool
+	}
+	if cfg.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{
+			Timeout:   cfg.DialTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	return transport, nil
 }
 
-func (s *S3Store) UploadData(data []byte, key string) (err error) {
-	if s == nil {
+// s3BucketLookup maps S3Config.BucketLookup to the minio lookup type.
+func s3BucketLookup(lookup string) (minio.BucketLookupType, error) {
+	switch strings.ToLower(lookup) {
+	case "", "auto":
+		return minio.BucketLookupAuto, nil
+	case "path":
+		return minio.BucketLookupPath, nil
+	case "dns":
+		return minio.BucketLookupDNS, nil
+	default:
+		return minio.BucketLookupAuto, fmt.Errorf("invalid s3 bucket lookup: %s", lookup)
+	}
+}
+
+// configured reports whether the store was created by NewS3Store. The
+// methods of a nil or zero S3Store return S3NotConfigError instead of
+// panicking on the missing client.
+func (s *S3Store) configured() bool {
+	return s != nil && s.client != nil && s.cfg != nil
+}
+
+// writable returns ErrReadOnly for a store accessing its bucket anonymously.
+func (s *S3Store) writable() error {
+	if s.cfg.Anonymous {
+		return fmt.Errorf("s3 bucket %s is accessed anonymously: %w", s.cfg.Bucket, ErrReadOnly)
+	}
+	return nil
+}
+
+// Close closes the idle connections of the store's HTTP transport.
+// In-flight requests are not interrupted. A client passed with
+// WithHTTPClient is left alone, since it belongs to the caller.
+func (s *S3Store) Close() error {
+	if s == nil || s.transport == nil {
+		return nil
+	}
+	s.transport.CloseIdleConnections()
+	return nil
+}
+
+// HealthCheck checks that the bucket exists and is accessible with the
+// configured credentials.
+func (s *S3Store) HealthCheck(ctx context.Context) error {
+	if !s.configured() {
 		return S3NotConfigError
 	}
-	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
-	opts := minio.PutObjectOptions{}
+	ok, err := s.client.BucketExists(ctx, s.cfg.Bucket)
+	if err != nil {
+		return fmt.Errorf("check bucket %s: %w", s.cfg.Bucket, classifyS3Error(err))
+	}
+	if !ok {
+		return fmt.Errorf("bucket %s: %w", s.cfg.Bucket, ErrNotFound)
+	}
+	return nil
+}
 
-	info, err := s.client.PutObject(context.TODO(), s.cfg.Bucket, key, bytes.NewReader(data), int64(len(data)), opts)
+// createBucket creates the bucket in the configured region if it doesn't
+// exist.
+func (s *S3Store) createBucket(ctx context.Context) error {
+	ok, err := s.client.BucketExists(ctx, s.cfg.Bucket)
 	if err != nil {
-		return fmt.Errorf("upload data: %v", err)
+		return fmt.Errorf("check bucket %s: %w", s.cfg.Bucket, classifyS3Error(err))
+	}
+	if ok {
+		return nil
 	}
-	log.Debugw("uploaded data", "key", key, "size", info.Size, "took", time.Since(start))
+	err = s.client.MakeBucket(ctx, s.cfg.Bucket, minio.MakeBucketOptions{Region: s.cfg.Region})
+	if err != nil {
+		return fmt.Errorf("create bucket %s: %w", s.cfg.Bucket, classifyS3WriteError(err))
+	}
+	s.log.Infow("created bucket", "bucket", s.cfg.Bucket, "region", s.cfg.Region)
 	return nil
 }
 
-func (s *S3Store) Upload(file string, key string) (err error) {
-	if s == nil {
+// verifyWritable puts and deletes healthCheckKey to make sure the
+// credentials are allowed to write to and delete from the bucket.
+func (s *S3Store) verifyWritable(ctx context.Context) error {
+	_, err := s.client.PutObject(ctx, s.cfg.Bucket, healthCheckKey, bytes.NewReader([]byte("ok")), 2, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("verify bucket %s is writable: %w", s.cfg.Bucket, classifyS3WriteError(err))
+	}
+	err = s.client.RemoveObject(ctx, s.cfg.Bucket, healthCheckKey, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("verify bucket %s is deletable: %w", s.cfg.Bucket, classifyS3WriteError(err))
+	}
+	return nil
+}
+
+// classifyS3Error wraps authentication failures, permission failures and
+// missing keys with ErrAuthFailed, ErrPermissionDenied and ErrNotFound
+// respectively.
+func classifyS3Error(err error) error {
+	switch minio.ToErrorResponse(err).Code {
+	case "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken", "InvalidToken":
+		return fmt.Errorf("%w: %w", ErrAuthFailed, err)
+	case "AccessDenied":
+		return fmt.Errorf("%w: %w", ErrPermissionDenied, err)
+	case "NoSuchKey":
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	default:
+		return err
+	}
+}
+
+// classifyS3WriteError classifies the error of a write or a delete, which
+// are denied to credentials that can only read: those are ErrReadOnly.
+func classifyS3WriteError(err error) error {
+	if minio.ToErrorResponse(err).Code == "AccessDenied" {
+		return fmt.Errorf("%w: %w", ErrReadOnly, err)
+	}
+	return classifyS3Error(err)
+}
+
+func (s *S3Store) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	if !s.configured() {
 		return S3NotConfigError
 	}
+	if err := s.writable(); err != nil {
+		return err
+	}
 	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
-	opts := minio.PutObjectOptions{}
+	key = objectKey(key)
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	hasher.data(data)
+	putOpts, err := s.putOptions(key, int64(len(data)), o)
+	if err != nil {
+		return err
+	}
+	putOpts.UserMetadata = hasher.metadata()
 
-	info, err := s.client.FPutObject(context.TODO(), s.cfg.Bucket, key, file, opts)
+	var info minio.UploadInfo
+	err = s.retry.forUpload(o).Do(context.TODO(), func() (err error) {
+		putOpts.Progress = newProgressHook(int64(len(data)), o.Progress)
+		reader := s.limiter().reader(bytes.NewReader(data))
+		info, err = s.client.PutObject(context.TODO(), s.cfg.Bucket, key, reader, int64(len(data)), putOpts)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("upload file: %v", err)
+		return fmt.Errorf("upload data: %w", uploadError(err, o))
 	}
-	log.Debugw("uploaded file", "key", key, "file", file, "size", info.Size, "took", time.Since(start))
-	return nil
+	hasher.done()
+	s.log.Debugw("uploaded data", "key", key, "size", info.Size, "took", time.Since(start))
+	return s.waitVisible(key)
 }
 
-func (s *S3Store) UploadReader(reader io.Reader, size int64, key string) (err error) {
-	if s == nil {
+func (s *S3Store) Upload(file string, key string, opts ...UploadOption) (err error) {
+	if !s.configured() {
 		return S3NotConfigError
 	}
+	if err := s.writable(); err != nil {
+		return err
+	}
 	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
-	opts := minio.PutObjectOptions{}
+	key = objectKey(key)
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
+	if err != nil {
+		return err
+	}
+	total := int64(-1)
+	if fi, err := os.Stat(file); err == nil {
+		total = fi.Size()
+	}
+	putOpts, err := s.putOptions(key, total, o)
+	if err != nil {
+		return err
+	}
 
-	info, err := s.client.PutObject(context.TODO(), s.cfg.Bucket, key, reader, size, opts)
+	var info minio.UploadInfo
+	err = s.retry.forUpload(o).Do(context.TODO(), func() (err error) {
+		putOpts.Progress = newProgressHook(total, o.Progress)
+		if hasher == nil && s.rateLimit <= 0 {
+			info, err = s.client.FPutObject(context.TODO(), s.cfg.Bucket, key, file, putOpts)
+			return err
+		}
+		// stream the file through the hasher and the rate limiter instead
+		// of letting minio read it
+		hasher.reset()
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close() // nolint: errcheck
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		reader := s.limiter().reader(hasher.reader(f))
+		info, err = s.client.PutObject(context.TODO(), s.cfg.Bucket, key, reader, fi.Size(), putOpts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("upload file: %w", uploadError(err, o))
+	}
+	hasher.done()
+	s.log.Debugw("uploaded file", "key", key, "file", file, "size", info.Size, "took", time.Since(start))
+	return s.waitVisible(key)
+}
+
+// UploadReader uploads the content of reader. It isn't retried since the
+// reader is consumed by the first attempt.
+func (s *S3Store) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	key = objectKey(key)
+	o := NewUploadOptions(opts...)
+	hasher, err := o.newContentHasher()
 	if err != nil {
-		return fmt.Errorf("upload reader: %v", err)
+		return err
+	}
+
+	if size < 0 {
+		size = -1
+	}
+	putOpts, err := s.putOptions(key, size, o)
+	if err != nil {
+		return err
+	}
+	putOpts.Progress = newProgressHook(size, o.Progress)
+	reader = s.limiter().reader(hasher.reader(reader))
+	info, err := s.client.PutObject(context.TODO(), s.cfg.Bucket, key, reader, size, putOpts)
+	if err != nil {
+		return fmt.Errorf("upload reader: %w", uploadError(err, o))
+	}
+	hasher.done()
+	s.log.Debugw("uploaded reader", "key", key, "size", info.Size, "took", time.Since(start))
+	return s.waitVisible(key)
+}
+
+// putOptions returns the PutObject options of an upload of size bytes to
+// key, -1 if the size isn't known. IfMatch and IfNoneMatch are sent as
+// conditional PUT headers, and a create-only upload as If-None-Match: *. The
+// part size and concurrency of the upload override those of the
+// configuration.
+func (s *S3Store) putOptions(key string, size int64, o UploadOptions) (minio.PutObjectOptions, error) {
+	opts := minio.PutObjectOptions{
+		ContentType: s.contentType(key),
+		PartSize:    uint64(cmp.Or(o.PartSize, s.cfg.PartSize)),
+		NumThreads:  uint(cmp.Or(o.UploadConcurrency, s.cfg.UploadConcurrency)),
+	}
+	if err := validatePartSize(int64(opts.PartSize)); err != nil {
+		return opts, err
+	}
+	if size < 0 && opts.PartSize == 0 {
+		// minio would size the parts for the largest object S3 accepts,
+		// buffering over 500MiB per part
+		opts.PartSize = defaultStreamPartSize
+	}
+	// minio only sends the parts of a reader without ReadAt concurrently
+	// when asked to buffer them, which takes a part size to be bounded.
+	opts.ConcurrentStreamParts = opts.PartSize > 0 && opts.NumThreads > 1
+	if o.IfMatch != "" {
+		opts.SetMatchETag(o.IfMatch)
+	}
+	switch {
+	case o.IfNoneMatch != "":
+		opts.SetMatchETagExcept(o.IfNoneMatch)
+	case !o.Overwrite:
+		opts.SetMatchETagExcept("*")
+	}
+	if o.ExpiryDays < 0 {
+		return opts, fmt.Errorf("invalid expiry days: %d", o.ExpiryDays)
+	}
+	if o.ExpiryDays > 0 {
+		if err := s.ensureExpiryRule(o.ExpiryDays); err != nil {
+			return opts, err
+		}
+		opts.UserTags = map[string]string{expiryTagKey: strconv.Itoa(o.ExpiryDays)}
+	}
+	return opts, nil
+}
+
+// validatePartSize checks a multipart upload part size against the limits
+// of S3, 0 leaves it to minio.
+func validatePartSize(size int64) error {
+	if size != 0 && (size < minUploadPartSize || size > maxUploadPartSize) {
+		return fmt.Errorf("part size %d is not between %d and %d", size, minUploadPartSize, maxUploadPartSize)
 	}
-	log.Debugw("uploaded reader", "key", key, "size", info.Size, "took", time.Since(start))
 	return nil
 }
 
+// uploadError classifies an upload error. A failed IfMatch or IfNoneMatch
+// is ErrPreconditionFailed, while the precondition of a create-only upload
+// fails when the object exists. S3 answers If-Match on a missing object with
+// NoSuchKey.
+func uploadError(err error, o UploadOptions) error {
+	code := minio.ToErrorResponse(err).Code
+	switch {
+	case o.conditional() && code == "PreconditionFailed", o.IfMatch != "" && code == "NoSuchKey":
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	case !o.Overwrite && code == "PreconditionFailed":
+		return fmt.Errorf("%w: %w", ErrAlreadyExists, err)
+	}
+	return classifyS3WriteError(err)
+}
+
+// Publish uploads data with the content type, ACL and tags of opts in a
+// single PutObject request, which the server applies atomically.
+func (s *S3Store) Publish(key string, data []byte, opts PublishOptions) (err error) {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	key = objectKey(key)
+	putOpts := minio.PutObjectOptions{
+		ContentType: opts.ContentType,
+		UserTags:    opts.Tags,
+	}
+	if putOpts.ContentType == "" {
+		putOpts.ContentType = s.contentType(key)
+	}
+	if opts.ACL != "" {
+		putOpts.UserMetadata = map[string]string{"x-amz-acl": opts.ACL}
+	}
+
+	var info minio.UploadInfo
+	err = s.retry.Do(context.TODO(), func() (err error) {
+		info, err = s.client.PutObject(context.TODO(), s.cfg.Bucket, key, bytes.NewReader(data), int64(len(data)), putOpts)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("publish: %w", classifyS3WriteError(err))
+	}
+	s.log.Debugw("published", "key", key, "size", info.Size, "acl", opts.ACL, "took", time.Since(start))
+	return s.waitVisible(key)
+}
+
+// contentType returns the content type for the extension of key, looked up
+// in S3Config.ContentTypeByExt first and then in the mime package.
+func (s *S3Store) contentType(key string) string {
+	ext := strings.ToLower(path.Ext(key))
+	if ext == "" {
+		return ""
+	}
+	if ct, ok := s.cfg.ContentTypeByExt[ext]; ok {
+		return ct
+	}
+	if ct, ok := s.cfg.ContentTypeByExt[strings.TrimPrefix(ext, ".")]; ok {
+		return ct
+	}
+	return mime.TypeByExtension(ext)
+}
+
 // DeleteDirectory removes the directory from the s3 store.
 // This is a soft-delete operation, all files will be renamed to .
 func (s *S3Store) DeleteDirectory(dir string) (err error) {
-	if s == nil {
+	if !s.configured() {
 		return S3NotConfigError
 	}
+	if err := s.writable(); err != nil {
+		return err
+	}
 	start := time.Now()
-	dir = makeSureKeyAsDir(strings.TrimPrefix(dir, "/"))
+	dir = makeSureKeyAsDir(objectKey(dir))
 	opts := minio.ListObjectsOptions{
 		Recursive: true,
 		Prefix:    dir,
 	}
-	log.Debugw("delete directory", "dir", dir)
-	objectsCh := s.client.ListObjects(context.TODO(), s.cfg.Bucket, opts)
-	for obj := range objectsCh {
-		log.Debugw("delete object", "key", obj.Key, "size", obj.Size)
+	s.log.Debugw("delete directory", "dir", dir)
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		s.log.Debugw("delete object", "key", obj.Key, "size", obj.Size)
 		objStart := time.Now()
-		dest := minio.CopyDestOptions{
-			Bucket: s.cfg.Bucket,
-			Object: path.Join(recyclePath, obj.Key),
-		}
-		src := minio.CopySrcOptions{
-			Bucket: s.cfg.Bucket,
-			Object: obj.Key,
+		res, err := s.deleteObject(obj.Key, "")
+		if err != nil {
+			return err
 		}
+		s.log.Debugw("deleted object", "key", obj.Key, "size", res.Size, "recycled", res.Recycled, "took", time.Since(objStart))
+		return nil
+	})
+	if err != nil {
+		s.log.Errorf("delete object failed: %v", err)
+	}
+	s.log.Debugw("deleted directory", "key", dir, "took", time.Since(start))
+	return err
+}
 
-		info, copyErr := s.client.CopyObject(context.TODO(), dest, src)
-		if copyErr != nil {
-			err = copyErr
-			break
-		}
-		removeErr := s.client.RemoveObject(context.TODO(), src.Bucket, src.Object, minio.RemoveObjectOptions{})
-		if removeErr != nil {
-			err = removeErr
-			break
-		}
-		log.Debugw("deleted object", "key", obj.Key, "size", info.Size, "took", time.Since(objStart))
+// DeleteDirectoryPreview lists the keys DeleteDirectory(dir) would move to
+// the recycle bin, from the same listing.
+func (s *S3Store) DeleteDirectoryPreview(dir string) (keys []string, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
 	}
+	dir = makeSureKeyAsDir(objectKey(dir))
+	opts := minio.ListObjectsOptions{
+		Recursive: true,
+		Prefix:    dir,
+	}
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
 	if err != nil {
-		log.Errorf("delete object failed: %v", err)
-		// consume the rest
-		for range objectsCh {
-		}
+		return nil, err
 	}
-	log.Debugw("deleted directory", "key", dir, "took", time.Since(start))
-	return err
+	s.log.Debugw("previewed delete directory", "dir", dir, "count", len(keys))
+	return keys, nil
 }
 
 // Delete deletes the object.
-// This is soft-delete operation, file will be renamed to recyclePath.
+// This is soft-delete operation, file will be renamed to recyclePath. In a
+// bucket with versioning enabled, the object is removed without a recycle
+// copy instead, since its previous version keeps the data: see DeleteObject.
 func (s *S3Store) Delete(key string) (err error) {
-	if s == nil {
-		return S3NotConfigError
+	return s.DeleteWithReason(key, "")
+}
+
+// DeleteWithReason soft-deletes the object like Delete, recording reason on
+// the recycle copy. See ListRecycled.
+func (s *S3Store) DeleteWithReason(key string, reason string) (err error) {
+	_, err = s.DeleteObject(key, reason)
+	return err
+}
+
+// DeleteObject deletes the object like DeleteWithReason and reports how.
+//
+// Soft-delete and versioning would otherwise double up: the removal of the
+// original after the recycle copy only adds a delete marker, leaving the
+// data both in the recycle bin and in the previous version. So in a bucket
+// with versioning enabled, the object is removed without a recycle copy,
+// reason isn't recorded, and the version ID of the delete marker is
+// returned; removing the marker restores the object. Buckets with versioning
+// suspended are soft-deleted as usual, since their removals overwrite the
+// data. Versioning is checked on the first delete; a bucket whose
+// versioning can't be read is treated as unversioned.
+func (s *S3Store) DeleteObject(key string, reason string) (DeleteResult, error) {
+	if !s.configured() {
+		return DeleteResult{}, S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return DeleteResult{}, err
 	}
 	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 
-	dest := minio.CopyDestOptions{
-		Bucket: s.cfg.Bucket,
-		Object: path.Join(recyclePath, key),
+	res, err := s.deleteObject(key, reason)
+	if err != nil {
+		return res, err
 	}
+	s.log.Debugw("deleted object", "key", key, "reason", reason, "size", res.Size, "recycled", res.Recycled, "took", time.Since(start))
+	return res, nil
+}
+
+// deleteObject recycles the object, or removes it in a versioned bucket.
+func (s *S3Store) deleteObject(key string, reason string) (DeleteResult, error) {
+	if !s.isVersioned() {
+		info, err := s.recycle(key, reason)
+		if err != nil {
+			return DeleteResult{}, err
+		}
+		return DeleteResult{Recycled: true, Size: info.Size}, nil
+	}
+	stat, err := s.statObject(key)
+	if err != nil {
+		return DeleteResult{}, fmt.Errorf("stat object: %w", classifyS3Error(err))
+	}
+	versionID, err := s.removeVersioned(key)
+	if err != nil {
+		return DeleteResult{}, fmt.Errorf("remove object %s: %w", key, classifyS3WriteError(err))
+	}
+	return DeleteResult{Size: stat.Size, DeleteMarkerVersionID: versionID}, nil
+}
+
+// recycle copies the object into recyclePath and removes the original.
+// The recycle copy records the original key, the deletion time and the
+// reason in its metadata. If the removal fails, the recycle copy is deleted
+// again so the object isn't left in both places.
+func (s *S3Store) recycle(key string, reason string) (minio.UploadInfo, error) {
 	src := minio.CopySrcOptions{
 		Bucket: s.cfg.Bucket,
 		Object: key,
 	}
-	info, err := s.client.CopyObject(context.TODO(), dest, src)
+	stat, err := s.statObject(src.Object)
 	if err != nil {
-		return fmt.Errorf("copy object: %v", err)
+		return minio.UploadInfo{}, fmt.Errorf("stat object: %w", classifyS3Error(err))
 	}
-	err = s.client.RemoveObject(context.TODO(), src.Bucket, src.Object, minio.RemoveObjectOptions{})
-	log.Debugw("deleted object", "key", key, "size", info.Size, "took", time.Since(start))
-	return err
+	dest := minio.CopyDestOptions{
+		Bucket:          s.cfg.Bucket,
+		Object:          path.Join(recyclePath, key),
+		UserMetadata:    recycleMetadata(stat, key, reason),
+		ReplaceMetadata: true,
+	}
+	var info minio.UploadInfo
+	err = s.retry.Do(context.TODO(), func() (err error) {
+		info, err = s.client.CopyObject(context.TODO(), dest, src)
+		return err
+	})
+	if err != nil {
+		return info, fmt.Errorf("copy object: %w", classifyS3WriteError(err))
+	}
+	err = s.removeObject(src.Object)
+	if err != nil {
+		rollbackErr := s.removeObject(dest.Object)
+		if rollbackErr != nil {
+			return info, fmt.Errorf("remove object %s: %w; recycle copy %s left in place: %w", key, err, dest.Object, rollbackErr)
+		}
+		return info, fmt.Errorf("remove object %s: %w", key, err)
+	}
+	return info, nil
+}
+
+// Copy copies the object at src to dst server-side, keeping its metadata.
+// Objects over 5GiB are copied in parts.
+func (s *S3Store) Copy(src, dst string) error {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	start := time.Now()
+	src, dst = objectKey(src), objectKey(dst)
+	stat, err := s.statObject(src)
+	if err != nil {
+		return fmt.Errorf("stat object %s: %w", src, classifyS3Error(err))
+	}
+	srcOpts := minio.CopySrcOptions{
+		Bucket:    s.cfg.Bucket,
+		Object:    src,
+		MatchETag: stat.ETag,
+	}
+	dstOpts := minio.CopyDestOptions{
+		Bucket: s.cfg.Bucket,
+		Object: dst,
+	}
+	err = s.retry.Do(context.TODO(), func() (err error) {
+		if stat.Size > maxUploadPartSize {
+			// a single copy is limited to 5GiB like a single part
+			_, err = s.client.ComposeObject(context.TODO(), dstOpts, srcOpts)
+		} else {
+			_, err = s.client.CopyObject(context.TODO(), dstOpts, srcOpts)
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("copy object %s to %s: %w", srcOpts.Object, dstOpts.Object, classifyS3WriteError(err))
+	}
+	s.log.Debugw("copied object", "src", srcOpts.Object, "dst", dstOpts.Object, "took", time.Since(start))
+	return nil
 }
 
-// Exists checks if the object exists.
+// Exists checks if the object exists. Failures other than a missing object,
+// such as denied access, are returned rather than reported as absent.
 func (s *S3Store) Exists(key string) (bool, error) {
-	if s == nil {
+	if !s.configured() {
 		return false, S3NotConfigError
 	}
 	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
-	_, err := s.client.StatObject(context.TODO(), s.cfg.Bucket, key, minio.StatObjectOptions{})
+	key = objectKey(key)
+	_, err := s.statObject(key)
 	if err == nil {
-		log.Debugw("object exists", "key", key, "took", time.Since(start))
+		s.log.Debugw("object exists", "key", key, "took", time.Since(start))
 		return true, nil
 	}
-	log.Debugw("object not exists", "key", key, "took", time.Since(start))
+	if minio.ToErrorResponse(err).Code != "NoSuchKey" {
+		return false, fmt.Errorf("stat object: %w", classifyS3Error(err))
+	}
+	s.log.Debugw("object not exists", "key", key, "took", time.Since(start))
 	return false, nil
 }
 
 func (s *S3Store) Stat(key string) (FileStat, error) {
-	if s == nil {
+	if !s.configured() {
 		return FileStat{}, S3NotConfigError
 	}
 	start := time.Now()
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 
-	info, err := s.client.StatObject(context.TODO(), s.cfg.Bucket, key, minio.StatObjectOptions{})
+	info, err := s.statObject(key)
 	if err != nil {
-		return FileStat{}, fmt.Errorf("stat object: %v", err)
+		return FileStat{}, fmt.Errorf("stat object: %w", classifyS3Error(err))
 	}
-	log.Debugw("stat object", "key", key, "size", info.Size, "took", time.Since(start))
+	s.log.Debugw("stat object", "key", key, "size", info.Size, "took", time.Since(start))
 	return FileStat{
-		Size: info.Size,
+		Size:        info.Size,
+		ContentType: info.ContentType,
+		ETag:        info.ETag,
+		ModTime:     info.LastModified,
 	}, nil
 }
 
-func (s *S3Store) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
-	if s == nil {
+// GetMetadata returns the user metadata of the object, by name without the
+// X-Amz-Meta- prefix, along with the content headers S3 keeps with it, such
+// as Content-Type, Cache-Control and Content-Encoding.
+func (s *S3Store) GetMetadata(key string) (map[string]string, error) {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
+	key = objectKey(key)
+	info, err := s.statObject(key)
+	if err != nil {
+		return nil, fmt.Errorf("stat object: %w", classifyS3Error(err))
+	}
+	meta := make(map[string]string, len(info.Metadata))
+	for k, v := range info.Metadata {
+		if !strings.HasPrefix(k, "X-Amz-Meta-") {
+			meta[k] = strings.Join(v, ",")
+		}
+	}
+	for k, v := range info.UserMetadata {
+		meta[k] = v
+	}
+	return meta, nil
+}
+
+// Touch refreshes the LastModified of the object by copying it onto itself,
+// keeping its content type and user metadata. The copy is a single request,
+// so objects larger than 5 GiB can't be touched.
+func (s *S3Store) Touch(key string) error {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
 	start := time.Now()
-	obj, err := s.getObject(key, &offset, &size)
+	key = objectKey(key)
+	stat, err := s.statObject(key)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("stat object: %w", classifyS3Error(err))
 	}
+	src := minio.CopySrcOptions{
+		Bucket: s.cfg.Bucket,
+		Object: key,
+	}
+	// S3 refuses to copy an object onto itself unless its metadata is
+	// replaced, so the current metadata is sent again
+	dest := minio.CopyDestOptions{
+		Bucket:          s.cfg.Bucket,
+		Object:          key,
+		UserMetadata:    objectMetadata(stat),
+		ReplaceMetadata: true,
+	}
+	err = s.retry.Do(context.TODO(), func() error {
+		_, err := s.client.CopyObject(context.TODO(), dest, src)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("copy object: %w", classifyS3WriteError(err))
+	}
+	s.log.Debugw("touched object", "key", key, "took", time.Since(start))
+	return nil
+}
+
+func (s *S3Store) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
 	defer func() {
-		if err := obj.Close(); err != nil {
-			log.Errorf("close object failed: %v", err)
-		}
-		log.Debugw("downloaded object range", "key", key, "offset", offset, "size", size, "took", time.Since(start))
+		s.log.Debugw("downloaded object range", "key", key, "offset", offset, "size", size, "took", time.Since(start))
 	}()
-
-	return io.ReadAll(obj)
+	return s.readObject(key, &offset, &size)
 }
 
 func (s *S3Store) DownloadBytes(key string) ([]byte, error) {
-	if s == nil {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
 	start := time.Now()
-	obj, err := s.getObject(key, nil, nil)
-	if err != nil {
-		return nil, err
-	}
 	defer func() {
-		if err := obj.Close(); err != nil {
-			log.Errorf("close object failed: %v", err)
+		s.log.Debugw("downloaded object", "key", key, "took", time.Since(start))
+	}()
+	return s.readObject(key, nil, nil)
+}
+
+// readObject reads the object or a range of it into memory, retrying the
+// whole download on transient errors.
+func (s *S3Store) readObject(key string, offset *int64, size *int64) (data []byte, err error) {
+	err = s.retry.Do(context.TODO(), func() error {
+		obj, err := s.getObject(key, offset, size)
+		if err != nil {
+			return err
 		}
-		log.Debugw("downloaded object", "key", key, "took", time.Since(start))
+		defer func() {
+			if err := obj.Close(); err != nil {
+				s.log.Errorf("close object failed: %v", err)
+			}
+		}()
+		data, err = readAll(obj, s.maxDownload)
+		return err
+	})
+	return data, err
+}
+
+// DownloadBytesVerified downloads the object and checks it against the
+// checksum of the algorithm set with WithChecksumAlgorithm. The checksum
+// comes from the same response as the data, so it can't be from another
+// version of the object. A mismatch is retried like a transient error, as it
+// usually means the data was corrupted in transit.
+func (s *S3Store) DownloadBytesVerified(key string) (data []byte, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("downloaded verified bytes", "key", key, "size", len(data), "checksum", s.checksum, "took", time.Since(start))
 	}()
+	key = objectKey(key)
+	retry := s.retry
+	retry.Retryable = func(err error) bool {
+		return errors.Is(err, ErrChecksumMismatch) || IsRetryable(err)
+	}
+	err = retry.Do(context.TODO(), func() error {
+		obj, err := s.openObject(key, minio.GetObjectOptions{})
+		if err != nil {
+			return err
+		}
+		defer obj.Close() // nolint: errcheck
+		info, err := obj.Stat()
+		if err != nil {
+			return classifyS3Error(err)
+		}
+		sum, ok := s.checksum.s3Checksum(info)
+		if !ok {
+			return fmt.Errorf("object %s has no %s checksum to verify", key, s.checksum)
+		}
+		data, err = readAll(obj, s.maxDownload)
+		if err != nil {
+			return err
+		}
+		return s.checksum.verifyChecksum(data, sum)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("download verified %s: %w", key, err)
+	}
+	return data, nil
+}
 
-	return io.ReadAll(obj)
+// DownloadToFile downloads the object with FGetObject, which writes it to a
+// temporary file renamed to localPath once complete. With a rate limit, the
+// object is copied through the limiter instead.
+func (s *S3Store) DownloadToFile(key, localPath string) error {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if s.rateLimit > 0 {
+		return downloadToFile(s, key, localPath)
+	}
+	start := time.Now()
+	key = objectKey(key)
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	err := s.retry.Do(context.TODO(), func() error {
+		return s.client.FGetObject(context.TODO(), s.cfg.Bucket, key, localPath, minio.GetObjectOptions{})
+	})
+	if err != nil {
+		return fmt.Errorf("download %s to file: %w", key, classifyS3Error(err))
+	}
+	s.log.Debugw("downloaded to file", "key", key, "file", localPath, "took", time.Since(start))
+	return nil
+}
+
+// objectMetadata returns the content type and user metadata of an object
+// in the form CopyDestOptions.UserMetadata takes them, for copies that
+// replace the metadata.
+func objectMetadata(stat minio.ObjectInfo) map[string]string {
+	meta := map[string]string{}
+	for k, v := range stat.UserMetadata {
+		meta[k] = v
+	}
+	if stat.ContentType != "" {
+		meta["Content-Type"] = stat.ContentType
+	}
+	return meta
+}
+
+func (s *S3Store) statObject(key string) (info minio.ObjectInfo, err error) {
+	err = s.retry.Do(context.TODO(), func() (err error) {
+		info, err = s.client.StatObject(context.TODO(), s.cfg.Bucket, key, minio.StatObjectOptions{})
+		return err
+	})
+	return info, err
+}
+
+// waitVisible polls the object after an upload until it's visible or
+// S3Config.ConsistencyTimeout elapses.
+func (s *S3Store) waitVisible(key string) error {
+	if s.cfg.ConsistencyTimeout <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.TODO(), s.cfg.ConsistencyTimeout)
+	defer cancel()
+	backoff := consistencyPollInterval
+	for {
+		_, err := s.client.StatObject(ctx, s.cfg.Bucket, key, minio.StatObjectOptions{})
+		if err == nil {
+			return nil
+		}
+		if minio.ToErrorResponse(err).Code != "NoSuchKey" && ctx.Err() == nil {
+			return fmt.Errorf("wait for %s to be visible: %w", key, err)
+		}
+		if sleepContext(ctx, backoff) != nil {
+			return fmt.Errorf("object %s not visible after %s", key, s.cfg.ConsistencyTimeout)
+		}
+		backoff = min(2*backoff, maxConsistencyPollInterval)
+	}
+}
+
+func (s *S3Store) removeObject(key string) error {
+	return s.retry.Do(context.TODO(), func() error {
+		return s.client.RemoveObject(context.TODO(), s.cfg.Bucket, key, minio.RemoveObjectOptions{})
+	})
 }
 
 func (s *S3Store) DownloadReader(key string) (io.ReadCloser, error) {
-	if s == nil {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
 	start := time.Now()
 	defer func() {
-		log.Debugw("downloaded reader", "key", key, "took", time.Since(start))
+		s.log.Debugw("downloaded reader", "key", key, "took", time.Since(start))
 	}()
 	return s.getObject(key, nil, nil)
 }
 
+// DownloadReaderIf sends cond as If-None-Match and If-Modified-Since
+// headers. The request is sent right away rather than on the first read, so
+// an unchanged object is reported here.
+func (s *S3Store) DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("downloaded reader if changed", "key", key, "took", time.Since(start))
+	}()
+	opts := minio.GetObjectOptions{}
+	if cond.IfNoneMatch != "" {
+		if err := opts.SetMatchETagExcept(cond.IfNoneMatch); err != nil {
+			return nil, err
+		}
+	} else if !cond.IfModifiedSince.IsZero() {
+		if err := opts.SetModified(cond.IfModifiedSince); err != nil {
+			return nil, err
+		}
+	}
+	obj, err := s.openObject(key, opts)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		_ = obj.Close()
+		if minio.ToErrorResponse(err).StatusCode == http.StatusNotModified {
+			return nil, fmt.Errorf("object %s: %w", key, ErrNotModified)
+		}
+		return nil, classifyS3Error(err)
+	}
+	return obj, nil
+}
+
 func (s *S3Store) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
-	if s == nil {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
 	start := time.Now()
 	defer func() {
-		log.Debugw("downloaded range reader", "key", key, "offset", offset, "size", size, "took", time.Since(start))
+		s.log.Debugw("downloaded range reader", "key", key, "offset", offset, "size", size, "took", time.Since(start))
 	}()
 	return s.getObject(key, &offset, &size)
 }
 
-func (s *S3Store) ListPrefix(key string) (keys []string, err error) {
-	if s == nil {
+func (s *S3Store) ListPrefix(key string) ([]string, error) {
+	return s.ListPrefixContext(context.TODO(), key)
+}
+
+// ListPrefixContext lists the keys under key like ListPrefix, stopping the
+// listing as soon as ctx is done.
+func (s *S3Store) ListPrefixContext(ctx context.Context, key string) (keys []string, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("listed prefix", "key", key, "count", len(keys), "took", time.Since(start))
+	}()
+	err = s.WalkPrefix(ctx, key, func(obj ObjectStat) error {
+		keys = append(keys, obj.Key)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// IsEmpty reports whether there are no objects under key. It stops at the
+// first object listed.
+func (s *S3Store) IsEmpty(key string) (bool, error) {
+	if !s.configured() {
+		return false, S3NotConfigError
+	}
+	opts := minio.ListObjectsOptions{
+		Prefix:    objectKey(key),
+		Recursive: true,
+		MaxKeys:   1,
+	}
+	empty := true
+	err := s.listObjects(context.TODO(), opts, func(minio.ObjectInfo) error {
+		empty = false
+		return SkipRemaining
+	})
+	if err != nil {
+		return false, err
+	}
+	return empty, nil
+}
+
+// ListPrefixStat lists the objects under key with the size, ETag and
+// modification time from the listing.
+func (s *S3Store) ListPrefixStat(key string) (objects []ObjectStat, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("listed prefix stat", "key", key, "count", len(objects), "took", time.Since(start))
+	}()
+	err = s.WalkPrefix(context.TODO(), key, func(obj ObjectStat) error {
+		objects = append(objects, obj)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// WalkPrefix calls fn for each object under key as it's listed. The listing
+// is stopped when fn returns an error or ctx is done.
+func (s *S3Store) WalkPrefix(ctx context.Context, key string, fn WalkFunc) error {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	opts := minio.ListObjectsOptions{
+		Prefix:    objectKey(key),
+		Recursive: true,
+	}
+	err := s.listObjects(ctx, opts, func(obj minio.ObjectInfo) error {
+		// the listing may still deliver the objects it already fetched
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		return fn(ObjectStat{
+			Key: obj.Key,
+			FileStat: FileStat{
+				Size:        obj.Size,
+				ContentType: obj.ContentType,
+				ETag:        obj.ETag,
+				ModTime:     obj.LastModified,
+			},
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// PrefixUsage sums up the sizes of the objects under key as they are
+// listed, without keeping the listing.
+func (s *S3Store) PrefixUsage(key string) (totalBytes int64, count int64, err error) {
+	if !s.configured() {
+		return 0, 0, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("prefix usage", "key", key, "bytes", totalBytes, "count", count, "took", time.Since(start))
+	}()
+	opts := minio.ListObjectsOptions{
+		Prefix:    objectKey(key),
+		Recursive: true,
+	}
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		totalBytes += obj.Size
+		count++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return totalBytes, count, nil
+}
+
+// ListPrefixDepth lists key level by level with delimiter listings, down
+// to maxDepth levels, so deeper objects are never listed.
+func (s *S3Store) ListPrefixDepth(key string, maxDepth int) (keys []string, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	if err := checkListDepth(maxDepth); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("listed prefix depth", "key", key, "depth", maxDepth, "took", time.Since(start))
+	}()
+	key = objectKey(key)
+	if key != "" {
+		key = makeSureKeyAsDir(key)
+	}
+	level := []string{key}
+	for depth := 1; depth <= maxDepth && len(level) > 0; depth++ {
+		var next []string
+		for _, prefix := range level {
+			opts := minio.ListObjectsOptions{Prefix: prefix}
+			err := s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+				if !strings.HasSuffix(obj.Key, "/") || depth == maxDepth {
+					keys = append(keys, obj.Key)
+				} else {
+					next = append(next, obj.Key)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		level = next
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// ListRollup streams the full listing under key and rolls up the objects
+// deeper than depth under their common prefix at that depth.
+func (s *S3Store) ListRollup(key string, depth int) ([]RollupEntry, error) {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
 	start := time.Now()
 	defer func() {
-		log.Debugw("listed prefix", "key", key, "took", time.Since(start))
+		s.log.Debugw("listed rollup", "key", key, "depth", depth, "took", time.Since(start))
 	}()
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
+	r, err := newRollup(key, depth)
+	if err != nil {
+		return nil, err
+	}
 	opts := minio.ListObjectsOptions{
 		Prefix:    key,
 		Recursive: true,
 	}
-	for obj := range s.client.ListObjects(context.TODO(), s.cfg.Bucket, opts) {
-		keys = append(keys, obj.Key)
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		r.add(obj.Key, obj.Size)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r.result(), nil
+}
+
+// listObjects calls fn for each object listed with opts, as they are listed.
+// It stops at the first error of the listing or of fn, which can return
+// SkipRemaining to stop without an error, or when ctx is done. The listing
+// is then cancelled and drained, since minio-go blocks sending the
+// cancellation until the channel is read.
+func (s *S3Store) listObjects(ctx context.Context, opts minio.ListObjectsOptions, fn func(obj minio.ObjectInfo) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	objectsCh := s.client.ListObjects(ctx, s.cfg.Bucket, opts)
+	defer func() {
+		cancel()
+		for range objectsCh {
+		}
+	}()
+	for obj := range objectsCh {
+		if obj.Err != nil {
+			return fmt.Errorf("list objects: %w", classifyS3Error(obj.Err))
+		}
+		if err := fn(obj); err != nil {
+			return walkResult(err)
+		}
 	}
-	return
+	return nil
 }
 
-func (s *S3Store) getObject(key string, offset *int64, size *int64) (*minio.Object, error) {
-	if s == nil {
+// getObject opens the object, optionally limited to a byte range.
+// A range running past the end of the object is clamped to the object size,
+// and a range starting at or after the end yields no bytes. A negative size
+// reads from offset to the end of the object.
+func (s *S3Store) getObject(key string, offset *int64, size *int64) (io.ReadCloser, error) {
+	if !s.configured() {
 		return nil, S3NotConfigError
 	}
-	key = strings.TrimPrefix(key, "/")
+	key = objectKey(key)
 	opts := minio.GetObjectOptions{}
 	if offset != nil || size != nil {
-		var start, end int64
+		var start int64
 		if offset != nil {
 			start = *offset
 		}
-		if size != nil {
-			end = start + *size - 1
-		}
-		if err := opts.SetRange(start, end); err != nil {
-			return nil, fmt.Errorf("set range: %v", err)
+		switch {
+		case size != nil && *size == 0:
+			return io.NopCloser(bytes.NewReader(nil)), nil
+		case size != nil && *size > 0:
+			if err := opts.SetRange(start, start+*size-1); err != nil {
+				return nil, fmt.Errorf("set range: %w", err)
+			}
+		case start != 0:
+			// SetRange(start, 0) reads from start to the end, no range at
+			// all reads the whole object.
+			if err := opts.SetRange(start, 0); err != nil {
+				return nil, fmt.Errorf("set range: %w", err)
+			}
 		}
 	}
-	return s.client.GetObject(context.TODO(), s.cfg.Bucket, key, opts)
+	return s.openObject(key, opts)
 }
 
-var _ Interface = &S3Store{}
+// limiter returns the rate limiter of a transfer, nil without a rate limit.
+func (s *S3Store) limiter() *rateLimiter {
+	return newRateLimiter(s.rateLimit)
+}
+
+func (s *S3Store) openObject(key string, opts minio.GetObjectOptions) (*s3Object, error) {
+	key = objectKey(key)
+	var obj *minio.Object
+	err := s.retry.Do(context.TODO(), func() (err error) {
+		obj, err = s.client.GetObject(context.TODO(), s.cfg.Bucket, key, opts)
+		return err
+	})
+	if err != nil {
+		return nil, classifyS3Error(err)
+	}
+	return &s3Object{obj, s.limiter()}, nil
+}
+
+// s3Object reports a range starting past the end of the object as an empty
+// read instead of an InvalidRange error. Since minio.Object sends the
+// request lazily, errors like a missing key are classified here too. Reads
+// are limited by the store's rate limit, if any.
+type s3Object struct {
+	*minio.Object
+	limiter *rateLimiter
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	n, err := o.Object.Read(o.limiter.limit(p))
+	o.limiter.wait(n)
+	if err != nil && minio.ToErrorResponse(err).Code == "InvalidRange" {
+		return n, io.EOF
+	}
+	return n, classifyS3Error(err)
+}
+
+var (
+	_ Interface             = &S3Store{}
+	_ RollupLister          = &S3Store{}
+	_ DepthLister           = &S3Store{}
+	_ Publisher             = &S3Store{}
+	_ io.Closer             = &S3Store{}
+	_ HealthChecker         = &S3Store{}
+	_ ConditionalDownloader = &S3Store{}
+	_ VerifiedDownloader    = &S3Store{}
+	_ FileDownloader        = &S3Store{}
+	_ StatLister            = &S3Store{}
+	_ Walker                = &S3Store{}
+	_ ContextLister         = &S3Store{}
+	_ UsageReporter         = &S3Store{}
+	_ Toucher               = &S3Store{}
+	_ ParallelDownloader    = &S3Store{}
+	_ DeletePreviewer       = &S3Store{}
+	_ EmptyChecker          = &S3Store{}
+	_ Copier                = &S3Store{}
+	_ MetadataGetter        = &S3Store{}
+)
 
 func makeSureKeyAsDir(key string) string {
 	if strings.HasSuffix(key, "/") {
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+)
+
+// expiryTagKey is the object tag holding the ExpiryDays of an upload, which
+// the lifecycle rule of the bucket for those days filters on.
+const expiryTagKey = "store-expiry-days"
+
+// expiryRuleID returns the ID of the lifecycle rule expiring the objects
+// uploaded with ExpiryDays(days).
+func expiryRuleID(days int) string {
+	return fmt.Sprintf("store-expire-%dd", days)
+}
+
+// ensureExpiryRule adds the lifecycle rule expiring the objects tagged with
+// days to the bucket, keeping its other rules. The bucket is checked once
+// per store and number of days. Adding the rule reads and writes the whole
+// lifecycle configuration, so stores adding rules to the same bucket at the
+// same time may drop each other's.
+func (s *S3Store) ensureExpiryRule(days int) error {
+	s.lifecycleLk.Lock()
+	defer s.lifecycleLk.Unlock()
+	if s.expiryRules[days] {
+		return nil
+	}
+	ctx := context.TODO()
+	cfg, err := s.client.GetBucketLifecycle(ctx, s.cfg.Bucket)
+	if minio.ToErrorResponse(err).Code == "NoSuchLifecycleConfiguration" {
+		cfg, err = lifecycle.NewConfiguration(), nil
+	}
+	if err != nil {
+		return fmt.Errorf("get bucket lifecycle: %w", classifyS3Error(err))
+	}
+	id := expiryRuleID(days)
+	found := false
+	for _, rule := range cfg.Rules {
+		if rule.ID == id {
+			found = true
+			break
+		}
+	}
+	if !found {
+		cfg.Rules = append(cfg.Rules, lifecycle.Rule{
+			ID:     id,
+			Status: "Enabled",
+			RuleFilter: lifecycle.Filter{
+				Tag: lifecycle.Tag{Key: expiryTagKey, Value: strconv.Itoa(days)},
+			},
+			Expiration: lifecycle.Expiration{Days: lifecycle.ExpirationDays(days)},
+		})
+		if err := s.client.SetBucketLifecycle(ctx, s.cfg.Bucket, cfg); err != nil {
+			return fmt.Errorf("set bucket lifecycle: %w", classifyS3WriteError(err))
+		}
+		s.log.Infow("added expiry lifecycle rule", "bucket", s.cfg.Bucket, "rule", id)
+	}
+	if s.expiryRules == nil {
+		s.expiryRules = map[int]bool{}
+	}
+	s.expiryRules[days] = true
+	return nil
+}
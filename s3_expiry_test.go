@@ -0,0 +1,59 @@
+package store
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/minio/minio-go/v7/pkg/lifecycle"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Store_ExpiryDays(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	ctx := context.Background()
+	existing := lifecycle.NewConfiguration()
+	existing.Rules = []lifecycle.Rule{{
+		ID:         "logs",
+		Status:     "Enabled",
+		RuleFilter: lifecycle.Filter{Prefix: "logs/"},
+		Expiration: lifecycle.Expiration{Days: 30},
+	}}
+	assert.NoError(t, store.client.SetBucketLifecycle(ctx, "test-bucket", existing))
+
+	assert.NoError(t, store.UploadData([]byte("tmp"), "tmp/a", ExpiryDays(3)))
+	assert.NoError(t, store.UploadReader(strings.NewReader("tmp"), 3, "tmp/b", ExpiryDays(3)))
+	assert.NoError(t, store.UploadData([]byte("tmp"), "tmp/c", ExpiryDays(7)))
+	assert.NoError(t, store.UploadData([]byte("kept"), "kept"))
+
+	obj, _ := fake.get("test-bucket", "tmp/a")
+	assert.Equal(t, "store-expiry-days=3", obj.tags)
+	obj, _ = fake.get("test-bucket", "tmp/b")
+	assert.Equal(t, "store-expiry-days=3", obj.tags)
+	obj, _ = fake.get("test-bucket", "kept")
+	assert.Empty(t, obj.tags)
+
+	cfg, err := store.client.GetBucketLifecycle(ctx, "test-bucket")
+	assert.NoError(t, err)
+	rules := map[string]lifecycle.Rule{}
+	for _, rule := range cfg.Rules {
+		rules[rule.ID] = rule
+	}
+	assert.Len(t, rules, 3)
+	assert.Contains(t, rules, "logs", "the other rules are kept")
+	assert.Equal(t, expiryTagKey, rules["store-expire-3d"].RuleFilter.Tag.Key)
+	assert.Equal(t, "3", rules["store-expire-3d"].RuleFilter.Tag.Value)
+	assert.Equal(t, lifecycle.ExpirationDays(3), rules["store-expire-3d"].Expiration.Days)
+	assert.Equal(t, lifecycle.ExpirationDays(7), rules["store-expire-7d"].Expiration.Days)
+
+	puts := 0
+	for _, req := range fake.requests {
+		if req == "PUT /test-bucket/" {
+			puts++
+		}
+	}
+	assert.Equal(t, 3, puts, "each rule is added once, after the existing one")
+
+	err = store.UploadData([]byte("tmp"), "tmp/d", ExpiryDays(-1))
+	assert.Error(t, err)
+}
@@ -0,0 +1,655 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeS3Object is an object held by fakeS3.
+type fakeS3Object struct {
+	data         []byte
+	etag         string
+	contentType  string
+	lastModified time.Time
+	metadata     map[string]string
+	tags         string
+	acl          string
+}
+
+// fakeS3 is a minimal in-memory S3 server covering the subset of the API
+// used by S3Store. It lets S3Store tests run without a MinIO instance and
+// allows injecting failures per request.
+type fakeS3 struct {
+	lk      sync.Mutex
+	buckets map[string]map[string]*fakeS3Object
+	// hook, if set, is called before a request is served. Returning a
+	// non-zero status makes the server respond with that error instead.
+	hook     func(r *http.Request) (status int, code string)
+	requests []string
+	uploads  map[string]map[int][]byte
+	// versioned holds the buckets with versioning enabled, where deletes
+	// report a delete marker.
+	versioned map[string]bool
+	markers   int
+	// lifecycles holds the lifecycle configuration documents by bucket.
+	lifecycles map[string][]byte
+
+	server *httptest.Server
+}
+
+func newFakeS3(t testing.TB, buckets ...string) *fakeS3 {
+	f := &fakeS3{
+		buckets:    map[string]map[string]*fakeS3Object{},
+		uploads:    map[string]map[int][]byte{},
+		versioned:  map[string]bool{},
+		lifecycles: map[string][]byte{},
+	}
+	for _, b := range buckets {
+		f.buckets[b] = map[string]*fakeS3Object{}
+	}
+	f.server = httptest.NewServer(f)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// newFakeS3Store creates an S3Store backed by a fake server with a single
+// "test-bucket" bucket.
+func newFakeS3Store(t testing.TB) (*S3Store, *fakeS3) {
+	f := newFakeS3(t, "test-bucket")
+	st, err := NewS3Store(f.config("test-bucket"))
+	if err != nil {
+		t.Fatalf("failed to create S3Store: %v", err)
+	}
+	return st.(*S3Store), f
+}
+
+func (f *fakeS3) config(bucket string) *S3Config {
+	return &S3Config{
+		Endpoint:  strings.TrimPrefix(f.server.URL, "http://"),
+		Region:    "us-east-1",
+		Bucket:    bucket,
+		AccessKey: "minioadmin",
+		SecretKey: "minioadmin",
+	}
+}
+
+func (f *fakeS3) setHook(hook func(r *http.Request) (int, string)) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.hook = hook
+}
+
+// setVersioning enables or suspends versioning on a bucket.
+func (f *fakeS3) setVersioning(bucket string, enabled bool) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.versioned[bucket] = enabled
+}
+
+// put stores an object directly, bypassing the HTTP API.
+func (f *fakeS3) put(bucket, key string, data []byte) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.buckets[bucket][key] = newFakeS3Object(data)
+}
+
+// get returns an object directly, bypassing the HTTP API.
+func (f *fakeS3) get(bucket, key string) (*fakeS3Object, bool) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	obj, ok := f.buckets[bucket][key]
+	return obj, ok
+}
+
+// pendingUploads returns the number of multipart uploads neither completed
+// nor aborted.
+func (f *fakeS3) pendingUploads() int {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	return len(f.uploads)
+}
+
+// setModified sets the modification time of an object.
+func (f *fakeS3) setModified(bucket, key string, t time.Time) {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	f.buckets[bucket][key].lastModified = t.UTC().Truncate(time.Second)
+}
+
+// keys returns the sorted object keys of a bucket.
+func (f *fakeS3) keys(bucket string) []string {
+	f.lk.Lock()
+	defer f.lk.Unlock()
+	var keys []string
+	for k := range f.buckets[bucket] {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func newFakeS3Object(data []byte) *fakeS3Object {
+	sum := md5.Sum(data)
+	return &fakeS3Object{
+		data:         data,
+		etag:         hex.EncodeToString(sum[:]),
+		lastModified: time.Now().UTC().Truncate(time.Second),
+		metadata:     map[string]string{},
+	}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lk.Lock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+	hook := f.hook
+	f.lk.Unlock()
+	if hook != nil {
+		if status, code := hook(r); status != 0 {
+			writeFakeS3Error(w, r, status, code)
+			return
+		}
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+	bucket := parts[0]
+	key := ""
+	if len(parts) == 2 {
+		key = parts[1]
+	}
+	q := r.URL.Query()
+
+	f.lk.Lock()
+	defer f.lk.Unlock()
+
+	if key == "" {
+		f.serveBucket(w, r, bucket, q)
+		return
+	}
+	objects, ok := f.buckets[bucket]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	if q.Has("uploads") || q.Has("uploadId") {
+		f.serveMultipart(w, r, objects, bucket, key, q)
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		if src := r.Header.Get("X-Amz-Copy-Source"); src != "" {
+			f.copyObject(w, r, objects, key, src)
+			return
+		}
+		data, err := readFakeS3Body(r)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		if m := r.Header.Get("If-None-Match"); m != "" && objects[key] != nil && (m == "*" || strings.Trim(m, `"`) == objects[key].etag) {
+			writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		if m := r.Header.Get("If-Match"); m != "" && (objects[key] == nil || strings.Trim(m, `"`) != objects[key].etag) {
+			writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+			return
+		}
+		obj := newFakeS3Object(data)
+		applyFakeS3Headers(obj, r.Header)
+		objects[key] = obj
+		w.Header().Set("ETag", `"`+obj.etag+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := objects[key]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		f.serveObject(w, r, obj)
+	case http.MethodDelete:
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) serveMultipart(w http.ResponseWriter, r *http.Request, objects map[string]*fakeS3Object, bucket, key string, q url.Values) {
+	if q.Has("uploads") {
+		uploadID := fmt.Sprintf("upload-%d", len(f.uploads)+1)
+		f.uploads[uploadID] = map[int][]byte{}
+		writeFakeS3XML(w, struct {
+			XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+			Bucket   string
+			Key      string
+			UploadId string
+		}{Bucket: bucket, Key: key, UploadId: uploadID})
+		return
+	}
+	uploadID := q.Get("uploadId")
+	parts, ok := f.uploads[uploadID]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchUpload")
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		partNumber, _ := strconv.Atoi(q.Get("partNumber"))
+		// Serve the part body without holding the lock so parts really
+		// are uploaded concurrently.
+		f.lk.Unlock()
+		data, err := readFakeS3Body(r)
+		f.lk.Lock()
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		parts[partNumber] = data
+		w.Header().Set("ETag", `"`+newFakeS3Object(data).etag+`"`)
+		w.WriteHeader(http.StatusOK)
+	case http.MethodPost:
+		var req struct {
+			Parts []struct {
+				PartNumber int
+				ETag       string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+			return
+		}
+		var data []byte
+		for i, part := range req.Parts {
+			if i > 0 && part.PartNumber <= req.Parts[i-1].PartNumber {
+				writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidPartOrder")
+				return
+			}
+			body, ok := parts[part.PartNumber]
+			if !ok || strings.Trim(part.ETag, `"`) != newFakeS3Object(body).etag {
+				writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, body...)
+		}
+		delete(f.uploads, uploadID)
+		obj := newFakeS3Object(data)
+		objects[key] = obj
+		writeFakeS3XML(w, struct {
+			XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+			Bucket  string
+			Key     string
+			ETag    string
+		}{Bucket: bucket, Key: key, ETag: `"` + obj.etag + `"`})
+	case http.MethodDelete:
+		delete(f.uploads, uploadID)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeFakeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func (f *fakeS3) serveBucket(w http.ResponseWriter, r *http.Request, bucket string, q url.Values) {
+	objects, ok := f.buckets[bucket]
+	switch {
+	case ok && q.Has("lifecycle"):
+		f.serveLifecycle(w, r, bucket)
+		return
+	case r.Method == http.MethodPut:
+		if !ok {
+			f.buckets[bucket] = map[string]*fakeS3Object{}
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	case !ok:
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchBucket")
+		return
+	case q.Has("location"):
+		writeFakeS3XML(w, struct {
+			XMLName xml.Name `xml:"LocationConstraint"`
+			Value   string   `xml:",chardata"`
+		}{Value: "us-east-1"})
+		return
+	case q.Has("versioning"):
+		status := ""
+		if f.versioned[bucket] {
+			status = "Enabled"
+		}
+		writeFakeS3XML(w, struct {
+			XMLName xml.Name `xml:"VersioningConfiguration"`
+			Status  string   `xml:",omitempty"`
+		}{Status: status})
+		return
+	case r.Method == http.MethodPost && q.Has("delete"):
+		f.deleteObjects(w, r, bucket, objects)
+		return
+	case r.Method == http.MethodHead:
+		w.WriteHeader(http.StatusOK)
+		return
+	case r.Method == http.MethodGet:
+		f.listObjects(w, objects, q)
+		return
+	}
+	writeFakeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+}
+
+func (f *fakeS3) serveLifecycle(w http.ResponseWriter, r *http.Request, bucket string) {
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeFakeS3Error(w, r, http.StatusBadRequest, "IncompleteBody")
+			return
+		}
+		f.lifecycles[bucket] = data
+		w.WriteHeader(http.StatusOK)
+	case http.MethodGet:
+		data, ok := f.lifecycles[bucket]
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchLifecycleConfiguration")
+			return
+		}
+		w.Header().Set("Content-Type", "application/xml")
+		_, _ = w.Write(data)
+	default:
+		writeFakeS3Error(w, r, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+// deleteObjects serves a multi-object delete. In a versioned bucket, each
+// deletion reports a new delete marker.
+func (f *fakeS3) deleteObjects(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]*fakeS3Object) {
+	var req struct {
+		Objects []struct {
+			Key string
+		} `xml:"Object"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "MalformedXML")
+		return
+	}
+	type deleted struct {
+		Key                   string
+		DeleteMarker          bool   `xml:",omitempty"`
+		DeleteMarkerVersionID string `xml:"DeleteMarkerVersionId,omitempty"`
+	}
+	var res struct {
+		XMLName xml.Name  `xml:"DeleteResult"`
+		Deleted []deleted `xml:"Deleted"`
+	}
+	for _, obj := range req.Objects {
+		delete(objects, obj.Key)
+		d := deleted{Key: obj.Key}
+		if f.versioned[bucket] {
+			f.markers++
+			d.DeleteMarker = true
+			d.DeleteMarkerVersionID = fmt.Sprintf("marker-%d", f.markers)
+		}
+		res.Deleted = append(res.Deleted, d)
+	}
+	writeFakeS3XML(w, res)
+}
+
+func (f *fakeS3) listObjects(w http.ResponseWriter, objects map[string]*fakeS3Object, q url.Values) {
+	type content struct {
+		Key          string
+		LastModified string
+		ETag         string
+		Size         int64
+	}
+	type commonPrefix struct {
+		Prefix string
+	}
+	type result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Name                  string
+		Prefix                string
+		KeyCount              int
+		MaxKeys               int
+		Delimiter             string
+		IsTruncated           bool
+		NextContinuationToken string
+		Contents              []content
+		CommonPrefixes        []commonPrefix
+	}
+
+	prefix, delimiter := q.Get("prefix"), q.Get("delimiter")
+	after := q.Get("continuation-token")
+	if after == "" {
+		after = q.Get("start-after")
+	}
+	maxKeys := 1000
+	if v, err := strconv.Atoi(q.Get("max-keys")); err == nil && v > 0 {
+		maxKeys = v
+	}
+
+	var keys []string
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	res := result{Prefix: prefix, Delimiter: delimiter, MaxKeys: maxKeys}
+	seen := map[string]bool{}
+	for _, k := range keys {
+		if k <= after {
+			continue
+		}
+		if res.KeyCount == maxKeys {
+			res.IsTruncated = true
+			break
+		}
+		if delimiter != "" {
+			if i := strings.Index(k[len(prefix):], delimiter); i >= 0 {
+				cp := k[:len(prefix)+i+len(delimiter)]
+				if !seen[cp] {
+					seen[cp] = true
+					res.CommonPrefixes = append(res.CommonPrefixes, commonPrefix{cp})
+					res.KeyCount++
+					res.NextContinuationToken = cp + "\xff"
+				}
+				continue
+			}
+		}
+		obj := objects[k]
+		res.Contents = append(res.Contents, content{
+			Key:          k,
+			LastModified: obj.lastModified.Format(time.RFC3339),
+			ETag:         `"` + obj.etag + `"`,
+			Size:         int64(len(obj.data)),
+		})
+		res.KeyCount++
+		res.NextContinuationToken = k
+	}
+	if !res.IsTruncated {
+		res.NextContinuationToken = ""
+	}
+	writeFakeS3XML(w, res)
+}
+
+func (f *fakeS3) copyObject(w http.ResponseWriter, r *http.Request, objects map[string]*fakeS3Object, key, src string) {
+	src, _ = url.PathUnescape(src)
+	parts := strings.SplitN(strings.TrimPrefix(src, "/"), "/", 2)
+	if len(parts) != 2 {
+		writeFakeS3Error(w, r, http.StatusBadRequest, "InvalidArgument")
+		return
+	}
+	srcObj, ok := f.buckets[parts[0]][parts[1]]
+	if !ok {
+		writeFakeS3Error(w, r, http.StatusNotFound, "NoSuchKey")
+		return
+	}
+	obj := newFakeS3Object(srcObj.data)
+	if r.Header.Get("X-Amz-Metadata-Directive") == "REPLACE" {
+		applyFakeS3Headers(obj, r.Header)
+	} else {
+		obj.contentType = srcObj.contentType
+		for k, v := range srcObj.metadata {
+			obj.metadata[k] = v
+		}
+	}
+	objects[key] = obj
+	writeFakeS3XML(w, struct {
+		XMLName      xml.Name `xml:"CopyObjectResult"`
+		ETag         string
+		LastModified string
+	}{ETag: `"` + obj.etag + `"`, LastModified: obj.lastModified.Format(time.RFC3339)})
+}
+
+// fakeS3NotModified evaluates the If-None-Match and If-Modified-Since
+// headers of a GET, the latter only without the former like HTTP.
+func fakeS3NotModified(r *http.Request, obj *fakeS3Object) bool {
+	if m := r.Header.Get("If-None-Match"); m != "" {
+		return m == "*" || strings.Trim(m, `"`) == obj.etag
+	}
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	return err == nil && !obj.lastModified.After(since)
+}
+
+func (f *fakeS3) serveObject(w http.ResponseWriter, r *http.Request, obj *fakeS3Object) {
+	h := w.Header()
+	h.Set("ETag", `"`+obj.etag+`"`)
+	h.Set("Last-Modified", obj.lastModified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	if obj.contentType != "" {
+		h.Set("Content-Type", obj.contentType)
+	}
+	for k, v := range obj.metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	if fakeS3NotModified(r, obj) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if m := r.Header.Get("If-Match"); m != "" && strings.Trim(m, `"`) != obj.etag {
+		writeFakeS3Error(w, r, http.StatusPreconditionFailed, "PreconditionFailed")
+		return
+	}
+
+	data := obj.data
+	status := http.StatusOK
+	if rng := r.Header.Get("Range"); rng != "" {
+		start, end, ok := parseFakeS3Range(rng, int64(len(data)))
+		if !ok {
+			writeFakeS3Error(w, r, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if r.Method == http.MethodGet {
+		_, _ = w.Write(data)
+	}
+}
+
+// parseFakeS3Range parses a single "bytes=start-end" range, clamping the end
+// to the object size like S3 does.
+func parseFakeS3Range(rng string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok {
+		return 0, 0, false
+	}
+	from, to, _ := strings.Cut(spec, "-")
+	if from == "" {
+		n, err := strconv.ParseInt(to, 10, 64)
+		if err != nil || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(from, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if to != "" {
+		if end, err = strconv.ParseInt(to, 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func applyFakeS3Headers(obj *fakeS3Object, h http.Header) {
+	obj.contentType = h.Get("Content-Type")
+	obj.tags = h.Get("X-Amz-Tagging")
+	obj.acl = h.Get("X-Amz-Acl")
+	for k, v := range h {
+		if name, ok := strings.CutPrefix(k, "X-Amz-Meta-"); ok {
+			obj.metadata[name] = v[0]
+		}
+	}
+}
+
+// readFakeS3Body reads the request body, decoding aws-chunked payloads used
+// by streaming signatures.
+func readFakeS3Body(r *http.Request) ([]byte, error) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return io.ReadAll(r.Body)
+	}
+	var out bytes.Buffer
+	br := bufio.NewReader(r.Body)
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		sizeHex, _, _ := strings.Cut(strings.TrimSpace(line), ";")
+		n, err := strconv.ParseInt(sizeHex, 16, 64)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return out.Bytes(), nil
+		}
+		if _, err := io.CopyN(&out, br, n); err != nil {
+			return nil, err
+		}
+		if _, err := br.Discard(2); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeFakeS3XML(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func writeFakeS3Error(w http.ResponseWriter, r *http.Request, status int, code string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = xml.NewEncoder(w).Encode(struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+		Key     string
+	}{Code: code, Message: code, Key: r.URL.Path})
+}
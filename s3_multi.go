@@ -1,81 +1,253 @@
 package store
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"os"
+	"strings"
+	"sync"
 )
 
+var (
+	_ io.Closer             = &S3MultiStore{}
+	_ HealthChecker         = &S3MultiStore{}
+	_ ConditionalDownloader = &S3MultiStore{}
+	_ VerifiedDownloader    = &S3MultiStore{}
+	_ FileDownloader        = &S3MultiStore{}
+	_ StatLister            = &S3MultiStore{}
+	_ Walker                = &S3MultiStore{}
+	_ ContextLister         = &S3MultiStore{}
+	_ MetadataGetter        = &S3MultiStore{}
+	_ UsageReporter         = &S3MultiStore{}
+	_ Toucher               = &S3MultiStore{}
+	_ ParallelDownloader    = &S3MultiStore{}
+	_ DeletePreviewer       = &S3MultiStore{}
+	_ EmptyChecker          = &S3MultiStore{}
+	_ Copier                = &S3MultiStore{}
+)
+
+// S3MultiStore routes keys to the S3Store of the matching configuration.
+// Keys of the form s3://bucket/key go to a configuration of the bucket.
+// The stores are created on first use and cached until Close.
 type S3MultiStore struct {
-	cfg *S3MultiStoreConfig
+	cfg  *S3MultiStoreConfig
+	opts []Option
+
+	lk     sync.Mutex
+	stores map[*S3Config]Interface
+	closed bool
 }
 
-func NewS3MultiStore(cfgPath string) (Interface, error) {
+func NewS3MultiStore(cfgPath string, opts ...Option) (Interface, error) {
 	cfg, err := LoadS3MultiStoreConfig(cfgPath)
 	if err != nil {
 		return nil, err
 	}
-	return &S3MultiStore{cfg: cfg}, nil
+	return NewS3MultiStoreWithConfig(cfg, opts...), nil
+}
+
+// NewS3MultiStoreWithConfig creates a new S3MultiStore from a loaded configuration.
+func NewS3MultiStoreWithConfig(cfg *S3MultiStoreConfig, opts ...Option) Interface {
+	return &S3MultiStore{cfg: cfg, opts: opts}
 }
 
 // NewS3MultiStoreWithEnv creates a new S3MultiStore with the given environment variable name.
-func NewS3MultiStoreWithEnv() (Interface, error) {
+func NewS3MultiStoreWithEnv(opts ...Option) (Interface, error) {
 	cfgPath, ok := os.LookupEnv(S3Env)
 	if !ok {
 		return nil, S3NotConfigError
 	}
-	return NewS3MultiStore(cfgPath)
+	return NewS3MultiStore(cfgPath, opts...)
+}
+
+// getStore returns the cached store for the configuration serving key,
+// creating it on first use, and the key in its bucket. A network-style key,
+// s3://bucket/key, is served by a configuration of that bucket.
+func (s *S3MultiStore) getStore(key string) (Interface, string, error) {
+	if s == nil || s.cfg == nil {
+		return nil, key, S3NotConfigError
+	}
+	var (
+		cfg *S3Config
+		err error
+	)
+	if strings.HasPrefix(key, "s3://") {
+		var info PathInfo
+		if info, err = ParsePath(key); err != nil {
+			return nil, key, err
+		}
+		key = info.Path
+		cfg, err = s.cfg.getBucketConfig(info.Bucket, key)
+	} else {
+		cfg, err = s.cfg.getConfig(key)
+	}
+	if err != nil {
+		return nil, key, err
+	}
+	st, err := s.storeFor(cfg)
+	return st, key, err
+}
+
+// storeFor returns the cached store of cfg, creating it on first use.
+// Creating a store may check its bucket over the network, so it's done
+// without holding the lock, which would block the other prefixes meanwhile.
+// Of the stores created by racing first uses, the first one cached is kept.
+func (s *S3MultiStore) storeFor(cfg *S3Config) (Interface, error) {
+	s.lk.Lock()
+	st, ok := s.stores[cfg]
+	closed := s.closed
+	s.lk.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+	if ok {
+		return st, nil
+	}
+
+	st, err := NewS3Store(cfg, s.opts...)
+	if err != nil {
+		return nil, err
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.closed {
+		_ = closeStore(st)
+		return nil, ErrClosed
+	}
+	if cached, ok := s.stores[cfg]; ok {
+		_ = closeStore(st)
+		return cached, nil
+	}
+	if s.stores == nil {
+		s.stores = make(map[*S3Config]Interface)
+	}
+	s.stores[cfg] = st
+	return st, nil
+}
+
+// Close closes all the cached stores. The store can't be used afterwards.
+func (s *S3MultiStore) Close() error {
+	if s == nil {
+		return nil
+	}
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var errs []error
+	for cfg, st := range s.stores {
+		if err := closeStore(st); err != nil {
+			errs = append(errs, fmt.Errorf("close store for %s/%s: %w", cfg.Endpoint, cfg.Bucket, err))
+		}
+	}
+	s.stores = nil
+	return errors.Join(errs...)
+}
+
+// HealthCheck checks every configured bucket and returns the errors joined.
+func (s *S3MultiStore) HealthCheck(ctx context.Context) error {
+	if s == nil || s.cfg == nil {
+		return S3NotConfigError
+	}
+	var errs []error
+	for prefix, cfg := range s.cfg.configs() {
+		st, err := s.storeFor(cfg)
+		if err == nil {
+			err = st.(HealthChecker).HealthCheck(ctx)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("prefix %q: %w", prefix, err))
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func (s *S3MultiStore) Stat(key string) (FileStat, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return FileStat{}, err
 	}
 	return st.Stat(key)
 }
 
-func (s *S3MultiStore) UploadData(data []byte, key string) (err error) {
-	st, err := s.cfg.getStore(key)
+func (s *S3MultiStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return err
 	}
-	return st.UploadData(data, key)
+	return st.UploadData(data, key, opts...)
 }
 
-func (s *S3MultiStore) Upload(file string, key string) (err error) {
-	st, err := s.cfg.getStore(key)
+func (s *S3MultiStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return err
 	}
-	return st.Upload(file, key)
+	return st.Upload(file, key, opts...)
 }
 
-func (s *S3MultiStore) UploadReader(reader io.Reader, size int64, key string) (err error) {
-	st, err := s.cfg.getStore(key)
+func (s *S3MultiStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return err
 	}
-	return st.UploadReader(reader, size, key)
+	return st.UploadReader(reader, size, key, opts...)
 }
 
 func (s *S3MultiStore) DeleteDirectory(dir string) (err error) {
-	st, err := s.cfg.getStore(dir)
+	st, dir, err := s.getStore(dir)
 	if err != nil {
 		return err
 	}
 	return st.DeleteDirectory(dir)
 }
 
+func (s *S3MultiStore) DeleteDirectoryPreview(dir string) ([]string, error) {
+	st, dir, err := s.getStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	return st.(DeletePreviewer).DeleteDirectoryPreview(dir)
+}
+
+func (s *S3MultiStore) IsEmpty(key string) (bool, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return false, err
+	}
+	return st.(EmptyChecker).IsEmpty(key)
+}
+
 func (s *S3MultiStore) Delete(key string) (err error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return err
 	}
 	return st.Delete(key)
 }
 
+// Copy copies the object at src to dst. Keys served by the same
+// configuration are copied server-side, while copies between configurations,
+// which may be different buckets or endpoints, are streamed from one store
+// to the other.
+func (s *S3MultiStore) Copy(src, dst string) error {
+	srcStore, srcKey, err := s.getStore(src)
+	if err != nil {
+		return fmt.Errorf("copy from %s: %w", src, err)
+	}
+	dstStore, dstKey, err := s.getStore(dst)
+	if err != nil {
+		return fmt.Errorf("copy to %s: %w", dst, err)
+	}
+	return Copy(srcStore, srcKey, dstStore, dstKey)
+}
+
 func (s *S3MultiStore) Exists(key string) (bool, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return false, err
 	}
@@ -83,7 +255,7 @@ func (s *S3MultiStore) Exists(key string) (bool, error) {
 }
 
 func (s *S3MultiStore) DownloadBytes(key string) ([]byte, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return nil, err
 	}
@@ -91,7 +263,7 @@ func (s *S3MultiStore) DownloadBytes(key string) ([]byte, error) {
 }
 
 func (s *S3MultiStore) DownloadReader(key string) (io.ReadCloser, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +271,7 @@ func (s *S3MultiStore) DownloadReader(key string) (io.ReadCloser, error) {
 }
 
 func (s *S3MultiStore) DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +279,7 @@ func (s *S3MultiStore) DownloadRangeBytes(key string, offset int64, size int64)
 }
 
 func (s *S3MultiStore) DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return nil, err
 	}
@@ -115,9 +287,117 @@ func (s *S3MultiStore) DownloadRangeReader(key string, offset int64, size int64)
 }
 
 func (s *S3MultiStore) ListPrefix(key string) ([]string, error) {
-	st, err := s.cfg.getStore(key)
+	st, key, err := s.getStore(key)
 	if err != nil {
 		return nil, err
 	}
 	return st.ListPrefix(key)
 }
+
+func (s *S3MultiStore) ListPrefixContext(ctx context.Context, key string) ([]string, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(ContextLister).ListPrefixContext(ctx, key)
+}
+
+func (s *S3MultiStore) ListPrefixStat(key string) ([]ObjectStat, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(StatLister).ListPrefixStat(key)
+}
+
+func (s *S3MultiStore) GetMetadata(key string) (map[string]string, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(MetadataGetter).GetMetadata(key)
+}
+
+func (s *S3MultiStore) WalkPrefix(ctx context.Context, key string, fn WalkFunc) error {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return err
+	}
+	return st.(Walker).WalkPrefix(ctx, key, fn)
+}
+
+func (s *S3MultiStore) PrefixUsage(key string) (int64, int64, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return st.(UsageReporter).PrefixUsage(key)
+}
+
+func (s *S3MultiStore) Touch(key string) error {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return err
+	}
+	return st.(Toucher).Touch(key)
+}
+
+func (s *S3MultiStore) ListRollup(key string, depth int) ([]RollupEntry, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	rl, ok := st.(RollupLister)
+	if !ok {
+		return nil, notSupportedError("ListRollup", st)
+	}
+	return rl.ListRollup(key, depth)
+}
+
+func (s *S3MultiStore) ListPrefixDepth(key string, maxDepth int) ([]string, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(DepthLister).ListPrefixDepth(key, maxDepth)
+}
+
+func (s *S3MultiStore) DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(ConditionalDownloader).DownloadReaderIf(key, cond)
+}
+
+func (s *S3MultiStore) DownloadParallel(key string, partSize int64, concurrency int) (io.ReadCloser, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(ParallelDownloader).DownloadParallel(key, partSize, concurrency)
+}
+
+func (s *S3MultiStore) DownloadBytesVerified(key string) ([]byte, error) {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return nil, err
+	}
+	return st.(VerifiedDownloader).DownloadBytesVerified(key)
+}
+
+func (s *S3MultiStore) DownloadToFile(key, localPath string) error {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return err
+	}
+	return DownloadToFile(st, key, localPath)
+}
+
+func (s *S3MultiStore) Publish(key string, data []byte, opts PublishOptions) error {
+	st, key, err := s.getStore(key)
+	if err != nil {
+		return err
+	}
+	return st.(Publisher).Publish(key, data, opts)
+}
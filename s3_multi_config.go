@@ -1,24 +1,39 @@
 package store
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
-	"path"
+	"sort"
 	"strings"
 	"sync"
-
-	"github.com/pelletier/go-toml"
 )
 
+// DefaultConfigPrefix is the prefix of the catch-all configuration of an
+// S3MultiStoreConfig: the default selector routes the keys no other prefix
+// matches to it.
+const DefaultConfigPrefix = "*"
+
+// SelectConfigFunc picks the S3 configuration that serves the given key.
+type SelectConfigFunc func(cfgs map[string]*S3Config, key string) (*S3Config, bool)
+
 type S3MultiStoreConfig struct {
 	path         string
-	selectConfig func(cfgs map[string]*S3Config, key string) (*S3Config, bool)
+	selectConfig SelectConfigFunc
 	cfgs         map[string]*S3Config
 	lk           sync.RWMutex
 }
 
-func (s *S3MultiStoreConfig) getStore(key string) (Interface, error) {
+func (s *S3MultiStoreConfig) getStore(key string, opts ...Option) (Interface, error) {
+	cfg, err := s.getConfig(key)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3Store(cfg, opts...)
+}
+
+// getConfig returns the configuration serving key.
+func (s *S3MultiStoreConfig) getConfig(key string) (*S3Config, error) {
 	s.lk.RLock()
 	defer s.lk.RUnlock()
 
@@ -26,29 +41,131 @@ func (s *S3MultiStoreConfig) getStore(key string) (Interface, error) {
 	if !ok {
 		return nil, fmt.Errorf("no s3 configuration found for key: %s", key)
 	}
+	return cfg, nil
+}
+
+// getBucketConfig returns the configuration serving key in bucket. Among
+// several configurations of the bucket, the one whose prefix matches key
+// wins as usual, then the one with the smallest prefix.
+func (s *S3MultiStoreConfig) getBucketConfig(bucket string, key string) (*S3Config, error) {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+
+	var (
+		cfgs     = make(map[string]*S3Config)
+		fallback string
+	)
+	for prefix, cfg := range s.cfgs {
+		if cfg.Bucket != bucket {
+			continue
+		}
+		if len(cfgs) == 0 || prefix < fallback {
+			fallback = prefix
+		}
+		cfgs[prefix] = cfg
+	}
+	if len(cfgs) == 0 {
+		return nil, fmt.Errorf("no s3 configuration found for bucket: %s", bucket)
+	}
+	if cfg, ok := s.selectConfig(cfgs, key); ok {
+		return cfg, nil
+	}
+	return cfgs[fallback], nil
+}
 
-	return NewS3Store(cfg)
+// configs returns a copy of the configurations by prefix.
+func (s *S3MultiStoreConfig) configs() map[string]*S3Config {
+	s.lk.RLock()
+	defer s.lk.RUnlock()
+	cfgs := make(map[string]*S3Config, len(s.cfgs))
+	for prefix, cfg := range s.cfgs {
+		cfgs[prefix] = cfg
+	}
+	return cfgs
 }
 
-func LoadS3MultiStoreConfig(cfgPath string) (*S3MultiStoreConfig, error) {
-	cfgs := make(map[string]*S3Config)
+// WithSelector replaces the function used to route keys to configurations.
+// Passing nil restores the default longest-prefix selector.
+func (s *S3MultiStoreConfig) WithSelector(fn SelectConfigFunc) *S3MultiStoreConfig {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	if fn == nil {
+		fn = defaultSelectConfigCallbackFunc
+	}
+	s.selectConfig = fn
+	return s
+}
 
+func LoadS3MultiStoreConfig(cfgPath string) (*S3MultiStoreConfig, error) {
 	raw, err := os.ReadFile(cfgPath)
 	if err != nil {
-		return nil, fmt.Errorf("read s3 configuration file error: %v", err)
-	}
-	ext := strings.ToLower(path.Ext(cfgPath))
-	if ext == ".json" {
-		err = json.Unmarshal(raw, &cfgs)
-	} else if ext == ".toml" {
-		err = toml.Unmarshal(raw, &cfgs)
-	} else {
-		return nil, fmt.Errorf("invalid s3 configuration format")
+		return nil, fmt.Errorf("read s3 configuration file error: %w", err)
 	}
+	cfg, err := LoadS3MultiStoreConfigFromBytes(raw, configFormat(cfgPath))
 	if err != nil {
-		return nil, fmt.Errorf("unmarshal s3 configuration error: %v", err)
+		return nil, err
+	}
+	cfg.path = cfgPath
+	return cfg, nil
+}
+
+// LoadS3MultiStoreConfigFromBytes loads the multi-store configuration from data.
+// The format is one of "json", "toml" or "yaml".
+func LoadS3MultiStoreConfigFromBytes(data []byte, format string) (*S3MultiStoreConfig, error) {
+	cfgs := make(map[string]*S3Config)
+	if err := unmarshalConfig(data, format, &cfgs); err != nil {
+		return nil, fmt.Errorf("unmarshal s3 configuration error: %w", err)
+	}
+	cfg := &S3MultiStoreConfig{cfgs: cfgs, selectConfig: defaultSelectConfigCallbackFunc}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid s3 configuration: %w", err)
+	}
+	return cfg, nil
+}
+
+// validate checks that there is at least one configuration and that each has
+// an endpoint and a bucket, reporting every offending prefix. Overlapping
+// prefixes such as "a" and "a/b" are legal, the longest one wins, but they're
+// logged since they're often a typo.
+func (s *S3MultiStoreConfig) validate() error {
+	if len(s.cfgs) == 0 {
+		return errors.New("no s3 configuration found")
+	}
+	prefixes := make([]string, 0, len(s.cfgs))
+	for prefix := range s.cfgs {
+		prefixes = append(prefixes, prefix)
 	}
-	return &S3MultiStoreConfig{path: cfgPath, cfgs: cfgs, selectConfig: defaultSelectConfigCallbackFunc}, nil
+	sort.Strings(prefixes)
+
+	var errs []error
+	for _, prefix := range prefixes {
+		cfg := s.cfgs[prefix]
+		if cfg == nil {
+			errs = append(errs, fmt.Errorf("prefix %q: configuration is empty", prefix))
+			continue
+		}
+		if cfg.Endpoint == "" {
+			errs = append(errs, fmt.Errorf("prefix %q: endpoint is not set", prefix))
+		}
+		if cfg.Bucket == "" {
+			errs = append(errs, fmt.Errorf("prefix %q: bucket is not set", prefix))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	for i, prefix := range prefixes {
+		if prefix == DefaultConfigPrefix {
+			continue
+		}
+		for _, other := range prefixes[i+1:] {
+			if other != DefaultConfigPrefix && isKeyStartsWithPrefix(other, prefix) {
+				log.Warnw("overlapping s3 configuration prefixes, the longest one wins", "prefix", prefix, "nested", other)
+			}
+		}
+	}
+	return nil
 }
 
 func isKeyStartsWithPrefix(key, prefix string) bool {
@@ -61,11 +178,26 @@ func isKeyStartsWithPrefix(key, prefix string) bool {
 	return strings.HasPrefix(key, prefix)
 }
 
-var defaultSelectConfigCallbackFunc = func(cfgs map[string]*S3Config, key string) (*S3Config, bool) {
+// defaultSelectConfigCallbackFunc selects the configuration with the longest
+// prefix matching the key. Prefixes match on whole path segments, so "a"
+// matches "a/x" but not "ab/x". The result doesn't depend on map iteration
+// order; ties between equal-length prefixes can't happen since map keys are unique.
+// Keys no prefix matches go to the DefaultConfigPrefix configuration, if any.
+var defaultSelectConfigCallbackFunc SelectConfigFunc = func(cfgs map[string]*S3Config, key string) (*S3Config, bool) {
+	var (
+		selected *S3Config
+		longest  = -1
+	)
 	for keyPrefix, config := range cfgs {
-		if isKeyStartsWithPrefix(key, keyPrefix) {
-			return config, true
+		if keyPrefix == DefaultConfigPrefix {
+			continue
+		}
+		if isKeyStartsWithPrefix(key, keyPrefix) && len(keyPrefix) > longest {
+			selected, longest = config, len(keyPrefix)
 		}
 	}
-	return nil, false
+	if selected == nil {
+		selected = cfgs[DefaultConfigPrefix]
+	}
+	return selected, selected != nil
 }
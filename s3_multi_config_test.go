@@ -57,6 +57,94 @@ useSSL = false
 	assert.Equal(t, "localhost:9000", cfg.cfgs["prefix1"].Endpoint, "unexpected endpoint")
 }
 
+func TestLoadS3MultiStoreConfig_YAML(t *testing.T) {
+	cfgContent := `
+prefix1:
+  endpoint: localhost:9000
+  region: us-east-1
+  bucket: bucket1
+  access_key: key1
+  secret_key: secret1
+  use_ssl: false
+`
+	for _, name := range []string{"config.yaml", "config.yml"} {
+		cfgPath := filepath.Join(t.TempDir(), name)
+		err := os.WriteFile(cfgPath, []byte(cfgContent), 0644)
+		assert.NoError(t, err, "failed to write config file")
+
+		cfg, err := LoadS3MultiStoreConfig(cfgPath)
+		assert.NoError(t, err, "failed to load config: %s", name)
+		assert.NotNil(t, cfg, "config should not be nil")
+		assert.Equal(t, "localhost:9000", cfg.cfgs["prefix1"].Endpoint, "unexpected endpoint")
+		assert.Equal(t, "key1", cfg.cfgs["prefix1"].AccessKey, "unexpected access key")
+	}
+}
+
+func TestLoadS3MultiStoreConfigFromBytes(t *testing.T) {
+	content := `
+prefix1:
+  endpoint: localhost:9000
+  bucket: bucket1
+`
+	cfg, err := LoadS3MultiStoreConfigFromBytes([]byte(content), "yaml")
+	assert.NoError(t, err, "failed to load config")
+	assert.Equal(t, "localhost:9000", cfg.cfgs["prefix1"].Endpoint, "unexpected endpoint")
+
+	_, err = LoadS3MultiStoreConfigFromBytes([]byte(content), "ini")
+	assert.Error(t, err, "expected error for unsupported format")
+}
+
+func TestLoadS3MultiStoreConfigFromBytes_Invalid(t *testing.T) {
+	_, err := LoadS3MultiStoreConfigFromBytes([]byte(`{}`), "json")
+	assert.ErrorContains(t, err, "no s3 configuration found")
+
+	content := `
+prefix1:
+  endpoint: localhost:9000
+prefix2:
+  bucket: bucket2
+prefix3:
+`
+	_, err = LoadS3MultiStoreConfigFromBytes([]byte(content), "yaml")
+	assert.ErrorContains(t, err, `prefix "prefix1": bucket is not set`)
+	assert.ErrorContains(t, err, `prefix "prefix2": endpoint is not set`)
+	assert.ErrorContains(t, err, `prefix "prefix3": configuration is empty`)
+
+	// Overlapping prefixes are only logged: the longest one wins.
+	content = `
+a:
+  endpoint: localhost:9000
+  bucket: bucket1
+a/b:
+  endpoint: localhost:9000
+  bucket: bucket2
+`
+	cfg, err := LoadS3MultiStoreConfigFromBytes([]byte(content), "yaml")
+	assert.NoError(t, err)
+	selected, err := cfg.getConfig("a/b/key")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket2", selected.Bucket)
+}
+
+func TestLoadS3MultiStoreConfigFromBytes_Env(t *testing.T) {
+	t.Setenv("STORE_TEST_BUCKET", "bucket1")
+	t.Setenv("STORE_TEST_SECRET_KEY", "secret1")
+	content := `{
+        "prefix1": {
+            "endpoint": "localhost:9000",
+            "bucket": "${STORE_TEST_BUCKET}",
+            "secret_key": "${STORE_TEST_SECRET_KEY}"
+        }
+    }`
+	cfg, err := LoadS3MultiStoreConfigFromBytes([]byte(content), "json")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket1", cfg.cfgs["prefix1"].Bucket)
+	assert.Equal(t, "secret1", cfg.cfgs["prefix1"].SecretKey)
+
+	_, err = LoadS3MultiStoreConfigFromBytes([]byte(`{"prefix1": {"endpoint": "$STORE_TEST_UNSET", "bucket": "b"}}`), "json")
+	assert.ErrorContains(t, err, "STORE_TEST_UNSET")
+}
+
 func TestS3MultiStoreConfig_getStore(t *testing.T) {
 	cfg := &S3MultiStoreConfig{
 		cfgs: map[string]*S3Config{
@@ -81,3 +169,88 @@ func TestIsKeyStartsWithPrefix(t *testing.T) {
 	assert.True(t, isKeyStartsWithPrefix("prefix1/some/key", "prefix1"), "expected true for matching prefix")
 	assert.False(t, isKeyStartsWithPrefix("prefix2/some/key", "prefix1"), "expected false for non-matching prefix")
 }
+
+func TestDefaultSelectConfigCallbackFunc_LongestPrefix(t *testing.T) {
+	cfgs := map[string]*S3Config{
+		"a":   {Bucket: "bucket-a"},
+		"a/b": {Bucket: "bucket-ab"},
+	}
+
+	cfg, ok := defaultSelectConfigCallbackFunc(cfgs, "a/b/key")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket-ab", cfg.Bucket, "longest prefix should win")
+
+	cfg, ok = defaultSelectConfigCallbackFunc(cfgs, "a/c/key")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket-a", cfg.Bucket)
+
+	_, ok = defaultSelectConfigCallbackFunc(cfgs, "b/key")
+	assert.False(t, ok)
+}
+
+func TestDefaultSelectConfigCallbackFunc_MapOrder(t *testing.T) {
+	prefixes := []string{"a", "a/b", "a/b/c", "a/bc", "b"}
+	expected := map[string]string{
+		"a/key":       "a",
+		"a/b/key":     "a/b",
+		"a/b/c/key":   "a/b/c",
+		"a/b/cd/key":  "a/b",
+		"a/bc/key":    "a/bc",
+		"a/b/c":       "a/b/c",
+		"b/a/b/c/key": "b",
+	}
+	// Map iteration order changes from one range to the next, so selecting
+	// many times from fresh maps would catch an order-dependent selector.
+	for i := 0; i < 100; i++ {
+		cfgs := make(map[string]*S3Config, len(prefixes))
+		for _, prefix := range prefixes {
+			cfgs[prefix] = &S3Config{Bucket: prefix}
+		}
+		for key, want := range expected {
+			cfg, ok := defaultSelectConfigCallbackFunc(cfgs, key)
+			if !assert.True(t, ok, key) || !assert.Equal(t, want, cfg.Bucket, key) {
+				return
+			}
+		}
+	}
+}
+
+func TestDefaultSelectConfigCallbackFunc_Default(t *testing.T) {
+	cfgs := map[string]*S3Config{
+		"a":                 {Bucket: "bucket-a"},
+		DefaultConfigPrefix: {Bucket: "bucket-default"},
+	}
+
+	cfg, ok := defaultSelectConfigCallbackFunc(cfgs, "a/key")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket-a", cfg.Bucket, "a specific prefix should win over the default")
+
+	for _, key := range []string{"b/key", "key", "ab/key", "*/key"} {
+		cfg, ok = defaultSelectConfigCallbackFunc(cfgs, key)
+		assert.True(t, ok, key)
+		assert.Equal(t, "bucket-default", cfg.Bucket, key)
+	}
+}
+
+func TestS3MultiStoreConfig_WithSelector(t *testing.T) {
+	cfg := &S3MultiStoreConfig{
+		cfgs: map[string]*S3Config{
+			"prefix1": {Endpoint: "localhost:9000", Bucket: "bucket1"},
+			"prefix2": {Endpoint: "localhost:9000", Bucket: "bucket2"},
+		},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	cfg.WithSelector(func(cfgs map[string]*S3Config, key string) (*S3Config, bool) {
+		c, ok := cfgs["prefix2"]
+		return c, ok
+	})
+
+	selected, ok := cfg.selectConfig(cfg.cfgs, "prefix1/some/key")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket2", selected.Bucket, "custom selector should be used")
+
+	cfg.WithSelector(nil)
+	selected, ok = cfg.selectConfig(cfg.cfgs, "prefix1/some/key")
+	assert.True(t, ok)
+	assert.Equal(t, "bucket1", selected.Bucket, "nil should restore the default selector")
+}
@@ -2,9 +2,13 @@ package store
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -199,3 +203,182 @@ func TestS3MultiStore_ListPrefix(t *testing.T) {
 		assert.NoError(t, err, "failed to delete key during cleanup")
 	}()
 }
+
+func TestS3MultiStore_Close(t *testing.T) {
+	f := newFakeS3(t, "test-bucket")
+	cfg := &S3MultiStoreConfig{
+		cfgs:         map[string]*S3Config{"prefix1": f.config("test-bucket")},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+
+	assert.NoError(t, store.UploadData([]byte("content"), "prefix1/a.txt"))
+	first, _, err := store.getStore("prefix1/a.txt")
+	assert.NoError(t, err)
+	second, _, err := store.getStore("prefix1/b.txt")
+	assert.NoError(t, err)
+	assert.Same(t, first, second, "the store should be cached per configuration")
+
+	assert.NoError(t, store.Close())
+	assert.ErrorIs(t, store.UploadData([]byte("content"), "prefix1/a.txt"), ErrClosed)
+	assert.NoError(t, store.Close(), "closing twice should be a no-op")
+}
+
+func TestS3MultiStore_HealthCheck(t *testing.T) {
+	f := newFakeS3(t, "bucket1")
+	cfg := &S3MultiStoreConfig{
+		cfgs: map[string]*S3Config{
+			"prefix1": f.config("bucket1"),
+			"prefix2": f.config("bucket2"),
+		},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+
+	err := store.HealthCheck(context.Background())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorContains(t, err, `prefix "prefix2"`)
+	assert.NotContains(t, err.Error(), `prefix "prefix1"`)
+}
+
+func TestS3MultiStore_BucketURL(t *testing.T) {
+	f := newFakeS3(t, "bucket1", "bucket2")
+	cfg := &S3MultiStoreConfig{
+		cfgs: map[string]*S3Config{
+			"prefix1": f.config("bucket1"),
+			"prefix2": f.config("bucket2"),
+			"prefix3": f.config("bucket2"),
+		},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+
+	assert.NoError(t, store.UploadData([]byte("one"), "s3://bucket1/dir/a.txt"))
+	assert.NoError(t, store.UploadData([]byte("two"), "s3://bucket2/prefix3/b.txt"))
+	_, ok := f.get("bucket1", "dir/a.txt")
+	assert.True(t, ok, "the bucket of the URL should be used")
+	_, ok = f.get("bucket2", "prefix3/b.txt")
+	assert.True(t, ok)
+
+	data, err := store.DownloadBytes("s3://bucket2/prefix3/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(data))
+	keys, err := store.ListPrefix("s3://bucket1/dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dir/a.txt"}, keys)
+
+	_, err = store.DownloadBytes("s3://bucket3/a.txt")
+	assert.ErrorContains(t, err, "bucket3")
+
+	s := &Store{osStore: NewOSStore(), s3Store: store}
+	data, err = s.DownloadBytes("s3://bucket1/dir/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+	assert.NoError(t, s.UploadData([]byte("legacy"), "s3:/prefix1/c.txt"))
+	_, ok = f.get("bucket1", "prefix1/c.txt")
+	assert.True(t, ok, "legacy keys are still routed by prefix")
+}
+
+func TestS3MultiStore_NotConfigured(t *testing.T) {
+	for _, s := range []*S3MultiStore{nil, {}} {
+		_, err := s.Stat("key")
+		assert.ErrorIs(t, err, S3NotConfigError)
+		assert.ErrorIs(t, s.HealthCheck(context.Background()), S3NotConfigError)
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestS3MultiStore_Copy(t *testing.T) {
+	f := newFakeS3(t, "bucket1", "bucket2")
+	cfg := &S3MultiStoreConfig{
+		cfgs: map[string]*S3Config{
+			"prefix1": f.config("bucket1"),
+			"prefix2": f.config("bucket2"),
+		},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+	var copies, gets atomic.Int32
+	f.setHook(func(r *http.Request) (int, string) {
+		switch {
+		case r.Header.Get("X-Amz-Copy-Source") != "":
+			copies.Add(1)
+		case r.Method == http.MethodGet && r.URL.Path == "/bucket1/prefix1/a.txt":
+			gets.Add(1)
+		}
+		return 0, ""
+	})
+	assert.NoError(t, store.UploadData([]byte("content"), "prefix1/a.txt"))
+
+	// same configuration: server-side
+	assert.NoError(t, store.Copy("prefix1/a.txt", "prefix1/b.txt"))
+	assert.Equal(t, int32(1), copies.Load())
+	assert.Zero(t, gets.Load())
+	obj, ok := f.get("bucket1", "prefix1/b.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+
+	// across configurations: streamed
+	assert.NoError(t, store.Copy("prefix1/a.txt", "prefix2/a.txt"))
+	assert.Equal(t, int32(1), copies.Load())
+	assert.Equal(t, int32(1), gets.Load())
+	obj, ok = f.get("bucket2", "prefix2/a.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "content", string(obj.data))
+
+	assert.ErrorIs(t, store.Copy("prefix1/missing.txt", "prefix1/c.txt"), ErrNotFound)
+	assert.ErrorIs(t, store.Copy("prefix1/missing.txt", "prefix2/c.txt"), ErrNotFound)
+	err := store.Copy("prefix1/a.txt", "prefix3/a.txt")
+	assert.ErrorContains(t, err, "copy to prefix3/a.txt")
+	err = store.Copy("prefix3/a.txt", "prefix1/a.txt")
+	assert.ErrorContains(t, err, "copy from prefix3/a.txt")
+}
+
+func TestS3MultiStore_ListRollup_NotSupported(t *testing.T) {
+	fake := newFakeS3(t, "bucket")
+	bucketCfg := fake.config("bucket")
+	cfg := &S3MultiStoreConfig{
+		cfgs:         map[string]*S3Config{"prefix": bucketCfg},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+	store.stores = map[*S3Config]Interface{bucketCfg: NewMemStore()}
+
+	assert.NotPanics(t, func() {
+		_, err := store.ListRollup("prefix/dir", 1)
+		assert.ErrorIs(t, err, ErrNotSupported)
+	})
+}
+
+func TestS3MultiStore_SlowStoreCreation(t *testing.T) {
+	slow, fast := newFakeS3(t, "slow"), newFakeS3(t, "fast")
+	slowCfg := slow.config("slow")
+	slowCfg.VerifyWritable = true
+	cfg := &S3MultiStoreConfig{
+		cfgs:         map[string]*S3Config{"prefix1": slowCfg, "prefix2": fast.config("fast")},
+		selectConfig: defaultSelectConfigCallbackFunc,
+	}
+	store := NewS3MultiStoreWithConfig(cfg).(*S3MultiStore)
+
+	blocked, release := make(chan struct{}), make(chan struct{})
+	var once sync.Once
+	slow.setHook(func(r *http.Request) (int, string) {
+		once.Do(func() {
+			close(blocked)
+			<-release
+		})
+		return 0, ""
+	})
+	done := make(chan error)
+	go func() {
+		done <- store.UploadData([]byte("slow"), "prefix1/a.txt")
+	}()
+	<-blocked
+
+	// the other prefix isn't held up by the check of the slow bucket
+	assert.NoError(t, store.UploadData([]byte("fast"), "prefix2/a.txt"))
+	close(release)
+	assert.NoError(t, <-done)
+	_, ok := slow.get("slow", "prefix1/a.txt")
+	assert.True(t, ok)
+}
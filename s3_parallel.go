@@ -0,0 +1,276 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+const (
+	// minUploadPartSize is the smallest part size S3 accepts for every part
+	// but the last one.
+	minUploadPartSize = 5 << 20
+	// maxUploadPartSize is the largest part size S3 accepts.
+	maxUploadPartSize = 5 << 30
+	// defaultStreamPartSize is the part size of the uploads of an unknown
+	// size when none is configured.
+	defaultStreamPartSize = 64 << 20
+
+	defaultUploadPartSize    = 16 << 20
+	defaultUploadConcurrency = 4
+
+	defaultDownloadPartSize    = 16 << 20
+	defaultDownloadConcurrency = 4
+)
+
+// ParallelUploadOptions configures S3Store.UploadParallel.
+type ParallelUploadOptions struct {
+	// PartSize is the size of every part but the last one.
+	// Defaults to 16MiB and must be at least 5MiB.
+	PartSize int64
+	// Concurrency is the number of parts uploaded at the same time.
+	// Defaults to 4.
+	Concurrency int
+	// PartRetries is the number of times a failed part is retried before
+	// the whole upload is aborted. Like S3Config.MaxRetries, zero doesn't
+	// retry.
+	PartRetries int
+}
+
+func (o ParallelUploadOptions) withDefaults() ParallelUploadOptions {
+	if o.PartSize == 0 {
+		o.PartSize = defaultUploadPartSize
+	}
+	if o.Concurrency <= 0 {
+		o.Concurrency = defaultUploadConcurrency
+	}
+	if o.PartRetries < 0 {
+		o.PartRetries = 0
+	}
+	return o
+}
+
+// UploadParallel uploads size bytes read from r as a multipart upload,
+// sending up to opts.Concurrency parts at the same time.
+// Parts may finish in any order; they're sorted by part number before the
+// upload is completed. A failed part is retried on its own without
+// restarting the upload. If a part keeps failing, the upload is aborted.
+func (s *S3Store) UploadParallel(r io.ReaderAt, size int64, key string, opts ParallelUploadOptions) (err error) {
+	if !s.configured() {
+		return S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	opts = opts.withDefaults()
+	if opts.PartSize < minUploadPartSize {
+		return fmt.Errorf("part size %d is below the minimum of %d", opts.PartSize, minUploadPartSize)
+	}
+	start := time.Now()
+	key = objectKey(key)
+	ctx, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+
+	core := minio.Core{Client: s.client}
+	uploadID, err := core.NewMultipartUpload(ctx, s.cfg.Bucket, key, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("new multipart upload: %w", classifyS3WriteError(err))
+	}
+	completed := false
+	defer func() {
+		if err != nil && !completed {
+			if abortErr := core.AbortMultipartUpload(context.TODO(), s.cfg.Bucket, key, uploadID); abortErr != nil {
+				s.log.Errorf("abort multipart upload %s failed: %v", uploadID, abortErr)
+			}
+		}
+	}()
+
+	numParts := int((size + opts.PartSize - 1) / opts.PartSize)
+	if numParts == 0 {
+		numParts = 1
+	}
+	var (
+		lk       sync.Mutex
+		etags    = make(map[int]string, numParts)
+		firstErr error
+		wg       sync.WaitGroup
+		partsCh  = make(chan int)
+	)
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partsCh {
+				etag, partErr := s.uploadPart(ctx, core, r, size, key, uploadID, partNumber, opts)
+				lk.Lock()
+				if partErr != nil {
+					if firstErr == nil {
+						firstErr = partErr
+						cancel()
+					}
+				} else {
+					etags[partNumber] = etag
+				}
+				lk.Unlock()
+			}
+		}()
+	}
+	for partNumber := 1; partNumber <= numParts; partNumber++ {
+		if ctx.Err() != nil {
+			break
+		}
+		partsCh <- partNumber
+	}
+	close(partsCh)
+	wg.Wait()
+	if firstErr != nil {
+		return firstErr
+	}
+
+	parts := make([]minio.CompletePart, 0, len(etags))
+	for partNumber, etag := range etags {
+		parts = append(parts, minio.CompletePart{PartNumber: partNumber, ETag: etag})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	info, err := core.CompleteMultipartUpload(ctx, s.cfg.Bucket, key, uploadID, parts, minio.PutObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("complete multipart upload: %w", classifyS3WriteError(err))
+	}
+	completed = true
+	s.log.Debugw("uploaded parallel", "key", key, "size", size, "parts", len(parts), "etag", info.ETag, "took", time.Since(start))
+	return s.waitVisible(key)
+}
+
+// uploadPart uploads a single part, retrying it up to opts.PartRetries times.
+func (s *S3Store) uploadPart(ctx context.Context, core minio.Core, r io.ReaderAt, size int64, key, uploadID string, partNumber int, opts ParallelUploadOptions) (string, error) {
+	offset := int64(partNumber-1) * opts.PartSize
+	length := opts.PartSize
+	if offset+length > size {
+		length = size - offset
+	}
+	var err error
+	for attempt := 0; attempt <= opts.PartRetries; attempt++ {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		var part minio.ObjectPart
+		part, err = core.PutObjectPart(ctx, s.cfg.Bucket, key, uploadID, partNumber,
+			io.NewSectionReader(r, offset, length), length, minio.PutObjectPartOptions{})
+		if err == nil {
+			return part.ETag, nil
+		}
+		s.log.Debugw("upload part failed", "key", key, "part", partNumber, "attempt", attempt, "err", err)
+	}
+	return "", fmt.Errorf("upload part %d: %w", partNumber, classifyS3WriteError(err))
+}
+
+// DownloadParallel downloads the object with up to concurrency range
+// requests at the same time, in parts of partSize bytes, and streams the
+// parts in order. At most concurrency parts are held in memory. The parts
+// are requested with the ETag the object had when the download started, so
+// an object replaced during the download fails it with
+// ErrPreconditionFailed instead of mixing both versions. Objects of a
+// single part are downloaded with DownloadReader. A partSize or concurrency
+// of 0 defaults to 16MiB and 4.
+func (s *S3Store) DownloadParallel(key string, partSize int64, concurrency int) (io.ReadCloser, error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	if partSize <= 0 {
+		partSize = defaultDownloadPartSize
+	}
+	if concurrency <= 0 {
+		concurrency = defaultDownloadConcurrency
+	}
+	stat, err := s.Stat(key)
+	if err != nil {
+		return nil, err
+	}
+	if stat.Size <= partSize || concurrency == 1 {
+		return s.DownloadReader(key)
+	}
+	ctx, cancel := context.WithCancel(context.TODO())
+	pr, pw := io.Pipe()
+	go func() {
+		// stop the parts still downloading if one fails
+		defer cancel()
+		_ = pw.CloseWithError(s.downloadParts(ctx, pw, objectKey(key), stat, partSize, concurrency))
+	}()
+	return &rangeReaderCloser{Reader: pr, closer: func() error {
+		cancel()
+		return pr.Close()
+	}}, nil
+}
+
+// downloadParts writes the parts of the object to w in order, keeping up to
+// concurrency parts in flight.
+func (s *S3Store) downloadParts(ctx context.Context, w io.Writer, key string, stat FileStat, partSize int64, concurrency int) error {
+	start := time.Now()
+	type result struct {
+		data []byte
+		err  error
+	}
+	var (
+		pending []chan result
+		offset  int64
+	)
+	for offset < stat.Size || len(pending) > 0 {
+		for offset < stat.Size && len(pending) < concurrency {
+			ch := make(chan result, 1)
+			length := min(partSize, stat.Size-offset)
+			go func(offset int64) {
+				data, err := s.downloadPart(ctx, key, stat.ETag, offset, length)
+				ch <- result{data, err}
+			}(offset)
+			pending = append(pending, ch)
+			offset += length
+		}
+		res := <-pending[0]
+		pending = pending[1:]
+		if res.err != nil {
+			return res.err
+		}
+		if _, err := w.Write(res.data); err != nil {
+			return err
+		}
+	}
+	s.log.Debugw("downloaded parallel", "key", key, "size", stat.Size, "took", time.Since(start))
+	return nil
+}
+
+// downloadPart reads length bytes of the object at offset, retrying the
+// part on its own on transient errors.
+func (s *S3Store) downloadPart(ctx context.Context, key, etag string, offset, length int64) (data []byte, err error) {
+	opts := minio.GetObjectOptions{}
+	if err := opts.SetRange(offset, offset+length-1); err != nil {
+		return nil, fmt.Errorf("set range: %w", err)
+	}
+	if etag != "" {
+		if err := opts.SetMatchETag(etag); err != nil {
+			return nil, err
+		}
+	}
+	err = s.retry.Do(ctx, func() error {
+		obj, err := s.client.GetObject(ctx, s.cfg.Bucket, key, opts)
+		if err != nil {
+			return err
+		}
+		defer obj.Close() // nolint: errcheck
+		data, err = io.ReadAll(&s3Object{obj, s.limiter()})
+		return err
+	})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "PreconditionFailed" {
+			return nil, fmt.Errorf("object %s changed during the download: %w", key, ErrPreconditionFailed)
+		}
+		return nil, fmt.Errorf("download part at %d: %w", offset, classifyS3Error(err))
+	}
+	return data, nil
+}
@@ -0,0 +1,149 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestS3Store_UploadParallel_OutOfOrder(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	key := "test-upload-parallel.bin"
+	data := make([]byte, 3*minUploadPartSize+1024)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	var (
+		lk     sync.Mutex
+		failed bool
+	)
+	fake.setHook(func(r *http.Request) (int, string) {
+		switch r.URL.Query().Get("partNumber") {
+		case "1":
+			// make the first part finish last
+			time.Sleep(200 * time.Millisecond)
+		case "2":
+			lk.Lock()
+			defer lk.Unlock()
+			if !failed {
+				failed = true
+				return http.StatusForbidden, "AccessDenied"
+			}
+		}
+		return 0, ""
+	})
+
+	err := store.UploadParallel(bytes.NewReader(data), int64(len(data)), key, ParallelUploadOptions{
+		PartSize:    minUploadPartSize,
+		Concurrency: 4,
+		PartRetries: 1,
+	})
+	assert.NoError(t, err, "failed to upload in parallel")
+	assert.True(t, failed, "part 2 should have been retried")
+
+	obj, ok := fake.get("test-bucket", key)
+	assert.True(t, ok, "object should exist")
+	assert.True(t, bytes.Equal(data, obj.data), "assembled object mismatch")
+}
+
+func TestS3Store_UploadParallel_AbortOnFailure(t *testing.T) {
+	for _, retries := range []int{0, 1} {
+		store, fake := newFakeS3Store(t)
+		key := "test-upload-parallel-abort.bin"
+		data := make([]byte, 2*minUploadPartSize)
+		var attempts atomic.Int32
+		fake.setHook(func(r *http.Request) (int, string) {
+			if r.URL.Query().Get("partNumber") == "2" {
+				attempts.Add(1)
+				return http.StatusForbidden, "AccessDenied"
+			}
+			return 0, ""
+		})
+
+		err := store.UploadParallel(bytes.NewReader(data), int64(len(data)), key, ParallelUploadOptions{
+			PartSize:    minUploadPartSize,
+			PartRetries: retries,
+		})
+		assert.Error(t, err, "expected upload to fail")
+		assert.Equal(t, int32(retries+1), attempts.Load())
+		_, ok := fake.get("test-bucket", key)
+		assert.False(t, ok, "object should not exist")
+		assert.Zero(t, fake.pendingUploads(), "multipart upload should be aborted")
+	}
+}
+
+func TestS3Store_DownloadParallel(t *testing.T) {
+	s, f := newFakeS3Store(t)
+	data := make([]byte, 10*1024+100)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	f.put("test-bucket", "big", data)
+
+	var ranges atomic.Int32
+	f.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" {
+			ranges.Add(1)
+		}
+		return 0, ""
+	})
+	r, err := s.DownloadParallel("big", 1024, 3)
+	assert.NoError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+	assert.Equal(t, int32(11), ranges.Load())
+
+	// a single part is a plain download
+	ranges.Store(0)
+	r, err = DownloadParallel(s, "big", int64(len(data)), 3)
+	assert.NoError(t, err)
+	got, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, data, got)
+	assert.Zero(t, ranges.Load())
+
+	_, err = s.DownloadParallel("missing", 1024, 3)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestS3Store_DownloadParallel_Changed(t *testing.T) {
+	s, f := newFakeS3Store(t)
+	data := make([]byte, 4*1024)
+	f.put("test-bucket", "big", data)
+
+	var replaced atomic.Bool
+	f.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodGet && r.Header.Get("Range") != "" && !replaced.Swap(true) {
+			f.put("test-bucket", "big", []byte("replaced"))
+		}
+		return 0, ""
+	})
+	r, err := s.DownloadParallel("big", 1024, 2)
+	assert.NoError(t, err)
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, ErrPreconditionFailed)
+	assert.NoError(t, r.Close())
+}
+
+func TestDownloadParallel_OSStore(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	s := &Store{osStore: NewOSStore()}
+	assert.NoError(t, s.UploadData([]byte("content"), file))
+
+	r, err := s.DownloadParallel(file, 2, 4)
+	assert.NoError(t, err)
+	got, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.NoError(t, r.Close())
+	assert.Equal(t, "content", string(got))
+}
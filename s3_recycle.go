@@ -0,0 +1,264 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// RecycleRepairPolicy decides which copy RepairRecycle removes when an object
+// is found both live and in the recycle bin.
+type RecycleRepairPolicy string
+
+const (
+	// RepairCompleteDelete removes the live object, completing the delete.
+	RepairCompleteDelete RecycleRepairPolicy = "complete_delete"
+	// RepairKeepLive removes the recycle copy, undoing the delete.
+	RepairKeepLive RecycleRepairPolicy = "keep_live"
+)
+
+// Metadata recorded on recycle copies. Values are query-escaped since
+// metadata is sent as HTTP headers.
+const (
+	recycleMetaOriginalKey = "Store-Original-Key"
+	recycleMetaDeletedAt   = "Store-Deleted-At"
+	recycleMetaReason      = "Store-Delete-Reason"
+)
+
+// DeleteResult describes how S3Store.DeleteObject deleted an object.
+type DeleteResult struct {
+	// Recycled reports whether the object was copied to the recycle bin.
+	// It's false in a bucket with versioning enabled.
+	Recycled bool
+	// DeleteMarkerVersionID is the version ID of the delete marker that
+	// replaced the object in a bucket with versioning enabled.
+	DeleteMarkerVersionID string
+	// Size of the deleted object.
+	Size int64
+}
+
+// RecycledObject is an object in the recycle bin.
+type RecycledObject struct {
+	// Key is the key of the recycle copy.
+	Key string
+	// OriginalKey is the key the object was deleted from.
+	OriginalKey string
+	// DeletedAt is the time of the deletion, zero if it wasn't recorded.
+	DeletedAt time.Time
+	// Reason is the reason given to DeleteWithReason.
+	Reason string
+	Size   int64
+}
+
+// recycleMetadata returns the metadata of the recycle copy of an object,
+// keeping its content type and user metadata.
+func recycleMetadata(stat minio.ObjectInfo, key string, reason string) map[string]string {
+	meta := objectMetadata(stat)
+	meta[recycleMetaOriginalKey] = url.QueryEscape(key)
+	meta[recycleMetaDeletedAt] = now().UTC().Format(time.RFC3339)
+	if reason != "" {
+		meta[recycleMetaReason] = url.QueryEscape(reason)
+	}
+	return meta
+}
+
+// ListRecycled lists the objects deleted from under prefix along with the
+// deletion details recorded on their recycle copies. The details are in the
+// metadata of the copies, which S3 listings don't return, so it makes a
+// StatObject request per object on top of the listing.
+func (s *S3Store) ListRecycled(prefix string) (objects []RecycledObject, err error) {
+	if !s.configured() {
+		return nil, S3NotConfigError
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("listed recycled", "prefix", prefix, "count", len(objects), "took", time.Since(start))
+	}()
+	opts := minio.ListObjectsOptions{
+		Prefix:    recyclePath + objectKey(prefix),
+		Recursive: true,
+	}
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		stat, err := s.statObject(obj.Key)
+		if err != nil {
+			return fmt.Errorf("stat object %s: %w", obj.Key, err)
+		}
+		objects = append(objects, recycledObject(stat))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+func recycledObject(stat minio.ObjectInfo) RecycledObject {
+	o := RecycledObject{
+		Key:         stat.Key,
+		OriginalKey: strings.TrimPrefix(stat.Key, recyclePath),
+		Size:        stat.Size,
+	}
+	meta := stat.UserMetadata
+	if v, err := url.QueryUnescape(meta[recycleMetaOriginalKey]); err == nil && v != "" {
+		o.OriginalKey = v
+	}
+	if t, err := time.Parse(time.RFC3339, meta[recycleMetaDeletedAt]); err == nil {
+		o.DeletedAt = t
+	}
+	if v, err := url.QueryUnescape(meta[recycleMetaReason]); err == nil {
+		o.Reason = v
+	}
+	return o
+}
+
+// RepairRecycle finds the objects under prefix left both live and in the
+// recycle bin by an interrupted Delete, and removes one of the copies
+// according to S3Config.RecycleRepairPolicy. Only pairs with the same size
+// and ETag are repaired, so an object uploaded again after being deleted is
+// left alone. It returns the number of objects repaired.
+func (s *S3Store) RepairRecycle(prefix string) (repaired int, err error) {
+	if !s.configured() {
+		return 0, S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("repaired recycle", "prefix", prefix, "repaired", repaired, "took", time.Since(start))
+	}()
+	policy := s.cfg.RecycleRepairPolicy
+	switch policy {
+	case "":
+		policy = RepairCompleteDelete
+	case RepairCompleteDelete, RepairKeepLive:
+	default:
+		return 0, fmt.Errorf("invalid recycle repair policy: %s", policy)
+	}
+
+	opts := minio.ListObjectsOptions{
+		Prefix:    recyclePath + objectKey(prefix),
+		Recursive: true,
+	}
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		key := strings.TrimPrefix(obj.Key, recyclePath)
+		live, err := s.statObject(key)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil
+			}
+			return fmt.Errorf("stat object %s: %w", key, err)
+		}
+		if live.Size != obj.Size || live.ETag != obj.ETag {
+			s.log.Debugw("live object differs from recycle copy", "key", key)
+			return nil
+		}
+		remove := key
+		if policy == RepairKeepLive {
+			remove = obj.Key
+		}
+		if err := s.removeObject(remove); err != nil {
+			return fmt.Errorf("remove object %s: %w", remove, err)
+		}
+		s.log.Infow("repaired interrupted delete", "key", key, "removed", remove)
+		repaired++
+		return nil
+	})
+	return repaired, err
+}
+
+// PurgeRecycle permanently removes the objects that have been in the recycle
+// bin for longer than olderThan, going by the LastModified of the recycle
+// copies, and returns the number of objects removed. Each object is stat'ed
+// again right before its removal, so a copy refreshed by a Delete running
+// concurrently is kept. Objects already gone are skipped, which makes it safe
+// to run from several processes at once.
+func (s *S3Store) PurgeRecycle(olderThan time.Duration) (purged int, err error) {
+	if !s.configured() {
+		return 0, S3NotConfigError
+	}
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	if olderThan < 0 {
+		return 0, fmt.Errorf("invalid retention period %s", olderThan)
+	}
+	start := time.Now()
+	defer func() {
+		s.log.Debugw("purged recycle", "older_than", olderThan, "purged", purged, "took", time.Since(start))
+	}()
+	cutoff := now().Add(-olderThan)
+
+	opts := minio.ListObjectsOptions{
+		Prefix:    recyclePath,
+		Recursive: true,
+	}
+	err = s.listObjects(context.TODO(), opts, func(obj minio.ObjectInfo) error {
+		if !obj.LastModified.Before(cutoff) {
+			return nil
+		}
+		stat, err := s.statObject(obj.Key)
+		if err != nil {
+			if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+				return nil
+			}
+			return fmt.Errorf("stat object %s: %w", obj.Key, classifyS3Error(err))
+		}
+		if !stat.LastModified.Before(cutoff) {
+			s.log.Debugw("recycle copy refreshed", "key", obj.Key)
+			return nil
+		}
+		if err := s.removeObject(obj.Key); err != nil {
+			return fmt.Errorf("remove object %s: %w", obj.Key, classifyS3WriteError(err))
+		}
+		s.log.Debugw("purged recycled object", "key", obj.Key, "size", obj.Size, "last_modified", obj.LastModified)
+		purged++
+		return nil
+	})
+	return purged, err
+}
+
+// isVersioned reports whether versioning is enabled on the bucket. The
+// answer is cached once the bucket has been checked; an error is logged and
+// taken as unversioned for this delete, which keeps the recycle copy, and
+// the bucket is checked again on the next one.
+func (s *S3Store) isVersioned() bool {
+	s.versioningLk.Lock()
+	defer s.versioningLk.Unlock()
+	if s.versioningKnown {
+		return s.versioned
+	}
+	cfg, err := s.client.GetBucketVersioning(context.TODO(), s.cfg.Bucket)
+	if err != nil {
+		s.log.Warnw("failed to get bucket versioning, deleting with a recycle copy", "bucket", s.cfg.Bucket, "error", err)
+		return false
+	}
+	s.versioned = cfg.Enabled()
+	s.versioningKnown = true
+	return s.versioned
+}
+
+// removeVersioned removes the object from a versioned bucket, returning the
+// version ID of the delete marker. RemoveObject doesn't return it, so the
+// object is removed with a multi-object delete of one.
+func (s *S3Store) removeVersioned(key string) (versionID string, err error) {
+	err = s.retry.Do(context.TODO(), func() error {
+		objectsCh := make(chan minio.ObjectInfo, 1)
+		objectsCh <- minio.ObjectInfo{Key: key}
+		close(objectsCh)
+		var err error
+		for res := range s.client.RemoveObjectsWithResult(context.TODO(), s.cfg.Bucket, objectsCh, minio.RemoveObjectsOptions{}) {
+			// keep consuming so the removal goroutine can exit
+			if res.Err != nil && err == nil {
+				err = res.Err
+			}
+			versionID = res.DeleteMarkerVersionID
+		}
+		return err
+	})
+	return versionID, err
+}
@@ -0,0 +1,245 @@
+package store
+
+import (
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newInterruptedDeleteStore creates a fake S3 store holding:
+//   - a/x: left both live and recycled by an interrupted delete
+//   - a/y: properly deleted
+//   - a/z: uploaded again with new content after being deleted
+//   - b/x: left both live and recycled, outside the repaired prefix
+func newInterruptedDeleteStore(t *testing.T) (*S3Store, *fakeS3) {
+	store, fake := newFakeS3Store(t)
+	for _, key := range []string{"a/x", "_recycle/a/x", "_recycle/a/y", "_recycle/a/z", "b/x", "_recycle/b/x"} {
+		fake.put("test-bucket", key, []byte("old"))
+	}
+	fake.put("test-bucket", "a/z", []byte("new"))
+	return store, fake
+}
+
+func TestS3Store_RepairRecycle(t *testing.T) {
+	store, fake := newInterruptedDeleteStore(t)
+
+	repaired, err := store.RepairRecycle("a/")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+	assert.Equal(t, []string{"_recycle/a/x", "_recycle/a/y", "_recycle/a/z", "_recycle/b/x", "a/z", "b/x"}, fake.keys("test-bucket"))
+}
+
+func TestS3Store_RepairRecycle_KeepLive(t *testing.T) {
+	store, fake := newInterruptedDeleteStore(t)
+	store.cfg.RecycleRepairPolicy = RepairKeepLive
+
+	repaired, err := store.RepairRecycle("/a/")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, repaired)
+	assert.Equal(t, []string{"_recycle/a/y", "_recycle/a/z", "_recycle/b/x", "a/x", "a/z", "b/x"}, fake.keys("test-bucket"))
+}
+
+func TestS3Store_RepairRecycle_InvalidPolicy(t *testing.T) {
+	store, _ := newInterruptedDeleteStore(t)
+	store.cfg.RecycleRepairPolicy = "unknown"
+
+	_, err := store.RepairRecycle("a/")
+	assert.Error(t, err)
+}
+
+func TestS3Store_DeleteWithReason(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	clock := newFakeClock(t, time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, store.UploadData([]byte("content"), "dir/file.json"))
+	assert.NoError(t, store.UploadData([]byte("other"), "dir/other.txt"))
+
+	assert.NoError(t, store.DeleteWithReason("dir/file.json", "gdpr request #42"))
+	clock.Advance(time.Hour)
+	assert.NoError(t, store.Delete("dir/other.txt"))
+
+	recycled, err := store.ListRecycled("dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, []RecycledObject{
+		{
+			Key:         "_recycle/dir/file.json",
+			OriginalKey: "dir/file.json",
+			DeletedAt:   time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+			Reason:      "gdpr request #42",
+			Size:        7,
+		},
+		{
+			Key:         "_recycle/dir/other.txt",
+			OriginalKey: "dir/other.txt",
+			DeletedAt:   time.Date(2024, 5, 1, 13, 0, 0, 0, time.UTC),
+			Size:        5,
+		},
+	}, recycled)
+
+	obj, ok := fake.get("test-bucket", "_recycle/dir/file.json")
+	assert.True(t, ok)
+	assert.Equal(t, "application/json", obj.contentType, "recycle copy should keep the content type")
+}
+
+func TestS3Store_PurgeRecycle(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	clock := newFakeClock(t, time.Now())
+	for _, key := range []string{"a/x", "_recycle/a/x", "_recycle/a/y", "_recycle/b/z"} {
+		fake.put("test-bucket", key, []byte("data"))
+	}
+	fake.setModified("test-bucket", "a/x", clock.Now().Add(-72*time.Hour))
+	fake.setModified("test-bucket", "_recycle/a/x", clock.Now().Add(-72*time.Hour))
+	fake.setModified("test-bucket", "_recycle/b/z", clock.Now().Add(-48*time.Hour))
+
+	purged, err := store.PurgeRecycle(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, purged)
+	assert.Equal(t, []string{"_recycle/a/y", "a/x"}, fake.keys("test-bucket"))
+
+	purged, err = store.PurgeRecycle(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged, "purging again is a no-op")
+
+	clock.Advance(48 * time.Hour)
+	purged, err = store.PurgeRecycle(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	assert.Equal(t, []string{"a/x"}, fake.keys("test-bucket"))
+
+	_, err = store.PurgeRecycle(-time.Hour)
+	assert.Error(t, err)
+}
+
+func TestS3Store_PurgeRecycle_Refreshed(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	fake.put("test-bucket", "_recycle/a/x", []byte("data"))
+	fake.setModified("test-bucket", "_recycle/a/x", time.Now().Add(-72*time.Hour))
+	// the object is deleted again between the listing and the removal
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead {
+			fake.setModified("test-bucket", "_recycle/a/x", time.Now())
+		}
+		return 0, ""
+	})
+
+	purged, err := store.PurgeRecycle(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	assert.Equal(t, []string{"_recycle/a/x"}, fake.keys("test-bucket"))
+}
+
+func TestS3Store_DeleteObject_Versioned(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	fake.setVersioning("test-bucket", true)
+	assert.NoError(t, store.UploadData([]byte("content"), "dir/a.txt"))
+	assert.NoError(t, store.UploadData([]byte("other"), "dir/b.txt"))
+
+	res, err := store.DeleteObject("dir/a.txt", "")
+	assert.NoError(t, err)
+	assert.False(t, res.Recycled)
+	assert.Equal(t, "marker-1", res.DeleteMarkerVersionID)
+	assert.Equal(t, int64(7), res.Size)
+
+	assert.NoError(t, store.DeleteDirectory("dir"))
+	assert.Empty(t, fake.keys("test-bucket"), "no recycle copy in a versioned bucket")
+
+	_, err = store.DeleteObject("dir/a.txt", "")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestS3Store_DeleteObject_Unversioned(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	fake.setVersioning("test-bucket", false)
+	assert.NoError(t, store.UploadData([]byte("content"), "a.txt"))
+
+	res, err := store.DeleteObject("a.txt", "")
+	assert.NoError(t, err)
+	assert.True(t, res.Recycled)
+	assert.Empty(t, res.DeleteMarkerVersionID)
+	assert.Equal(t, []string{"_recycle/a.txt"}, fake.keys("test-bucket"))
+}
+
+func TestS3Store_DeleteObject_VersioningUnknown(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.URL.Query().Has("versioning") {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+	assert.NoError(t, store.UploadData([]byte("content"), "a.txt"))
+
+	res, err := store.DeleteObject("a.txt", "")
+	assert.NoError(t, err)
+	assert.True(t, res.Recycled, "unknown versioning keeps the recycle copy")
+	assert.Equal(t, []string{"_recycle/a.txt"}, fake.keys("test-bucket"))
+
+	// the error isn't cached, the next delete checks the bucket again
+	fake.setHook(nil)
+	fake.setVersioning("test-bucket", true)
+	assert.NoError(t, store.UploadData([]byte("content"), "b.txt"))
+	res, err = store.DeleteObject("b.txt", "")
+	assert.NoError(t, err)
+	assert.False(t, res.Recycled)
+	assert.Equal(t, []string{"_recycle/a.txt"}, fake.keys("test-bucket"))
+}
+
+// assertListingStopped asserts that no minio listing goroutine is left
+// running, blocked on sending objects nobody reads.
+func assertListingStopped(t *testing.T) {
+	t.Helper()
+	assert.Eventually(t, func() bool {
+		buf := make([]byte, 1<<20)
+		return !strings.Contains(string(buf[:runtime.Stack(buf, true)]), "minio-go/v7.(*Client).listObjects")
+	}, time.Second, 10*time.Millisecond, "the listing goroutine leaked")
+}
+
+func TestS3Store_RepairRecycle_Error(t *testing.T) {
+	store, fake := newInterruptedDeleteStore(t)
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead && r.URL.Path == "/test-bucket/a/x" {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+
+	_, err := store.RepairRecycle("a/")
+	assert.Error(t, err)
+	assertListingStopped(t)
+}
+
+func TestS3Store_ListRecycled_Error(t *testing.T) {
+	store, fake := newInterruptedDeleteStore(t)
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead && r.URL.Path == "/test-bucket/_recycle/a/x" {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+
+	_, err := store.ListRecycled("a/")
+	assert.Error(t, err)
+	assertListingStopped(t)
+}
+
+func TestS3Store_PurgeRecycle_Error(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	clock := newFakeClock(t, time.Now())
+	for _, key := range []string{"_recycle/a/x", "_recycle/a/y", "_recycle/a/z"} {
+		fake.put("test-bucket", key, []byte("data"))
+		fake.setModified("test-bucket", key, clock.Now().Add(-72*time.Hour))
+	}
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodDelete {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+
+	_, err := store.PurgeRecycle(24 * time.Hour)
+	assert.Error(t, err)
+	assertListingStopped(t)
+}
@@ -2,10 +2,24 @@ package store
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/minio/minio-go/v7"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -17,9 +31,13 @@ func setupS3Store(t *testing.T) *S3Store {
 		AccessKey: "minioadmin",
 		SecretKey: "minioadmin",
 		UseSSL:    false,
+
+		CreateBucketIfNotExists: true,
 	}
 	store, err := NewS3Store(cfg)
-	assert.NoError(t, err, "failed to create S3Store")
+	if !assert.NoError(t, err, "failed to create S3Store") {
+		t.FailNow()
+	}
 	return store.(*S3Store)
 }
 
@@ -98,6 +116,29 @@ func TestS3Store_Exists(t *testing.T) {
 	}()
 }
 
+func TestS3Store_Exists_Error(t *testing.T) {
+	disableMinioRetries(t)
+	store, fake := newFakeS3Store(t)
+	exists, err := store.Exists("missing.txt")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	for _, status := range []int{http.StatusForbidden, http.StatusInternalServerError} {
+		fake.setHook(func(r *http.Request) (int, string) {
+			if r.Method == http.MethodHead {
+				return status, ""
+			}
+			return 0, ""
+		})
+		exists, err := store.Exists("missing.txt")
+		assert.Error(t, err, "status %d must not be reported as a missing object", status)
+		assert.False(t, exists)
+		if status == http.StatusForbidden {
+			assert.ErrorIs(t, err, ErrPermissionDenied)
+		}
+	}
+}
+
 func TestS3Store_DownloadBytes(t *testing.T) {
 	store := setupS3Store(t)
 	data := []byte("test content")
@@ -188,3 +229,889 @@ func TestS3Store_ListPrefix(t *testing.T) {
 		assert.NoError(t, err, "failed to delete key during cleanup")
 	}()
 }
+
+var testS3Config = S3Config{
+	Endpoint:  "localhost:9000",
+	Region:    "us-east-1",
+	Bucket:    "bucket1",
+	AccessKey: "key1",
+	SecretKey: "secret1",
+	UseSSL:    true,
+}
+
+func TestLoadS3Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"config.json", `{
+    "endpoint": "localhost:9000",
+    "region": "us-east-1",
+    "bucket": "bucket1",
+    "access_key": "key1",
+    "secret_key": "secret1",
+    "use_ssl": true
+}`},
+		{"config.toml", `
+endpoint = "localhost:9000"
+region = "us-east-1"
+bucket = "bucket1"
+access_key = "key1"
+secret_key = "secret1"
+use_ssl = true
+`},
+		{"config.yaml", `
+endpoint: localhost:9000
+region: us-east-1
+bucket: bucket1
+access_key: key1
+secret_key: secret1
+use_ssl: true
+`},
+		{"config.yml", `
+endpoint: localhost:9000
+region: us-east-1
+bucket: bucket1
+access_key: key1
+secret_key: secret1
+use_ssl: true
+`},
+	}
+
+	for _, test := range tests {
+		cfgPath := filepath.Join(t.TempDir(), test.name)
+		err := os.WriteFile(cfgPath, []byte(test.content), 0644)
+		assert.NoError(t, err, "failed to write config file")
+
+		cfg, err := LoadS3Config(cfgPath)
+		assert.NoError(t, err, "failed to load config: %s", test.name)
+		assert.Equal(t, testS3Config, *cfg, "unexpected config: %s", test.name)
+	}
+}
+
+func TestLoadS3Config_Env(t *testing.T) {
+	t.Setenv("STORE_TEST_ACCESS_KEY", "key1")
+	t.Setenv("STORE_TEST_SECRET_KEY", "secret1")
+	content := `
+endpoint: localhost:9000
+region: us-east-1
+bucket: bucket1
+access_key: ${STORE_TEST_ACCESS_KEY}
+secret_key: $STORE_TEST_SECRET_KEY
+use_ssl: true
+content_type_by_ext:
+  .car: application/$$car
+`
+	cfg, err := LoadS3ConfigFromReader(strings.NewReader(content), "yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, "key1", cfg.AccessKey)
+	assert.Equal(t, "secret1", cfg.SecretKey)
+	assert.Equal(t, "application/$car", cfg.ContentTypeByExt[".car"])
+
+	_, err = LoadS3ConfigFromReader(strings.NewReader("secret_key: ${STORE_TEST_UNSET}"), "yaml")
+	assert.ErrorContains(t, err, "STORE_TEST_UNSET")
+}
+
+func TestLoadS3Config_InvalidFormat(t *testing.T) {
+	cfgPath := filepath.Join(t.TempDir(), "config.ini")
+	err := os.WriteFile(cfgPath, []byte("endpoint=localhost"), 0644)
+	assert.NoError(t, err, "failed to write config file")
+
+	_, err = LoadS3Config(cfgPath)
+	assert.Error(t, err, "expected error for unsupported format")
+}
+
+func TestS3Store_ListRollup(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	for key, content := range map[string]string{
+		"root/top.txt":     "1",
+		"root/a/one.txt":   "22",
+		"root/a/b/two.txt": "333",
+		"root/a/b/c/3.txt": "4444",
+		"other/x.txt":      "55555",
+	} {
+		fake.put("test-bucket", key, []byte(content))
+	}
+
+	entries, err := store.ListRollup("root/", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []RollupEntry{
+		{Prefix: "root/a/", IsDir: true, Count: 3, Size: 9},
+		{Prefix: "root/top.txt", Count: 1, Size: 1},
+	}, entries)
+
+	entries, err = store.ListRollup("root/", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []RollupEntry{
+		{Prefix: "root/a/b/", IsDir: true, Count: 2, Size: 7},
+		{Prefix: "root/a/one.txt", Count: 1, Size: 2},
+		{Prefix: "root/top.txt", Count: 1, Size: 1},
+	}, entries)
+}
+
+func TestLoadS3ConfigFromReader(t *testing.T) {
+	content := `{"endpoint": "localhost:9000", "bucket": "bucket1", "access_key": "key1"}`
+	cfg, err := LoadS3ConfigFromReader(strings.NewReader(content), "json")
+	assert.NoError(t, err, "failed to load config")
+	assert.Equal(t, "localhost:9000", cfg.Endpoint, "unexpected endpoint")
+	assert.Equal(t, "key1", cfg.AccessKey, "unexpected access key")
+
+	_, err = LoadS3ConfigFromReader(strings.NewReader(content), "ini")
+	assert.Error(t, err, "expected error for unsupported format")
+}
+
+func TestS3Store_Delete_RemoveFailureRollsBack(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	key := "test-delete-fail.txt"
+	fake.put("test-bucket", key, []byte("test content"))
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodDelete && r.URL.Path == "/test-bucket/"+key {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+
+	err := store.Delete(key)
+	assert.Error(t, err, "expected remove failure")
+	assert.Contains(t, err.Error(), key, "error should name the key")
+
+	_, ok := fake.get("test-bucket", key)
+	assert.True(t, ok, "original object should still exist")
+	_, ok = fake.get("test-bucket", recyclePath+key)
+	assert.False(t, ok, "recycle copy should be rolled back")
+}
+
+func TestS3Store_DownloadRangeBytes_PastEOF(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	key := "test-download-range-eof.txt"
+	data := []byte("test content")
+	fake.put("test-bucket", key, data)
+
+	content, err := store.DownloadRangeBytes(key, 5, 100)
+	assert.NoError(t, err, "size past EOF should be clamped")
+	assert.Equal(t, data[5:], content)
+
+	content, err = store.DownloadRangeBytes(key, int64(len(data)), 4)
+	assert.NoError(t, err, "offset at EOF should not error")
+	assert.Empty(t, content)
+
+	content, err = store.DownloadRangeBytes(key, 5, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, data[5:], content, "size -1 should read to the end")
+
+	content, err = store.DownloadRangeBytes(key, 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, data, content, "size -1 from offset 0 should read everything")
+}
+
+func TestS3Store_UploadData_ContentTypeByExt(t *testing.T) {
+	store, _ := newFakeS3Store(t)
+	store.cfg.ContentTypeByExt = map[string]string{
+		".car": "application/vnd.ipld.car",
+	}
+
+	err := store.UploadData([]byte(`{"a": 1}`), "test-content-type.json")
+	assert.NoError(t, err, "failed to upload data")
+	stat, err := store.Stat("test-content-type.json")
+	assert.NoError(t, err, "failed to stat object")
+	assert.Equal(t, "application/json", stat.ContentType)
+
+	err = store.UploadData([]byte("car"), "test-content-type.car")
+	assert.NoError(t, err, "failed to upload data")
+	stat, err = store.Stat("test-content-type.car")
+	assert.NoError(t, err, "failed to stat object")
+	assert.Equal(t, "application/vnd.ipld.car", stat.ContentType)
+}
+
+func TestS3Store_DownloadRangeReader_ToEnd(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	key := "test-download-range-to-end.txt"
+	data := []byte("test content")
+	fake.put("test-bucket", key, data)
+
+	r, err := store.DownloadRangeReader(key, 5, -1)
+	assert.NoError(t, err)
+	content, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data[5:], content)
+	assert.NoError(t, r.Close())
+}
+
+func TestS3Store_VerifyWritable(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	cfg := fake.config("test-bucket")
+	cfg.VerifyWritable = true
+
+	_, err := NewS3Store(cfg)
+	assert.NoError(t, err)
+	assert.Empty(t, fake.keys("test-bucket"), "health check object should be removed")
+}
+
+func TestS3Store_VerifyWritable_ReadOnly(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut || r.Method == http.MethodDelete {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+	cfg := fake.config("test-bucket")
+	cfg.VerifyWritable = true
+
+	s, err := NewS3Store(cfg)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.Nil(t, s)
+}
+
+func TestS3Store_VerifyWritable_AuthFailed(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	fake.setHook(func(r *http.Request) (int, string) {
+		return http.StatusForbidden, "InvalidAccessKeyId"
+	})
+	cfg := fake.config("test-bucket")
+	cfg.VerifyWritable = true
+
+	_, err := NewS3Store(cfg)
+	assert.ErrorIs(t, err, ErrAuthFailed)
+}
+
+func TestS3Store_AccessDenied(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	assert.NoError(t, store.UploadData([]byte("content"), "a.txt"))
+	fake.setHook(func(r *http.Request) (int, string) {
+		return http.StatusForbidden, "AccessDenied"
+	})
+
+	// a denied read says nothing about write access
+	_, err := store.DownloadBytes("a.txt")
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+	assert.NotErrorIs(t, err, ErrReadOnly)
+	_, err = store.Stat("a.txt")
+	assert.ErrorIs(t, err, ErrPermissionDenied)
+
+	assert.ErrorIs(t, store.UploadData([]byte("content"), "b.txt"), ErrReadOnly)
+}
+
+func TestS3Store_GetMetadata(t *testing.T) {
+	store, _ := newFakeS3Store(t)
+	var result UploadResult
+	assert.NoError(t, store.UploadData([]byte("hello"), "meta/a.txt", ContentHash(ChecksumSHA256, &result)))
+
+	meta, err := GetMetadata(store, "meta/a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "text/plain; charset=utf-8", meta["Content-Type"])
+	assert.NotEmpty(t, meta[ChecksumSHA256.metadataKey()])
+
+	_, err = GetMetadata(store, "meta/missing.txt")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestS3Store_ListingStopped(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	for _, key := range []string{"list/a", "list/b", "list/c"} {
+		fake.put("test-bucket", key, []byte("x"))
+	}
+
+	empty, err := store.IsEmpty("list/")
+	assert.NoError(t, err)
+	assert.False(t, empty)
+	assertListingStopped(t)
+
+	var walked []string
+	err = store.WalkPrefix(context.Background(), "list/", func(obj ObjectStat) error {
+		walked = append(walked, obj.Key)
+		return SkipRemaining
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"list/a"}, walked)
+	assertListingStopped(t)
+}
+
+func TestS3Store_ListPrefixContext(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	for i := 0; i < 2500; i++ {
+		fake.put("test-bucket", fmt.Sprintf("list/%04d", i), []byte("x"))
+	}
+	keys, err := store.ListPrefixContext(context.Background(), "list/")
+	assert.NoError(t, err)
+	assert.Len(t, keys, 2500)
+
+	// Cancel when the second page of 1000 keys is requested: the third
+	// must not be.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var pages atomic.Int32
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodGet && r.URL.Query().Has("list-type") && pages.Add(1) == 2 {
+			cancel()
+		}
+		return 0, ""
+	})
+	keys, err = store.ListPrefixContext(ctx, "list/")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Nil(t, keys)
+	assert.LessOrEqual(t, pages.Load(), int32(2))
+}
+
+func TestS3Store_Suite(t *testing.T) {
+	store, _ := newFakeS3Store(t)
+	testAll(t, store, "suite")
+}
+
+func BenchmarkS3Store(b *testing.B) {
+	store, _ := newFakeS3Store(b)
+	benchAll(b, store, "bench")
+}
+
+// disableMinioRetries turns off the client's own retries so the store's
+// retry policy is observable.
+func disableMinioRetries(t *testing.T) {
+	prev := minio.MaxRetry
+	minio.MaxRetry = 1
+	t.Cleanup(func() {
+		minio.MaxRetry = prev
+	})
+}
+
+func TestS3Store_Retry(t *testing.T) {
+	disableMinioRetries(t)
+	store, fake := newFakeS3Store(t)
+	var slept []time.Duration
+	store.retry = RetryPolicy{MaxRetries: 3, Sleep: fakeSleep(newFakeClock(t, time.Now()), &slept)}
+
+	failures := 2
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut && failures > 0 {
+			failures--
+			return http.StatusServiceUnavailable, "ServiceUnavailable"
+		}
+		return 0, ""
+	})
+	assert.NoError(t, store.UploadData([]byte("content"), "retry.txt"))
+	assert.Len(t, slept, 2)
+
+	// Permission errors are not retried.
+	puts := 0
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut {
+			puts++
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+	assert.Error(t, store.UploadData([]byte("content"), "denied.txt"))
+	assert.Equal(t, 1, puts)
+}
+
+func TestS3Store_Retry_Conditional(t *testing.T) {
+	disableMinioRetries(t)
+	store, fake := newFakeS3Store(t)
+	var slept []time.Duration
+	store.retry = RetryPolicy{MaxRetries: 3, Sleep: fakeSleep(newFakeClock(t, time.Now()), &slept)}
+
+	// the object is written but the response is lost
+	puts := 0
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut {
+			puts++
+			fake.put("test-bucket", strings.TrimPrefix(r.URL.Path, "/test-bucket/"), []byte("content"))
+			return http.StatusServiceUnavailable, "ServiceUnavailable"
+		}
+		return 0, ""
+	})
+	for _, opt := range []UploadOption{Overwrite(false), IfNoneMatch("*"), IfMatch("etag")} {
+		puts = 0
+		err := store.UploadData([]byte("content"), "conditional.txt", opt)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, ErrAlreadyExists, "the retry must not conflict with the first attempt")
+		assert.NotErrorIs(t, err, ErrPreconditionFailed)
+		assert.Equal(t, 1, puts, "conditional uploads are not retried")
+	}
+	assert.Empty(t, slept)
+}
+
+func TestS3Store_ConsistencyWait(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	store.cfg.ConsistencyTimeout = 5 * time.Second
+
+	// The object is reported missing for the first two stats.
+	var stats int
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead && stats < 2 {
+			stats++
+			return http.StatusNotFound, "NoSuchKey"
+		}
+		return 0, ""
+	})
+	assert.NoError(t, store.UploadData([]byte("content"), "eventual.txt"))
+	assert.Equal(t, 2, stats)
+	exists, err := store.Exists("eventual.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestS3Store_ConsistencyWait_Timeout(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	store.cfg.ConsistencyTimeout = 50 * time.Millisecond
+
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodHead {
+			return http.StatusNotFound, "NoSuchKey"
+		}
+		return 0, ""
+	})
+	err := store.UploadData([]byte("content"), "never.txt")
+	assert.ErrorContains(t, err, "not visible")
+}
+
+func TestS3Store_ListPrefixDepth(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	for _, key := range []string{"root/top.txt", "root/a/one.txt", "root/a/b/two.txt", "root/a/b/c/three.txt", "root/a/b/c/d/four.txt", "rootless.txt"} {
+		fake.put("test-bucket", key, []byte(key))
+	}
+
+	keys, err := store.ListPrefixDepth("root", 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root/a/b/", "root/a/one.txt", "root/top.txt"}, keys)
+
+	keys, err = store.ListPrefixDepth("/root/", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root/a/", "root/top.txt"}, keys)
+
+	keys, err = store.ListPrefixDepth("", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"root/", "rootless.txt"}, keys)
+}
+
+func TestS3Store_Publish(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	var puts int
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut {
+			puts++
+		}
+		return 0, ""
+	})
+
+	err := store.Publish("site/index.html", []byte("<html></html>"), PublishOptions{
+		ACL:  "public-read",
+		Tags: map[string]string{"release": "v1"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, puts, "publish should be a single request")
+
+	obj, ok := fake.get("test-bucket", "site/index.html")
+	assert.True(t, ok)
+	assert.Equal(t, "public-read", obj.acl)
+	assert.Equal(t, "release=v1", obj.tags)
+	assert.Equal(t, "text/html; charset=utf-8", obj.contentType)
+}
+
+func TestS3Store_CreateBucketIfNotExists(t *testing.T) {
+	fake := newFakeS3(t)
+	cfg := fake.config("new-bucket")
+
+	_, err := NewS3Store(cfg)
+	assert.NoError(t, err, "the bucket isn't checked by default")
+	_, ok := fake.buckets["new-bucket"]
+	assert.False(t, ok)
+
+	cfg.CreateBucketIfNotExists = true
+	s, err := NewS3Store(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("content"), "file.txt"))
+	assert.Equal(t, []string{"file.txt"}, fake.keys("new-bucket"))
+
+	_, err = NewS3Store(cfg)
+	assert.NoError(t, err, "an existing bucket should be left alone")
+}
+
+func TestS3Store_CreateBucketIfNotExists_Denied(t *testing.T) {
+	fake := newFakeS3(t)
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut {
+			return http.StatusForbidden, "AccessDenied"
+		}
+		return 0, ""
+	})
+	cfg := fake.config("new-bucket")
+	cfg.CreateBucketIfNotExists = true
+
+	_, err := NewS3Store(cfg)
+	assert.ErrorIs(t, err, ErrReadOnly)
+	assert.ErrorContains(t, err, "create bucket new-bucket")
+}
+
+func TestS3Credentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-access")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	cfg := &S3Config{AccessKey: "static-access", SecretKey: "static-secret"}
+
+	for _, tc := range []struct {
+		source string
+		want   string
+	}{
+		{"", "static-access"},
+		{CredentialsStatic, "static-access"},
+		{CredentialsEnv, "env-access"},
+		{CredentialsChain, "static-access"},
+	} {
+		cfg.CredentialsSource = tc.source
+		creds, err := s3Credentials(cfg)
+		assert.NoError(t, err, tc.source)
+		v, err := creds.Get()
+		assert.NoError(t, err, tc.source)
+		assert.Equal(t, tc.want, v.AccessKeyID, tc.source)
+	}
+
+	cfg = &S3Config{CredentialsSource: CredentialsChain}
+	creds, err := s3Credentials(cfg)
+	assert.NoError(t, err)
+	v, err := creds.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, "env-access", v.AccessKeyID, "the chain should fall back to the environment")
+
+	cfg.CredentialsSource = "vault"
+	_, err = s3Credentials(cfg)
+	assert.Error(t, err)
+	_, err = NewS3Store(cfg)
+	assert.Error(t, err)
+}
+
+func TestS3BucketLookup(t *testing.T) {
+	for lookup, want := range map[string]minio.BucketLookupType{
+		"":     minio.BucketLookupAuto,
+		"auto": minio.BucketLookupAuto,
+		"path": minio.BucketLookupPath,
+		"DNS":  minio.BucketLookupDNS,
+	} {
+		got, err := s3BucketLookup(lookup)
+		assert.NoError(t, err, lookup)
+		assert.Equal(t, want, got, lookup)
+	}
+	_, err := s3BucketLookup("virtual")
+	assert.Error(t, err)
+
+	fake := newFakeS3(t, "test-bucket")
+	cfg := fake.config("test-bucket")
+	cfg.BucketLookup = "path"
+	s, err := NewS3Store(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("content"), "file.txt"))
+	assert.Equal(t, []string{"file.txt"}, fake.keys("test-bucket"))
+}
+
+func TestS3Store_TLS(t *testing.T) {
+	disableMinioRetries(t)
+	fake := newFakeS3(t, "test-bucket")
+	server := httptest.NewTLSServer(fake)
+	t.Cleanup(server.Close)
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	assert.NoError(t, os.WriteFile(caPath, caPEM, 0644))
+
+	newConfig := func() *S3Config {
+		cfg := fake.config("test-bucket")
+		cfg.Endpoint = strings.TrimPrefix(server.URL, "https://")
+		cfg.UseSSL = true
+		return cfg
+	}
+
+	s, err := NewS3Store(newConfig())
+	assert.NoError(t, err)
+	assert.Error(t, s.UploadData([]byte("content"), "file.txt"), "the test CA isn't trusted by default")
+
+	cfg := newConfig()
+	cfg.CACertPath = caPath
+	s, err = NewS3Store(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("content"), "file.txt"))
+
+	cfg = newConfig()
+	cfg.InsecureSkipVerify = true
+	s, err = NewS3Store(cfg)
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("content"), "other.txt"))
+
+	cfg = newConfig()
+	cfg.CACertPath = filepath.Join(t.TempDir(), "missing.pem")
+	_, err = NewS3Store(cfg)
+	assert.Error(t, err)
+}
+
+type countingTransport struct {
+	requests atomic.Int32
+}
+
+func (c *countingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	c.requests.Add(1)
+	return http.DefaultTransport.RoundTrip(r)
+}
+
+func TestS3Store_WithHTTPClient(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	transport := &countingTransport{}
+	s, err := NewS3Store(fake.config("test-bucket"), WithHTTPClient(&http.Client{Transport: transport}))
+	assert.NoError(t, err)
+	assert.NoError(t, s.UploadData([]byte("content"), "file.txt"))
+	assert.NotZero(t, transport.requests.Load())
+	assert.NoError(t, s.(io.Closer).Close())
+}
+
+func TestS3Store_DownloadBytesVerified(t *testing.T) {
+	store, fake := newFakeS3Store(t)
+	data := []byte("verified content")
+	assert.NoError(t, store.UploadData(data, "verified.bin"))
+
+	got, err := store.DownloadBytesVerified("verified.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+
+	obj, _ := fake.get("test-bucket", "verified.bin")
+	fake.lk.Lock()
+	obj.data = []byte("corrupt content!")
+	fake.lk.Unlock()
+	_, err = store.DownloadBytesVerified("verified.bin")
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+
+	// the ETag of a multipart upload isn't an MD5
+	fake.lk.Lock()
+	obj.data = data
+	obj.etag = "0123456789abcdef0123456789abcdef-2"
+	fake.lk.Unlock()
+	_, err = store.DownloadBytesVerified("verified.bin")
+	assert.ErrorContains(t, err, "no md5 checksum")
+
+	sum := md5.Sum(data)
+	fake.lk.Lock()
+	obj.metadata["Md5"] = hex.EncodeToString(sum[:])
+	fake.lk.Unlock()
+	got, err = store.DownloadBytesVerified("verified.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestS3Store_DownloadBytesVerified_SHA256(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	st, err := NewS3Store(fake.config("test-bucket"), WithChecksumAlgorithm(ChecksumSHA256))
+	assert.NoError(t, err)
+	data := []byte("verified content")
+	assert.NoError(t, st.UploadData(data, "verified.bin"))
+	_, err = st.(VerifiedDownloader).DownloadBytesVerified("verified.bin")
+	assert.ErrorContains(t, err, "no sha256 checksum", "the ETag is not a SHA-256")
+
+	sum := sha256.Sum256(data)
+	obj, _ := fake.get("test-bucket", "verified.bin")
+	fake.lk.Lock()
+	obj.metadata["Sha256"] = hex.EncodeToString(sum[:])
+	fake.lk.Unlock()
+	got, err := st.(VerifiedDownloader).DownloadBytesVerified("verified.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestS3Store_ContentHash(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	var sent http.Header
+	fake.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut {
+			sent = r.Header.Clone()
+		}
+		return 0, ""
+	})
+	st, err := NewS3Store(fake.config("test-bucket"), WithChecksumAlgorithm(ChecksumSHA256))
+	assert.NoError(t, err)
+	data := []byte("content addressed")
+	var res UploadResult
+	assert.NoError(t, st.UploadData(data, "cas.bin", ContentHash(ChecksumSHA256, &res)))
+
+	sum := sha256.Sum256(data)
+	assert.Equal(t, hex.EncodeToString(sum[:]), res.Checksum)
+	assert.Equal(t, base64.StdEncoding.EncodeToString(sum[:]), sent.Get("X-Amz-Checksum-Sha256"), "the server should be able to verify the upload")
+	obj, _ := fake.get("test-bucket", "cas.bin")
+	assert.Equal(t, res.Checksum, obj.metadata["Sha256"])
+
+	got, err := st.(VerifiedDownloader).DownloadBytesVerified("cas.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+}
+
+func TestS3Store_RateLimit(t *testing.T) {
+	fake := newFakeS3(t, "test-bucket")
+	st, err := NewS3Store(fake.config("test-bucket"), WithRateLimit(256<<10))
+	assert.NoError(t, err)
+	data := bytes.Repeat([]byte("x"), 64<<10)
+
+	start := time.Now()
+	assert.NoError(t, st.UploadData(data, "limited.bin"))
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	start = time.Now()
+	got, err := st.DownloadBytes("limited.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, data, got)
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+}
+
+func TestS3Store_DownloadToFile(t *testing.T) {
+	store, _ := newFakeS3Store(t)
+	assert.NoError(t, store.UploadData([]byte("content"), "dir/file.txt"))
+
+	local := filepath.Join(t.TempDir(), "nested", "file.txt")
+	assert.NoError(t, DownloadToFile(store, "dir/file.txt", local))
+	data, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	err = DownloadToFile(store, "dir/missing.txt", local)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestS3Store_Touch(t *testing.T) {
+	st, fake := newFakeS3Store(t)
+	assert.NoError(t, st.Publish("touch.json", []byte("{}"), PublishOptions{ContentType: "application/json"}))
+	var res UploadResult
+	assert.NoError(t, st.UploadData([]byte("data"), "touch.bin", ContentHash(ChecksumSHA256, &res)))
+	old := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+	fake.lk.Lock()
+	for _, obj := range fake.buckets["test-bucket"] {
+		obj.lastModified = old
+	}
+	fake.lk.Unlock()
+
+	assert.NoError(t, st.Touch("touch.json"))
+	assert.NoError(t, st.Touch("/touch.bin"))
+	stat, err := st.Stat("touch.json")
+	assert.NoError(t, err)
+	assert.True(t, stat.ModTime.After(old))
+	assert.Equal(t, "application/json", stat.ContentType)
+	obj, _ := fake.get("test-bucket", "touch.bin")
+	assert.True(t, obj.lastModified.After(old))
+	assert.Equal(t, "data", string(obj.data))
+	assert.Equal(t, res.Checksum, obj.metadata["Sha256"], "the user metadata should be kept")
+
+	assert.ErrorIs(t, st.Touch("missing"), ErrNotFound)
+}
+
+func TestS3Store_PartSize(t *testing.T) {
+	f := newFakeS3(t, "test-bucket")
+	cfg := f.config("test-bucket")
+	cfg.PartSize = minUploadPartSize
+	cfg.UploadConcurrency = 2
+	st, err := NewS3Store(cfg)
+	assert.NoError(t, err)
+	s := st.(*S3Store)
+
+	var parts atomic.Int32
+	f.setHook(func(r *http.Request) (int, string) {
+		if r.Method == http.MethodPut && r.URL.Query().Has("partNumber") {
+			parts.Add(1)
+		}
+		return 0, ""
+	})
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minUploadPartSize+1024)/16)
+	// a reader without ReadAt, streamed in buffered parts
+	assert.NoError(t, s.UploadReader(io.MultiReader(bytes.NewReader(data)), int64(len(data)), "big"))
+	assert.Equal(t, int32(3), parts.Load())
+	obj, ok := f.get("test-bucket", "big")
+	assert.True(t, ok)
+	assert.Equal(t, data, obj.data)
+
+	// the options of an upload override the configuration
+	parts.Store(0)
+	assert.NoError(t, s.UploadData(data, "big", PartSize(2*minUploadPartSize), UploadConcurrency(1)))
+	assert.Equal(t, int32(2), parts.Load())
+	opts, err := s.putOptions("big", int64(len(data)), NewUploadOptions(UploadConcurrency(8)))
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(minUploadPartSize), opts.PartSize)
+	assert.Equal(t, uint(8), opts.NumThreads)
+	assert.True(t, opts.ConcurrentStreamParts)
+
+	assert.Error(t, s.UploadData(data, "big", PartSize(1<<20)))
+	cfg = f.config("test-bucket")
+	cfg.PartSize = 1 << 20
+	_, err = NewS3Store(cfg)
+	assert.Error(t, err)
+}
+
+func TestS3Store_NotConfigured(t *testing.T) {
+	for _, s := range []*S3Store{nil, {}} {
+		_, err := s.Stat("key")
+		assert.ErrorIs(t, err, S3NotConfigError)
+		assert.ErrorIs(t, s.Delete("key"), S3NotConfigError)
+		assert.ErrorIs(t, s.UploadData([]byte("data"), "key"), S3NotConfigError)
+		assert.ErrorIs(t, s.HealthCheck(context.Background()), S3NotConfigError)
+		assert.NoError(t, s.Close())
+	}
+}
+
+func TestS3Store_Anonymous(t *testing.T) {
+	f := newFakeS3(t, "public")
+	f.put("public", "dataset/a.csv", []byte("a,b,c"))
+	var signed atomic.Int32
+	f.setHook(func(r *http.Request) (int, string) {
+		if r.Header.Get("Authorization") != "" {
+			signed.Add(1)
+		}
+		return 0, ""
+	})
+	cfg := f.config("public")
+	cfg.AccessKey, cfg.SecretKey = "", ""
+	cfg.Anonymous = true
+	st, err := NewS3Store(cfg)
+	assert.NoError(t, err)
+
+	data, err := st.DownloadBytes("dataset/a.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, "a,b,c", string(data))
+	stat, err := st.Stat("dataset/a.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), stat.Size)
+	assert.Zero(t, signed.Load(), "anonymous requests must not be signed")
+
+	assert.ErrorIs(t, st.UploadData([]byte("x"), "dataset/b.csv"), ErrReadOnly)
+	assert.ErrorIs(t, st.Delete("dataset/a.csv"), ErrReadOnly)
+	assert.ErrorIs(t, st.DeleteDirectory("dataset"), ErrReadOnly)
+	assert.Equal(t, []string{"dataset/a.csv"}, f.keys("public"))
+
+	cfg = f.config("public")
+	cfg.Anonymous = true
+	_, err = NewS3Store(cfg)
+	assert.Error(t, err, "anonymous access with credentials")
+
+	cfg.AccessKey, cfg.SecretKey = "", ""
+	cfg.VerifyWritable = true
+	_, err = NewS3Store(cfg)
+	assert.Error(t, err, "anonymous access can't be writable")
+}
+
+func TestS3Store_UploadReader_UnknownSize(t *testing.T) {
+	s, f := newFakeS3Store(t)
+	var parts atomic.Int32
+	f.setHook(func(r *http.Request) (int, string) {
+		if r.URL.Query().Has("partNumber") {
+			parts.Add(1)
+		}
+		return 0, ""
+	})
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), (2*minUploadPartSize+1024)/16)
+	var progress int64
+	assert.NoError(t, s.UploadReader(io.MultiReader(bytes.NewReader(data)), -1, "stream",
+		PartSize(minUploadPartSize), Progress(func(n, total int64) {
+			assert.Equal(t, int64(-1), total)
+			progress = n
+		})))
+	assert.Equal(t, int32(3), parts.Load())
+	assert.Equal(t, int64(len(data)), progress)
+	obj, ok := f.get("test-bucket", "stream")
+	assert.True(t, ok)
+	assert.Equal(t, data, obj.data)
+
+	opts, err := s.putOptions("stream", -1, NewUploadOptions())
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(defaultStreamPartSize), opts.PartSize)
+	opts, err = s.putOptions("stream", int64(len(data)), NewUploadOptions())
+	assert.NoError(t, err)
+	assert.Zero(t, opts.PartSize, "known sizes are left to minio")
+}
@@ -1,10 +1,19 @@
 package store
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/service-sdk/go-sdk-qn/v2/operation"
+	"go.uber.org/zap"
 
 	logging "github.com/ipfs/go-log/v2"
 )
@@ -16,54 +25,238 @@ var (
 	ErrNotConfigured = fmt.Errorf("store is not configured")
 )
 
+// Interface is implemented by every backend.
+//
+// Keys name objects only: directories are virtual prefixes, as in object
+// stores, even on backends with real directories. Exists and Stat report a
+// directory, empty or not, as not existing, and ListPrefix returns no keys
+// for an empty or missing directory instead of an error.
 type Interface interface {
 	Stat(key string) (FileStat, error)
-	UploadData(data []byte, key string) (err error)
-	Upload(file string, key string) (err error)
-	UploadReader(reader io.Reader, size int64, key string) (err error)
+	// UploadData, Upload and UploadReader replace an existing object unless
+	// Overwrite(false) is passed, see UploadOptions. UploadReader takes the
+	// size of the content, or -1 if it isn't known; the reader is then
+	// streamed until EOF.
+	UploadData(data []byte, key string, opts ...UploadOption) (err error)
+	Upload(file string, key string, opts ...UploadOption) (err error)
+	UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error)
 	DeleteDirectory(dir string) (err error)
 	Delete(key string) (err error)
 	Exists(key string) (bool, error)
 	DownloadBytes(key string) ([]byte, error)
 	DownloadReader(key string) (io.ReadCloser, error)
+	// DownloadRangeBytes and DownloadRangeReader read size bytes starting at
+	// offset. A range running past the end of the object is clamped to the
+	// object size without an error, and a size of -1 means "from offset to
+	// the end of the object".
 	DownloadRangeBytes(key string, offset int64, size int64) ([]byte, error)
 	DownloadRangeReader(key string, offset int64, size int64) (io.ReadCloser, error)
 	ListPrefix(key string) ([]string, error)
 }
 
-var _ Interface = &Store{}
+var (
+	_ Interface             = &Store{}
+	_ io.Closer             = &Store{}
+	_ HealthChecker         = &Store{}
+	_ ConditionalDownloader = &Store{}
+	_ VerifiedDownloader    = &Store{}
+	_ FileDownloader        = &Store{}
+	_ StatLister            = &Store{}
+	_ Walker                = &Store{}
+	_ ContextLister         = &Store{}
+	_ MetadataGetter        = &Store{}
+	_ UsageReporter         = &Store{}
+	_ Toucher               = &Store{}
+	_ RollupLister          = &Store{}
+	_ DepthLister           = &Store{}
+	_ Publisher             = &Store{}
+	_ ParallelDownloader    = &Store{}
+	_ DeletePreviewer       = &Store{}
+	_ EmptyChecker          = &Store{}
+	_ Appender              = &Store{}
+	_ Copier                = &Store{}
+)
 
 type FileStat struct {
 	Size int64
+	// ContentType is the MIME type of the object, if the backend records one.
+	ContentType string
+	// ETag identifies the content of the object, for conditional uploads
+	// with IfMatch and IfNoneMatch. It's opaque and only meaningful to the
+	// backend that returned it.
+	ETag string
+	// ModTime is the time the object was last modified.
+	ModTime time.Time
+}
+
+// StoreOptions configures the behavior of the union Store.
+type StoreOptions struct {
+	// IgnoreUnsupportedOnExists makes Exists report (false, nil) for keys
+	// that can't be routed to any backend, instead of returning the error.
+	// Defaults to false, which propagates the routing error.
+	IgnoreUnsupportedOnExists bool
+	// Logger is passed to the backends with WithLogger when set.
+	Logger *zap.SugaredLogger
+	// KeyTransform, if set, maps every key, after its protocol is stripped,
+	// to the key stored in the backend, e.g. to hash a path segment holding
+	// a user identifier. It should transform keys segment by segment so that
+	// a transformed prefix still lists the transformed keys under it.
+	KeyTransform func(key string) string
+	// KeyInverse maps the keys returned by the listings back to the keys
+	// callers use. Listed keys are returned as stored if it's nil.
+	KeyInverse func(key string) string
+
+	// S3 configures the S3 backend of NewStoreWithConfig. S3 paths fail with
+	// ErrNotConfigured if it's nil.
+	S3 *S3MultiStoreConfig
+	// Qiniu configures the Qiniu backend of NewStoreWithConfig. Qiniu paths
+	// fail with ErrNotConfigured if it's nil.
+	Qiniu *QiniuConfig
+	// DisableOS makes local paths fail with ErrNotConfigured instead of
+	// being served from the local file system.
+	DisableOS bool
 }
 
 type Store struct {
 	osStore    Interface
 	qiniuStore Interface
 	s3Store    Interface
+	opts       StoreOptions
+
+	// backends are the backends of the protocols registered with
+	// RegisterBackend, created on first use.
+	backendsLk sync.Mutex
+	backends   map[PathProtocol]Interface
+}
+
+// NewStore creates a union Store. The Qiniu and S3 backends are enabled
+// only when QiNiuEnv and S3Env are set respectively; the S3 and Qiniu
+// fields of opts are ignored, see NewStoreWithConfig.
+func NewStore(opts StoreOptions) (*Store, error) {
+	s, storeOpts := newStore(opts)
+	if _, ok := os.LookupEnv(QiNiuEnv); ok {
+		st, err := NewQiniuStore(storeOpts...)
+		if err != nil {
+			return nil, err
+		}
+		s.qiniuStore = st
+	}
+	if _, ok := os.LookupEnv(S3Env); ok {
+		st, err := NewS3MultiStoreWithEnv(storeOpts...)
+		if err != nil {
+			return nil, err
+		}
+		s.s3Store = st
+	}
+	return s, nil
+}
+
+// NewStoreWithConfig creates a union Store from the configurations in opts,
+// regardless of QiNiuEnv and S3Env, so that stores configured differently
+// can be used in the same process.
+func NewStoreWithConfig(opts StoreOptions) (*Store, error) {
+	s, storeOpts := newStore(opts)
+	if opts.Qiniu != nil {
+		st, err := NewQiniuStoreWithConfig(opts.Qiniu, storeOpts...)
+		if err != nil {
+			return nil, err
+		}
+		s.qiniuStore = st
+	}
+	if opts.S3 != nil {
+		s.s3Store = NewS3MultiStoreWithConfig(opts.S3, storeOpts...)
+	}
+	return s, nil
 }
 
+// newStore returns a Store without its network backends and the options to
+// create them with.
+func newStore(opts StoreOptions) (*Store, []Option) {
+	var storeOpts []Option
+	if opts.Logger != nil {
+		storeOpts = append(storeOpts, WithLogger(opts.Logger))
+	}
+	s := &Store{opts: opts}
+	if !opts.DisableOS {
+		s.osStore = NewOSStore(storeOpts...)
+	}
+	return s, storeOpts
+}
+
+// getStoreByKey returns the backend serving key and the key on it. A
+// network-style S3 key, s3://bucket/key, is passed to the S3 store with its
+// bucket so that it's routed by bucket.
 func (s *Store) getStoreByKey(key string) (Interface, string, error) {
-	pp, p, err := GetPathProtocol(key)
+	info, err := ParsePath(key)
+	pp, p := info.Protocol, info.Path
 	if err != nil {
 		return nil, p, err
 	}
+	if s.opts.KeyTransform != nil {
+		p = s.opts.KeyTransform(p)
+	}
+	if info.Bucket != "" {
+		p = "s3://" + info.Bucket + "/" + strings.TrimPrefix(p, "/")
+	}
 	switch pp {
 	case QiniuProtocol:
 		if s.qiniuStore == nil {
-			return nil, p, fmt.Errorf("%s %s", pp, ErrNotConfigured)
+			return nil, p, fmt.Errorf("%s %w", pp, ErrNotConfigured)
 		}
 		return s.qiniuStore, p, nil
 	case S3Protocol:
 		if s.s3Store == nil {
-			return nil, p, fmt.Errorf("%s %s", pp, ErrNotConfigured)
+			return nil, p, fmt.Errorf("%s %w", pp, ErrNotConfigured)
 		}
 		return s.s3Store, p, nil
 	case OSProtocol:
+		if s.osStore == nil {
+			return nil, p, fmt.Errorf("os %w", ErrNotConfigured)
+		}
 		return s.osStore, p, nil
 	default:
-		return nil, p, fmt.Errorf("unsupported file path protocol: %s, %s", pp, key)
+		if factory, ok := registeredBackend(pp); ok {
+			st, err := s.registeredStore(pp, factory)
+			return st, p, err
+		}
+		return nil, p, fmt.Errorf("unsupported file path protocol: %s, %s: %w", pp, key, ErrNotSupported)
+	}
+}
+
+// Resolve returns where key is routed without touching the object: its
+// protocol, the backend serving it and the key on that backend, after
+// KeyTransform. The key of a network-style S3 path keeps its bucket,
+// s3://bucket/key. The protocol is returned even when there's no backend
+// for it, with the error the operations would return.
+func (s *Store) Resolve(key string) (PathProtocol, Interface, string, error) {
+	protocol, _, err := GetPathProtocol(key)
+	if err != nil {
+		return protocol, nil, "", err
+	}
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return protocol, nil, "", err
+	}
+	return protocol, st, p, nil
+}
+
+// registeredStore returns the backend of a protocol registered with
+// RegisterBackend, creating it with factory on first use.
+func (s *Store) registeredStore(protocol PathProtocol, factory BackendFactory) (Interface, error) {
+	s.backendsLk.Lock()
+	defer s.backendsLk.Unlock()
+	if st, ok := s.backends[protocol]; ok {
+		return st, nil
+	}
+	st, err := factory()
+	if err != nil {
+		return nil, fmt.Errorf("create %s backend: %w", protocol, err)
+	}
+	if s.backends == nil {
+		s.backends = map[PathProtocol]Interface{}
 	}
+	s.backends[protocol] = st
+	return st, nil
 }
 
 func (s *Store) Stat(key string) (FileStat, error) {
@@ -74,28 +267,28 @@ func (s *Store) Stat(key string) (FileStat, error) {
 	return st.Stat(p)
 }
 
-func (s *Store) UploadData(data []byte, key string) (err error) {
+func (s *Store) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
 	st, p, err := s.getStoreByKey(key)
 	if err != nil {
 		return err
 	}
-	return st.UploadData(data, p)
+	return st.UploadData(data, p, opts...)
 }
 
-func (s *Store) Upload(file string, key string) (err error) {
+func (s *Store) Upload(file string, key string, opts ...UploadOption) (err error) {
 	st, p, err := s.getStoreByKey(key)
 	if err != nil {
 		return err
 	}
-	return st.Upload(file, p)
+	return st.Upload(file, p, opts...)
 }
 
-func (s *Store) UploadReader(reader io.Reader, size int64, key string) (err error) {
+func (s *Store) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
 	st, p, err := s.getStoreByKey(key)
 	if err != nil {
 		return err
 	}
-	return st.UploadReader(reader, size, p)
+	return st.UploadReader(reader, size, p, opts...)
 }
 
 func (s *Store) DeleteDirectory(dir string) (err error) {
@@ -106,6 +299,27 @@ func (s *Store) DeleteDirectory(dir string) (err error) {
 	return st.DeleteDirectory(p)
 }
 
+// DeleteDirectoryPreview lists the keys DeleteDirectory(dir) would delete
+// on the backend the dir routes to.
+func (s *Store) DeleteDirectoryPreview(dir string) ([]string, error) {
+	st, p, err := s.getStoreByKey(dir)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := DeleteDirectoryPreview(st, p)
+	return s.inverseKeys(keys), err
+}
+
+// IsEmpty reports whether there are no objects under key on the backend the
+// key routes to.
+func (s *Store) IsEmpty(key string) (bool, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return false, err
+	}
+	return IsEmpty(st, p)
+}
+
 func (s *Store) Delete(key string) (err error) {
 	st, p, err := s.getStoreByKey(key)
 	if err != nil {
@@ -114,9 +328,15 @@ func (s *Store) Delete(key string) (err error) {
 	return st.Delete(p)
 }
 
+// Exists checks if the key exists in the backend it routes to.
+// Keys that can't be routed return an error unless
+// StoreOptions.IgnoreUnsupportedOnExists is set.
 func (s *Store) Exists(key string) (bool, error) {
 	st, p, err := s.getStoreByKey(key)
 	if err != nil {
+		if s.opts.IgnoreUnsupportedOnExists {
+			return false, nil
+		}
 		return false, err
 	}
 	return st.Exists(p)
@@ -159,5 +379,273 @@ func (s *Store) ListPrefix(key string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
-	return st.ListPrefix(p)
+	keys, err := st.ListPrefix(p)
+	return s.inverseKeys(keys), err
+}
+
+// ListPrefixContext lists the backend the key routes to, stopping when ctx
+// is done.
+func (s *Store) ListPrefixContext(ctx context.Context, key string) ([]string, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := ListPrefixContext(ctx, st, p)
+	return s.inverseKeys(keys), err
+}
+
+// ListRollup rolls up the listing of the backend the key routes to.
+func (s *Store) ListRollup(key string, depth int) ([]RollupEntry, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	rl, ok := st.(RollupLister)
+	if !ok {
+		return nil, notSupportedError("ListRollup", st)
+	}
+	entries, err := rl.ListRollup(p, depth)
+	if s.opts.KeyInverse != nil {
+		for i := range entries {
+			entries[i].Prefix = s.opts.KeyInverse(entries[i].Prefix)
+		}
+	}
+	return entries, err
+}
+
+// ListPrefixDepth lists the backend the key routes to down to maxDepth.
+func (s *Store) ListPrefixDepth(key string, maxDepth int) ([]string, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	dl, ok := st.(DepthLister)
+	if !ok {
+		return nil, notSupportedError("ListPrefixDepth", st)
+	}
+	keys, err := dl.ListPrefixDepth(p, maxDepth)
+	return s.inverseKeys(keys), err
+}
+
+// ListPrefixStat lists the backend the key routes to with the stats of the
+// keys.
+func (s *Store) ListPrefixStat(key string) ([]ObjectStat, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := ListPrefixStat(st, p)
+	if s.opts.KeyInverse != nil {
+		for i := range objects {
+			objects[i].Key = s.opts.KeyInverse(objects[i].Key)
+		}
+	}
+	return objects, err
+}
+
+// GetMetadata returns the metadata of key on the backend it routes to.
+func (s *Store) GetMetadata(key string) (map[string]string, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return GetMetadata(st, p)
+}
+
+// WalkPrefix walks the objects under key on the backend the key routes to.
+func (s *Store) WalkPrefix(ctx context.Context, key string, fn WalkFunc) error {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return err
+	}
+	return WalkPrefix(ctx, st, p, func(obj ObjectStat) error {
+		if s.opts.KeyInverse != nil {
+			obj.Key = s.opts.KeyInverse(obj.Key)
+		}
+		return fn(obj)
+	})
+}
+
+// PrefixUsage sums up the objects under key on the backend the key routes
+// to.
+func (s *Store) PrefixUsage(key string) (int64, int64, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return 0, 0, err
+	}
+	return PrefixUsage(st, p)
+}
+
+// Copy copies the object at src to dst, server-side if both keys route to
+// the same backend and it supports it.
+func (s *Store) Copy(src, dst string) error {
+	srcStore, srcKey, err := s.getStoreByKey(src)
+	if err != nil {
+		return err
+	}
+	dstStore, dstKey, err := s.getStoreByKey(dst)
+	if err != nil {
+		return err
+	}
+	return Copy(srcStore, srcKey, dstStore, dstKey)
+}
+
+// AppendData appends to the object on the backend the key routes to, if it
+// supports appends.
+func (s *Store) AppendData(data []byte, key string) error {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return err
+	}
+	return AppendData(st, data, p)
+}
+
+// Touch refreshes the modification time of the object on the backend the
+// key routes to.
+func (s *Store) Touch(key string) error {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return err
+	}
+	return Touch(st, p)
+}
+
+// inverseKeys maps listed keys back with StoreOptions.KeyInverse in place.
+func (s *Store) inverseKeys(keys []string) []string {
+	if s.opts.KeyInverse == nil {
+		return keys
+	}
+	for i, key := range keys {
+		keys[i] = s.opts.KeyInverse(key)
+	}
+	return keys
+}
+
+// Publish publishes the object on the backend the key routes to.
+func (s *Store) Publish(key string, data []byte, opts PublishOptions) error {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return err
+	}
+	pub, ok := st.(Publisher)
+	if !ok {
+		return notSupportedError("Publish", st)
+	}
+	return pub.Publish(p, data, opts)
+}
+
+// DownloadReaderIf downloads from the backend the key routes to, unless the
+// object matches cond.
+func (s *Store) DownloadReaderIf(key string, cond DownloadConditions) (io.ReadCloser, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := st.(ConditionalDownloader)
+	if !ok {
+		return nil, notSupportedError("DownloadReaderIf", st)
+	}
+	return cd.DownloadReaderIf(p, cond)
+}
+
+// DownloadParallel downloads the object from its backend with concurrent
+// range requests if the backend supports them, with DownloadReader
+// otherwise.
+func (s *Store) DownloadParallel(key string, partSize int64, concurrency int) (io.ReadCloser, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return DownloadParallel(st, p, partSize, concurrency)
+}
+
+// DownloadBytesVerified downloads and verifies the object from the backend
+// the key routes to.
+func (s *Store) DownloadBytesVerified(key string) ([]byte, error) {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	vd, ok := st.(VerifiedDownloader)
+	if !ok {
+		return nil, notSupportedError("DownloadBytesVerified", st)
+	}
+	return vd.DownloadBytesVerified(p)
+}
+
+// DownloadToFile downloads the object from the backend the key routes to.
+func (s *Store) DownloadToFile(key, localPath string) error {
+	st, p, err := s.getStoreByKey(key)
+	if err != nil {
+		return err
+	}
+	return DownloadToFile(st, p, localPath)
+}
+
+// HealthCheck checks every configured backend that supports it and reports
+// which ones failed.
+func (s *Store) HealthCheck(ctx context.Context) error {
+	backends := []struct {
+		name string
+		st   Interface
+	}{
+		{"os", s.osStore},
+		{"qiniu", s.qiniuStore},
+		{"s3", s.s3Store},
+	}
+	s.backendsLk.Lock()
+	for _, protocol := range slices.Sorted(maps.Keys(s.backends)) {
+		backends = append(backends, struct {
+			name string
+			st   Interface
+		}{protocol.String(), s.backends[protocol]})
+	}
+	s.backendsLk.Unlock()
+	var errs []error
+	for _, b := range backends {
+		hc, ok := b.st.(HealthChecker)
+		if !ok {
+			continue
+		}
+		if err := hc.HealthCheck(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("%s store: %w", b.name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close closes the configured backends, and those created for registered
+// protocols, and returns their errors joined.
+func (s *Store) Close() error {
+	stores := []Interface{s.osStore, s.qiniuStore, s.s3Store}
+	s.backendsLk.Lock()
+	for _, protocol := range slices.Sorted(maps.Keys(s.backends)) {
+		stores = append(stores, s.backends[protocol])
+	}
+	s.backends = nil
+	s.backendsLk.Unlock()
+	var errs []error
+	for _, st := range stores {
+		if st == nil {
+			continue
+		}
+		if err := closeStore(st); err != nil {
+			errs = append(errs, fmt.Errorf("close %T: %w", st, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeStore closes st if it holds resources. Backends and wrappers that do
+// implement io.Closer; Close isn't part of Interface so that simple
+// implementations don't need one.
+func closeStore(st Interface) error {
+	if c, ok := st.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+func notSupportedError(op string, st Interface) error {
+	return fmt.Errorf("%s is not supported by %T: %w", op, st, ErrNotSupported)
 }
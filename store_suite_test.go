@@ -0,0 +1,542 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testAll runs the conformance suite shared by all backends against st.
+// All the keys used are created under root, which must be empty.
+func testAll(t *testing.T, st Interface, root string) {
+	key := func(name string) string {
+		return path.Join(root, name)
+	}
+	data := []byte("0123456789")
+
+	t.Run("UploadData", func(t *testing.T) {
+		k := key("upload-data.txt")
+		assert.NoError(t, st.UploadData(data, k))
+		exists, err := st.Exists(k)
+		assert.NoError(t, err)
+		assert.True(t, exists)
+		stat, err := st.Stat(k)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(len(data)), stat.Size)
+		content, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, data, content)
+	})
+
+	t.Run("Upload", func(t *testing.T) {
+		file := filepath.Join(t.TempDir(), "file.txt")
+		assert.NoError(t, os.WriteFile(file, data, 0644))
+		k := key("upload.txt")
+		assert.NoError(t, st.Upload(file, k))
+		content, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, data, content)
+	})
+
+	t.Run("UploadReader", func(t *testing.T) {
+		k := key("upload-reader.txt")
+		assert.NoError(t, st.UploadReader(strings.NewReader(string(data)), int64(len(data)), k))
+		r, err := st.DownloadReader(k)
+		assert.NoError(t, err)
+		content, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.NoError(t, r.Close())
+		assert.Equal(t, data, content)
+	})
+
+	t.Run("OverwriteByDefault", func(t *testing.T) {
+		k := key("overwrite-default.txt")
+		assert.NoError(t, st.UploadData([]byte("first"), k))
+		assert.NoError(t, st.UploadData([]byte("second"), k), "uploads replace existing objects by default")
+		assert.NoError(t, st.UploadReader(bytes.NewReader([]byte("third")), 5, k))
+		got, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("third"), got)
+	})
+
+	t.Run("Overwrite", func(t *testing.T) {
+		k := key("overwrite.txt")
+		assert.NoError(t, st.UploadData([]byte("first"), k, Overwrite(false)))
+		err := st.UploadData([]byte("second"), k, Overwrite(false))
+		assert.ErrorIs(t, err, ErrAlreadyExists)
+		got, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("first"), got, "a create-only upload must not replace the object")
+
+		assert.NoError(t, st.UploadData([]byte("second"), k, Overwrite(true)))
+		got, err = st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("second"), got)
+	})
+
+	t.Run("ConditionalUpload", func(t *testing.T) {
+		k := key("conditional.txt")
+		assert.ErrorIs(t, st.UploadData([]byte("v1"), k, IfMatch("missing")), ErrPreconditionFailed)
+		assert.NoError(t, st.UploadData([]byte("v1"), k, IfNoneMatch("*")))
+		assert.ErrorIs(t, st.UploadData([]byte("v1"), k, IfNoneMatch("*")), ErrPreconditionFailed)
+
+		stat, err := st.Stat(k)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, stat.ETag)
+		assert.ErrorIs(t, st.UploadData([]byte("v2"), k, IfNoneMatch(stat.ETag)), ErrPreconditionFailed)
+		assert.NoError(t, st.UploadData([]byte("v2"), k, IfMatch(stat.ETag)))
+		// the ETag read before the update is stale
+		err = st.UploadData([]byte("v3"), k, IfMatch(stat.ETag))
+		assert.ErrorIs(t, err, ErrPreconditionFailed)
+		got, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, []byte("v2"), got)
+	})
+
+	t.Run("Touch", func(t *testing.T) {
+		if _, ok := st.(Toucher); !ok {
+			t.Skip("touch not supported")
+		}
+		k := key("touch.txt")
+		assert.NoError(t, st.UploadData([]byte("keep me"), k))
+		before, err := st.Stat(k)
+		assert.NoError(t, err)
+		assert.NoError(t, Touch(st, k))
+		after, err := st.Stat(k)
+		assert.NoError(t, err)
+		assert.False(t, after.ModTime.Before(before.ModTime))
+		data, err := st.DownloadBytes(k)
+		assert.NoError(t, err)
+		assert.Equal(t, "keep me", string(data))
+
+		assert.ErrorIs(t, Touch(st, key("touch-missing.txt")), ErrNotFound)
+	})
+
+	t.Run("ConditionalDownload", func(t *testing.T) {
+		cd, ok := st.(ConditionalDownloader)
+		if !ok {
+			t.Skip("conditional downloads not supported")
+		}
+		k := key("conditional-download.txt")
+		assert.NoError(t, st.UploadData([]byte("v1"), k))
+		stat, err := st.Stat(k)
+		assert.NoError(t, err)
+
+		_, err = cd.DownloadReaderIf(k, DownloadConditions{IfNoneMatch: stat.ETag})
+		assert.ErrorIs(t, err, ErrNotModified)
+		_, err = cd.DownloadReaderIf(k, DownloadConditions{IfModifiedSince: stat.ModTime})
+		assert.ErrorIs(t, err, ErrNotModified)
+		_, err = cd.DownloadReaderIf(key("missing.txt"), DownloadConditions{IfNoneMatch: stat.ETag})
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		r, err := cd.DownloadReaderIf(k, DownloadConditions{IfModifiedSince: stat.ModTime.Add(-time.Hour)})
+		if assert.NoError(t, err) {
+			got, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("v1"), got)
+			assert.NoError(t, r.Close())
+		}
+
+		assert.NoError(t, st.UploadData([]byte("v2"), k, Overwrite(true)))
+		r, err = cd.DownloadReaderIf(k, DownloadConditions{IfNoneMatch: stat.ETag})
+		if assert.NoError(t, err, "the cached ETag is stale") {
+			got, err := io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, []byte("v2"), got)
+			assert.NoError(t, r.Close())
+		}
+	})
+
+	t.Run("ContentHash", func(t *testing.T) {
+		data := []byte("hashed content")
+		sha := sha256.Sum256(data)
+		var res UploadResult
+		assert.NoError(t, st.UploadData(data, key("hash-data.txt"), ContentHash(ChecksumSHA256, &res)))
+		assert.Equal(t, UploadResult{Algorithm: ChecksumSHA256, Checksum: hex.EncodeToString(sha[:]), Size: int64(len(data))}, res)
+
+		res = UploadResult{}
+		err := st.UploadReader(bytes.NewReader(data), int64(len(data)), key("hash-reader.txt"), ContentHash(ChecksumCRC32C, &res))
+		assert.NoError(t, err)
+		crc := crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli))
+		assert.Equal(t, fmt.Sprintf("%08x", crc), res.Checksum)
+		assert.Equal(t, int64(len(data)), res.Size)
+
+		file := filepath.Join(t.TempDir(), "hash-file.txt")
+		assert.NoError(t, os.WriteFile(file, data, 0644))
+		res = UploadResult{}
+		assert.NoError(t, st.Upload(file, key("hash-file.txt"), ContentHash(ChecksumSHA256, &res)))
+		assert.Equal(t, hex.EncodeToString(sha[:]), res.Checksum)
+	})
+
+	t.Run("Progress", func(t *testing.T) {
+		data := bytes.Repeat([]byte("progress"), 16<<10)
+		var calls [][2]int64
+		progress := Progress(func(transferred, total int64) {
+			calls = append(calls, [2]int64{transferred, total})
+		})
+		err := st.UploadReader(bytes.NewReader(data), int64(len(data)), key("progress-reader.bin"), progress)
+		assert.NoError(t, err)
+		if assert.NotEmpty(t, calls) {
+			last := calls[len(calls)-1]
+			assert.Equal(t, last[1], last[0], "the last call should report the whole upload")
+			for i := 1; i < len(calls); i++ {
+				assert.GreaterOrEqual(t, calls[i][0], calls[i-1][0])
+			}
+		}
+
+		file := filepath.Join(t.TempDir(), "progress-file.bin")
+		assert.NoError(t, os.WriteFile(file, data, 0644))
+		calls = nil
+		assert.NoError(t, st.Upload(file, key("progress-file.bin"), progress))
+		if assert.NotEmpty(t, calls) {
+			last := calls[len(calls)-1]
+			assert.Equal(t, last[1], last[0])
+		}
+	})
+
+	t.Run("ListPrefixStat", func(t *testing.T) {
+		dir := key("statlist") + "/"
+		assert.NoError(t, st.UploadData([]byte("1"), dir+"a.txt"))
+		assert.NoError(t, st.UploadData([]byte("22"), dir+"sub/b.txt"))
+		objects, err := ListPrefixStat(st, dir)
+		assert.NoError(t, err)
+		sizes := map[string]int64{}
+		for _, obj := range objects {
+			sizes[strings.TrimPrefix(obj.Key, "/")] = obj.Size
+			stat, err := st.Stat(obj.Key)
+			assert.NoError(t, err)
+			assert.Equal(t, stat.ETag, obj.ETag, obj.Key)
+		}
+		assert.Equal(t, map[string]int64{
+			strings.TrimPrefix(dir, "/") + "a.txt":     1,
+			strings.TrimPrefix(dir, "/") + "sub/b.txt": 2,
+		}, sizes)
+	})
+
+	t.Run("WalkPrefix", func(t *testing.T) {
+		dir := key("walk") + "/"
+		assert.NoError(t, st.UploadData([]byte("1"), dir+"a.txt"))
+		assert.NoError(t, st.UploadData([]byte("22"), dir+"sub/b.txt"))
+		assert.NoError(t, st.UploadData([]byte("333"), dir+"sub/c.txt"))
+		sizes := map[string]int64{}
+		err := WalkPrefix(context.Background(), st, dir, func(obj ObjectStat) error {
+			sizes[strings.TrimPrefix(obj.Key, "/")] = obj.Size
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int64{
+			strings.TrimPrefix(dir, "/") + "a.txt":     1,
+			strings.TrimPrefix(dir, "/") + "sub/b.txt": 2,
+			strings.TrimPrefix(dir, "/") + "sub/c.txt": 3,
+		}, sizes)
+
+		visited := 0
+		err = WalkPrefix(context.Background(), st, dir, func(obj ObjectStat) error {
+			visited++
+			return SkipRemaining
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, visited)
+
+		errStop := errors.New("stop")
+		err = WalkPrefix(context.Background(), st, dir, func(obj ObjectStat) error {
+			return errStop
+		})
+		assert.ErrorIs(t, err, errStop)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		visited = 0
+		err = WalkPrefix(ctx, st, dir, func(obj ObjectStat) error {
+			visited++
+			cancel()
+			return nil
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, 1, visited)
+
+		err = WalkPrefix(context.Background(), st, key("walk-missing")+"/", func(obj ObjectStat) error {
+			return errStop
+		})
+		assert.NoError(t, err)
+	})
+
+	t.Run("PrefixUsage", func(t *testing.T) {
+		dir := key("usage") + "/"
+		assert.NoError(t, st.UploadData([]byte("1"), dir+"a.txt"))
+		assert.NoError(t, st.UploadData([]byte("22"), dir+"sub/b.txt"))
+		assert.NoError(t, st.UploadData([]byte("333"), dir+"sub/deeper/c.txt"))
+		total, count, err := PrefixUsage(st, dir)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(6), total)
+		assert.Equal(t, int64(3), count)
+
+		total, count, err = PrefixUsage(st, key("usage-missing")+"/")
+		assert.NoError(t, err)
+		assert.Zero(t, total)
+		assert.Zero(t, count)
+	})
+
+	t.Run("DownloadRange", func(t *testing.T) {
+		k := key("download-range.txt")
+		assert.NoError(t, st.UploadData(data, k))
+		for _, tc := range []struct {
+			offset, size int64
+			want         string
+		}{
+			{0, 1, "0"},
+			{2, 3, "234"},
+			{8, 10, "89"},
+			{4, -1, "456789"},
+			{20, 5, ""},
+		} {
+			content, err := st.DownloadRangeBytes(k, tc.offset, tc.size)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(content), "range %d+%d", tc.offset, tc.size)
+
+			r, err := st.DownloadRangeReader(k, tc.offset, tc.size)
+			assert.NoError(t, err)
+			content, err = io.ReadAll(r)
+			assert.NoError(t, err)
+			assert.NoError(t, r.Close())
+			assert.Equal(t, tc.want, string(content), "range reader %d+%d", tc.offset, tc.size)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		k := key("missing.txt")
+		exists, err := st.Exists(k)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+		_, err = st.Stat(k)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = st.DownloadBytes(k)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = st.DownloadRangeBytes(k, 0, 4)
+		assert.ErrorIs(t, err, ErrNotFound)
+		assert.ErrorIs(t, st.Delete(k), ErrNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		k := key("delete.txt")
+		assert.NoError(t, st.UploadData(data, k))
+		assert.NoError(t, st.Delete(k))
+		exists, err := st.Exists(k)
+		assert.NoError(t, err)
+		assert.False(t, exists)
+	})
+
+	t.Run("ListPrefix", func(t *testing.T) {
+		dir := key("list")
+		want := []string{path.Join(dir, "a.txt"), path.Join(dir, "b.txt")}
+		for _, k := range want {
+			assert.NoError(t, st.UploadData(data, k))
+		}
+		keys, err := st.ListPrefix(dir)
+		assert.NoError(t, err)
+		sort.Strings(keys)
+		assert.Equal(t, want, keys)
+
+		keys, err = st.ListPrefix(want[0])
+		assert.NoError(t, err)
+		assert.Equal(t, want[:1], keys)
+	})
+
+	t.Run("ListPrefix_Nested", func(t *testing.T) {
+		dir := key("nested")
+		want := []string{
+			path.Join(dir, "a.txt"),
+			path.Join(dir, "sub", "b.txt"),
+			path.Join(dir, "sub", "deep", "c.txt"),
+		}
+		for _, k := range want {
+			assert.NoError(t, st.UploadData(data, k))
+		}
+		keys, err := st.ListPrefix(dir)
+		assert.NoError(t, err)
+		sort.Strings(keys)
+		assert.Equal(t, want, keys)
+	})
+
+	t.Run("EmptyDirectory", func(t *testing.T) {
+		dir := key("empty-dir")
+		k := path.Join(dir, "a.txt")
+		assert.NoError(t, st.UploadData(data, k))
+		assert.NoError(t, st.Delete(k))
+
+		for _, d := range []string{dir, key("missing-dir")} {
+			exists, err := st.Exists(d)
+			assert.NoError(t, err)
+			assert.False(t, exists, "%s should not exist", d)
+			_, err = st.Stat(d)
+			assert.Error(t, err)
+			keys, err := st.ListPrefix(d)
+			assert.NoError(t, err)
+			assert.Empty(t, keys)
+		}
+	})
+
+	t.Run("DeleteDirectory", func(t *testing.T) {
+		dir := key("delete-dir")
+		files := []string{path.Join(dir, "a.txt"), path.Join(dir, "b.txt")}
+		for _, k := range files {
+			assert.NoError(t, st.UploadData(data, k))
+		}
+		assert.NoError(t, st.UploadData(data, dir+"-sibling.txt"))
+		preview, err := DeleteDirectoryPreview(st, dir)
+		assert.NoError(t, err)
+		if assert.Len(t, preview, len(files)) {
+			sort.Strings(preview)
+			for i, k := range files {
+				assert.True(t, strings.HasSuffix(preview[i], k), "%s previewed for %s", preview[i], k)
+				exists, err := st.Exists(k)
+				assert.NoError(t, err)
+				assert.True(t, exists, "preview must not delete %s", k)
+			}
+		}
+		empty, err := IsEmpty(st, dir)
+		assert.NoError(t, err)
+		assert.False(t, empty)
+		assert.NoError(t, st.DeleteDirectory(dir))
+		assert.NoError(t, st.Delete(dir+"-sibling.txt"))
+		empty, err = IsEmpty(st, dir)
+		assert.NoError(t, err)
+		assert.True(t, empty)
+		for _, k := range files {
+			exists, err := st.Exists(k)
+			assert.NoError(t, err)
+			assert.False(t, exists)
+		}
+		assert.NoError(t, st.DeleteDirectory(key("missing-dir")))
+		preview, err = DeleteDirectoryPreview(st, key("missing-dir"))
+		assert.NoError(t, err)
+		assert.Empty(t, preview)
+	})
+	t.Run("Concurrent", func(t *testing.T) {
+		testConcurrent(t, st, key("concurrent"))
+	})
+}
+
+// testConcurrent uploads, downloads and deletes from many goroutines at the
+// same time, each on its own keys, while all of them also overwrite a shared
+// key. Run it with -race to catch data races in the stores.
+func testConcurrent(t *testing.T, st Interface, root string) {
+	const (
+		workers    = 8
+		iterations = 10
+	)
+	shared := path.Join(root, "shared.txt")
+	written := map[string]bool{}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		written[fmt.Sprintf("worker %d", w)] = true
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			dir := path.Join(root, fmt.Sprintf("worker-%d", w))
+			for i := 0; i < iterations; i++ {
+				k := path.Join(dir, fmt.Sprintf("%d.txt", i))
+				data := []byte(fmt.Sprintf("worker %d iteration %d", w, i))
+				if !assert.NoError(t, st.UploadData(data, k, Overwrite(true))) {
+					return
+				}
+				got, err := st.DownloadBytes(k)
+				assert.NoError(t, err)
+				assert.Equal(t, data, got)
+				stat, err := st.Stat(k)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(len(data)), stat.Size)
+				assert.NoError(t, st.UploadData([]byte(fmt.Sprintf("worker %d", w)), shared, Overwrite(true)))
+			}
+			keys, err := st.ListPrefix(dir + "/")
+			assert.NoError(t, err)
+			assert.Len(t, keys, iterations)
+			for i := 0; i < iterations; i++ {
+				k := path.Join(dir, fmt.Sprintf("%d.txt", i))
+				assert.NoError(t, st.Delete(k))
+				exists, err := st.Exists(k)
+				assert.NoError(t, err)
+				assert.False(t, exists)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	data, err := st.DownloadBytes(shared)
+	assert.NoError(t, err)
+	assert.True(t, written[string(data)], "the shared key holds %q, which no worker wrote", data)
+	assert.NoError(t, st.Delete(shared))
+}
+
+// benchAll benchmarks the uploads and downloads of st for several object
+// sizes. The keys used are created under root.
+func benchAll(b *testing.B, st Interface, root string) {
+	sizes := []struct {
+		name string
+		size int
+	}{
+		{"1KiB", 1 << 10},
+		{"64KiB", 64 << 10},
+		{"1MiB", 1 << 20},
+		{"16MiB", 16 << 20},
+	}
+	for _, size := range sizes {
+		data := bytes.Repeat([]byte("x"), size.size)
+		k := path.Join(root, "bench-"+size.name)
+
+		b.Run("UploadData/"+size.name, func(b *testing.B) {
+			b.SetBytes(int64(size.size))
+			for i := 0; i < b.N; i++ {
+				if err := st.UploadData(data, k, Overwrite(true)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("UploadReader/"+size.name, func(b *testing.B) {
+			b.SetBytes(int64(size.size))
+			for i := 0; i < b.N; i++ {
+				if err := st.UploadReader(bytes.NewReader(data), int64(size.size), k, Overwrite(true)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("DownloadBytes/"+size.name, func(b *testing.B) {
+			b.SetBytes(int64(size.size))
+			for i := 0; i < b.N; i++ {
+				if _, err := st.DownloadBytes(k); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("DownloadReader/"+size.name, func(b *testing.B) {
+			b.SetBytes(int64(size.size))
+			for i := 0; i < b.N; i++ {
+				r, err := st.DownloadReader(k)
+				if err != nil {
+					b.Fatal(err)
+				}
+				if _, err := io.Copy(io.Discard, r); err != nil {
+					b.Fatal(err)
+				}
+				_ = r.Close()
+			}
+		})
+		if err := st.Delete(k); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
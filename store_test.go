@@ -0,0 +1,252 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore_Exists_UnsupportedProtocol(t *testing.T) {
+	key := "unknown:/file/path"
+
+	s := &Store{osStore: NewOSStore()}
+	exists, err := s.Exists(key)
+	assert.ErrorIs(t, err, ErrNotSupported, "expected error for unsupported protocol by default")
+	assert.False(t, exists)
+
+	s = &Store{osStore: NewOSStore(), opts: StoreOptions{IgnoreUnsupportedOnExists: true}}
+	exists, err = s.Exists(key)
+	assert.NoError(t, err, "unexpected error with IgnoreUnsupportedOnExists")
+	assert.False(t, exists)
+}
+
+func TestNewStore(t *testing.T) {
+	t.Setenv(S3Env, filepath.Join(t.TempDir(), "missing.json"))
+	s, err := NewStore(StoreOptions{})
+	assert.Error(t, err, "expected error for missing s3 configuration file")
+	assert.Nil(t, s)
+}
+
+func TestNewStoreWithConfig(t *testing.T) {
+	t.Setenv(S3Env, filepath.Join(t.TempDir(), "missing.json"))
+	f := newFakeS3(t, "bucket1", "bucket2")
+	newS3Config := func(bucket string) *S3MultiStoreConfig {
+		return &S3MultiStoreConfig{
+			cfgs:         map[string]*S3Config{"prefix": f.config(bucket)},
+			selectConfig: defaultSelectConfigCallbackFunc,
+		}
+	}
+
+	s1, err := NewStoreWithConfig(StoreOptions{S3: newS3Config("bucket1")})
+	assert.NoError(t, err, "S3Env should be ignored")
+	t.Cleanup(func() { _ = s1.Close() })
+	s2, err := NewStoreWithConfig(StoreOptions{S3: newS3Config("bucket2"), DisableOS: true})
+	assert.NoError(t, err)
+	t.Cleanup(func() { _ = s2.Close() })
+
+	assert.NoError(t, s1.UploadData([]byte("one"), "s3:/prefix/key"))
+	assert.NoError(t, s2.UploadData([]byte("two"), "s3:/prefix/key"))
+	data, err := s1.DownloadBytes("s3:/prefix/key")
+	assert.NoError(t, err)
+	assert.Equal(t, "one", string(data))
+	data, err = s2.DownloadBytes("s3:/prefix/key")
+	assert.NoError(t, err)
+	assert.Equal(t, "two", string(data))
+
+	file := filepath.Join(t.TempDir(), "file.txt")
+	assert.NoError(t, s1.UploadData([]byte("local"), file))
+	_, err = s2.DownloadBytes(file)
+	assert.ErrorIs(t, err, ErrNotConfigured)
+	_, err = s1.DownloadBytes("qiniu:/key")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+}
+
+func TestStore_DownloadRange_ToEnd(t *testing.T) {
+	s := &Store{osStore: NewOSStore()}
+	file := filepath.Join(t.TempDir(), "file.txt")
+	data := []byte("content")
+	assert.NoError(t, s.UploadData(data, file))
+
+	content, err := s.DownloadRangeBytes(file, 3, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, data[3:], content)
+
+	r, err := s.DownloadRangeReader(file, 3, -1)
+	assert.NoError(t, err)
+	content, err = io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Equal(t, data[3:], content)
+	assert.NoError(t, r.Close())
+}
+
+func TestStore(t *testing.T) {
+	testAll(t, &Store{osStore: NewOSStore()}, t.TempDir())
+}
+
+func TestStore_ListPrefixDepth(t *testing.T) {
+	s := &Store{osStore: NewOSStore()}
+	dir := t.TempDir()
+	assert.NoError(t, s.UploadData([]byte("content"), filepath.Join(dir, "a", "b", "file.txt")))
+
+	keys, err := s.ListPrefixDepth(dir, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{dir + "/a/"}, keys)
+
+	s.osStore = NewMemStore()
+	_, err = s.ListPrefixDepth(dir, 1)
+	assert.Error(t, err, "expected error for a backend without depth listing")
+}
+
+func TestStore_Publish(t *testing.T) {
+	st, fake := newFakeS3Store(t)
+	s := &Store{osStore: NewOSStore(), s3Store: st}
+
+	err := s.Publish("s3:/data.json", []byte("{}"), PublishOptions{ContentType: "application/x-custom", ACL: "private"})
+	assert.NoError(t, err)
+	obj, ok := fake.get("test-bucket", "data.json")
+	assert.True(t, ok)
+	assert.Equal(t, "private", obj.acl)
+	assert.Equal(t, "application/x-custom", obj.contentType)
+
+	err = s.Publish(filepath.Join(t.TempDir(), "file"), []byte("{}"), PublishOptions{})
+	assert.Error(t, err, "expected error for a backend without publish")
+}
+
+func TestStore_NotSupported(t *testing.T) {
+	s := &Store{osStore: NewOSStore()}
+	err := s.Publish(filepath.Join(t.TempDir(), "file.txt"), []byte("content"), PublishOptions{})
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
+
+func TestStore_Close(t *testing.T) {
+	s3, _ := newFakeS3Store(t)
+	s := &Store{osStore: NewOSStore(), s3Store: NewRetryStore(s3, RetryPolicy{})}
+	assert.NoError(t, s.Close())
+}
+
+func TestStore_HealthCheck(t *testing.T) {
+	s3, f := newFakeS3Store(t)
+	s := &Store{osStore: NewOSStore(), s3Store: s3}
+	assert.NoError(t, s.HealthCheck(context.Background()))
+
+	f.lk.Lock()
+	delete(f.buckets, "test-bucket")
+	f.lk.Unlock()
+	err := s.HealthCheck(context.Background())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.ErrorContains(t, err, "s3 store")
+}
+
+func TestStore_KeyTransform(t *testing.T) {
+	// hash the user id segment of "/users/<id>/..." keys
+	var (
+		lk    sync.Mutex
+		users = map[string]string{}
+	)
+	transform := func(key string) string {
+		parts := strings.SplitN(key, "/", 4)
+		if len(parts) < 3 || parts[1] != "users" || parts[2] == "" {
+			return key
+		}
+		sum := sha256.Sum256([]byte(parts[2]))
+		hashed := hex.EncodeToString(sum[:8])
+		lk.Lock()
+		users[hashed] = parts[2]
+		lk.Unlock()
+		parts[2] = hashed
+		return strings.Join(parts, "/")
+	}
+	inverse := func(key string) string {
+		parts := strings.SplitN(key, "/", 4)
+		if len(parts) < 3 || parts[1] != "users" {
+			return key
+		}
+		lk.Lock()
+		defer lk.Unlock()
+		if id, ok := users[parts[2]]; ok {
+			parts[2] = id
+		}
+		return strings.Join(parts, "/")
+	}
+	mem := NewMemStore()
+	s := &Store{osStore: mem, opts: StoreOptions{KeyTransform: transform, KeyInverse: inverse}}
+
+	assert.NoError(t, s.UploadData([]byte("a"), "/users/alice/profile.json"))
+	assert.NoError(t, s.UploadData([]byte("b"), "/users/alice/avatar.png"))
+	assert.NoError(t, s.UploadData([]byte("c"), "/users/bob/profile.json"))
+
+	stored, err := mem.ListPrefix("/users/")
+	assert.NoError(t, err)
+	for _, key := range stored {
+		assert.NotContains(t, key, "alice")
+		assert.NotContains(t, key, "bob")
+	}
+
+	data, err := s.DownloadBytes("/users/alice/profile.json")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("a"), data)
+
+	keys, err := s.ListPrefix("/users/alice/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/users/alice/avatar.png", "/users/alice/profile.json"}, keys)
+
+	keys, err = s.ListPrefix("/users/")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{"/users/alice/avatar.png", "/users/alice/profile.json", "/users/bob/profile.json"}, keys)
+
+	assert.NoError(t, s.Delete("/users/bob/profile.json"))
+	exists, err := mem.Exists(transform("/users/bob/profile.json"))
+	assert.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestStore_FileURI(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file.txt")
+	s := &Store{osStore: NewOSStore()}
+	assert.NoError(t, s.UploadData([]byte("content"), "file://"+file))
+	data, err := s.DownloadBytes(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+	exists, err := s.Exists("file://localhost" + file)
+	assert.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestStore_Resolve(t *testing.T) {
+	s3, _ := newFakeS3Store(t)
+	osStore := NewOSStore()
+	s := &Store{osStore: osStore, s3Store: s3, opts: StoreOptions{KeyTransform: strings.ToLower}}
+
+	protocol, st, key, err := s.Resolve("s3:/Dir/File.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, S3Protocol, protocol)
+	assert.Same(t, s3, st)
+	assert.Equal(t, "dir/file.txt", key)
+
+	protocol, st, key, err = s.Resolve("s3://bucket/dir/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, S3Protocol, protocol)
+	assert.Same(t, s3, st)
+	assert.Equal(t, "s3://bucket/dir/file.txt", key)
+
+	protocol, st, key, err = s.Resolve("/tmp/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, OSProtocol, protocol)
+	assert.Equal(t, osStore, st)
+	assert.Equal(t, "/tmp/file.txt", key)
+
+	protocol, st, _, err = s.Resolve("qiniu:/file.txt")
+	assert.ErrorIs(t, err, ErrNotConfigured)
+	assert.Equal(t, QiniuProtocol, protocol)
+	assert.Nil(t, st)
+
+	_, _, _, err = s.Resolve("unknown:/file.txt")
+	assert.ErrorIs(t, err, ErrNotSupported)
+}
@@ -0,0 +1,95 @@
+package store
+
+// Sync copies the objects under srcPrefix on src to the same relative keys
+// under dstPrefix on dst, skipping the objects that are already there. The
+// prefixes are treated as directories, and objects only under dstPrefix are
+// left alone.
+//
+// An object is copied if it's missing from dst, if the sizes differ, or if
+// the source was modified after the copy. The ETags are compared instead of
+// the modification times when src and dst are the same store, since ETags
+// of different backends aren't comparable. Both sides are listed with
+// ListPrefixStat, so no object is read to find what changed. Up to 8 objects
+// are copied at the same time; the keys that failed are reported in a
+// BatchError.
+func Sync(src Interface, srcPrefix string, dst Interface, dstPrefix string) error {
+	srcPrefix = makeSureKeyAsDir(srcPrefix)
+	dstPrefix = makeSureKeyAsDir(dstPrefix)
+	srcStats, err := relativeStats(src, srcPrefix)
+	if err != nil {
+		return err
+	}
+	dstStats, err := relativeStats(dst, dstPrefix)
+	if err != nil {
+		return err
+	}
+	var changed []string
+	for rel, stat := range srcStats {
+		dstStat, ok := dstStats[rel]
+		if !ok || syncChanged(stat, dstStat, src == dst) {
+			changed = append(changed, rel)
+		}
+	}
+	_, err = runBatch(changed, defaultBatchConcurrency, func(rel string) (struct{}, error) {
+		return struct{}{}, syncCopy(src, srcPrefix+rel, dst, dstPrefix+rel, srcStats[rel].Size)
+	})
+	return err
+}
+
+// relativeStats lists the stats under prefix by key relative to prefix.
+func relativeStats(st Interface, prefix string) (map[string]FileStat, error) {
+	objects, err := ListPrefixStat(st, prefix)
+	if err != nil {
+		return nil, err
+	}
+	stats := make(map[string]FileStat, len(objects))
+	for _, obj := range objects {
+		if rel, ok := relativeKey(prefix, obj.Key); ok {
+			stats[rel] = obj.FileStat
+		}
+	}
+	return stats, nil
+}
+
+func syncChanged(src, dst FileStat, sameStore bool) bool {
+	if src.Size != dst.Size {
+		return true
+	}
+	if sameStore && src.ETag != "" && dst.ETag != "" {
+		return src.ETag != dst.ETag
+	}
+	return src.ModTime.After(dst.ModTime)
+}
+
+// Copier is implemented by stores that can copy an object server-side,
+// without downloading it.
+type Copier interface {
+	// Copy copies the object at src to dst, replacing dst if it exists.
+	Copy(src, dst string) error
+}
+
+// Copy copies srcKey on src to dstKey on dst. The copy is done server-side
+// when src and dst are the same Copier, otherwise the object is downloaded
+// from src and uploaded to dst.
+func Copy(src Interface, srcKey string, dst Interface, dstKey string) error {
+	if c, ok := src.(Copier); ok && src == dst {
+		return c.Copy(srcKey, dstKey)
+	}
+	stat, err := src.Stat(srcKey)
+	if err != nil {
+		return err
+	}
+	return syncCopy(src, srcKey, dst, dstKey, stat.Size)
+}
+
+func syncCopy(src Interface, srcKey string, dst Interface, dstKey string, size int64) error {
+	if c, ok := src.(Copier); ok && src == dst {
+		return c.Copy(srcKey, dstKey)
+	}
+	r, err := src.DownloadReader(srcKey)
+	if err != nil {
+		return err
+	}
+	defer r.Close() // nolint: errcheck
+	return dst.UploadReader(r, size, dstKey)
+}
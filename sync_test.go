@@ -0,0 +1,76 @@
+package store
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// copyCountingStore records the keys uploaded with UploadReader.
+type copyCountingStore struct {
+	Interface
+	lk     sync.Mutex
+	copied []string
+}
+
+func (s *copyCountingStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) error {
+	s.lk.Lock()
+	s.copied = append(s.copied, key)
+	s.lk.Unlock()
+	return s.Interface.UploadReader(reader, size, key, opts...)
+}
+
+func (s *copyCountingStore) reset() []string {
+	s.lk.Lock()
+	defer s.lk.Unlock()
+	copied := s.copied
+	s.copied = nil
+	sort.Strings(copied)
+	return copied
+}
+
+func TestSync(t *testing.T) {
+	clock := newFakeClock(t, time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	src := NewMemStore()
+	for _, key := range []string{"src/a.txt", "src/b.txt", "src/sub/c.txt", "srcfile.txt"} {
+		assert.NoError(t, src.UploadData([]byte(key), key))
+	}
+	dst := &copyCountingStore{Interface: NewMemStore()}
+	assert.NoError(t, dst.UploadData([]byte("stale"), "dst/b.txt"))
+	assert.NoError(t, dst.UploadData([]byte("extra"), "dst/extra.txt"))
+
+	clock.Advance(time.Minute)
+	assert.NoError(t, Sync(src, "src", dst, "dst/"))
+	assert.Equal(t, []string{"dst/a.txt", "dst/b.txt", "dst/sub/c.txt"}, dst.reset())
+	data, err := dst.DownloadBytes("dst/sub/c.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "src/sub/c.txt", string(data))
+	exists, err := dst.Exists("dst/extra.txt")
+	assert.NoError(t, err)
+	assert.True(t, exists, "objects only in dst should be left alone")
+
+	assert.NoError(t, Sync(src, "src", dst, "dst/"))
+	assert.Empty(t, dst.reset(), "nothing changed")
+
+	clock.Advance(time.Minute)
+	assert.NoError(t, src.UploadData([]byte("src/A.txt"), "src/a.txt"))
+	assert.NoError(t, Sync(src, "src", dst, "dst/"))
+	assert.Equal(t, []string{"dst/a.txt"}, dst.reset(), "only the modified object should be copied")
+}
+
+func TestSync_SameStore(t *testing.T) {
+	st := &copyCountingStore{Interface: NewMemStore()}
+	assert.NoError(t, st.UploadData([]byte("one"), "a/1.txt"))
+	assert.NoError(t, st.UploadData([]byte("two"), "a/2.txt"))
+	assert.NoError(t, Sync(st, "a", st, "b"))
+	assert.Equal(t, []string{"b/1.txt", "b/2.txt"}, st.reset())
+
+	// same size, different content: only the ETag tells them apart
+	assert.NoError(t, st.UploadData([]byte("owt"), "a/2.txt"))
+	assert.NoError(t, Sync(st, "a", st, "b"))
+	assert.Equal(t, []string{"b/2.txt"}, st.reset())
+}
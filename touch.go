@@ -0,0 +1,19 @@
+package store
+
+// Toucher is implemented by stores that can refresh the modification time
+// of an object without rewriting its content.
+type Toucher interface {
+	// Touch sets the modification time of the object to now. It fails
+	// with ErrNotFound if the object doesn't exist.
+	Touch(key string) error
+}
+
+// Touch refreshes the modification time of key on st, failing with
+// ErrNotSupported if st isn't a Toucher.
+func Touch(st Interface, key string) error {
+	t, ok := st.(Toucher)
+	if !ok {
+		return notSupportedError("Touch", st)
+	}
+	return t.Touch(key)
+}
@@ -0,0 +1,182 @@
+//go:build otel
+
+package store
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	_ Interface = &TracingStore{}
+	_ io.Closer = &TracingStore{}
+)
+
+// TracingStore wraps a store and records an OpenTelemetry span for every
+// operation, named like "store.S3.DownloadBytes". Spans carry the key, the
+// backend and, for transfers, the size, and record the operation's error.
+//
+// TracingStore is only built with the "otel" build tag, so users who don't
+// trace don't compile in the OpenTelemetry dependency.
+type TracingStore struct {
+	inner   Interface
+	tracer  trace.Tracer
+	backend string
+	ctx     context.Context
+}
+
+// NewTracingStore creates a TracingStore. The backend name used in span
+// names and attributes is derived from the type of inner if empty.
+func NewTracingStore(inner Interface, tracer trace.Tracer, backend string) *TracingStore {
+	if backend == "" {
+		backend = backendName(inner)
+	}
+	return &TracingStore{
+		inner:   inner,
+		tracer:  tracer,
+		backend: backend,
+		ctx:     context.Background(),
+	}
+}
+
+// WithContext returns a view of the store whose spans are children of the
+// span in ctx, if any.
+func (s *TracingStore) WithContext(ctx context.Context) *TracingStore {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// backendName returns the short name of a store type, e.g. "S3" for
+// *S3Store.
+func backendName(st Interface) string {
+	switch st.(type) {
+	case *S3Store, *S3MultiStore:
+		return "S3"
+	case *OSStore:
+		return "OS"
+	case *QiniuStore:
+		return "Qiniu"
+	case *MemStore:
+		return "Mem"
+	case *Store:
+		return "Store"
+	}
+	name := fmt.Sprintf("%T", st)
+	name = name[strings.LastIndex(name, ".")+1:]
+	return strings.TrimSuffix(name, "Store")
+}
+
+func (s *TracingStore) start(op string, key string) trace.Span {
+	_, span := s.tracer.Start(s.ctx, "store."+s.backend+"."+op, trace.WithAttributes(
+		attribute.String("store.backend", s.backend),
+		attribute.String("store.key", key),
+	))
+	return span
+}
+
+// end records err and the transferred size on span and ends it.
+func end(span trace.Span, size int64, err error) {
+	if size >= 0 {
+		span.SetAttributes(attribute.Int64("store.size", size))
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (s *TracingStore) Stat(key string) (stat FileStat, err error) {
+	span := s.start("Stat", key)
+	defer func() { end(span, stat.Size, err) }()
+	return s.inner.Stat(key)
+}
+
+func (s *TracingStore) UploadData(data []byte, key string, opts ...UploadOption) (err error) {
+	span := s.start("UploadData", key)
+	defer func() { end(span, int64(len(data)), err) }()
+	return s.inner.UploadData(data, key, opts...)
+}
+
+func (s *TracingStore) Upload(file string, key string, opts ...UploadOption) (err error) {
+	span := s.start("Upload", key)
+	span.SetAttributes(attribute.String("store.file", file))
+	defer func() { end(span, -1, err) }()
+	return s.inner.Upload(file, key, opts...)
+}
+
+func (s *TracingStore) UploadReader(reader io.Reader, size int64, key string, opts ...UploadOption) (err error) {
+	span := s.start("UploadReader", key)
+	defer func() { end(span, size, err) }()
+	return s.inner.UploadReader(reader, size, key, opts...)
+}
+
+func (s *TracingStore) DeleteDirectory(dir string) (err error) {
+	span := s.start("DeleteDirectory", dir)
+	defer func() { end(span, -1, err) }()
+	return s.inner.DeleteDirectory(dir)
+}
+
+func (s *TracingStore) Delete(key string) (err error) {
+	span := s.start("Delete", key)
+	defer func() { end(span, -1, err) }()
+	return s.inner.Delete(key)
+}
+
+func (s *TracingStore) Exists(key string) (exists bool, err error) {
+	span := s.start("Exists", key)
+	defer func() {
+		span.SetAttributes(attribute.Bool("store.exists", exists))
+		end(span, -1, err)
+	}()
+	return s.inner.Exists(key)
+}
+
+func (s *TracingStore) DownloadBytes(key string) (data []byte, err error) {
+	span := s.start("DownloadBytes", key)
+	defer func() { end(span, int64(len(data)), err) }()
+	return s.inner.DownloadBytes(key)
+}
+
+// DownloadReader traces opening the reader, not reading it.
+func (s *TracingStore) DownloadReader(key string) (r io.ReadCloser, err error) {
+	span := s.start("DownloadReader", key)
+	defer func() { end(span, -1, err) }()
+	return s.inner.DownloadReader(key)
+}
+
+func (s *TracingStore) DownloadRangeBytes(key string, offset int64, size int64) (data []byte, err error) {
+	span := s.start("DownloadRangeBytes", key)
+	span.SetAttributes(attribute.Int64("store.offset", offset))
+	defer func() { end(span, int64(len(data)), err) }()
+	return s.inner.DownloadRangeBytes(key, offset, size)
+}
+
+// DownloadRangeReader traces opening the reader, not reading it.
+func (s *TracingStore) DownloadRangeReader(key string, offset int64, size int64) (r io.ReadCloser, err error) {
+	span := s.start("DownloadRangeReader", key)
+	span.SetAttributes(attribute.Int64("store.offset", offset))
+	defer func() { end(span, size, err) }()
+	return s.inner.DownloadRangeReader(key, offset, size)
+}
+
+func (s *TracingStore) ListPrefix(key string) (keys []string, err error) {
+	span := s.start("ListPrefix", key)
+	defer func() {
+		span.SetAttributes(attribute.Int("store.count", len(keys)))
+		end(span, -1, err)
+	}()
+	return s.inner.ListPrefix(key)
+}
+
+// Close closes the wrapped store.
+func (s *TracingStore) Close() error {
+	return closeStore(s.inner)
+}
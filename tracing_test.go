@@ -0,0 +1,46 @@
+//go:build otel
+
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestTracingStore(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := tp.Tracer("test")
+	s := NewTracingStore(NewMemStore(), tracer, "")
+
+	ctx, parent := tracer.Start(context.Background(), "request")
+	st := s.WithContext(ctx)
+	assert.NoError(t, st.UploadData([]byte("content"), "key"))
+	_, err := st.DownloadBytes("missing")
+	assert.Error(t, err)
+	parent.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 3)
+	upload, download := spans[0], spans[1]
+	assert.Equal(t, "store.Mem.UploadData", upload.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), upload.Parent().SpanID())
+	assert.Contains(t, upload.Attributes(), attribute.String("store.key", "key"))
+	assert.Contains(t, upload.Attributes(), attribute.Int64("store.size", 7))
+	assert.Equal(t, codes.Unset, upload.Status().Code)
+
+	assert.Equal(t, "store.Mem.DownloadBytes", download.Name())
+	assert.Equal(t, codes.Error, download.Status().Code)
+	assert.Len(t, download.Events(), 1, "the error should be recorded")
+}
+
+func TestTracingStore_Suite(t *testing.T) {
+	tp := sdktrace.NewTracerProvider()
+	testAll(t, NewTracingStore(NewMemStore(), tp.Tracer("test"), "Mem"), "tracing")
+}
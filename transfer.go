@@ -0,0 +1,203 @@
+package store
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileDownloader is implemented by stores that can download an object to a
+// local file more efficiently than by copying DownloadReader.
+type FileDownloader interface {
+	DownloadToFile(key, localPath string) error
+}
+
+// DownloadToFile downloads key from st to localPath, creating the parent
+// directories. The object is streamed to a temporary file that replaces
+// localPath once complete, so localPath never holds a partial download.
+func DownloadToFile(st Interface, key, localPath string) error {
+	if fd, ok := st.(FileDownloader); ok {
+		return fd.DownloadToFile(key, localPath)
+	}
+	return downloadToFile(st, key, localPath)
+}
+
+func downloadToFile(st Interface, key, localPath string) (err error) {
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+	r, err := st.DownloadReader(key)
+	if err != nil {
+		return err
+	}
+	defer r.Close() // nolint: errcheck
+	f, err := os.CreateTemp(filepath.Dir(localPath), "."+filepath.Base(localPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			_ = f.Close()
+			_ = os.Remove(f.Name())
+		}
+	}()
+	if _, err = io.Copy(f, r); err != nil {
+		return fmt.Errorf("download %s: %w", key, err)
+	}
+	// CreateTemp creates the file with mode 0600
+	if err = f.Chmod(0644); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), localPath)
+}
+
+// DownloadToWriter streams key from st to w without holding the object in
+// memory, e.g. to proxy it to an HTTP response, and returns the number of
+// bytes written.
+func DownloadToWriter(st Interface, key string, w io.Writer) (int64, error) {
+	r, err := st.DownloadReader(key)
+	if err != nil {
+		return 0, err
+	}
+	return copyAndClose(w, r, key)
+}
+
+// DownloadRangeToWriter streams size bytes of key starting at offset from st
+// to w like DownloadToWriter. A negative size reads until the end of the
+// object.
+func DownloadRangeToWriter(st Interface, key string, offset int64, size int64, w io.Writer) (int64, error) {
+	r, err := st.DownloadRangeReader(key, offset, size)
+	if err != nil {
+		return 0, err
+	}
+	return copyAndClose(w, r, key)
+}
+
+func copyAndClose(w io.Writer, r io.ReadCloser, key string) (int64, error) {
+	n, err := io.Copy(w, r)
+	closeErr := r.Close()
+	if err != nil {
+		return n, fmt.Errorf("download %s: %w", key, err)
+	}
+	return n, closeErr
+}
+
+// UploadDirectory uploads every file under localDir to st, at its path
+// relative to localDir under keyPrefix. Up to 8 files are uploaded at the
+// same time; the files that failed are reported in a BatchError.
+func UploadDirectory(st Interface, localDir, keyPrefix string) error {
+	var files []string
+	err := filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if keyPrefix != "" {
+		keyPrefix = makeSureKeyAsDir(keyPrefix)
+	}
+	_, err = runBatch(files, defaultBatchConcurrency, func(file string) (struct{}, error) {
+		rel, err := filepath.Rel(localDir, file)
+		if err != nil {
+			return struct{}{}, err
+		}
+		return struct{}{}, st.Upload(file, keyPrefix+filepath.ToSlash(rel))
+	})
+	return err
+}
+
+// DownloadDirectory downloads every object under keyPrefix on st to its
+// path relative to keyPrefix under localDir. keyPrefix is treated as a
+// directory. Up to 8 objects are downloaded at the same time; the keys that
+// failed are reported in a BatchError.
+func DownloadDirectory(st Interface, keyPrefix, localDir string) error {
+	keyPrefix = makeSureKeyAsDir(keyPrefix)
+	keys, err := st.ListPrefix(keyPrefix)
+	if err != nil {
+		return err
+	}
+	var rels []string
+	for _, key := range keys {
+		if rel, ok := relativeKey(keyPrefix, key); ok {
+			rels = append(rels, rel)
+		}
+	}
+	_, err = runBatch(rels, defaultBatchConcurrency, func(rel string) (struct{}, error) {
+		return struct{}{}, DownloadToFile(st, keyPrefix+rel, filepath.Join(localDir, filepath.FromSlash(rel)))
+	})
+	return err
+}
+
+// relativeKey returns key, listed under prefix, relative to prefix. The
+// union Store lists the keys of its backends, without the protocol of
+// prefix, so the key is matched against the path of prefix.
+func relativeKey(prefix, key string) (string, bool) {
+	if _, p, err := GetPathProtocol(prefix); err == nil {
+		prefix = p
+	}
+	rel, ok := strings.CutPrefix(strings.TrimPrefix(key, "/"), strings.TrimPrefix(prefix, "/"))
+	return rel, ok && rel != ""
+}
+
+// LocalFile is a local copy of an object opened by OpenLocalFile.
+type LocalFile struct {
+	*os.File
+	temp bool
+}
+
+// Close closes the file and removes it if it's a temporary download.
+func (f *LocalFile) Close() error {
+	err := f.File.Close()
+	if f.temp {
+		if rerr := os.Remove(f.Name()); err == nil {
+			err = rerr
+		}
+	}
+	return err
+}
+
+// OpenLocalFile opens the object at the union path p as a local file, for
+// readers that need random access or a file name, such as CAR readers.
+// OSProtocol paths are opened in place. Other objects are downloaded from st
+// through DownloadReader to a temporary file that Close removes, since
+// object stores can only be read sequentially or by range and most of these
+// readers seek back and forth; st must resolve p, e.g. a *Store.
+func OpenLocalFile(st Interface, p string) (*LocalFile, error) {
+	protocol, path, err := GetPathProtocol(p)
+	if err != nil {
+		return nil, err
+	}
+	if protocol == OSProtocol {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		return &LocalFile{File: f}, nil
+	}
+	f, err := os.CreateTemp("", "store-*")
+	if err != nil {
+		return nil, err
+	}
+	lf := &LocalFile{File: f, temp: true}
+	if _, err := DownloadToWriter(st, p, f); err != nil {
+		_ = lf.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		_ = lf.Close()
+		return nil, err
+	}
+	return lf, nil
+}
@@ -0,0 +1,138 @@
+package store
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUploadDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.txt":           "a",
+		"sub/b.txt":       "b",
+		"sub/deep/c.json": "c",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		assert.NoError(t, os.MkdirAll(filepath.Dir(p), 0755))
+		assert.NoError(t, os.WriteFile(p, []byte(content), 0644))
+	}
+
+	st := NewMemStore()
+	assert.NoError(t, UploadDirectory(st, dir, "backup"))
+	keys, err := st.ListPrefix("backup/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"backup/a.txt", "backup/sub/b.txt", "backup/sub/deep/c.json"}, keys)
+	data, err := st.DownloadBytes("backup/sub/deep/c.json")
+	assert.NoError(t, err)
+	assert.Equal(t, "c", string(data))
+
+	err = UploadDirectory(st, filepath.Join(dir, "missing"), "backup")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestDownloadToFile(t *testing.T) {
+	st := NewMemStore()
+	assert.NoError(t, st.UploadData([]byte("content"), "dir/file.txt"))
+
+	local := filepath.Join(t.TempDir(), "nested", "dir", "file.txt")
+	assert.NoError(t, DownloadToFile(st, "dir/file.txt", local))
+	data, err := os.ReadFile(local)
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+
+	err = DownloadToFile(st, "dir/missing.txt", filepath.Join(filepath.Dir(local), "missing.txt"))
+	assert.ErrorIs(t, err, ErrNotFound)
+	entries, err := os.ReadDir(filepath.Dir(local))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "a failed download should leave no file behind")
+}
+
+func TestDownloadToWriter(t *testing.T) {
+	st := NewMemStore()
+	assert.NoError(t, st.UploadData([]byte("0123456789"), "file.txt"))
+
+	var buf bytes.Buffer
+	n, err := DownloadToWriter(st, "file.txt", &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), n)
+	assert.Equal(t, "0123456789", buf.String())
+
+	buf.Reset()
+	n, err = DownloadRangeToWriter(st, "file.txt", 2, 3, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, "234", buf.String())
+
+	buf.Reset()
+	n, err = DownloadRangeToWriter(st, "file.txt", 7, -1, &buf)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), n)
+	assert.Equal(t, "789", buf.String())
+
+	_, err = DownloadToWriter(st, "missing.txt", &buf)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestDownloadDirectory(t *testing.T) {
+	st := NewMemStore()
+	for _, key := range []string{"backup/a.txt", "backup/sub/b.txt", "backups/other.txt"} {
+		assert.NoError(t, st.UploadData([]byte(key), key))
+	}
+	dir := t.TempDir()
+	assert.NoError(t, DownloadDirectory(st, "backup", dir))
+
+	data, err := os.ReadFile(filepath.Join(dir, "sub", "b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "backup/sub/b.txt", string(data))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 2, "backups/ is not under backup/")
+}
+
+func TestDownloadDirectory_Store(t *testing.T) {
+	src := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(src, "sub"), 0755))
+	assert.NoError(t, os.WriteFile(filepath.Join(src, "sub", "file.txt"), []byte("content"), 0644))
+
+	s := &Store{osStore: NewOSStore()}
+	dst := t.TempDir()
+	assert.NoError(t, DownloadDirectory(s, src, dst))
+	data, err := os.ReadFile(filepath.Join(dst, "sub", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(data))
+}
+
+func TestOpenLocalFile(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "piece.car")
+	assert.NoError(t, os.WriteFile(local, []byte("local"), 0644))
+	f, err := OpenLocalFile(NewMemStore(), local)
+	assert.NoError(t, err)
+	assert.Equal(t, local, f.Name(), "OS paths are opened in place")
+	assert.NoError(t, f.Close())
+	_, err = os.Stat(local)
+	assert.NoError(t, err, "Close keeps files it didn't download")
+
+	st := NewMemStore()
+	assert.NoError(t, st.UploadData([]byte("0123456789"), "s3:/pieces/piece.car"))
+	f, err = OpenLocalFile(st, "s3:/pieces/piece.car")
+	assert.NoError(t, err)
+	buf := make([]byte, 3)
+	_, err = f.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "456", string(buf))
+	data, err := io.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "0123456789", string(data))
+	assert.NoError(t, f.Close())
+	_, err = os.Stat(f.Name())
+	assert.ErrorIs(t, err, os.ErrNotExist, "Close removes the download")
+
+	_, err = OpenLocalFile(st, "s3:/pieces/missing.car")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
@@ -0,0 +1,266 @@
+package store
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+)
+
+// UploadOption configures a single call to UploadData, Upload or
+// UploadReader.
+type UploadOption func(*UploadOptions)
+
+// UploadOptions are the settings of an upload. Stores build them from the
+// UploadOptions passed to the call with NewUploadOptions.
+type UploadOptions struct {
+	// Overwrite replaces an existing object. When false, the upload fails
+	// with ErrAlreadyExists if the key exists. Defaults to true on every
+	// store; an OSStore created WithCreateOnly defaults to false unless
+	// IfMatch or IfNoneMatch is passed.
+	Overwrite bool
+	// IfMatch, if set, uploads only if the object exists and its ETag is
+	// IfMatch.
+	IfMatch string
+	// IfNoneMatch, if set, uploads only if the object's ETag isn't
+	// IfNoneMatch, or, if it's "*", only if the object doesn't exist.
+	IfNoneMatch string
+	// ContentHash is the algorithm of the checksum written to Result.
+	ContentHash ChecksumAlgorithm
+	// Result, if set, receives the checksum of the uploaded bytes once the
+	// upload succeeds.
+	Result *UploadResult
+	// Progress, if set, is called as the data is uploaded.
+	Progress ProgressFunc
+	// PartSize and UploadConcurrency, if set, override those of
+	// S3Config for the upload. Other backends ignore them.
+	PartSize          int64
+	UploadConcurrency int
+	// PreserveMode makes OSStore.Upload give the file it writes the
+	// permissions of the source file instead of the store's file mode.
+	// Other backends ignore it.
+	PreserveMode bool
+	// ExpiryDays, if positive, makes S3Store delete the object that many
+	// days after the upload. Other backends ignore it.
+	ExpiryDays int
+
+	// overwriteSet records an explicit Overwrite, see createOnlyUploadOptions.
+	overwriteSet bool
+}
+
+// UploadResult describes the bytes written by an upload.
+type UploadResult struct {
+	// Algorithm of Checksum.
+	Algorithm ChecksumAlgorithm
+	// Checksum is the hex digest of the uploaded bytes.
+	Checksum string
+	// Size is the number of bytes uploaded.
+	Size int64
+}
+
+// NewUploadOptions returns the defaults with opts applied.
+func NewUploadOptions(opts ...UploadOption) UploadOptions {
+	o := UploadOptions{
+		Overwrite: true,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// createOnlyUploadOptions is NewUploadOptions for stores that don't replace
+// existing objects by default. A conditional upload still replaces the
+// object when its condition holds.
+func createOnlyUploadOptions(opts ...UploadOption) UploadOptions {
+	o := NewUploadOptions(opts...)
+	if !o.overwriteSet && !o.conditional() {
+		o.Overwrite = false
+	}
+	return o
+}
+
+// Overwrite sets whether the upload may replace an existing object.
+// Overwrite(false) makes the upload create-only.
+func Overwrite(overwrite bool) UploadOption {
+	return func(o *UploadOptions) {
+		o.Overwrite = overwrite
+		o.overwriteSet = true
+	}
+}
+
+// IfMatch makes the upload conditional on the object's current ETag being
+// etag, as returned by Stat. It fails with ErrPreconditionFailed otherwise,
+// which prevents lost updates when writers race on the same key.
+func IfMatch(etag string) UploadOption {
+	return func(o *UploadOptions) {
+		o.IfMatch = etag
+	}
+}
+
+// IfNoneMatch makes the upload conditional on the object's current ETag not
+// being etag, or with "*", on the object not existing. It fails with
+// ErrPreconditionFailed otherwise.
+func IfNoneMatch(etag string) UploadOption {
+	return func(o *UploadOptions) {
+		o.IfNoneMatch = etag
+	}
+}
+
+// ContentHash makes the upload compute the checksum of the bytes it writes
+// with alg, e.g. ChecksumSHA256, and store it in result once the upload
+// succeeds. The data is hashed as it's uploaded, without a second pass.
+// S3Store also stores the checksum of UploadData in the object metadata,
+// where DownloadBytesVerified finds it, and sends it to the server to
+// verify. A nil result disables hashing.
+func ContentHash(alg ChecksumAlgorithm, result *UploadResult) UploadOption {
+	return func(o *UploadOptions) {
+		o.ContentHash = alg
+		o.Result = result
+	}
+}
+
+// Progress makes the upload call fn as the data is uploaded, e.g. to show a
+// progress bar. The total is -1 for UploadReader with an unknown size.
+func Progress(fn ProgressFunc) UploadOption {
+	return func(o *UploadOptions) {
+		o.Progress = fn
+	}
+}
+
+// PartSize sets the part size of the multipart upload S3Store switches to
+// for large objects, between 5MiB and 5GiB.
+func PartSize(size int64) UploadOption {
+	return func(o *UploadOptions) {
+		o.PartSize = size
+	}
+}
+
+// UploadConcurrency sets the number of parts of a multipart upload to S3Store
+// sent at the same time.
+func UploadConcurrency(n int) UploadOption {
+	return func(o *UploadOptions) {
+		o.UploadConcurrency = n
+	}
+}
+
+// PreserveMode sets whether OSStore.Upload keeps the permissions of the
+// source file, e.g. the executable bit of a script.
+func PreserveMode(preserve bool) UploadOption {
+	return func(o *UploadOptions) {
+		o.PreserveMode = preserve
+	}
+}
+
+// ExpiryDays makes S3Store delete the object days after the upload, e.g. for
+// temporary artifacts. S3 has no per-object expiry: the object is tagged
+// with days, and a rule of the bucket lifecycle expires the objects with
+// that tag. The rule is added on the first upload with days, which takes
+// the s3:PutLifecycleConfiguration permission. S3 runs the lifecycle rules
+// about once a day, so objects may outlive their expiry by up to a day.
+// OSStore, MemStore and QiniuStore have no expiry and ignore this option.
+func ExpiryDays(days int) UploadOption {
+	return func(o *UploadOptions) {
+		o.ExpiryDays = days
+	}
+}
+
+// conditional reports whether the upload depends on the object's ETag.
+func (o UploadOptions) conditional() bool {
+	return o.IfMatch != "" || o.IfNoneMatch != ""
+}
+
+// checkETag checks the conditions of o against the current ETag of the
+// object, with exists false if it doesn't exist. It's used by the backends
+// without conditional uploads of their own.
+func (o UploadOptions) checkETag(key string, etag string, exists bool) error {
+	switch {
+	case o.IfMatch != "" && !exists:
+		return fmt.Errorf("object %s doesn't exist: %w", key, ErrPreconditionFailed)
+	case o.IfMatch != "" && o.IfMatch != etag:
+		return fmt.Errorf("object %s has etag %s, not %s: %w", key, etag, o.IfMatch, ErrPreconditionFailed)
+	case o.IfNoneMatch == "*" && exists:
+		return fmt.Errorf("object %s exists: %w", key, ErrPreconditionFailed)
+	case o.IfNoneMatch != "" && exists && o.IfNoneMatch == etag:
+		return fmt.Errorf("object %s has etag %s: %w", key, etag, ErrPreconditionFailed)
+	}
+	return nil
+}
+
+// contentHasher computes the checksum of the bytes of an upload for
+// ContentHash. Its methods do nothing on a nil contentHasher, which is what
+// newContentHasher returns when hashing isn't requested.
+type contentHasher struct {
+	alg    ChecksumAlgorithm
+	h      hash.Hash
+	n      int64
+	result *UploadResult
+}
+
+func (o UploadOptions) newContentHasher() (*contentHasher, error) {
+	if o.Result == nil {
+		return nil, nil
+	}
+	h, err := o.ContentHash.newHash()
+	if err != nil {
+		return nil, err
+	}
+	return &contentHasher{alg: o.ContentHash, h: h, result: o.Result}, nil
+}
+
+func (c *contentHasher) Write(p []byte) (int, error) {
+	c.h.Write(p)
+	c.n += int64(len(p))
+	return len(p), nil
+}
+
+// reader hashes what's read from r.
+func (c *contentHasher) reader(r io.Reader) io.Reader {
+	if c == nil {
+		return r
+	}
+	return io.TeeReader(r, c)
+}
+
+func (c *contentHasher) data(data []byte) {
+	if c == nil {
+		return
+	}
+	_, _ = c.Write(data)
+}
+
+// reset starts over for another attempt of the upload.
+func (c *contentHasher) reset() {
+	if c == nil {
+		return
+	}
+	c.h.Reset()
+	c.n = 0
+}
+
+// done stores the checksum in the result of the upload.
+func (c *contentHasher) done() {
+	if c == nil {
+		return
+	}
+	*c.result = UploadResult{
+		Algorithm: c.alg,
+		Checksum:  hex.EncodeToString(c.h.Sum(nil)),
+		Size:      c.n,
+	}
+}
+
+// metadata returns the user metadata storing the checksum of the data hashed
+// so far, along with the header the S3 server verifies it with.
+func (c *contentHasher) metadata() map[string]string {
+	if c == nil {
+		return nil
+	}
+	sum := c.h.Sum(nil)
+	md := map[string]string{c.alg.metadataKey(): hex.EncodeToString(sum)}
+	if header := c.alg.amzHeader(); header != "" {
+		md[header] = base64.StdEncoding.EncodeToString(sum)
+	}
+	return md
+}